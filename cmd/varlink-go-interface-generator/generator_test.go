@@ -1,6 +1,9 @@
 package main
 
 import (
+	"bytes"
+	"io/ioutil"
+	"os"
 	"strings"
 	"testing"
 )
@@ -88,3 +91,281 @@ method TestObject(object: object) -> (object: object)
 	}
 	// FIXME: compare b.String() against expected output
 }
+
+func TestObjectType(t *testing.T) {
+	description := `
+interface org.example.object
+method Store(data: object, extra: ?object) -> ()
+	`
+
+	_, b, err := generateTemplate(description)
+	if err != nil {
+		t.Fatalf("Error parsing %v", err)
+	}
+	for _, s := range []string{
+		"Data  json.RawMessage `json:\"data\"`",
+		"Extra json.RawMessage `json:\"extra,omitempty\"`",
+		"\"encoding/json\"",
+	} {
+		if !strings.Contains(string(b), s) {
+			t.Fatalf("Generated source is missing `%s`:\n%s", s, b)
+		}
+	}
+
+	defer func() { objectType, objectImport = "json.RawMessage", "" }()
+	objectType, objectImport = "value.Value", "example.com/value"
+	_, b, err = generateTemplate(description)
+	if err != nil {
+		t.Fatalf("Error parsing %v", err)
+	}
+	for _, s := range []string{
+		"Data  value.Value  `json:\"data\"`",
+		"Extra *value.Value `json:\"extra,omitempty\"`",
+		"\"example.com/value\"",
+	} {
+		if !strings.Contains(string(b), s) {
+			t.Fatalf("Generated source is missing `%s`:\n%s", s, b)
+		}
+	}
+}
+
+func TestFieldNames(t *testing.T) {
+	description := `
+interface org.example.fields
+type Engine (engine_id: int, fuel_level: ?float)
+method Monitor(drive_id: string) -> (active_engines: [](engine_id: int, is_on: bool), spare: Engine)
+	`
+
+	defer func() { fieldNames = "title" }()
+	fieldNames = "camel"
+	_, b, err := generateTemplate(description)
+	if err != nil {
+		t.Fatalf("Error parsing %v", err)
+	}
+	for _, s := range []string{
+		"EngineId  int64    `json:\"engine_id\"`",
+		"FuelLevel *float64 `json:\"fuel_level,omitempty\"`",
+		"ActiveEngines []struct {",
+		"`json:\"active_engines\"`",
+		"IsOn     bool  `json:\"is_on\"`",
+		"DriveId string `json:\"drive_id\"`",
+	} {
+		if !strings.Contains(string(b), s) {
+			t.Fatalf("Generated source is missing `%s`:\n%s", s, b)
+		}
+	}
+}
+
+func TestRegistration(t *testing.T) {
+	_, b, err := generateTemplate(`
+interface org.example.small
+method Ping(ping: string) -> (pong: string)
+	`)
+	if err != nil {
+		t.Fatalf("Error parsing %v", err)
+	}
+	for _, s := range []string{
+		"type VarlinkBase struct{}",
+		"func (m Ping_methods) Call(ctx context.Context, c *varlink.Connection, ping_in_ string, opts_ ...varlink.CallOption) (pong_out_ string, err_ error) {",
+		"c.SendWithOptions(ctx, \"org.example.small.Ping\", in, append(opts_[:len(opts_):len(opts_)], varlink.WithFlags(flags))...)",
+		"func (VarlinkBase) Ping(ctx context.Context, c VarlinkCall, ping_ string) error {",
+		"varlink.RegisterBinding(`org.example.small`, func(impl interface{}) interface{} {",
+		"if m, ok := impl.(orgexamplesmallInterface); ok {",
+	} {
+		if !strings.Contains(string(b), s) {
+			t.Fatalf("Generated source is missing `%s`:\n%s", s, b)
+		}
+	}
+}
+
+func TestMultipleInterfaces(t *testing.T) {
+	packages, err := generateTemplates(`# The first interface.
+interface org.example.first
+method First() -> ()
+
+interface org.example.second
+method Second() -> ()
+`)
+	if err != nil {
+		t.Fatalf("generateTemplates(): %v", err)
+	}
+	if len(packages) != 2 {
+		t.Fatalf("Generated %d packages", len(packages))
+	}
+
+	for i, name := range []string{"org.example.first", "org.example.second"} {
+		p := packages[i]
+		if p.pkgname != strings.Replace(name, ".", "", -1) {
+			t.Fatalf("Unexpected package name %s", p.pkgname)
+		}
+		source := string(p.source)
+		if !strings.Contains(source, "package "+p.pkgname+"\n") ||
+			!strings.Contains(source, "varlink.RegisterBinding(`"+name+"`") {
+			t.Fatalf("Unexpected package %s:\n%s", p.pkgname, source)
+		}
+	}
+	if strings.Contains(string(packages[0].source), "org.example.second") {
+		t.Fatalf("First package describes the second interface:\n%s", packages[0].source)
+	}
+
+	// A single interface is generated unchanged
+	single := "interface org.example.single\nmethod Single() -> () \n\n"
+	_, source, err := generateTemplate(single)
+	if err != nil {
+		t.Fatalf("generateTemplate(): %v", err)
+	}
+	packages, err = generateTemplates(single)
+	if err != nil {
+		t.Fatalf("generateTemplates(): %v", err)
+	}
+	if !bytes.Equal(packages[0].source, source) {
+		t.Fatalf("Single interface generated as:\n%s\ninstead of:\n%s", packages[0].source, source)
+	}
+}
+
+func TestDocComments(t *testing.T) {
+	_, b, err := generateTemplate(`
+interface org.example.docs
+
+# An engine of the ship.
+type Engine (
+  # The number of the engine
+  id: int,
+  on: bool
+)
+
+# Start the engine.
+method Start(id: int) -> ()
+
+# The engine is already running.
+error AlreadyRunning (
+  # The engine which runs
+  id: int
+)
+	`)
+	if err != nil {
+		t.Fatalf("Error parsing %v", err)
+	}
+	for _, s := range []string{
+		"// An engine of the ship.\ntype Engine struct {\n\t// The number of the engine\n\tId int64",
+		"// Start the engine.\ntype Start_methods struct{}",
+		"\t// Start the engine.\n\tStart(ctx context.Context, c VarlinkCall, id_ int64) error",
+		"// The engine is already running.\ntype AlreadyRunning struct {\n\t// The engine which runs\n\tId int64",
+		"// ReplyStart sends the reply of org.example.docs.Start.",
+	} {
+		if !strings.Contains(string(b), s) {
+			t.Fatalf("Generated source is missing `%s`:\n%s", s, b)
+		}
+	}
+}
+
+func TestStableOrder(t *testing.T) {
+	members := []string{
+		"type B (b: int)",
+		"type A (a: int)",
+		"method Second(b: B) -> ()",
+		"method First(a: A) -> ()",
+		"error Z ()",
+		"error Y (y: int)",
+	}
+	reversed := make([]string, len(members))
+	for i, m := range members {
+		reversed[len(members)-1-i] = m
+	}
+
+	// The generated code differs only in the embedded description
+	generate := func(members []string) string {
+		description := "interface org.example.order\n" + strings.Join(members, "\n")
+		_, b, err := generateTemplate(description)
+		if err != nil {
+			t.Fatalf("Error parsing %v", err)
+		}
+		return strings.Replace(string(b), description, "", 1)
+	}
+	expect(t, generate(members), generate(reversed))
+
+	source := generate(members)
+	if strings.Index(source, "type A ") > strings.Index(source, "type B ") ||
+		!strings.Contains(source, `[]string{"First", "Second"}`) {
+		t.Fatalf("Members are not sorted:\n%s", source)
+	}
+}
+
+func TestSkeleton(t *testing.T) {
+	description := `
+interface org.example.skeleton
+# Ping the service.
+method Ping(ping: string) -> (pong: string)
+method Reset() -> ()
+	`
+	packages, err := generateTemplates(description)
+	if err != nil {
+		t.Fatalf("generateTemplates(): %v", err)
+	}
+	source := string(packages[0].skeleton)
+	for _, s := range []string{
+		"package orgexampleskeleton\n",
+		"type VarlinkService struct {\n\tVarlinkBase\n}",
+		"// Ping the service.\nfunc (s *VarlinkService) Ping(ctx context.Context, c VarlinkCall, ping_ string) error {\n" +
+			"\treturn c.ReplyMethodNotImplemented(ctx, \"org.example.skeleton.Ping\")",
+		"func (s *VarlinkService) Reset(ctx context.Context, c VarlinkCall) error {",
+	} {
+		if !strings.Contains(source, s) {
+			t.Fatalf("Skeleton is missing `%s`:\n%s", s, source)
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "varlink-skeleton")
+	if err != nil {
+		t.Fatalf("TempDir(): %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The edited skeleton is kept
+	filename := dir + "/service.go"
+	writeSkeleton(filename, packages[0].skeleton)
+	if err := ioutil.WriteFile(filename, []byte("edited"), 0660); err != nil {
+		t.Fatalf("WriteFile(): %v", err)
+	}
+	writeSkeleton(filename, packages[0].skeleton)
+	if b, _ := ioutil.ReadFile(filename); string(b) != "edited" {
+		t.Fatalf("Skeleton was overwritten:\n%s", b)
+	}
+
+	// The skeleton is written when the generated file is up to date
+	varlinkFile := dir + "/org.example.skeleton.varlink"
+	if err := ioutil.WriteFile(varlinkFile, []byte(description), 0644); err != nil {
+		t.Fatal(err)
+	}
+	generateFile(varlinkFile)
+	defer func(s bool) { skeleton = s }(skeleton)
+	skeleton = true
+	generateFile(varlinkFile)
+	b, err := ioutil.ReadFile(dir + "/orgexampleskeleton_service.go")
+	if err != nil || !bytes.Equal(b, packages[0].skeleton) {
+		t.Fatalf("Skeleton of the unchanged package was not written: %v\n%s", err, b)
+	}
+}
+
+func TestSentinelErrors(t *testing.T) {
+	_, b, err := generateTemplate(`
+interface org.example.errors
+method F() -> ()
+error Busy ()
+error Failed (reason: string)
+	`)
+	if err != nil {
+		t.Fatalf("Error parsing %v", err)
+	}
+	for _, s := range []string{
+		"ErrBusy   = &Busy{}",
+		"ErrFailed = &Failed{}",
+		"func (e Failed) VarlinkErrorName() string {\n\treturn \"org.example.errors.Failed\"\n}",
+		"func (e *Failed) Is(target error) bool {\n\tswitch t := target.(type) {\n\tcase *Failed:\n\t\treturn true\n" +
+			"\tcase *varlink.Error:\n\t\treturn t.Name == \"org.example.errors.Failed\"",
+	} {
+		if !strings.Contains(string(b), s) {
+			t.Fatalf("Generated source is missing `%s`:\n%s", s, b)
+		}
+	}
+}
@@ -2,17 +2,73 @@ package main
 
 import (
 	"bytes"
+	"flag"
 	"fmt"
 	"go/format"
 	"io/ioutil"
 	"os"
 	"path"
+	"sort"
 	"strings"
 
 	"github.com/varlink/go/varlink/idl"
 )
 
+// The Go type of the varlink object type, and the package it needs; set
+// with the -object-type and -object-import flags.
+var (
+	objectType   = "json.RawMessage"
+	objectImport = ""
+)
+
+// fieldNames is the policy mapping varlink field names to Go field names, set
+// with the -field-names flag; the JSON tags always carry the varlink names.
+//
+//	title: capitalize the first letter, active_engines becomes Active_engines
+//	camel: convert snake_case to CamelCase, active_engines becomes ActiveEngines
+var fieldNames = "title"
+
+// skeleton enables writing an implementation of all methods to edit, the
+// <pkgname>_service.go file next to the generated one, if it does not exist.
+var skeleton = false
+
+func goFieldName(name string) string {
+	if fieldNames != "camel" {
+		return strings.Title(name)
+	}
+
+	var b strings.Builder
+	for _, word := range strings.Split(name, "_") {
+		b.WriteString(strings.Title(word))
+	}
+	if b.Len() == 0 {
+		return strings.Title(name)
+	}
+	return b.String()
+}
+
+// nilable returns whether the Go type can represent a missing value itself,
+// so a maybe type does not need a pointer.
+func nilable(goType string) bool {
+	for _, prefix := range []string{"[]", "map[", "*", "interface{"} {
+		if strings.HasPrefix(goType, prefix) {
+			return true
+		}
+	}
+	return goType == "json.RawMessage"
+}
+
 func writeType(b *bytes.Buffer, t *idl.Type, json bool, ident int) {
+	writeTypeDocs(b, t, json, false, ident)
+}
+
+// writeDeclaration writes the type of a type or error declaration, with the
+// documentation of its fields.
+func writeDeclaration(b *bytes.Buffer, t *idl.Type) {
+	writeTypeDocs(b, t, true, true, 0)
+}
+
+func writeTypeDocs(b *bytes.Buffer, t *idl.Type, json bool, docs bool, ident int) {
 	switch t.Kind {
 	case idl.TypeBool:
 		b.WriteString("bool")
@@ -27,19 +83,21 @@ func writeType(b *bytes.Buffer, t *idl.Type, json bool, ident int) {
 		b.WriteString("string")
 
 	case idl.TypeObject:
-		b.WriteString("json.RawMessage")
+		b.WriteString(objectType)
 
 	case idl.TypeArray:
 		b.WriteString("[]")
-		writeType(b, t.ElementType, json, ident)
+		writeTypeDocs(b, t.ElementType, json, docs, ident)
 
 	case idl.TypeMap:
 		b.WriteString("map[string]")
-		writeType(b, t.ElementType, json, ident)
+		writeTypeDocs(b, t.ElementType, json, docs, ident)
 
 	case idl.TypeMaybe:
-		b.WriteString("*")
-		writeType(b, t.ElementType, json, ident)
+		if t.ElementType.Kind != idl.TypeObject || !nilable(objectType) {
+			b.WriteString("*")
+		}
+		writeTypeDocs(b, t.ElementType, json, docs, ident)
 
 	case idl.TypeAlias:
 		b.WriteString(t.Alias)
@@ -50,12 +108,14 @@ func writeType(b *bytes.Buffer, t *idl.Type, json bool, ident int) {
 		} else {
 			b.WriteString("struct {\n")
 			for _, field := range t.Fields {
-				for i := 0; i < ident+1; i++ {
-					b.WriteString("\t")
+				indent := strings.Repeat("\t", ident+1)
+				if docs && field.Doc != "" {
+					b.WriteString(indent + "// " + strings.Replace(field.Doc, "\n", "\n"+indent+"// ", -1) + "\n")
 				}
+				b.WriteString(indent)
 
-				b.WriteString(strings.Title(field.Name) + " ")
-				writeType(b, field.Type, json, ident+1)
+				b.WriteString(goFieldName(field.Name) + " ")
+				writeTypeDocs(b, field.Type, json, docs, ident+1)
 				if json {
 					b.WriteString(" `json:\"" + field.Name)
 					if field.Type.Kind == idl.TypeMaybe {
@@ -91,7 +151,69 @@ func generateTemplate(description string) (string, []byte, error) {
 		return "", nil, err
 	}
 
+	return generateInterface(midl)
+}
+
+// generated is the Go package of an interface.
+type generated struct {
+	pkgname  string
+	source   []byte
+	skeleton []byte
+}
+
+// generateTemplates returns the packages of all interfaces of the description.
+func generateTemplates(description string) ([]generated, error) {
+	idls, err := idl.NewAll(description)
+	if err != nil {
+		return nil, err
+	}
+
+	// A single interface embeds the description like generateTemplate does, the
+	// interfaces of a file with several are cut out of it
+	if len(idls) == 1 {
+		idls[0].Description = strings.TrimRight(description, "\n")
+	} else {
+		for _, midl := range idls {
+			midl.Description = strings.TrimRight(midl.Description, "\n")
+		}
+	}
+
+	packages := make([]generated, 0, len(idls))
+	for _, midl := range idls {
+		pkgname, b, err := generateInterface(midl)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", midl.Name, err)
+		}
+		skeleton, err := generateSkeleton(midl)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", midl.Name, err)
+		}
+		packages = append(packages, generated{pkgname, b, skeleton})
+	}
+	return packages, nil
+}
+
+// sortMembers returns a copy of the interface with its types, errors and
+// methods sorted by name, so that reordering the members of the description
+// does not move the generated code around.
+func sortMembers(midl *idl.IDL) *idl.IDL {
+	sorted := *midl
+
+	sorted.Aliases = append([]*idl.Alias(nil), midl.Aliases...)
+	sort.SliceStable(sorted.Aliases, func(i, j int) bool { return sorted.Aliases[i].Name < sorted.Aliases[j].Name })
+
+	sorted.Errors = append([]*idl.Error(nil), midl.Errors...)
+	sort.SliceStable(sorted.Errors, func(i, j int) bool { return sorted.Errors[i].Name < sorted.Errors[j].Name })
+
+	sorted.Methods = append([]*idl.Method(nil), midl.Methods...)
+	sort.SliceStable(sorted.Methods, func(i, j int) bool { return sorted.Methods[i].Name < sorted.Methods[j].Name })
+
+	return &sorted
+}
+
+func generateInterface(midl *idl.IDL) (string, []byte, error) {
 	pkgname := strings.Replace(midl.Name, ".", "", -1)
+	midl = sortMembers(midl)
 
 	var b bytes.Buffer
 	b.WriteString("// Code generated by github.com/varlink/go/cmd/varlink-go-interface-generator, DO NOT EDIT.\n\n")
@@ -105,27 +227,27 @@ func generateTemplate(description string) (string, []byte, error) {
 	for _, a := range midl.Aliases {
 		writeDocString(&b, a.Doc)
 		b.WriteString("type " + a.Name + " ")
-		writeType(&b, a.Type, true, 0)
+		writeDeclaration(&b, a.Type)
 		b.WriteString("\n\n")
 	}
 
 	for _, a := range midl.Errors {
 		writeDocString(&b, a.Doc)
 		b.WriteString("type " + a.Name + " ")
-		writeType(&b, a.Type, true, 0)
+		writeDeclaration(&b, a.Type)
 		b.WriteString("\nfunc (e " + a.Name + ") Error() string {\n")
 		b.WriteString("\ts := \"" + midl.Name + "." + a.Name + "\"\n")
 		if len(a.Type.Fields) > 0 {
 			b.WriteString("\ts += fmt.Sprintf(\"(")
 			for i, f := range a.Type.Fields {
-				b.WriteString(strings.Title(f.Name) + ": %v")
+				b.WriteString(goFieldName(f.Name) + ": %v")
 				if i != len(a.Type.Fields)-1 {
 					b.WriteString(", ")
 				}
 			}
 			b.WriteString(")\", ")
 			for i, f := range a.Type.Fields {
-				b.WriteString("e." + strings.Title(f.Name))
+				b.WriteString("e." + goFieldName(f.Name))
 				if i != len(a.Type.Fields)-1 {
 					b.WriteString(", ")
 				}
@@ -134,18 +256,47 @@ func generateTemplate(description string) (string, []byte, error) {
 		}
 		b.WriteString("\treturn s")
 		b.WriteString("}\n\n")
+
+		name := midl.Name + "." + a.Name
+		b.WriteString("// VarlinkErrorName returns the name of the varlink error.\n")
+		b.WriteString("func (e " + a.Name + ") VarlinkErrorName() string {\n" +
+			"\treturn \"" + name + "\"\n" +
+			"}\n\n")
+		b.WriteString("// Is reports whether the target has the name of the error, like Err" + a.Name + ",\n" +
+			"// for errors.Is; the parameters are not compared.\n")
+		b.WriteString("func (e *" + a.Name + ") Is(target error) bool {\n" +
+			"\tswitch t := target.(type) {\n" +
+			"\tcase *" + a.Name + ":\n" +
+			"\t\treturn true\n" +
+			"\tcase *varlink.Error:\n" +
+			"\t\treturn t.Name == \"" + name + "\"\n" +
+			"\t}\n" +
+			"\treturn false\n" +
+			"}\n\n")
+	}
+
+	if len(midl.Errors) > 0 {
+		b.WriteString("// Generated sentinel errors, matching all errors of their name with errors.Is\n\n")
+
+		b.WriteString("var (\n")
+		for _, a := range midl.Errors {
+			b.WriteString("\tErr" + a.Name + " = &" + a.Name + "{}\n")
+		}
+		b.WriteString(")\n\n")
 	}
 
+	b.WriteString("// Generated client error conversion\n\n")
+
 	b.WriteString("func Dispatch_Error(err error) error {\n")
 	b.WriteString("\tif e, ok := err.(*varlink.Error); ok {\n")
 	b.WriteString("\t\tswitch e.Name {\n")
 	for _, a := range midl.Errors {
 		b.WriteString("\t\tcase \"" + midl.Name + "." + a.Name + "\":\n")
 		b.WriteString("\t\t\terrorRawParameters := e.Parameters.(*json.RawMessage)\n")
+		b.WriteString("\t\t\tvar param " + a.Name + "\n")
 		b.WriteString("\t\t\tif errorRawParameters == nil {\n")
-		b.WriteString("\t\t\t\treturn e\n")
+		b.WriteString("\t\t\t\treturn &param\n")
 		b.WriteString("\t\t\t}\n")
-		b.WriteString("\t\t\tvar param " + a.Name + "\n")
 		b.WriteString("\t\t\terr := json.Unmarshal(*errorRawParameters, &param)\n")
 		b.WriteString("\t\t\tif err != nil {\n")
 		b.WriteString("\t\t\t\treturn e\n")
@@ -161,15 +312,18 @@ func generateTemplate(description string) (string, []byte, error) {
 
 	for _, m := range midl.Methods {
 		writeDocString(&b, m.Doc)
-		b.WriteString("type " + m.Name + "_methods struct{}\n")
+		b.WriteString("type " + m.Name + "_methods struct{}\n\n")
+		b.WriteString("// " + m.Name + " returns the client of the method " + midl.Name + "." + m.Name + ".\n")
 		b.WriteString("func " + m.Name + "() " + m.Name + "_methods { return " + m.Name + "_methods{} }\n\n")
 
+		b.WriteString("// Call calls the method and returns its reply, with the settings of the\n" +
+			"// options, like varlink.WithTimeout.\n")
 		b.WriteString("func (m " + m.Name + "_methods) Call(ctx context.Context, c *varlink.Connection")
 		for _, field := range m.In.Fields {
 			b.WriteString(", " + field.Name + "_in_ ")
 			writeType(&b, field.Type, false, 1)
 		}
-		b.WriteString(") (")
+		b.WriteString(", opts_ ...varlink.CallOption) (")
 		for _, field := range m.Out.Fields {
 			b.WriteString(field.Name + "_out_ ")
 			writeType(&b, field.Type, false, 1)
@@ -180,7 +334,7 @@ func generateTemplate(description string) (string, []byte, error) {
 		for _, field := range m.In.Fields {
 			b.WriteString(", " + field.Name + "_in_ ")
 		}
-		b.WriteString(")\n")
+		b.WriteString(", opts_...)\n")
 		b.WriteString("if err_ != nil {\n" +
 			"\treturn\n" +
 			"}\n")
@@ -193,12 +347,14 @@ func generateTemplate(description string) (string, []byte, error) {
 		b.WriteString("\treturn\n" +
 			"}\n\n")
 
+		b.WriteString("// Send sends the method call with the flags and the options; the returned\n" +
+			"// function receives the replies.\n")
 		b.WriteString("func (m " + m.Name + "_methods) Send(ctx context.Context, c *varlink.Connection, flags uint64")
 		for _, field := range m.In.Fields {
 			b.WriteString(", " + field.Name + "_in_ ")
 			writeType(&b, field.Type, false, 1)
 		}
-		b.WriteString(") (func(ctx context.Context) (")
+		b.WriteString(", opts_ ...varlink.CallOption) (func(ctx context.Context) (")
 		for _, field := range m.Out.Fields {
 			writeType(&b, field.Type, false, 1)
 			b.WriteString(", ")
@@ -211,17 +367,17 @@ func generateTemplate(description string) (string, []byte, error) {
 			for _, field := range m.In.Fields {
 				switch field.Type.Kind {
 				case idl.TypeStruct, idl.TypeArray, idl.TypeMap:
-					b.WriteString("\tin." + strings.Title(field.Name) + " = ")
+					b.WriteString("\tin." + goFieldName(field.Name) + " = ")
 					writeType(&b, field.Type, true, 1)
 					b.WriteString("(" + field.Name + "_in_)\n")
 
 				default:
-					b.WriteString("\tin." + strings.Title(field.Name) + " = " + field.Name + "_in_\n")
+					b.WriteString("\tin." + goFieldName(field.Name) + " = " + field.Name + "_in_\n")
 				}
 			}
-			b.WriteString("\treceive, err := c.Send(ctx, \"" + midl.Name + "." + m.Name + "\", in, flags)\n")
+			b.WriteString("\treceive, err := c.SendWithOptions(ctx, \"" + midl.Name + "." + m.Name + "\", in, append(opts_[:len(opts_):len(opts_)], varlink.WithFlags(flags))...)\n")
 		} else {
-			b.WriteString("\treceive, err := c.Send(ctx, \"" + midl.Name + "." + m.Name + "\", nil, flags)\n")
+			b.WriteString("\treceive, err := c.SendWithOptions(ctx, \"" + midl.Name + "." + m.Name + "\", nil, append(opts_[:len(opts_):len(opts_)], varlink.WithFlags(flags))...)\n")
 		}
 		b.WriteString("\tif err != nil {\n" +
 			"\t\treturn nil, err\n" +
@@ -250,22 +406,24 @@ func generateTemplate(description string) (string, []byte, error) {
 			switch field.Type.Kind {
 			case idl.TypeStruct, idl.TypeArray, idl.TypeMap:
 				writeType(&b, field.Type, false, 2)
-				b.WriteString("(out." + strings.Title(field.Name) + ")\n")
+				b.WriteString("(out." + goFieldName(field.Name) + ")\n")
 
 			default:
-				b.WriteString("out." + strings.Title(field.Name) + "\n")
+				b.WriteString("out." + goFieldName(field.Name) + "\n")
 			}
 		}
 		b.WriteString("\t\treturn\n" +
 			"\t}, nil\n")
 		b.WriteString("}\n\n")
 
+		b.WriteString("// Upgrade calls the method with the options to upgrade the connection; the\n" +
+			"// returned function receives the reply and the upgraded connection.\n")
 		b.WriteString("func (m " + m.Name + "_methods) Upgrade(ctx context.Context, c *varlink.Connection")
 		for _, field := range m.In.Fields {
 			b.WriteString(", " + field.Name + "_in_ ")
 			writeType(&b, field.Type, false, 1)
 		}
-		b.WriteString(") (func(ctx context.Context) (")
+		b.WriteString(", opts_ ...varlink.CallOption) (func(ctx context.Context) (")
 		for _, field := range m.Out.Fields {
 			b.WriteString(field.Name + "_out_ ")
 			writeType(&b, field.Type, false, 1)
@@ -279,17 +437,17 @@ func generateTemplate(description string) (string, []byte, error) {
 			for _, field := range m.In.Fields {
 				switch field.Type.Kind {
 				case idl.TypeStruct, idl.TypeArray, idl.TypeMap:
-					b.WriteString("\tin." + strings.Title(field.Name) + " = ")
+					b.WriteString("\tin." + goFieldName(field.Name) + " = ")
 					writeType(&b, field.Type, true, 1)
 					b.WriteString("(" + field.Name + "_in_)\n")
 
 				default:
-					b.WriteString("\tin." + strings.Title(field.Name) + " = " + field.Name + "_in_\n")
+					b.WriteString("\tin." + goFieldName(field.Name) + " = " + field.Name + "_in_\n")
 				}
 			}
-			b.WriteString("\treceive, err := c.Upgrade(ctx, \"" + midl.Name + "." + m.Name + "\", in)\n")
+			b.WriteString("\treceive, err := c.UpgradeWithOptions(ctx, \"" + midl.Name + "." + m.Name + "\", in, opts_...)\n")
 		} else {
-			b.WriteString("\treceive, err := c.Upgrade(ctx, \"" + midl.Name + "." + m.Name + "\", nil)\n")
+			b.WriteString("\treceive, err := c.UpgradeWithOptions(ctx, \"" + midl.Name + "." + m.Name + "\", nil, opts_...)\n")
 		}
 		b.WriteString("if err != nil {\n" +
 			"\treturn nil, err\n" +
@@ -319,10 +477,10 @@ func generateTemplate(description string) (string, []byte, error) {
 			switch field.Type.Kind {
 			case idl.TypeStruct, idl.TypeArray, idl.TypeMap:
 				writeType(&b, field.Type, false, 2)
-				b.WriteString("(out." + strings.Title(field.Name) + ")\n")
+				b.WriteString("(out." + goFieldName(field.Name) + ")\n")
 
 			default:
-				b.WriteString("out." + strings.Title(field.Name) + "\n")
+				b.WriteString("out." + goFieldName(field.Name) + "\n")
 			}
 		}
 		b.WriteString("\t\treturn\n" +
@@ -333,7 +491,13 @@ func generateTemplate(description string) (string, []byte, error) {
 	b.WriteString("// Generated service interface with all methods\n\n")
 
 	b.WriteString("type " + pkgname + "Interface interface {\n")
-	for _, m := range midl.Methods {
+	for i, m := range midl.Methods {
+		if m.Doc != "" {
+			if i > 0 {
+				b.WriteString("\n")
+			}
+			b.WriteString("\t// " + strings.Replace(m.Doc, "\n", "\n\t// ", -1) + "\n")
+		}
 		b.WriteString("\t" + m.Name + "(ctx context.Context, c VarlinkCall")
 		for _, field := range m.In.Fields {
 			b.WriteString(", " + field.Name + "_ ")
@@ -365,12 +529,12 @@ func generateTemplate(description string) (string, []byte, error) {
 			for _, field := range e.Type.Fields {
 				switch field.Type.Kind {
 				case idl.TypeStruct, idl.TypeArray, idl.TypeMap:
-					b.WriteString("\tout." + strings.Title(field.Name) + " = ")
+					b.WriteString("\tout." + goFieldName(field.Name) + " = ")
 					writeType(&b, field.Type, true, 1)
 					b.WriteString("(" + field.Name + "_)\n")
 
 				default:
-					b.WriteString("\tout." + strings.Title(field.Name) + " = " + field.Name + "_\n")
+					b.WriteString("\tout." + goFieldName(field.Name) + " = " + field.Name + "_\n")
 				}
 			}
 		}
@@ -381,6 +545,7 @@ func generateTemplate(description string) (string, []byte, error) {
 	b.WriteString("// Generated reply methods for all varlink methods\n\n")
 
 	for _, m := range midl.Methods {
+		b.WriteString("// Reply" + m.Name + " sends the reply of " + midl.Name + "." + m.Name + ".\n")
 		b.WriteString("func (c *VarlinkCall) Reply" + m.Name + "(ctx context.Context, ")
 		for i, field := range m.Out.Fields {
 			if i > 0 {
@@ -397,12 +562,12 @@ func generateTemplate(description string) (string, []byte, error) {
 			for _, field := range m.Out.Fields {
 				switch field.Type.Kind {
 				case idl.TypeStruct, idl.TypeArray, idl.TypeMap:
-					b.WriteString("\tout." + strings.Title(field.Name) + " = ")
+					b.WriteString("\tout." + goFieldName(field.Name) + " = ")
 					writeType(&b, field.Type, true, 1)
 					b.WriteString("(" + field.Name + "_)\n")
 
 				default:
-					b.WriteString("\tout." + strings.Title(field.Name) + " = " + field.Name + "_\n")
+					b.WriteString("\tout." + goFieldName(field.Name) + " = " + field.Name + "_\n")
 				}
 			}
 			b.WriteString("\treturn c.Reply(ctx, &out)\n")
@@ -438,7 +603,7 @@ func generateTemplate(description string) (string, []byte, error) {
 			b.WriteString("\n")
 			b.WriteString("\t\terr := call.GetParameters(&in)\n" +
 				"\t\tif err != nil {\n" +
-				"\t\t\treturn call.ReplyInvalidParameter(ctx, \"parameters\")\n" +
+				"\t\t\treturn call.ReplyParameterError(ctx, err)\n" +
 				"\t\t}\n")
 			b.WriteString("\t\treturn s." + pkgname + "Interface." + m.Name + "(ctx, VarlinkCall{call}")
 			if len(m.In.Fields) > 0 {
@@ -447,10 +612,10 @@ func generateTemplate(description string) (string, []byte, error) {
 					case idl.TypeStruct, idl.TypeArray, idl.TypeMap:
 						b.WriteString(", ")
 						writeType(&b, field.Type, false, 2)
-						b.WriteString("(in." + strings.Title(field.Name) + ")")
+						b.WriteString("(in." + goFieldName(field.Name) + ")")
 
 					default:
-						b.WriteString(", in." + strings.Title(field.Name))
+						b.WriteString(", in." + goFieldName(field.Name))
 					}
 				}
 			}
@@ -470,6 +635,18 @@ func generateTemplate(description string) (string, []byte, error) {
 	b.WriteString("func (s *VarlinkInterface) VarlinkGetName() string {\n" +
 		"\treturn `" + midl.Name + "`\n" + "}\n\n")
 
+	b.WriteString("// Generated varlink method names\n\n")
+
+	b.WriteString("func (s *VarlinkInterface) VarlinkGetMethods() []string {\n" +
+		"\treturn []string{")
+	for i, m := range midl.Methods {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString("\"" + m.Name + "\"")
+	}
+	b.WriteString("}\n}\n\n")
+
 	b.WriteString("// Generated varlink interface description\n\n")
 
 	// Special-quote backtick, it cannot be part of a backtick-quoted string
@@ -486,9 +663,45 @@ func generateTemplate(description string) (string, []byte, error) {
 		"\treturn &VarlinkInterface{m}\n" +
 		"}\n")
 
-	ret_string := b.String()
+	if len(midl.Methods) > 0 {
+		b.WriteString("\n// Generated base implementation, embed it to implement only some of the methods\n\n")
+
+		b.WriteString("type VarlinkBase struct{}\n\n")
+
+		for _, m := range midl.Methods {
+			b.WriteString("func (VarlinkBase) " + m.Name + "(ctx context.Context, c VarlinkCall")
+			for _, field := range m.In.Fields {
+				b.WriteString(", " + field.Name + "_ ")
+				writeType(&b, field.Type, false, 1)
+			}
+			b.WriteString(") error {\n" +
+				"\treturn c.ReplyMethodNotImplemented(ctx, \"" + midl.Name + "." + m.Name + "\")\n" +
+				"}\n\n")
+		}
+
+		b.WriteString("// Generated registration for varlink.RegisterAll\n\n")
+
+		b.WriteString("func init() {\n" +
+			"\tvarlink.RegisterBinding(`" + midl.Name + "`, func(impl interface{}) interface{} {\n" +
+			"\t\tif m, ok := impl.(" + pkgname + "Interface); ok {\n" +
+			"\t\t\treturn VarlinkNew(m)\n" +
+			"\t\t}\n" +
+			"\t\treturn nil\n" +
+			"\t})\n" +
+			"}\n")
+	}
+
+	pretty, err := formatSource(b.String(), "\"github.com/varlink/go/varlink\"")
+	if err != nil {
+		return "", nil, err
+	}
+
+	return pkgname, pretty, nil
+}
 
-	imports := []string{"\"github.com/varlink/go/varlink\""}
+// formatSource replaces the @IMPORTS@ placeholder of the source with the
+// imports it needs, and formats it.
+func formatSource(ret_string string, imports ...string) ([]byte, error) {
 	if strings.Contains(ret_string, "context.Context") {
 		imports = append(imports, "\"context\"")
 	}
@@ -498,14 +711,47 @@ func generateTemplate(description string) (string, []byte, error) {
 	if strings.Contains(ret_string, "fmt.Sprintf") {
 		imports = append(imports, "\"fmt\"")
 	}
+	if objectImport != "" && strings.Contains(ret_string, objectType) {
+		imports = append(imports, "\""+objectImport+"\"")
+	}
 	ret_string = strings.Replace(ret_string, "@IMPORTS@", fmt.Sprintf("import (\n%s\n)", strings.Join(imports, "\n\t")), 1)
 
-	pretty, err := format.Source([]byte(ret_string))
-	if err != nil {
-		return "", nil, err
+	return format.Source([]byte(ret_string))
+}
+
+// generateSkeleton returns the source of an implementation of all methods of
+// the interface, to be edited by the user. It is generated only once; methods
+// added to the interface later are served by the embedded VarlinkBase, until
+// they are implemented.
+func generateSkeleton(midl *idl.IDL) ([]byte, error) {
+	pkgname := strings.Replace(midl.Name, ".", "", -1)
+	midl = sortMembers(midl)
+
+	var b bytes.Buffer
+	b.WriteString("// Generated once by github.com/varlink/go/cmd/varlink-go-interface-generator -skeleton,\n" +
+		"// edit it to implement the methods, it is not regenerated.\n\n")
+	b.WriteString("package " + pkgname + "\n\n")
+	b.WriteString("@IMPORTS@\n\n")
+
+	b.WriteString("// VarlinkService implements " + midl.Name + ", register it with\n" +
+		"// VarlinkNew(&VarlinkService{}).\n")
+	b.WriteString("type VarlinkService struct {\n" +
+		"\tVarlinkBase\n" +
+		"}\n\n")
+
+	for _, m := range midl.Methods {
+		writeDocString(&b, m.Doc)
+		b.WriteString("func (s *VarlinkService) " + m.Name + "(ctx context.Context, c VarlinkCall")
+		for _, field := range m.In.Fields {
+			b.WriteString(", " + field.Name + "_ ")
+			writeType(&b, field.Type, false, 1)
+		}
+		b.WriteString(") error {\n" +
+			"\treturn c.ReplyMethodNotImplemented(ctx, \"" + midl.Name + "." + m.Name + "\")\n" +
+			"}\n\n")
 	}
 
-	return pkgname, pretty, nil
+	return formatSource(b.String())
 }
 
 func generateFile(varlinkFile string) {
@@ -515,14 +761,53 @@ func generateFile(varlinkFile string) {
 		os.Exit(1)
 	}
 
-	pkgname, b, err := generateTemplate(string(file))
+	packages, err := generateTemplates(string(file))
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error parsing file '%s': %s\n", varlinkFile, err)
 		os.Exit(1)
 	}
 
-	filename := path.Dir(varlinkFile) + "/" + pkgname + ".go"
-	err = ioutil.WriteFile(filename, b, 0660)
+	// A Go package per interface, in a directory per package if there are several
+	for _, p := range packages {
+		dir := path.Dir(varlinkFile)
+		if len(packages) > 1 {
+			dir = path.Join(dir, p.pkgname)
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				fmt.Fprintf(os.Stderr, "Error creating directory '%s': %s\n", dir, err)
+				os.Exit(1)
+			}
+		}
+
+		if skeleton {
+			writeSkeleton(dir+"/"+p.pkgname+"_service.go", p.skeleton)
+		}
+
+		// An unchanged file is not rewritten, to not trigger rebuilds
+		filename := dir + "/" + p.pkgname + ".go"
+		if current, err := ioutil.ReadFile(filename); err == nil && bytes.Equal(current, p.source) {
+			continue
+		}
+		err = ioutil.WriteFile(filename, p.source, 0660)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing file '%s': %s\n", filename, err)
+			os.Exit(1)
+		}
+	}
+}
+
+// writeSkeleton writes the implementation file, unless it exists already and
+// carries the implementation of the user.
+func writeSkeleton(filename string, source []byte) {
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0660)
+	if os.IsExist(err) {
+		return
+	}
+	if err == nil {
+		_, err = f.Write(source)
+		if e := f.Close(); err == nil {
+			err = e
+		}
+	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error writing file '%s': %s\n", filename, err)
 		os.Exit(1)
@@ -530,9 +815,24 @@ func generateFile(varlinkFile string) {
 }
 
 func main() {
-	if len(os.Args) != 2 {
-		fmt.Printf("Usage: %s <file>\n", os.Args[0])
+	flag.StringVar(&objectType, "object-type", objectType, "Go type of the varlink object type")
+	flag.StringVar(&objectImport, "object-import", "", "package to import for the object type")
+	flag.StringVar(&fieldNames, "field-names", fieldNames, "Go field names: title or camel")
+	flag.BoolVar(&skeleton, "skeleton", skeleton, "write an implementation to edit, once")
+	flag.Usage = func() {
+		fmt.Printf("Usage: %s [-object-type TYPE] [-object-import PATH] [-field-names title|camel] [-skeleton] <file>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if fieldNames != "title" && fieldNames != "camel" {
+		fmt.Fprintf(os.Stderr, "Unknown field name policy '%s'\n", fieldNames)
+		os.Exit(1)
+	}
+
+	if flag.NArg() != 1 {
+		flag.Usage()
 		os.Exit(1)
 	}
-	generateFile(os.Args[1])
+	generateFile(flag.Arg(0))
 }
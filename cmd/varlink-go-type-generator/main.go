@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"go/ast"
 	"go/importer"
@@ -9,8 +10,49 @@ import (
 	"go/types"
 	"log"
 	"os"
+	"reflect"
+	"strings"
+	"unicode"
 )
 
+// fieldNames is the policy mapping Go field names without a json tag to varlink
+// field names, set with the -field-names flag.
+//
+//	go: keep the Go name, ActiveEngines stays ActiveEngines
+//	snake: convert CamelCase to snake_case, ActiveEngines becomes active_engines
+var fieldNames = "go"
+
+// VarlinkFieldName returns the varlink name of a struct field, the name in its
+// json tag or the name mapped by the field name policy. An empty name means the
+// field is not encoded.
+func VarlinkFieldName(field *types.Var, tag string) string {
+	name := strings.Split(reflect.StructTag(tag).Get("json"), ",")[0]
+	if name == "-" {
+		return ""
+	}
+	if name != "" {
+		return name
+	}
+
+	if fieldNames != "snake" {
+		return field.Name()
+	}
+
+	runes := []rune(field.Name())
+	var b strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			// Start a new word, but keep acronyms like ID in one piece
+			if i > 0 && (unicode.IsLower(runes[i-1]) || (i+1 < len(runes) && unicode.IsLower(runes[i+1]))) {
+				b.WriteRune('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
 func IsBasicGoType(t types.Type, flag types.BasicInfo) bool {
 	switch u := t.(type) {
 	case *types.Basic:
@@ -46,6 +88,9 @@ func GoToVarlinkType(t types.Type) string {
 		return fmt.Sprintf("<<<%s>>>", t.String())
 
 	case *types.Named:
+		if u.Obj().Pkg() != nil && u.Obj().Pkg().Path() == "encoding/json" && u.Obj().Name() == "RawMessage" {
+			return "object"
+		}
 		return u.Obj().Name()
 
 	case *types.Map:
@@ -57,7 +102,7 @@ func GoToVarlinkType(t types.Type) string {
 
 	case *types.Interface:
 		if u.Empty() {
-			return "()"
+			return "object"
 		}
 		return fmt.Sprintf("<<<%s>>>", u.String())
 
@@ -74,14 +119,20 @@ func GoToVarlinkType(t types.Type) string {
 		if u.NumFields() > 0 {
 			s := ""
 			for i := 0; i < u.NumFields(); i++ {
-				if i > 0 {
+				name := VarlinkFieldName(u.Field(i), u.Tag(i))
+				if name == "" {
+					continue
+				}
+				if s != "" {
 					s += ",\n"
 				}
 				s += fmt.Sprintf("\t%s: %s",
-					u.Field(i).Name(), GoToVarlinkType(u.Field(i).Type()))
+					name, GoToVarlinkType(u.Field(i).Type()))
 			}
 
-			return fmt.Sprintf("(\n%s\n)", s)
+			if s != "" {
+				return fmt.Sprintf("(\n%s\n)", s)
+			}
 		}
 		return "()"
 
@@ -134,8 +185,24 @@ func PrintDefsUses(name string, fset *token.FileSet, files []*ast.File) error {
 }
 
 func main() {
+	flag.StringVar(&fieldNames, "field-names", fieldNames, "varlink names of untagged fields: go or snake")
+	flag.Usage = func() {
+		fmt.Printf("Usage: %s [-field-names go|snake] <file or directory>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if fieldNames != "go" && fieldNames != "snake" {
+		fmt.Fprintf(os.Stderr, "Unknown field name policy '%s'\n", fieldNames)
+		os.Exit(1)
+	}
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
 
-	path := os.Args[1]
+	path := flag.Arg(0)
 	fs := token.NewFileSet()
 
 	if stat, err := os.Stat(path); err == nil && stat.IsDir() {
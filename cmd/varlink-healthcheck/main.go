@@ -0,0 +1,68 @@
+// Command varlink-healthcheck probes a varlink service for container health
+// checks. It connects to the address, or to the service of an interface name
+// found by the default resolver chain, and calls org.varlink.service.GetInfo,
+// or the method given with -method without parameters. It exits with status 0
+// if the service replied without an error, and with status 1 otherwise.
+//
+//	HEALTHCHECK CMD ["varlink-healthcheck", "unix:/run/org.example.this"]
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/varlink/go/varlink"
+)
+
+// check connects to the service and calls the method, GetInfo if it is empty.
+func check(ctx context.Context, address string, method string) error {
+	var c *varlink.Connection
+	var err error
+	if strings.Contains(address, ":") {
+		c, err = varlink.NewConnection(ctx, address)
+	} else {
+		c, err = varlink.NewInterfaceConnection(ctx, address, varlink.DefaultResolverChain)
+	}
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if method == "" {
+		_, err = c.GetServiceInfo(ctx)
+		return err
+	}
+
+	var out interface{}
+	return c.Call(ctx, method, nil, &out)
+}
+
+func main() {
+	method := flag.String("method", "", "method to call instead of GetInfo, like org.example.this.Ping")
+	timeout := flag.Duration("timeout", 5*time.Second, "time to wait for the reply")
+	quiet := flag.Bool("quiet", false, "do not report errors")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [-method METHOD] [-timeout DURATION] [-quiet] <address or interface>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	if err := check(ctx, flag.Arg(0), *method); err != nil {
+		if !*quiet {
+			fmt.Fprintf(os.Stderr, "varlink-healthcheck: %v\n", err)
+		}
+		os.Exit(1)
+	}
+}
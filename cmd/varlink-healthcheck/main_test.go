@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/varlink/go/varlink"
+)
+
+func TestCheck(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unix sockets are not available")
+	}
+
+	dir, err := ioutil.TempDir("", "varlink-healthcheck")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	address := "unix:" + filepath.Join(dir, "socket")
+
+	ctx := context.Background()
+	if err := check(ctx, address, ""); err == nil {
+		t.Fatalf("check succeeded without a service")
+	}
+
+	service, _ := varlink.NewService(
+		"Varlink",
+		"Varlink Test",
+		"1",
+		"https://github.com/varlink/go/varlink",
+	)
+	servererror := make(chan error, 1)
+	go func() {
+		servererror <- service.Listen(ctx, address, 0)
+	}()
+	time.Sleep(time.Second / 5)
+
+	if err := check(ctx, address, ""); err != nil {
+		t.Fatalf("check: %v", err)
+	}
+	if err := check(ctx, address, "org.varlink.service.GetInterfaceDescription"); err == nil {
+		t.Fatalf("check succeeded for a method replying with an error")
+	}
+
+	service.Shutdown()
+	if err := <-servererror; err != nil {
+		t.Fatalf("service.Listen(): %v", err)
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+
+	"github.com/varlink/go/varlink"
+	"github.com/varlink/go/varlink/capture"
+)
+
+// The exit codes of the call command tell the class of the failure apart.
+const (
+	exitFailure  = 1 // the call failed, like a connection closed by the service
+	exitUsage    = 2 // the arguments are invalid
+	exitConnect  = 3 // the service could not be reached
+	exitTimeout  = 4 // the timeout passed
+	exitNotFound = 5 // the interface or the method does not exist, or is not implemented
+	exitInvalid  = 6 // a parameter is invalid
+	exitDenied   = 7 // org.varlink.service.PermissionDenied
+	exitBusy     = 8 // the service is not available, in maintenance, or rate limited
+	exitError    = 9 // an error of the interface of the method
+)
+
+// codeError is an error ending the command with the exit code.
+type codeError struct {
+	code int
+	err  error
+}
+
+func (e *codeError) Error() string {
+	return e.err.Error()
+}
+
+// replyExitCode returns the exit code of the error received for a call.
+func replyExitCode(err error) int {
+	switch err.(type) {
+	case *varlink.InterfaceNotFound, *varlink.MethodNotFound, *varlink.MethodNotImplemented:
+		return exitNotFound
+	case *varlink.InvalidParameter, *varlink.InvalidParameterDetails:
+		return exitInvalid
+	case *varlink.PermissionDenied:
+		return exitDenied
+	case *varlink.ServiceNotAvailable, *varlink.MaintenanceUnavailable, *varlink.RateLimitExceeded:
+		return exitBusy
+	case *varlink.Error:
+		return exitError
+	}
+	if isTimeout(err) {
+		return exitTimeout
+	}
+	return exitFailure
+}
+
+func isTimeout(err error) bool {
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return true
+	}
+	return errors.Is(err, context.DeadlineExceeded)
+}
+
+// printer writes the replies to the output, one per line, or indented. Every
+// reply is written right away, so the replies of -more can be piped into other
+// tools as JSON Lines.
+type printer struct {
+	out    io.Writer
+	pretty bool
+}
+
+func (p *printer) print(v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if p.pretty {
+		var indented bytes.Buffer
+		if err := json.Indent(&indented, b, "", "  "); err != nil {
+			return err
+		}
+		b = indented.Bytes()
+	}
+	_, err = fmt.Fprintf(p.out, "%s\n", b)
+	return err
+}
+
+// dumpWire prints the messages of the connection to w, as "varlink decode"
+// does, until the returned function is called.
+func dumpWire(c *varlink.Connection, w io.Writer) func() {
+	r, pw := io.Pipe()
+	c.SetCapture(capture.NewWriter(pw))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		records := capture.NewReader(r)
+		for {
+			record, err := records.Next()
+			if err != nil {
+				r.CloseWithError(err)
+				return
+			}
+			fmt.Fprintln(w, formatRecord(record))
+		}
+	}()
+
+	return func() {
+		c.SetCapture(nil)
+		pw.Close()
+		<-done
+	}
+}
+
+// callOptions are the flags of the call command.
+type callOptions struct {
+	timeout time.Duration
+	pretty  bool
+	compact bool
+	verbose bool
+	more    bool
+	oneway  bool
+	path    string
+}
+
+// callFlags returns the flags of the call command, shared with the completion.
+func callFlags() (*flag.FlagSet, *callOptions) {
+	var o callOptions
+	flags := flag.NewFlagSet("call", flag.ContinueOnError)
+	flags.DurationVar(&o.timeout, "timeout", 0, "fail if the call does not end in time; zero means no limit")
+	flags.BoolVar(&o.pretty, "pretty", false, "print the replies indented")
+	flags.BoolVar(&o.compact, "json", false, "print every reply on one line, the default")
+	flags.BoolVar(&o.verbose, "verbose", false, "print the messages sent and received to stderr")
+	flags.BoolVar(&o.more, "more", false, "ask for more than one reply")
+	flags.BoolVar(&o.oneway, "oneway", false, "do not ask for a reply")
+	flags.StringVar(&o.path, "select", "", "print the value at the jq-style `path` of every reply, like .items[0].name")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: call [flags] ADDRESS METHOD [PARAMETERS]\n\n")
+		flags.PrintDefaults()
+		fmt.Fprintf(flags.Output(), "\nExit codes:\n"+
+			"  1  the call failed\n"+
+			"  2  invalid arguments\n"+
+			"  3  the service could not be reached\n"+
+			"  4  the timeout passed\n"+
+			"  5  the interface or method was not found or is not implemented\n"+
+			"  6  invalid parameter\n"+
+			"  7  permission denied\n"+
+			"  8  the service is not available\n"+
+			"  9  an error of the interface\n")
+	}
+	return flags, &o
+}
+
+func call(args []string) error {
+	flags, o := callFlags()
+	if err := flags.Parse(args); err != nil {
+		return &codeError{exitUsage, err}
+	}
+	if flags.NArg() < 2 || flags.NArg() > 3 {
+		return &codeError{exitUsage, fmt.Errorf("expected the address, the method and optionally the parameters")}
+	}
+	if o.pretty && o.compact {
+		return &codeError{exitUsage, fmt.Errorf("-pretty and -json are exclusive")}
+	}
+	var sel selector
+	if o.path != "" {
+		var err error
+		if sel, err = parseSelector(o.path); err != nil {
+			return &codeError{exitUsage, err}
+		}
+	}
+
+	var parameters interface{}
+	if flags.NArg() == 3 {
+		raw := json.RawMessage(flags.Arg(2))
+		if !json.Valid(raw) {
+			return &codeError{exitUsage, fmt.Errorf("the parameters are no valid JSON")}
+		}
+		parameters = raw
+	}
+	var callFlags uint64
+	if o.more {
+		callFlags |= varlink.More
+	}
+	if o.oneway {
+		callFlags |= varlink.Oneway
+	}
+
+	ctx := context.Background()
+	if o.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.timeout)
+		defer cancel()
+	}
+
+	c, err := varlink.NewConnection(ctx, flags.Arg(0))
+	if err != nil {
+		code := exitConnect
+		if isTimeout(err) {
+			code = exitTimeout
+		}
+		return &codeError{code, err}
+	}
+	defer c.Close()
+	if o.verbose {
+		stop := dumpWire(c, os.Stderr)
+		defer stop()
+	}
+
+	p := &printer{out: os.Stdout, pretty: o.pretty}
+	receive, err := c.Send(ctx, flags.Arg(1), parameters, callFlags)
+	if err != nil {
+		return &codeError{replyExitCode(err), err}
+	}
+	if o.oneway {
+		return nil
+	}
+	for {
+		var out json.RawMessage
+		replyFlags, err := receive(ctx, &out)
+		if err != nil {
+			if reply := errorReply(err); reply != nil {
+				p.print(struct {
+					Error      string          `json:"error"`
+					Parameters json.RawMessage `json:"parameters,omitempty"`
+				}{reply.Error, reply.Parameters})
+			}
+			return &codeError{replyExitCode(err), err}
+		}
+		if out == nil {
+			out = json.RawMessage("{}")
+		}
+		if sel != nil {
+			if out, err = sel.apply(out); err != nil {
+				return err
+			}
+		}
+		if err := p.print(out); err != nil {
+			return err
+		}
+		if replyFlags&varlink.Continues == 0 {
+			return nil
+		}
+	}
+}
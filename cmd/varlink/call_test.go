@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"runtime"
+	"testing"
+
+	"github.com/varlink/go/varlink/idl"
+)
+
+func TestCall(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("skipping test on windows")
+	}
+
+	midl, err := idl.New(`interface org.example.ping
+
+# -> {"method": "org.example.ping.Ping", "parameters": {"ping": "hi"}}
+# <- {"parameters": {"pong": "hi"}}
+# -> {"method": "org.example.ping.Ping", "parameters": {"ping": ""}}
+# <- {"error": "org.example.ping.Empty"}
+# -> {"method": "org.example.ping.Ping", "parameters": {"ping": "secret"}}
+# <- {"error": "org.varlink.service.PermissionDenied"}
+method Ping(ping: string) -> (pong: string)
+
+# -> {"method": "org.example.ping.Count", "more": true}
+# <- {"parameters": {"n": 1}, "continues": true}
+# <- {"parameters": {"n": 2}}
+method Count() -> (n: int)
+
+error Empty ()
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	examples, err := midl.Examples()
+	if err != nil {
+		t.Fatal(err)
+	}
+	address, stop, err := serveMock([]*mockInterface{{idl: midl, examples: examples}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+
+	for _, c := range []struct {
+		args []string
+		code int
+	}{
+		{[]string{address, "org.example.ping.Ping", `{"ping": "hi"}`}, 0},
+		{[]string{"-pretty", "-verbose", address, "org.example.ping.Ping", `{"ping": "hi"}`}, 0},
+		{[]string{"-more", "-select", ".n", address, "org.example.ping.Count"}, 0},
+		{[]string{"-select", "n", address, "org.example.ping.Count"}, exitUsage},
+		{[]string{address, "org.example.ping.Ping", `{"ping": ""}`}, exitError},
+		{[]string{address, "org.example.ping.Ping", `{"ping": "secret"}`}, exitDenied},
+		{[]string{address, "org.example.ping.Pong"}, exitNotFound},
+		{[]string{address, "org.example.pong.Ping"}, exitNotFound},
+		{[]string{address, "org.example.ping.Ping", `{"ping":`}, exitUsage},
+		{[]string{"-pretty", "-json", address, "org.example.ping.Ping"}, exitUsage},
+		{[]string{address}, exitUsage},
+		{[]string{address + ".missing", "org.example.ping.Ping"}, exitConnect},
+	} {
+		code := 0
+		if err := call(c.args); err != nil {
+			code = exitFailure
+			if e, ok := err.(*codeError); ok {
+				code = e.code
+			}
+		}
+		if code != c.code {
+			t.Errorf("call(%q) exited with %d, expected %d", c.args, code, c.code)
+		}
+	}
+}
+
+func TestSelector(t *testing.T) {
+	parameters := json.RawMessage(`{"items": [{"name": "a"}, {"name": "b", "tags": null}], "count": 2}`)
+	for path, expected := range map[string]string{
+		".":                `{"items": [{"name": "a"}, {"name": "b", "tags": null}], "count": 2}`,
+		".count":           `2`,
+		".items[1].name":   `"b"`,
+		".items[1]":        `{"name": "b", "tags": null}`,
+		".items[2].name":   `null`,
+		".items[1].tags.x": `null`,
+		".missing.field":   `null`,
+		".count.field":     `error`,
+		".items.name":      `error`,
+		"items":            `error`,
+		".items[x]":        `error`,
+		".items[0":         `error`,
+		"..count":          `error`,
+		".items[0]name":    `error`,
+		".count.":          `error`,
+	} {
+		s, err := parseSelector(path)
+		var value json.RawMessage
+		if err == nil {
+			value, err = s.apply(parameters)
+		}
+		if err != nil {
+			value = json.RawMessage("error")
+		}
+		if string(value) != expected {
+			t.Errorf("selecting %s returned %s, expected %s", path, value, expected)
+		}
+	}
+}
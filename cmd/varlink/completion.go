@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/varlink/go/varlink"
+	"github.com/varlink/go/varlink/idl"
+)
+
+// completeTimeout limits the time to ask the service for its interfaces.
+const completeTimeout = 2 * time.Second
+
+// The completion scripts pass the words of the command line up to the one
+// being completed to "varlink __complete", which prints the candidates.
+var completionScripts = map[string]string{
+	"bash": `_varlink() {
+	local cur prev words cword
+	_init_completion -n : || return
+	local IFS=$'\n'
+	COMPREPLY=($(varlink __complete "${words[@]:1:cword}" 2>/dev/null))
+	__ltrim_colon_completions "$cur"
+}
+complete -o default -F _varlink varlink
+`,
+	"zsh": `#compdef varlink
+_varlink() {
+	local -a candidates
+	candidates=(${(f)"$(varlink __complete "${(@)words[2,CURRENT]}" 2>/dev/null)"})
+	if (( ${#candidates} )); then
+		compadd -Q -a candidates
+	else
+		_files
+	fi
+}
+compdef _varlink varlink
+`,
+	"fish": `function __varlink_complete
+	set -l words (commandline -opc)
+	set -e words[1]
+	varlink __complete $words (commandline -ct) 2>/dev/null
+end
+complete -c varlink -f -a '(__varlink_complete)'
+`,
+}
+
+func completion(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("expected the shell, bash, zsh or fish")
+	}
+	script, ok := completionScripts[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown shell '%s'", args[0])
+	}
+	fmt.Print(script)
+	return nil
+}
+
+// complete prints the candidates for the last of the words.
+func complete(args []string) {
+	if len(args) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), completeTimeout)
+	defer cancel()
+	for _, candidate := range completeWords(ctx, args) {
+		fmt.Println(candidate)
+	}
+}
+
+// completeWords returns the candidates completing the last of the words
+// following "varlink": the commands, the flags and the arguments of call. The
+// methods are those of the service at the address, the parameters a template
+// with the keys of the method's input.
+func completeWords(ctx context.Context, words []string) []string {
+	cur := words[len(words)-1]
+	if len(words) == 1 {
+		var names []string
+		for name := range commands {
+			names = append(names, name)
+		}
+		return matching(names, cur)
+	}
+
+	switch words[0] {
+	case "completion":
+		if len(words) == 2 {
+			var shells []string
+			for shell := range completionScripts {
+				shells = append(shells, shell)
+			}
+			return matching(shells, cur)
+		}
+		return nil
+	case "call":
+	default:
+		return nil
+	}
+
+	flags, _ := callFlags()
+	if strings.HasPrefix(cur, "-") {
+		var names []string
+		flags.VisitAll(func(f *flag.Flag) {
+			names = append(names, "-"+f.Name)
+		})
+		return matching(names, cur)
+	}
+
+	flags.SetOutput(ioutil.Discard)
+	if flags.Parse(words[1:len(words)-1]) != nil {
+		return nil
+	}
+	switch flags.NArg() {
+	case 1:
+		methods, err := serviceMethods(ctx, flags.Arg(0))
+		if err != nil {
+			return nil
+		}
+		var names []string
+		for name := range methods {
+			names = append(names, name)
+		}
+		return matching(names, cur)
+	case 2:
+		methods, err := serviceMethods(ctx, flags.Arg(0))
+		m, ok := methods[flags.Arg(1)]
+		if err != nil || !ok {
+			return nil
+		}
+		return matching([]string{"'" + parameterTemplate(m.idl, m.method.In, 0) + "'"}, cur)
+	}
+	return nil
+}
+
+// matching returns the sorted candidates starting with the prefix.
+func matching(candidates []string, prefix string) []string {
+	var matches []string
+	for _, c := range candidates {
+		if strings.HasPrefix(c, prefix) {
+			matches = append(matches, c)
+		}
+	}
+	sort.Strings(matches)
+	return matches
+}
+
+// serviceMethod is a method of the interface described by the IDL.
+type serviceMethod struct {
+	idl    *idl.IDL
+	method *idl.Method
+}
+
+// schemaCacheDir is the directory of the interface descriptions kept between
+// completions; empty means the cache directory of the user.
+var schemaCacheDir = ""
+
+// serviceMethods returns the methods of the interfaces of the service, by
+// their fully-qualified name. The descriptions are kept in the schema cache, so
+// the methods of a service which is briefly unreachable are still completed.
+func serviceMethods(ctx context.Context, address string) (map[string]serviceMethod, error) {
+	cache, _ := varlink.NewSchemaCache(schemaCacheDir)
+	c, err := varlink.NewConnectionWithConfig(ctx, address, varlink.DialConfig{SchemaCache: cache})
+	if err != nil {
+		if cache != nil {
+			if methods, ok := cachedMethods(cache, address); ok {
+				return methods, nil
+			}
+		}
+		return nil, err
+	}
+	defer c.Close()
+
+	info, err := c.GetServiceInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	methods := make(map[string]serviceMethod)
+	for _, name := range info.Interfaces {
+		if name == "org.varlink.service" {
+			continue
+		}
+		midl, err := c.GetInterfaceIDL(ctx, name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+			continue
+		}
+		addMethods(methods, midl)
+	}
+	return methods, nil
+}
+
+// cachedMethods returns the methods of the interfaces the schema cache holds
+// for the last version of the service seen.
+func cachedMethods(cache *varlink.SchemaCache, address string) (map[string]serviceMethod, bool) {
+	info, ok := cache.Info(address)
+	if !ok {
+		return nil, false
+	}
+	methods := make(map[string]serviceMethod)
+	for _, name := range info.Interfaces {
+		description, ok := cache.Description(address, info.Version, name)
+		if !ok {
+			continue
+		}
+		if midl, err := idl.New(description); err == nil {
+			addMethods(methods, midl)
+		}
+	}
+	return methods, true
+}
+
+func addMethods(methods map[string]serviceMethod, midl *idl.IDL) {
+	for _, m := range midl.Methods {
+		methods[midl.Name+"."+m.Name] = serviceMethod{midl, m}
+	}
+}
+
+// maxTemplateDepth limits the nesting of the types of a template, which
+// may refer to themselves.
+const maxTemplateDepth = 4
+
+// parameterTemplate returns a JSON object with the fields of the struct type
+// and values of their type.
+func parameterTemplate(midl *idl.IDL, in *idl.Type, depth int) string {
+	fields := make([]string, 0, len(in.Fields))
+	for _, f := range in.Fields {
+		fields = append(fields, fmt.Sprintf("%q: %s", f.Name, templateValue(midl, f.Type, depth)))
+	}
+	return "{" + strings.Join(fields, ", ") + "}"
+}
+
+func templateValue(midl *idl.IDL, t *idl.Type, depth int) string {
+	switch t.Kind {
+	case idl.TypeBool:
+		return "false"
+	case idl.TypeInt:
+		return "0"
+	case idl.TypeFloat:
+		return "0.0"
+	case idl.TypeString:
+		return `""`
+	case idl.TypeArray:
+		return "[]"
+	case idl.TypeMaybe:
+		return "null"
+	case idl.TypeEnum:
+		if len(t.Fields) > 0 {
+			return fmt.Sprintf("%q", t.Fields[0].Name)
+		}
+		return `""`
+	case idl.TypeStruct:
+		if depth < maxTemplateDepth {
+			return parameterTemplate(midl, t, depth+1)
+		}
+	case idl.TypeAlias:
+		for _, a := range midl.Aliases {
+			if a.Name == t.Alias && depth < maxTemplateDepth {
+				return templateValue(midl, a.Type, depth+1)
+			}
+		}
+	}
+	return "{}"
+}
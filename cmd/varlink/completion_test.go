@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/varlink/go/varlink/idl"
+)
+
+func TestCompleteWords(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("skipping test on windows")
+	}
+
+	midl, err := idl.New(`interface org.example.ping
+
+type Options (loud: bool, level: (low, high))
+
+method Ping(ping: string, count: ?int, options: Options) -> (pong: string)
+method Pause() -> ()
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "completion")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	schemaCacheDir = dir
+	defer func() { schemaCacheDir = "" }()
+
+	address, stop, err := serveMock([]*mockInterface{{idl: midl}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	running := true
+	defer func() {
+		if running {
+			stop()
+		}
+	}()
+
+	for _, c := range []struct {
+		words    []string
+		expected string
+	}{
+		{[]string{"c"}, "call completion"},
+		{[]string{"completion", ""}, "bash fish zsh"},
+		{[]string{"call", "-p"}, "-pretty"},
+		{[]string{"call", "-pretty", address, "org.example.ping.P"}, "org.example.ping.Pause org.example.ping.Ping"},
+		{[]string{"call", address, "org.example.ping.Ping", ""},
+			`'{"ping": "", "count": null, "options": {"loud": false, "level": "low"}}'`},
+		{[]string{"call", address + ".missing", ""}, ""},
+		{[]string{"decode", ""}, ""},
+	} {
+		candidates := completeWords(context.Background(), c.words)
+		if got := strings.Join(candidates, " "); got != c.expected {
+			t.Errorf("completeWords(%q) = %q, expected %q", c.words, got, c.expected)
+		}
+	}
+
+	// The methods of the service are completed from the schema cache while it
+	// is unreachable
+	stop()
+	running = false
+	words := []string{"call", address, "org.example.ping.P"}
+	if got := strings.Join(completeWords(context.Background(), words), " "); got != "org.example.ping.Pause org.example.ping.Ping" {
+		t.Errorf("completeWords(%q) = %q without the service", words, got)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/varlink/go/varlink/capture"
+)
+
+// formatRecord returns a line describing the record. Messages are printed as
+// JSON, the raw data of upgraded connections is quoted.
+func formatRecord(r *capture.Record) string {
+	data := r.Data
+	if len(data) > 0 && data[len(data)-1] == 0 {
+		data = data[:len(data)-1]
+	}
+
+	text := fmt.Sprintf("%q", data)
+	if json.Valid(data) {
+		text = string(data)
+	}
+
+	return fmt.Sprintf("%s #%d %s %s", r.Time.UTC().Format(time.RFC3339Nano), r.Connection, r.Direction, text)
+}
+
+func decode(args []string) error {
+	flags := flag.NewFlagSet("decode", flag.ContinueOnError)
+	connection := flags.Uint64("connection", 0, "only print the records of the connection")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	in := io.Reader(os.Stdin)
+	switch flags.NArg() {
+	case 0:
+	case 1:
+		f, err := os.Open(flags.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	default:
+		return fmt.Errorf("too many arguments")
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+
+	r := capture.NewReader(in)
+	for {
+		record, err := r.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if *connection != 0 && record.Connection != *connection {
+			continue
+		}
+		fmt.Fprintln(out, formatRecord(record))
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/varlink/go/varlink"
+	"github.com/varlink/go/varlink/registry"
+)
+
+// interfaceName matches the names of the sockets named after an interface.
+var interfaceName = regexp.MustCompile(`^[A-Za-z]([-A-Za-z0-9]*[A-Za-z0-9])?(\.[A-Za-z0-9]([-A-Za-z0-9]*[A-Za-z0-9])?)+$`)
+
+// stringsFlag is a flag which may be given more than once.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// inventoryEntry describes a reachable service.
+type inventoryEntry struct {
+	Address    string   `json:"address"`
+	Vendor     string   `json:"vendor"`
+	Product    string   `json:"product"`
+	Version    string   `json:"version"`
+	URL        string   `json:"url"`
+	Interfaces []string `json:"interfaces"`
+}
+
+// listSources are the places the services are looked for.
+type listSources struct {
+	dirs     []string
+	registry string
+	resolver string
+	environ  []string
+}
+
+// addresses returns the addresses of the services found in the sources, with
+// the errors of the sources which could not be read. Missing directories and a
+// missing resolver are no errors.
+func (s *listSources) addresses(ctx context.Context) ([]string, []error) {
+	var addresses []string
+	var errs []error
+
+	for _, env := range s.environ {
+		if strings.HasPrefix(env, "VARLINK_ADDRESS_") {
+			if i := strings.IndexByte(env, '='); i > 0 && i < len(env)-1 {
+				addresses = append(addresses, env[i+1:])
+			}
+		}
+	}
+
+	for _, dir := range s.dirs {
+		files, err := ioutil.ReadDir(dir)
+		if err != nil && !os.IsNotExist(err) {
+			errs = append(errs, err)
+		}
+		for _, fi := range files {
+			if fi.Mode()&os.ModeSocket != 0 && interfaceName.MatchString(fi.Name()) {
+				addresses = append(addresses, "unix:"+filepath.Join(dir, fi.Name()))
+			}
+		}
+	}
+
+	if s.registry != "" {
+		files, err := filepath.Glob(filepath.Join(s.registry, "*.json"))
+		if err != nil {
+			errs = append(errs, err)
+		}
+		for _, file := range files {
+			b, err := ioutil.ReadFile(file)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			var e registry.Entry
+			if err := json.Unmarshal(b, &e); err != nil || e.Address == "" {
+				errs = append(errs, fmt.Errorf("%s: invalid registration", file))
+				continue
+			}
+			addresses = append(addresses, e.Address)
+		}
+	}
+
+	if s.resolver != "" {
+		if r, err := varlink.NewResolver(ctx, s.resolver); err == nil {
+			var interfaces []string
+			if err := r.GetInfo(ctx, nil, nil, nil, nil, &interfaces); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %v", s.resolver, err))
+			}
+			for _, iface := range interfaces {
+				if address, err := r.Resolve(ctx, iface); err == nil && address != "" {
+					addresses = append(addresses, address)
+				}
+			}
+			r.Close()
+		}
+	}
+
+	return addresses, errs
+}
+
+// inventory asks each service for its information; unreachable services are
+// reported to stderr and left out. Addresses of the same service are listed
+// once.
+func inventory(ctx context.Context, addresses []string, timeout time.Duration) []inventoryEntry {
+	seen := make(map[string]bool)
+	entries := []inventoryEntry{}
+	for _, address := range addresses {
+		if seen[address] {
+			continue
+		}
+		seen[address] = true
+
+		callCtx, cancel := context.WithTimeout(ctx, timeout)
+		e, err := serviceEntry(callCtx, address)
+		cancel()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", address, err)
+			continue
+		}
+		entries = append(entries, *e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Address < entries[j].Address })
+	return entries
+}
+
+func serviceEntry(ctx context.Context, address string) (*inventoryEntry, error) {
+	c, err := varlink.NewConnection(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	e := inventoryEntry{Address: address}
+	if err := c.GetInfo(ctx, &e.Vendor, &e.Product, &e.Version, &e.URL, &e.Interfaces); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+func list(args []string) error {
+	var dirs stringsFlag
+	flags := flag.NewFlagSet("list", flag.ContinueOnError)
+	flags.Var(&dirs, "dir", "look for the sockets named after interfaces in the `directory`, instead of /run/varlink and /run; may be repeated")
+	registryDir := flags.String("registry", "/run/varlink/registry", "read the registrations in the `directory`, empty for none")
+	resolver := flags.String("resolver", varlink.ResolverAddress, "ask the resolver at the `address` for its interfaces, empty for none")
+	timeout := flags.Duration("timeout", 2*time.Second, "timeout of the connection to every service")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if len(dirs) == 0 {
+		dirs = stringsFlag{"/run/varlink", "/run"}
+	}
+
+	ctx := context.Background()
+	resolverCtx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+	sources := listSources{dirs: dirs, registry: *registryDir, resolver: *resolver, environ: os.Environ()}
+	addresses, errs := sources.addresses(resolverCtx)
+	for _, err := range errs {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	addresses = append(addresses, flags.Args()...)
+
+	b, err := json.MarshalIndent(inventory(ctx, addresses, *timeout), "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s\n", b)
+	return nil
+}
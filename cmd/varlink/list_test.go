@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/varlink/go/varlink"
+)
+
+func TestList(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("skipping test on windows")
+	}
+
+	dir, err := ioutil.TempDir("", "varlink-list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	service, err := varlink.NewService("Varlink", "List Test", "1", "https://github.com/varlink/go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := "unix:" + filepath.Join(dir, "org.example.ping")
+	if err := service.Bind(context.Background(), address); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		service.DoListen(context.Background(), 0)
+		close(done)
+	}()
+	defer func() {
+		service.Shutdown()
+		<-done
+	}()
+
+	// Sockets not named after an interface are not asked
+	other, err := net.Listen("unix", filepath.Join(dir, "other"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+
+	// The registered address is listed once, the unreachable one not at all
+	registryDir := filepath.Join(dir, "registry")
+	os.Mkdir(registryDir, 0755)
+	ioutil.WriteFile(filepath.Join(registryDir, "org.example.ping.json"), []byte(`{"address": "`+address+`", "pid": 1}`), 0644)
+	ioutil.WriteFile(filepath.Join(registryDir, "org.example.gone.json"), []byte(`{"address": "unix:`+dir+`/gone", "pid": 1}`), 0644)
+	ioutil.WriteFile(filepath.Join(registryDir, "invalid.json"), []byte(`{`), 0644)
+
+	sources := listSources{
+		dirs:     []string{dir, filepath.Join(dir, "missing")},
+		registry: registryDir,
+		environ:  []string{"VARLINK_ADDRESS_ORG_EXAMPLE_PING=" + address, "HOME=/root"},
+	}
+	ctx := context.Background()
+	addresses, errs := sources.addresses(ctx)
+	if len(addresses) != 4 || len(errs) != 1 {
+		t.Fatalf("Unexpected addresses %v, errors %v", addresses, errs)
+	}
+
+	entries := inventory(ctx, addresses, time.Second)
+	if len(entries) != 1 || entries[0].Address != address || entries[0].Product != "List Test" ||
+		fmt.Sprint(entries[0].Interfaces) != "[org.varlink.service org.varlink.internal org.varlink.stream]" {
+		t.Fatalf("Unexpected inventory %+v", entries)
+	}
+}
@@ -0,0 +1,64 @@
+// Command varlink is a tool to inspect varlink services and their traffic, and to
+// create new ones.
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+)
+
+type command struct {
+	usage string
+	run   func(args []string) error
+}
+
+var commands = map[string]command{
+	"call":          {"call [-timeout DURATION] [-json|-pretty] [-select PATH] [-verbose] [-more|-oneway] ADDRESS METHOD [PARAMETERS]  call a method", call},
+	"completion":    {"completion bash|zsh|fish  print the script completing the commands, methods and parameters", completion},
+	"decode":        {"decode [-connection ID] [FILE]  print the messages of a capture", decode},
+	"list":          {"list [-dir DIR] [-registry DIR] [-resolver ADDRESS] [-timeout DURATION] [ADDRESS...]  print the inventory of the reachable services as JSON", list},
+	"new-service":   {"new-service [-dir DIR] [-module PATH] INTERFACE  create a Go module implementing a service", newService},
+	"systemd-units": {"systemd-units [-address ADDRESS] [-exec CMD] [-user USER] [-hardening] ... NAME  write the units to run a service", systemdUnits},
+	"test-examples": {"test-examples [-address ADDRESS] [-timeout DURATION] FILE  run the examples of the interface descriptions", testExamples},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [arguments]\n\nCommands:\n", os.Args[0])
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %s\n", commands[name].usage)
+	}
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(1)
+	}
+
+	// The completion scripts ask for the candidates of the word being completed
+	if os.Args[1] == "__complete" {
+		complete(os.Args[2:])
+		return
+	}
+
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+		os.Exit(1)
+	}
+
+	if err := cmd.run(os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[1], err)
+		code := exitFailure
+		if e, ok := err.(*codeError); ok {
+			code = e.code
+		}
+		os.Exit(code)
+	}
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/varlink/go/varlink/idl"
+	"github.com/varlink/go/varlink/systemd"
+)
+
+// scaffold describes the service created by new-service.
+type scaffold struct {
+	Interface string // org.example.foo
+	Package   string // orgexamplefoo
+	Command   string // foo
+	Module    string
+}
+
+var scaffoldFiles = []struct {
+	path     string
+	template string
+}{
+	{"go.mod", `module {{.Module}}
+
+go 1.13
+`},
+
+	{"{{.Package}}/{{.Interface}}.varlink", `# The {{.Command}} service.
+interface {{.Interface}}
+
+# Returns the ping argument as pong.
+method Ping(ping: string) -> (pong: string)
+`},
+
+	{"{{.Package}}/generate.go", `package {{.Package}}
+
+//go:generate go run github.com/varlink/go/cmd/varlink-go-interface-generator {{.Interface}}.varlink
+`},
+
+	{"main.go", `// Command {{.Command}} implements the {{.Interface}} varlink service.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/varlink/go/varlink"
+
+	"{{.Module}}/{{.Package}}"
+)
+
+type service struct {
+	{{.Package}}.VarlinkBase
+}
+
+func (s *service) Ping(ctx context.Context, c {{.Package}}.VarlinkCall, ping string) error {
+	return c.ReplyPing(ctx, ping)
+}
+
+// newService returns the varlink service with all interfaces registered.
+func newService() (*varlink.Service, error) {
+	s, err := varlink.NewService(
+		"Example",
+		"{{.Command}}",
+		"1",
+		"https://example.org/{{.Command}}",
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := varlink.RegisterAll(s, &service{}); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func main() {
+	address := flag.String("varlink", "unix:/run/{{.Interface}}", "address to listen on, ignored with socket activation")
+	flag.Parse()
+
+	s, err := newService()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "{{.Command}}: %v\n", err)
+		os.Exit(1)
+	}
+
+	// SIGTERM and SIGINT shut down, SIGHUP reloads, SIGUSR1 prints the stats
+	if err := varlink.RunWithSignals(s, *address); err != nil {
+		fmt.Fprintf(os.Stderr, "{{.Command}}: %v\n", err)
+		os.Exit(1)
+	}
+}
+`},
+
+	{"main_test.go", `package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/varlink/go/varlink"
+
+	"{{.Module}}/{{.Package}}"
+)
+
+func TestPing(t *testing.T) {
+	dir, err := ioutil.TempDir("", "{{.Command}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	address := "unix:" + filepath.Join(dir, "socket")
+
+	s, err := newService()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	servererror := make(chan error, 1)
+	go func() {
+		servererror <- s.Listen(context.Background(), address, 0)
+	}()
+	defer func() {
+		s.Shutdown()
+		if err := <-servererror; err != nil {
+			t.Fatalf("service.Listen(): %v", err)
+		}
+	}()
+	time.Sleep(time.Second / 5)
+
+	c, err := varlink.NewConnection(context.Background(), address)
+	if err != nil {
+		t.Fatalf("varlink.NewConnection(): %v", err)
+	}
+	defer c.Close()
+
+	pong, err := {{.Package}}.Ping().Call(context.Background(), c, "hello")
+	if err != nil {
+		t.Fatalf("Ping(): %v", err)
+	}
+	if pong != "hello" {
+		t.Fatalf("Ping() returned '%s'", pong)
+	}
+}
+`},
+}
+
+// newScaffold returns the scaffold of the service implementing the interface.
+func newScaffold(name string, module string) (*scaffold, error) {
+	if _, err := idl.New("interface " + name + "\nmethod Ping() -> ()\n"); err != nil {
+		return nil, fmt.Errorf("invalid interface name '%s'", name)
+	}
+
+	s := &scaffold{
+		Interface: name,
+		Package:   strings.Replace(name, ".", "", -1),
+		Command:   name[strings.LastIndex(name, ".")+1:],
+		Module:    module,
+	}
+	if s.Module == "" {
+		s.Module = name
+	}
+	return s, nil
+}
+
+// write creates the files of the scaffold in the directory, it does not
+// overwrite existing files. The systemd units are written last.
+func (s *scaffold) write(dir string) error {
+	for _, f := range scaffoldFiles {
+		path, err := s.expand(f.path)
+		if err != nil {
+			return err
+		}
+		path = filepath.Join(dir, filepath.FromSlash(path))
+
+		if _, err := os.Stat(path); err == nil {
+			return fmt.Errorf("%s already exists", path)
+		}
+
+		content, err := s.expand(f.template)
+		if err != nil {
+			return err
+		}
+
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			return err
+		}
+	}
+
+	return writeUnits(dir, &systemd.Config{
+		Name:      s.Interface,
+		Address:   "unix:/run/" + s.Interface,
+		ExecStart: "/usr/bin/" + s.Command,
+		Hardening: true,
+	})
+}
+
+func (s *scaffold) expand(text string) (string, error) {
+	t, err := template.New("").Parse(text)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	if err := t.Execute(&b, s); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+func newService(args []string) error {
+	flags := flag.NewFlagSet("new-service", flag.ContinueOnError)
+	dir := flags.String("dir", "", "directory to create, the interface name by default")
+	module := flags.String("module", "", "Go module path, the interface name by default")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() != 1 {
+		return fmt.Errorf("expected the interface name")
+	}
+
+	s, err := newScaffold(flags.Arg(0), *module)
+	if err != nil {
+		return err
+	}
+
+	if *dir == "" {
+		*dir = s.Interface
+	}
+	if err := s.write(*dir); err != nil {
+		return err
+	}
+
+	fmt.Printf("Created %s, build it with:\n\n\tcd %s\n\tgo mod tidy\n\tgo generate ./...\n\tgo test ./...\n\tgo build\n", *dir, *dir)
+	return nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewService(t *testing.T) {
+	if _, err := newScaffold("example", ""); err == nil {
+		t.Fatalf("newScaffold accepted an invalid interface name")
+	}
+
+	dir, err := ioutil.TempDir("", "varlink-new-service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := newScaffold("org.example.foo", "example.com/foo")
+	if err != nil {
+		t.Fatalf("newScaffold: %v", err)
+	}
+	if err := s.write(dir); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	for _, path := range []string{"main.go", "main_test.go", "orgexamplefoo/generate.go"} {
+		if _, err := parser.ParseFile(token.NewFileSet(), filepath.Join(dir, path), nil, 0); err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+	}
+	for _, path := range []string{"go.mod", "orgexamplefoo/org.example.foo.varlink", "org.example.foo.service", "org.example.foo.socket"} {
+		if _, err := os.Stat(filepath.Join(dir, path)); err != nil {
+			t.Fatalf("%s is missing: %v", path, err)
+		}
+	}
+
+	if err := s.write(dir); err == nil {
+		t.Fatalf("write overwrote the existing files")
+	}
+}
+
+// TestNewServiceBuilds generates the code of a new service and builds it
+// against this tree, without the network.
+func TestNewServiceBuilds(t *testing.T) {
+	if testing.Short() {
+		t.Skip("building the new service takes a while")
+	}
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("the go command is not available")
+	}
+	root, err := filepath.Abs(filepath.Join("..", ".."))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "varlink-new-service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := newScaffold("org.example.foo", "example.com/foo")
+	if err != nil {
+		t.Fatalf("newScaffold: %v", err)
+	}
+	if err := s.write(dir); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	f, err := os.OpenFile(filepath.Join(dir, "go.mod"), os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.WriteString("\nrequire github.com/varlink/go v0.0.0\n\nreplace github.com/varlink/go => " + root + "\n")
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, args := range [][]string{
+		{"generate", "./..."},
+		{"build", "./..."},
+		{"vet", "./..."},
+	} {
+		cmd := exec.Command(goTool, args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), "GOFLAGS=-mod=mod", "GOPROXY=off", "GOWORK=off")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("go %s: %v\n%s", args[0], err, out)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// selectorStep is a field name, or an array index if field is empty.
+type selectorStep struct {
+	field string
+	index int
+}
+
+// selector picks a value out of the parameters of a reply, with a jq-style
+// path like ".items[0].name"; "." selects the parameters.
+type selector []selectorStep
+
+func parseSelector(path string) (selector, error) {
+	if !strings.HasPrefix(path, ".") {
+		return nil, fmt.Errorf("the path '%s' does not start with '.'", path)
+	}
+
+	var s selector
+	rest := path[1:]
+	for rest != "" {
+		switch {
+		case rest[0] == '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated index in '%s'", path)
+			}
+			index, err := strconv.Atoi(rest[1:end])
+			if err != nil || index < 0 {
+				return nil, fmt.Errorf("invalid index '%s' in '%s'", rest[1:end], path)
+			}
+			s = append(s, selectorStep{index: index})
+			rest = rest[end+1:]
+
+		default:
+			// Fields following a step are separated by a dot
+			if len(s) > 0 {
+				if rest[0] != '.' {
+					return nil, fmt.Errorf("missing '.' before '%s' in '%s'", rest, path)
+				}
+				rest = rest[1:]
+			}
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("empty field name in '%s'", path)
+			}
+			s = append(s, selectorStep{field: rest[:end]})
+			rest = rest[end:]
+		}
+	}
+	return s, nil
+}
+
+// apply returns the selected value, null if it does not exist, like jq.
+func (s selector) apply(parameters json.RawMessage) (json.RawMessage, error) {
+	value := parameters
+	for _, step := range s {
+		if step.field != "" {
+			var object map[string]json.RawMessage
+			if err := json.Unmarshal(value, &object); err != nil {
+				return nil, fmt.Errorf("cannot select '%s' of a value which is no object", step.field)
+			}
+			value = object[step.field]
+		} else {
+			var array []json.RawMessage
+			if err := json.Unmarshal(value, &array); err != nil {
+				return nil, fmt.Errorf("cannot select [%d] of a value which is no array", step.index)
+			}
+			value = nil
+			if step.index < len(array) {
+				value = array[step.index]
+			}
+		}
+		if value == nil || string(value) == "null" {
+			return json.RawMessage("null"), nil
+		}
+	}
+	return value, nil
+}
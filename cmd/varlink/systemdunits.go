@@ -0,0 +1,53 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/varlink/go/varlink/systemd"
+)
+
+// writeUnits writes the .service and .socket units of the config to the directory.
+func writeUnits(dir string, config *systemd.Config) error {
+	service, err := config.ServiceUnit()
+	if err != nil {
+		return err
+	}
+	socket, err := config.SocketUnit()
+	if err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, config.Name+".service"), []byte(service), 0644); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, config.Name+".socket"), []byte(socket), 0644)
+}
+
+func systemdUnits(args []string) error {
+	var config systemd.Config
+	flags := flag.NewFlagSet("systemd-units", flag.ContinueOnError)
+	flags.StringVar(&config.Address, "address", "", "address of the service, unix:/run/NAME by default")
+	flags.StringVar(&config.ExecStart, "exec", "", "command line starting the service")
+	flags.StringVar(&config.Description, "description", "", "description of the units")
+	flags.StringVar(&config.User, "user", "", "user running the service, a dynamic user by default")
+	flags.StringVar(&config.Group, "group", "", "group running the service")
+	flags.StringVar(&config.SocketMode, "mode", "", "file mode of the unix socket")
+	flags.BoolVar(&config.Hardening, "hardening", false, "sandbox the service")
+	dir := flags.String("dir", ".", "directory to write the units to")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() != 1 {
+		return fmt.Errorf("expected the unit name")
+	}
+	config.Name = flags.Arg(0)
+	if config.Address == "" {
+		config.Address = "unix:/run/" + config.Name
+	}
+
+	return writeUnits(*dir, &config)
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/varlink/go/varlink"
+	"github.com/varlink/go/varlink/idl"
+)
+
+// exampleCall and exampleReply are the messages of an example.
+type exampleCall struct {
+	Method     string          `json:"method"`
+	Parameters json.RawMessage `json:"parameters"`
+	More       bool            `json:"more"`
+	Oneway     bool            `json:"oneway"`
+}
+
+type exampleReply struct {
+	Parameters json.RawMessage `json:"parameters"`
+	Continues  bool            `json:"continues"`
+	Error      string          `json:"error"`
+}
+
+// sameJSON reports whether the values are equal, with a missing value equal to
+// an empty object, like the parameters of a reply.
+func sameJSON(a json.RawMessage, b json.RawMessage) bool {
+	decode := func(raw json.RawMessage) interface{} {
+		var v interface{}
+		if len(raw) > 0 {
+			json.Unmarshal(raw, &v)
+		}
+		if v == nil {
+			v = map[string]interface{}{}
+		}
+		return v
+	}
+	return reflect.DeepEqual(decode(a), decode(b))
+}
+
+// mockInterface replies to the calls of the examples with their replies.
+type mockInterface struct {
+	idl      *idl.IDL
+	examples []idl.Example
+}
+
+func (m *mockInterface) VarlinkDispatch(ctx context.Context, call varlink.Call, methodname string) error {
+	var parameters json.RawMessage
+	call.GetParameters(&parameters)
+
+	for _, e := range m.examples {
+		var in exampleCall
+		if json.Unmarshal([]byte(e.Call), &in) != nil || in.Method != m.idl.Name+"."+methodname || !sameJSON(in.Parameters, parameters) {
+			continue
+		}
+
+		for _, r := range e.Replies {
+			var reply exampleReply
+			if err := json.Unmarshal([]byte(r), &reply); err != nil {
+				return err
+			}
+			call.Continues = reply.Continues
+			if err := mockReply(ctx, &call, &reply); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return call.ReplyMethodNotImplemented(ctx, m.idl.Name+"."+methodname)
+}
+
+func mockReply(ctx context.Context, call *varlink.Call, reply *exampleReply) error {
+	var parameters interface{}
+	if len(reply.Parameters) > 0 {
+		parameters = reply.Parameters
+	}
+	if reply.Error == "" {
+		return call.Reply(ctx, parameters)
+	}
+
+	// The errors of org.varlink.service are only sent by their reply methods
+	var p map[string]string
+	json.Unmarshal(reply.Parameters, &p)
+	switch reply.Error {
+	case "org.varlink.service.InterfaceNotFound":
+		return call.ReplyInterfaceNotFound(ctx, p["interface"])
+	case "org.varlink.service.MethodNotFound":
+		return call.ReplyMethodNotFound(ctx, p["method"])
+	case "org.varlink.service.MethodNotImplemented":
+		return call.ReplyMethodNotImplemented(ctx, p["method"])
+	case "org.varlink.service.InvalidParameter":
+		return call.ReplyInvalidParameter(ctx, p["parameter"])
+	case "org.varlink.service.PermissionDenied":
+		return call.ReplyPermissionDenied(ctx)
+	case "org.varlink.service.ServiceNotAvailable":
+		return call.ReplyServiceNotAvailable(ctx)
+	}
+	return call.ReplyError(ctx, reply.Error, parameters)
+}
+
+func (m *mockInterface) VarlinkGetName() string {
+	return m.idl.Name
+}
+
+func (m *mockInterface) VarlinkGetDescription() string {
+	return m.idl.Description
+}
+
+// errorReply returns the reply of an error returned by a receive function, or
+// nil if the error is no error reply.
+func errorReply(err error) *exampleReply {
+	if e, ok := err.(*varlink.Error); ok {
+		reply := &exampleReply{Error: e.Name}
+		if raw, ok := e.Parameters.(*json.RawMessage); ok && raw != nil {
+			reply.Parameters = *raw
+		}
+		return reply
+	}
+
+	// The errors of org.varlink.service are converted to their types
+	if name := err.Error(); strings.HasPrefix(name, "org.varlink.service.") && !strings.Contains(name, " ") {
+		parameters, _ := json.Marshal(err)
+		return &exampleReply{Error: name, Parameters: parameters}
+	}
+	return nil
+}
+
+// runExample sends the call of the example and compares the replies to the
+// expected ones. Replies which do not match the interface description fail the
+// example as well.
+func runExample(ctx context.Context, address string, e idl.Example) error {
+	var in exampleCall
+	if err := json.Unmarshal([]byte(e.Call), &in); err != nil {
+		return err
+	}
+	if !in.Oneway && len(e.Replies) == 0 {
+		return fmt.Errorf("the example has no reply")
+	}
+
+	c, err := varlink.NewConnection(ctx, address)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	var diagnostics []string
+	c.SetDiagnostics(func(d varlink.Diagnostic) {
+		diagnostics = append(diagnostics, d.String())
+	})
+
+	var flags uint64
+	if in.More {
+		flags |= varlink.More
+	}
+	if in.Oneway {
+		flags |= varlink.Oneway
+	}
+	var parameters interface{}
+	if len(in.Parameters) > 0 {
+		parameters = in.Parameters
+	}
+	receive, err := c.Send(ctx, in.Method, parameters, flags)
+	if err != nil {
+		return err
+	}
+
+	for i, r := range e.Replies {
+		var expected exampleReply
+		if err := json.Unmarshal([]byte(r), &expected); err != nil {
+			return err
+		}
+
+		var out json.RawMessage
+		flags, err := receive(ctx, &out)
+		reply := &exampleReply{Parameters: out, Continues: flags&varlink.Continues != 0}
+		if err != nil {
+			if reply = errorReply(err); reply == nil {
+				return err
+			}
+		}
+
+		if reply.Error != expected.Error || reply.Continues != expected.Continues || !sameJSON(reply.Parameters, expected.Parameters) {
+			got, _ := json.Marshal(reply)
+			return fmt.Errorf("reply %d is %s, expected %s", i, got, r)
+		}
+		if len(diagnostics) > 0 {
+			return fmt.Errorf("reply %d does not match the interface: %s", i, strings.Join(diagnostics, "; "))
+		}
+		if !reply.Continues && i < len(e.Replies)-1 {
+			return fmt.Errorf("reply %d is the last one, expected %d replies", i, len(e.Replies))
+		}
+		if reply.Continues && i == len(e.Replies)-1 {
+			return fmt.Errorf("more than the expected %d replies", len(e.Replies))
+		}
+	}
+	return nil
+}
+
+// serveMock serves the interfaces replying the replies of the examples, and
+// returns its address and the function stopping it.
+func serveMock(interfaces []*mockInterface) (string, func(), error) {
+	service, err := varlink.NewService("Varlink", "Examples Mock", "1", "https://github.com/varlink/go")
+	if err != nil {
+		return "", nil, err
+	}
+	for _, m := range interfaces {
+		if err := service.RegisterInterface(m); err != nil {
+			return "", nil, err
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "varlink-test-examples")
+	if err != nil {
+		return "", nil, err
+	}
+	address := "unix:" + filepath.Join(dir, "mock")
+	if err := service.Bind(context.Background(), address); err != nil {
+		os.RemoveAll(dir)
+		return "", nil, err
+	}
+
+	done := make(chan struct{})
+	go func() {
+		service.DoListen(context.Background(), 0)
+		close(done)
+	}()
+
+	return address, func() {
+		service.Shutdown()
+		<-done
+		os.RemoveAll(dir)
+	}, nil
+}
+
+func testExamples(args []string) error {
+	flags := flag.NewFlagSet("test-examples", flag.ContinueOnError)
+	address := flags.String("address", "", "run the examples against the service, instead of a mock replying their replies")
+	timeout := flags.Duration("timeout", 10*time.Second, "timeout of every example")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("expected one interface description file")
+	}
+
+	description, err := ioutil.ReadFile(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	idls, err := idl.NewAll(string(description))
+	if err != nil {
+		return err
+	}
+
+	var interfaces []*mockInterface
+	var count int
+	for _, midl := range idls {
+		examples, err := midl.Examples()
+		if err != nil {
+			return fmt.Errorf("%s: %v", midl.Name, err)
+		}
+		interfaces = append(interfaces, &mockInterface{idl: midl, examples: examples})
+		count += len(examples)
+	}
+	if count == 0 {
+		return fmt.Errorf("no examples found")
+	}
+
+	// Without a service, the examples check that their replies match the
+	// interface description
+	if *address == "" {
+		mock, stop, err := serveMock(interfaces)
+		if err != nil {
+			return err
+		}
+		defer stop()
+		*address = mock
+	}
+
+	failed := 0
+	for _, m := range interfaces {
+		for _, e := range m.examples {
+			name := m.idl.Name
+			if e.Member != "" {
+				name += "." + e.Member
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+			err := runExample(ctx, *address, e)
+			cancel()
+			if err != nil {
+				failed++
+				fmt.Printf("FAIL %s: %s\n     %v\n", name, e.Call, err)
+				continue
+			}
+			fmt.Printf("ok   %s: %s\n", name, e.Call)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d examples failed", failed, count)
+	}
+	return nil
+}
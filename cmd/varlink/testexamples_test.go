@@ -0,0 +1,62 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestTestExamples(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("skipping test on windows")
+	}
+
+	dir, err := ioutil.TempDir("", "varlink-test-examples")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	run := func(description string) error {
+		file := filepath.Join(dir, "org.example.ping.varlink")
+		if err := ioutil.WriteFile(file, []byte(description), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return testExamples([]string{file})
+	}
+
+	description := `interface org.example.ping
+
+# Ping the service.
+# -> {"method": "org.example.ping.Ping", "parameters": {"ping": "hi"}}
+# <- {"parameters": {"pong": "hi"}}
+# -> {"method": "org.example.ping.Ping", "parameters": {"ping": ""}}
+# <- {"error": "org.example.ping.Empty"}
+method Ping(ping: string) -> (pong: string)
+
+# Count to three.
+# -> {"method": "org.example.ping.Count", "more": true}
+# <- {"parameters": {"n": 1}, "continues": true}
+# <- {"parameters": {"n": 2}, "continues": true}
+# <- {"parameters": {"n": 3}}
+method Count() -> (n: int)
+
+error Empty ()
+`
+	if err := run(description); err != nil {
+		t.Fatalf("testExamples(): %v", err)
+	}
+
+	// The mock replies what the example expects, but the interface disagrees
+	wrong := strings.Replace(description, `{"pong": "hi"}`, `{"pong": 1}`, -1)
+	if err := run(wrong); err == nil || err.Error() != "1 of 3 examples failed" {
+		t.Fatalf("testExamples() returned %v", err)
+	}
+
+	if err := run("interface org.example.ping\nmethod Ping() -> ()\n"); err == nil {
+		t.Fatal("testExamples() succeeded without examples")
+	}
+}
@@ -0,0 +1,137 @@
+// Package console attaches clients to a console over an upgraded connection.
+// After the reply to Attach, the connection no longer carries varlink messages
+// but the console protocol of the upgrade package, which the service speaks with
+// upgrade.ConsoleServer and the client with upgrade.ConsoleClient.
+//
+// The console of the example is a line-based shell knowing the commands echo,
+// size and exit:
+//
+//	c, err := varlink.NewConnection(ctx, "unix:/run/org.example.console")
+//	err = console.Attach(ctx, c, os.Stdin, os.Stdout, nil)
+package console
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/varlink/go/examples/console/orgexampleconsole"
+	"github.com/varlink/go/varlink"
+	"github.com/varlink/go/varlink/upgrade"
+)
+
+var errDetached = errors.New("console: detached")
+
+// Console implements org.example.console, with a shell of its own for every
+// client.
+type Console struct{}
+
+// Attach serves a shell on the upgraded connection.
+func (c *Console) Attach(ctx context.Context, call orgexampleconsole.VarlinkCall) error {
+	if !call.WantsUpgrade() {
+		return call.ReplyInvalidParameter(ctx, "upgrade")
+	}
+	if err := call.ReplyAttach(ctx); err != nil {
+		return err
+	}
+
+	sh := newShell()
+	defer sh.Close()
+	server := upgrade.ConsoleServer{Console: sh, Resize: sh.resize}
+	if err := server.Serve(ctx, call.Conn); err != nil {
+		return err
+	}
+
+	// The upgraded protocol ends with the connection
+	return errDetached
+}
+
+// shell runs the commands typed on the console.
+type shell struct {
+	input  *io.PipeWriter
+	output *io.PipeReader
+
+	mutex sync.Mutex
+	size  upgrade.WindowSize
+}
+
+func newShell() *shell {
+	inputR, inputW := io.Pipe()
+	outputR, outputW := io.Pipe()
+	sh := &shell{input: inputW, output: outputR}
+	go sh.run(inputR, outputW)
+	return sh
+}
+
+// Read reads the output of the shell, Write types the input.
+func (sh *shell) Read(b []byte) (int, error) {
+	return sh.output.Read(b)
+}
+
+func (sh *shell) Write(b []byte) (int, error) {
+	return sh.input.Write(b)
+}
+
+// Close stops the shell.
+func (sh *shell) Close() error {
+	sh.input.Close()
+	return sh.output.Close()
+}
+
+func (sh *shell) resize(size upgrade.WindowSize) error {
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+	sh.size = size
+	return nil
+}
+
+// run runs the commands of the input until exit, which ends the output and the
+// console session.
+func (sh *shell) run(input io.Reader, output *io.PipeWriter) {
+	defer output.Close()
+
+	lines := bufio.NewScanner(input)
+	for fmt.Fprint(output, "> "); lines.Scan(); fmt.Fprint(output, "> ") {
+		command := strings.Fields(lines.Text())
+		if len(command) == 0 {
+			continue
+		}
+
+		switch command[0] {
+		case "echo":
+			fmt.Fprintln(output, strings.Join(command[1:], " "))
+		case "size":
+			sh.mutex.Lock()
+			size := sh.size
+			sh.mutex.Unlock()
+			fmt.Fprintf(output, "%dx%d\n", size.Cols, size.Rows)
+		case "exit":
+			fmt.Fprintln(output, "bye")
+			return
+		default:
+			fmt.Fprintf(output, "%s: unknown command\n", command[0])
+		}
+	}
+}
+
+// Attach attaches the terminal to the console of the service on the
+// connection, until the console ends or the detach keys, ctrl-p ctrl-q, are
+// typed. The connection is upgraded, it cannot be used for other calls
+// afterwards.
+func Attach(ctx context.Context, c *varlink.Connection, stdin io.Reader, stdout io.Writer, resize <-chan upgrade.WindowSize) error {
+	receive, err := orgexampleconsole.Attach().Upgrade(ctx, c)
+	if err != nil {
+		return err
+	}
+	_, conn, err := receive(ctx)
+	if err != nil {
+		return err
+	}
+
+	client := upgrade.ConsoleClient{Stdin: stdin, Stdout: stdout, Resize: resize}
+	return client.Attach(ctx, conn)
+}
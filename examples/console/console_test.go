@@ -0,0 +1,59 @@
+package console
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/varlink/go/examples/console/orgexampleconsole"
+	"github.com/varlink/go/varlink"
+	"github.com/varlink/go/varlink/upgrade"
+	"github.com/varlink/go/varlink/varlinktest"
+)
+
+func TestConsole(t *testing.T) {
+	address, stop := varlinktest.Serve(t, orgexampleconsole.VarlinkNew(&Console{}))
+	defer stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	c, err := varlink.NewConnection(ctx, address)
+	if err != nil {
+		t.Fatalf("NewConnection(): %v", err)
+	}
+	defer c.Close()
+
+	// Attach is only served on an upgraded connection
+	if err := orgexampleconsole.Attach().Call(ctx, c); err == nil {
+		t.Fatal("Attach() without upgrade succeeded")
+	}
+
+	stdin, typed := io.Pipe()
+	output, stdout := io.Pipe()
+	resize := make(chan upgrade.WindowSize, 1)
+	resize <- upgrade.WindowSize{Rows: 24, Cols: 80}
+	attached := make(chan error, 1)
+	go func() {
+		attached <- Attach(ctx, c, stdin, stdout, resize)
+		stdout.Close()
+	}()
+
+	screen := bufio.NewReader(output)
+	for _, step := range []struct{ input, output string }{
+		{"echo hello  world\n", "> hello world\n"},
+		{"size\n", "> 80x24\n"},
+		{"ls\n", "> ls: unknown command\n"},
+		{"exit\n", "> bye\n"},
+	} {
+		typed.Write([]byte(step.input))
+		line, err := screen.ReadString('\n')
+		if err != nil || line != step.output {
+			t.Fatalf("The console replied %q to %q, %v", line, step.input, err)
+		}
+	}
+	if err := <-attached; err != nil {
+		t.Fatalf("Attach(): %v", err)
+	}
+}
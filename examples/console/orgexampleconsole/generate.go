@@ -0,0 +1,3 @@
+package orgexampleconsole
+
+//go:generate go run ../../../cmd/varlink-go-interface-generator/main.go org.example.console.varlink
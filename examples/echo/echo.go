@@ -0,0 +1,37 @@
+// Package echo is the smallest varlink service: its method replies with the
+// text it is called with. The code of the org.example.echo interface is
+// generated from its description by varlink-go-interface-generator, the
+// service implements the methods of the generated interface:
+//
+//	service, err := varlink.NewService("Example", "Echo", "1", "https://github.com/varlink/go")
+//	service.RegisterInterface(orgexampleecho.VarlinkNew(&echo.Echo{}))
+//	err = service.Listen(ctx, "unix:/run/org.example.echo", 0)
+//
+// Clients call it with the generated client methods, which return the errors
+// of the interface as their generated types:
+//
+//	c, err := varlink.NewConnection(ctx, "unix:/run/org.example.echo")
+//	text, err := orgexampleecho.Echo().Call(ctx, c, "hello", nil)
+//	if errors.Is(err, orgexampleecho.ErrEmpty) {
+package echo
+
+import (
+	"context"
+	"strings"
+
+	"github.com/varlink/go/examples/echo/orgexampleecho"
+)
+
+// Echo implements org.example.echo.
+type Echo struct{}
+
+// Echo replies with the text.
+func (e *Echo) Echo(ctx context.Context, c orgexampleecho.VarlinkCall, text string, shout *bool) error {
+	if text == "" {
+		return c.ReplyEmpty(ctx)
+	}
+	if shout != nil && *shout {
+		text = strings.ToUpper(text)
+	}
+	return c.ReplyEcho(ctx, text)
+}
@@ -0,0 +1,53 @@
+package echo
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/varlink/go/examples/echo/orgexampleecho"
+	"github.com/varlink/go/varlink"
+	"github.com/varlink/go/varlink/varlinktest"
+)
+
+// contract are the replies the clients of the service rely on.
+const contract = `{"interactions": [
+	{"call": {"method": "org.example.echo.Echo", "parameters": {"text": "hello"}}, "replies": [{"parameters": {"text": "hello"}}]},
+	{"call": {"method": "org.example.echo.Echo", "parameters": {"text": "hello", "shout": true}}, "replies": [{"parameters": {"text": "HELLO"}}]},
+	{"call": {"method": "org.example.echo.Echo", "parameters": {"text": ""}}, "replies": [{"error": "org.example.echo.Empty"}]}
+]}`
+
+func TestContract(t *testing.T) {
+	address, stop := varlinktest.Serve(t, orgexampleecho.VarlinkNew(&Echo{}))
+	defer stop()
+
+	c, err := varlinktest.ReadContract(strings.NewReader(contract))
+	if err != nil {
+		t.Fatalf("ReadContract(): %v", err)
+	}
+	if err := varlinktest.Verify(context.Background(), address, c); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClient(t *testing.T) {
+	address, stop := varlinktest.Serve(t, orgexampleecho.VarlinkNew(&Echo{}))
+	defer stop()
+
+	ctx := context.Background()
+	c, err := varlink.NewConnection(ctx, address)
+	if err != nil {
+		t.Fatalf("NewConnection(): %v", err)
+	}
+	defer c.Close()
+
+	shout := true
+	text, err := orgexampleecho.Echo().Call(ctx, c, "hello", &shout)
+	if err != nil || text != "HELLO" {
+		t.Fatalf("Echo() returned %s, %v", text, err)
+	}
+	if _, err := orgexampleecho.Echo().Call(ctx, c, "", nil); !errors.Is(err, orgexampleecho.ErrEmpty) {
+		t.Fatalf("Echo() returned %v", err)
+	}
+}
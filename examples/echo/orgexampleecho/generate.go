@@ -0,0 +1,3 @@
+package orgexampleecho
+
+//go:generate go run ../../../cmd/varlink-go-interface-generator/main.go org.example.echo.varlink
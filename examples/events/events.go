@@ -0,0 +1,152 @@
+// Package events streams events with replies carrying the continues flag. A
+// call of Watch with the more flag is answered with an event reply whenever an
+// event is published, until the client hangs up or the service shuts down; the
+// context of Call.Context tells the handler when to stop.
+//
+// The client keeps its stream with a varlink.Subscription, which dials again
+// after the connection failed and resumes the stream after the last event:
+//
+//	s := events.Subscribe("unix:/run/org.example.events")
+//	defer s.Close()
+//	for {
+//		event, err := s.Next(ctx)
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"sync"
+
+	"github.com/varlink/go/examples/events/orgexampleevents"
+	"github.com/varlink/go/varlink"
+)
+
+// Events implements org.example.events.
+type Events struct {
+	// Keep is the number of the recent events kept for the subscribers resuming
+	// their stream; zero means 100.
+	Keep int
+
+	mutex  sync.Mutex
+	events []orgexampleevents.Event
+	last   int64
+	// published is closed when the next event is published
+	published chan struct{}
+}
+
+// since returns the kept events following the event with the id, and the
+// channel closed when the next event is published.
+func (e *Events) since(id int64) ([]orgexampleevents.Event, <-chan struct{}) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.published == nil {
+		e.published = make(chan struct{})
+	}
+
+	var events []orgexampleevents.Event
+	for _, event := range e.events {
+		if event.Id > id {
+			events = append(events, event)
+		}
+	}
+	return events, e.published
+}
+
+// Publish sends the message to the subscribers.
+func (e *Events) Publish(ctx context.Context, c orgexampleevents.VarlinkCall, message string) error {
+	keep := e.Keep
+	if keep <= 0 {
+		keep = 100
+	}
+
+	e.mutex.Lock()
+	e.last++
+	event := orgexampleevents.Event{Id: e.last, Message: message}
+	e.events = append(e.events, event)
+	if len(e.events) > keep {
+		e.events = e.events[len(e.events)-keep:]
+	}
+	if e.published != nil {
+		close(e.published)
+	}
+	e.published = make(chan struct{})
+	e.mutex.Unlock()
+
+	return c.ReplyPublish(ctx, event)
+}
+
+// Watch streams the events.
+func (e *Events) Watch(ctx context.Context, c orgexampleevents.VarlinkCall, after *int64, count *int64) error {
+	var id int64
+	if after != nil {
+		id = *after
+	} else {
+		e.mutex.Lock()
+		id = e.last
+		e.mutex.Unlock()
+	}
+
+	var sent int64
+	for {
+		events, published := e.since(id)
+		for _, event := range events {
+			sent++
+			c.Continues = c.WantsMore() && (count == nil || sent < *count)
+			if err := c.ReplyWatch(ctx, event); err != nil {
+				return err
+			}
+			if !c.Continues {
+				return nil
+			}
+			id = event.Id
+		}
+
+		// The stream of a client which hung up ends with the context of the call
+		select {
+		case <-published:
+		case <-c.Context().Done():
+			return c.Context().Err()
+		}
+	}
+}
+
+// Subscription receives the events of the service.
+type Subscription struct {
+	*varlink.Subscription
+}
+
+// Subscribe returns a subscription to all events of the service at the
+// address, the kept ones first.
+func Subscribe(address string) *Subscription {
+	return &Subscription{varlink.NewSubscription(varlink.SubscriptionConfig{
+		Dial: func(ctx context.Context) (*varlink.Connection, error) {
+			return varlink.NewConnection(ctx, address)
+		},
+		Method: "org.example.events.Watch",
+		Parameters: func(resume string) interface{} {
+			after, _ := strconv.ParseInt(resume, 10, 64)
+			return map[string]int64{"after": after}
+		},
+		Token: func(reply json.RawMessage) string {
+			var out struct {
+				Event orgexampleevents.Event `json:"event"`
+			}
+			json.Unmarshal(reply, &out)
+			return strconv.FormatInt(out.Event.Id, 10)
+		},
+	})}
+}
+
+// Next returns the next event.
+func (s *Subscription) Next(ctx context.Context) (orgexampleevents.Event, error) {
+	var out struct {
+		Event orgexampleevents.Event `json:"event"`
+	}
+	reply, err := s.Subscription.Next(ctx)
+	if err != nil {
+		return out.Event, err
+	}
+	err = json.Unmarshal(reply, &out)
+	return out.Event, err
+}
@@ -0,0 +1,62 @@
+package events
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/varlink/go/examples/events/orgexampleevents"
+	"github.com/varlink/go/varlink"
+	"github.com/varlink/go/varlink/varlinktest"
+)
+
+// contract streams the kept events, only the last two are kept.
+const contract = `{"interactions": [
+	{"call": {"method": "org.example.events.Publish", "parameters": {"message": "a"}}, "replies": [{"parameters": {"event": {"id": 1, "message": "a"}}}]},
+	{"call": {"method": "org.example.events.Publish", "parameters": {"message": "b"}}, "replies": [{"parameters": {"event": {"id": 2, "message": "b"}}}]},
+	{"call": {"method": "org.example.events.Publish", "parameters": {"message": "c"}}, "replies": [{"parameters": {"event": {"id": 3, "message": "c"}}}]},
+	{"call": {"method": "org.example.events.Watch", "parameters": {"after": 0, "count": 2}, "more": true}, "replies": [
+		{"parameters": {"event": {"id": 2, "message": "b"}}, "continues": true},
+		{"parameters": {"event": {"id": 3, "message": "c"}}}
+	]},
+	{"call": {"method": "org.example.events.Watch", "parameters": {"after": 2}}, "replies": [{"parameters": {"event": {"id": 3, "message": "c"}}}]}
+]}`
+
+func TestContract(t *testing.T) {
+	address, stop := varlinktest.Serve(t, orgexampleevents.VarlinkNew(&Events{Keep: 2}))
+	defer stop()
+
+	c, err := varlinktest.ReadContract(strings.NewReader(contract))
+	if err != nil {
+		t.Fatalf("ReadContract(): %v", err)
+	}
+	if err := varlinktest.Verify(context.Background(), address, c); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSubscription(t *testing.T) {
+	address, stop := varlinktest.Serve(t, orgexampleevents.VarlinkNew(&Events{Keep: 2}))
+	defer stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	c, err := varlink.NewConnection(ctx, address)
+	if err != nil {
+		t.Fatalf("NewConnection(): %v", err)
+	}
+	defer c.Close()
+
+	s := Subscribe(address)
+	defer s.Close()
+	for _, message := range []string{"a", "b", "c"} {
+		if _, err := orgexampleevents.Publish().Call(ctx, c, message); err != nil {
+			t.Fatalf("Publish(): %v", err)
+		}
+		event, err := s.Next(ctx)
+		if err != nil || event.Message != message {
+			t.Fatalf("Next() returned %v, %v", event, err)
+		}
+	}
+}
@@ -0,0 +1,3 @@
+package orgexampleevents
+
+//go:generate go run ../../../cmd/varlink-go-interface-generator/main.go org.example.events.varlink
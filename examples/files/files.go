@@ -0,0 +1,132 @@
+// Package files transfers files in chunks, which the service streams as the
+// replies of one call with the more flag. The client writes every chunk as it
+// arrives, so neither side holds the whole file:
+//
+//	c, err := varlink.NewConnection(ctx, "unix:/run/org.example.files")
+//	err = files.Download(ctx, c, "report.pdf", w)
+//
+// On unix sockets, a service can pass large data as a file descriptor instead,
+// with Call.AttachFile and varlink.Blob.
+package files
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/varlink/go/examples/files/orgexamplefiles"
+	"github.com/varlink/go/varlink"
+)
+
+// defaultChunkSize is the size of the chunks if the client did not ask for one.
+const defaultChunkSize = 64 * 1024
+
+// Files implements org.example.files, for the regular files of the directory.
+type Files struct {
+	Dir string
+}
+
+// List returns the files.
+func (f *Files) List(ctx context.Context, c orgexamplefiles.VarlinkCall) error {
+	infos, err := ioutil.ReadDir(f.Dir)
+	if err != nil {
+		return err
+	}
+	files := []orgexamplefiles.File{}
+	for _, fi := range infos {
+		if fi.Mode()&os.ModeType == 0 {
+			files = append(files, orgexamplefiles.File{Name: fi.Name(), Size: fi.Size()})
+		}
+	}
+	return c.ReplyList(ctx, files)
+}
+
+// Download streams the file.
+func (f *Files) Download(ctx context.Context, c orgexamplefiles.VarlinkCall, name string, chunkSize *int64) error {
+	size := int64(defaultChunkSize)
+	if chunkSize != nil {
+		if *chunkSize <= 0 {
+			return c.ReplyInvalidParameter(ctx, "chunk_size")
+		}
+		size = *chunkSize
+	}
+	// The name cannot leave the directory
+	if name == "" || strings.ContainsAny(name, `/\`) || name == "." || name == ".." {
+		return c.ReplyNotFound(ctx, name)
+	}
+
+	file, err := os.Open(filepath.Join(f.Dir, name))
+	if os.IsNotExist(err) {
+		return c.ReplyNotFound(ctx, name)
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if !c.WantsMore() {
+		data, err := ioutil.ReadAll(file)
+		if err != nil {
+			return err
+		}
+		return c.ReplyDownload(ctx, 0, base64.StdEncoding.EncodeToString(data))
+	}
+
+	// The chunk ending at the size of the file is the last one, an empty one
+	// for an empty file
+	fi, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	chunk := make([]byte, size)
+	var offset int64
+	for {
+		n, err := io.ReadFull(file, chunk)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		c.Continues = n == len(chunk) && offset+int64(n) < fi.Size()
+		if err := c.ReplyDownload(ctx, offset, base64.StdEncoding.EncodeToString(chunk[:n])); err != nil {
+			return err
+		}
+		if !c.Continues {
+			return nil
+		}
+		offset += int64(n)
+	}
+}
+
+// Download writes the file of the service on the connection to w.
+func Download(ctx context.Context, c *varlink.Connection, name string, w io.Writer) error {
+	receive, err := orgexamplefiles.Download().Send(ctx, c, varlink.More, name, nil)
+	if err != nil {
+		return err
+	}
+
+	var written int64
+	for {
+		offset, data, flags, err := receive(ctx)
+		if err != nil {
+			return err
+		}
+		if offset != written {
+			return fmt.Errorf("received the chunk at %d after %d bytes", offset, written)
+		}
+		b, err := base64.StdEncoding.DecodeString(data)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+		written += int64(len(b))
+		if flags&varlink.Continues == 0 {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package files
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/varlink/go/examples/files/orgexamplefiles"
+	"github.com/varlink/go/varlink"
+	"github.com/varlink/go/varlink/varlinktest"
+)
+
+// contract downloads hello.txt, holding "hello, world", in chunks of 5 bytes.
+const contract = `{"interactions": [
+	{"call": {"method": "org.example.files.List"}, "replies": [{"parameters": {"files": [{"name": "empty", "size": 0}, {"name": "hello.txt", "size": 12}]}}]},
+	{"call": {"method": "org.example.files.Download", "parameters": {"name": "hello.txt", "chunk_size": 5}, "more": true}, "replies": [
+		{"parameters": {"offset": 0, "data": "aGVsbG8="}, "continues": true},
+		{"parameters": {"offset": 5, "data": "LCB3b3I="}, "continues": true},
+		{"parameters": {"offset": 10, "data": "bGQ="}}
+	]},
+	{"call": {"method": "org.example.files.Download", "parameters": {"name": "hello.txt"}}, "replies": [{"parameters": {"offset": 0, "data": "aGVsbG8sIHdvcmxk"}}]},
+	{"call": {"method": "org.example.files.Download", "parameters": {"name": "empty"}, "more": true}, "replies": [{"parameters": {"offset": 0, "data": ""}}]},
+	{"call": {"method": "org.example.files.Download", "parameters": {"name": "../hello.txt"}}, "replies": [{"error": "org.example.files.NotFound", "parameters": {"name": "../hello.txt"}}]}
+]}`
+
+func TestContract(t *testing.T) {
+	dir, err := ioutil.TempDir("", "files")
+	if err != nil {
+		t.Fatalf("TempDir(): %v", err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "hello.txt"), []byte("hello, world"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "empty"), nil, 0644)
+	os.Mkdir(filepath.Join(dir, "subdir"), 0755)
+
+	address, stop := varlinktest.Serve(t, orgexamplefiles.VarlinkNew(&Files{Dir: dir}))
+	defer stop()
+
+	c, err := varlinktest.ReadContract(strings.NewReader(contract))
+	if err != nil {
+		t.Fatalf("ReadContract(): %v", err)
+	}
+	if err := varlinktest.Verify(context.Background(), address, c); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDownload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "files")
+	if err != nil {
+		t.Fatalf("TempDir(): %v", err)
+	}
+	defer os.RemoveAll(dir)
+	data := make([]byte, 3*defaultChunkSize+1)
+	rand.Read(data)
+	ioutil.WriteFile(filepath.Join(dir, "data"), data, 0644)
+
+	address, stop := varlinktest.Serve(t, orgexamplefiles.VarlinkNew(&Files{Dir: dir}))
+	defer stop()
+
+	ctx := context.Background()
+	c, err := varlink.NewConnection(ctx, address)
+	if err != nil {
+		t.Fatalf("NewConnection(): %v", err)
+	}
+	defer c.Close()
+
+	var b bytes.Buffer
+	if err := Download(ctx, c, "data", &b); err != nil {
+		t.Fatalf("Download(): %v", err)
+	}
+	if !bytes.Equal(b.Bytes(), data) {
+		t.Fatalf("Download() received %d bytes, expected %d", b.Len(), len(data))
+	}
+
+	// The connection is still usable after the stream
+	if err := Download(ctx, c, "missing", &b); err == nil {
+		t.Fatal("Download() of a missing file succeeded")
+	} else if e, ok := err.(*orgexamplefiles.NotFound); !ok || e.Name != "missing" {
+		t.Fatalf("Download() returned %v", err)
+	}
+}
@@ -0,0 +1,3 @@
+package orgexamplefiles
+
+//go:generate go run ../../../cmd/varlink-go-interface-generator/main.go org.example.files.varlink
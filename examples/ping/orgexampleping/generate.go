@@ -0,0 +1,3 @@
+package orgexampleping
+
+//go:generate go run ../../../cmd/varlink-go-interface-generator/main.go org.example.ping.varlink
@@ -0,0 +1,41 @@
+// Package ping shows the contract tests of varlinktest: the test of the client
+// replaces the service with a mock, and records the calls the client makes as
+// the contract in testdata/contract.json; the test of the service verifies that
+// the service still replies to them as the client expects. Run the tests with
+// -update after changing the client to record its contract again.
+//
+// The client measures the time of a call on a connection:
+//
+//	c, err := varlink.NewConnection(ctx, "unix:/run/org.example.ping")
+//	rtt, err := ping.RoundTrip(ctx, c)
+package ping
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/varlink/go/examples/ping/orgexampleping"
+	"github.com/varlink/go/varlink"
+)
+
+// Ping implements org.example.ping.
+type Ping struct{}
+
+// Ping replies with the ping.
+func (p *Ping) Ping(ctx context.Context, c orgexampleping.VarlinkCall, ping string) error {
+	return c.ReplyPing(ctx, ping)
+}
+
+// RoundTrip returns the time a call of Ping takes on the connection.
+func RoundTrip(ctx context.Context, c *varlink.Connection) (time.Duration, error) {
+	start := time.Now()
+	pong, err := orgexampleping.Ping().Call(ctx, c, "ping")
+	if err != nil {
+		return 0, err
+	}
+	if pong != "ping" {
+		return 0, fmt.Errorf("the service replied %s", pong)
+	}
+	return time.Since(start), nil
+}
@@ -0,0 +1,81 @@
+package ping
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/varlink/go/examples/ping/orgexampleping"
+	"github.com/varlink/go/varlink"
+	"github.com/varlink/go/varlink/varlinktest"
+)
+
+var update = flag.Bool("update", false, "record the contract of the client in testdata/contract.json")
+
+var contractFile = filepath.Join("testdata", "contract.json")
+
+func TestClient(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the mock listens on a unix socket")
+	}
+
+	mock, err := varlinktest.NewMock(orgexampleping.VarlinkNew(nil).VarlinkGetDescription())
+	if err != nil {
+		t.Fatalf("NewMock(): %v", err)
+	}
+	defer mock.Close()
+	mock.Expect(`{"method": "org.example.ping.Ping", "parameters": {"ping": "ping"}}`, `{"parameters": {"pong": "ping"}}`)
+
+	ctx := context.Background()
+	c, err := varlink.NewConnection(ctx, mock.Address())
+	if err != nil {
+		t.Fatalf("NewConnection(): %v", err)
+	}
+	defer c.Close()
+	if _, err := RoundTrip(ctx, c); err != nil {
+		t.Fatalf("RoundTrip(): %v", err)
+	}
+	if uncalled := mock.Uncalled(); len(uncalled) > 0 {
+		t.Fatalf("The client did not call %s", uncalled[0].Call)
+	}
+
+	var b bytes.Buffer
+	if err := mock.Contract().Write(&b); err != nil {
+		t.Fatalf("Write(): %v", err)
+	}
+	if *update {
+		if err := ioutil.WriteFile(contractFile, b.Bytes(), 0644); err != nil {
+			t.Fatalf("WriteFile(): %v", err)
+		}
+	}
+	recorded, err := ioutil.ReadFile(contractFile)
+	if err != nil {
+		t.Fatalf("ReadFile(): %v", err)
+	}
+	if !bytes.Equal(recorded, b.Bytes()) {
+		t.Fatalf("The client changed its contract, run the tests with -update:\n%s", b.Bytes())
+	}
+}
+
+func TestService(t *testing.T) {
+	address, stop := varlinktest.Serve(t, orgexampleping.VarlinkNew(&Ping{}))
+	defer stop()
+
+	f, err := os.Open(contractFile)
+	if err != nil {
+		t.Fatalf("Open(): %v", err)
+	}
+	defer f.Close()
+	contract, err := varlinktest.ReadContract(f)
+	if err != nil {
+		t.Fatalf("ReadContract(): %v", err)
+	}
+	if err := varlinktest.Verify(context.Background(), address, contract); err != nil {
+		t.Fatal(err)
+	}
+}
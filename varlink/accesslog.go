@@ -0,0 +1,56 @@
+package varlink
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// AccessLogEntry describes a completed method call, passed to
+// ServiceConfig.AccessLog.
+type AccessLogEntry struct {
+	// Peer is the address of the client, nil for calls not received on a
+	// connection of the service.
+	Peer   net.Addr
+	Method string
+	// Duration is the time from receiving the call to its end.
+	Duration time.Duration
+	// ReplySize is the number of bytes of all replies of the call.
+	ReplySize int
+	// Error is the name of the error replied, empty for calls ending with a
+	// reply; Err is the error returned by the method, which closes the
+	// connection.
+	Error string
+	Err   error
+}
+
+type peerAddrKey struct{}
+
+func peerAddrFromContext(ctx context.Context) net.Addr {
+	addr, _ := ctx.Value(peerAddrKey{}).(net.Addr)
+	return addr
+}
+
+// completed passes the completed call to ServiceConfig.AccessLog and
+// ServiceConfig.Metrics.
+func (s *Service) completed(ctx context.Context, c *Call, start time.Time, err error) {
+	c.state.mutex.Lock()
+	size, name := c.state.replySize, c.state.errorName
+	c.state.mutex.Unlock()
+
+	duration := time.Since(start)
+	if s.config.Metrics != nil {
+		s.config.Metrics.ObserveCall(c.In.Method, name, duration)
+	}
+	if s.config.AccessLog == nil {
+		return
+	}
+	s.config.AccessLog(AccessLogEntry{
+		Peer:      peerAddrFromContext(ctx),
+		Method:    c.In.Method,
+		Duration:  duration,
+		ReplySize: size,
+		Error:     name,
+		Err:       err,
+	})
+}
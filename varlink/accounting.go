@@ -0,0 +1,114 @@
+package varlink
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MethodUsage is the resource usage accumulated by the calls of a method.
+type MethodUsage struct {
+	Method string
+	Calls  uint64
+	// WallTime is the total time spent in the method handler, MaxWallTime the
+	// longest single call.
+	WallTime    time.Duration
+	MaxWallTime time.Duration
+	// AllocatedBytes is the total heap allocation during the calls, only measured
+	// with ServiceConfig.AccountAllocations.
+	AllocatedBytes uint64
+	// Goroutines is the number of goroutines the calls left running.
+	Goroutines int64
+}
+
+// accounting measures the resource usage of the method calls. The allocations
+// and goroutines are process-wide deltas, concurrent calls are charged for each
+// other's usage.
+type accounting struct {
+	mutex       sync.Mutex
+	allocations bool
+	methods     map[string]*MethodUsage
+}
+
+func newAccounting(allocations bool) *accounting {
+	return &accounting{
+		allocations: allocations,
+		methods:     make(map[string]*MethodUsage),
+	}
+}
+
+// measure calls the handler and charges its usage to the method.
+func (a *accounting) measure(method string, handler func() error) error {
+	var before runtime.MemStats
+	if a.allocations {
+		runtime.ReadMemStats(&before)
+	}
+	goroutines := runtime.NumGoroutine()
+	start := time.Now()
+
+	err := handler()
+
+	wall := time.Since(start)
+	spawned := int64(runtime.NumGoroutine() - goroutines)
+	var allocated uint64
+	if a.allocations {
+		var after runtime.MemStats
+		runtime.ReadMemStats(&after)
+		allocated = after.TotalAlloc - before.TotalAlloc
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	u, ok := a.methods[method]
+	if !ok {
+		u = &MethodUsage{Method: method}
+		a.methods[method] = u
+	}
+	u.Calls++
+	u.WallTime += wall
+	if wall > u.MaxWallTime {
+		u.MaxWallTime = wall
+	}
+	u.AllocatedBytes += allocated
+	u.Goroutines += spawned
+
+	return err
+}
+
+func (a *accounting) usage() []MethodUsage {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	usage := make([]MethodUsage, 0, len(a.methods))
+	for _, u := range a.methods {
+		usage = append(usage, *u)
+	}
+	sort.Slice(usage, func(i, j int) bool { return usage[i].Method < usage[j].Method })
+	return usage
+}
+
+func (a *accounting) write(w io.Writer) error {
+	for _, u := range a.usage() {
+		line := fmt.Sprintf("method: %s calls=%d wall=%s max=%s goroutines=%d",
+			u.Method, u.Calls, u.WallTime, u.MaxWallTime, u.Goroutines)
+		if a.allocations {
+			line += fmt.Sprintf(" allocated=%d", u.AllocatedBytes)
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Usage returns the resource usage of the called methods, sorted by method
+// name, if ServiceConfig.Accounting is set.
+func (s *Service) Usage() []MethodUsage {
+	if s.accounting == nil {
+		return nil
+	}
+	return s.accounting.usage()
+}
@@ -0,0 +1,100 @@
+package varlink
+
+import "time"
+
+// AdaptiveLimit adjusts the limit of concurrently dispatched calls to the observed
+// latency of the calls, by additive increase and multiplicative decrease: while
+// the limit is reached and the calls are as fast as the baseline latency, the
+// limit grows by one for every call; a call slower than the baseline by more
+// than the tolerance shrinks it by the backoff factor. The baseline is the
+// lowest latency of the recent calls. Calls which want more replies or an
+// upgrade are not sampled.
+type AdaptiveLimit struct {
+	// InitialCalls is the limit to start with; zero means 10.
+	InitialCalls int
+	// MinCalls and MaxCalls bound the limit; zero means 1 and 1000.
+	MinCalls int
+	MaxCalls int
+	// Tolerance is the factor of the baseline latency above which a call is
+	// considered slow; zero means 2.
+	Tolerance float64
+	// Backoff is the factor the limit is multiplied with after a slow call;
+	// zero means 0.9.
+	Backoff float64
+}
+
+// adaptiveWindow is the number of calls after which the baseline latency is
+// renewed from the calls of the last window, to follow lasting changes.
+const adaptiveWindow = 500
+
+type adaptiveLimit struct {
+	config    AdaptiveLimit
+	limit     float64
+	baseline  time.Duration
+	windowMin time.Duration
+	samples   int
+}
+
+func newAdaptiveLimit(config AdaptiveLimit) *adaptiveLimit {
+	if config.MinCalls <= 0 {
+		config.MinCalls = 1
+	}
+	if config.MaxCalls <= 0 {
+		config.MaxCalls = 1000
+	}
+	if config.MaxCalls < config.MinCalls {
+		config.MaxCalls = config.MinCalls
+	}
+	if config.InitialCalls <= 0 {
+		config.InitialCalls = 10
+	}
+	if config.Tolerance <= 1 {
+		config.Tolerance = 2
+	}
+	if config.Backoff <= 0 || config.Backoff >= 1 {
+		config.Backoff = 0.9
+	}
+
+	a := &adaptiveLimit{config: config, limit: float64(config.InitialCalls)}
+	a.clamp()
+	return a
+}
+
+func (a *adaptiveLimit) clamp() {
+	if a.limit < float64(a.config.MinCalls) {
+		a.limit = float64(a.config.MinCalls)
+	}
+	if a.limit > float64(a.config.MaxCalls) {
+		a.limit = float64(a.config.MaxCalls)
+	}
+}
+
+// update returns the new limit after a call with the latency; saturated is
+// whether the limit was reached while the call was running.
+func (a *adaptiveLimit) update(latency time.Duration, saturated bool) int {
+	if a.baseline == 0 || latency < a.baseline {
+		a.baseline = latency
+	}
+	if a.windowMin == 0 || latency < a.windowMin {
+		a.windowMin = latency
+	}
+	a.samples++
+	if a.samples >= adaptiveWindow {
+		a.baseline = a.windowMin
+		a.windowMin = 0
+		a.samples = 0
+	}
+
+	if float64(latency) > a.config.Tolerance*float64(a.baseline) {
+		a.limit *= a.config.Backoff
+	} else if saturated {
+		a.limit++
+	}
+	a.clamp()
+
+	return a.current()
+}
+
+func (a *adaptiveLimit) current() int {
+	return int(a.limit)
+}
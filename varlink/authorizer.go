@@ -0,0 +1,92 @@
+package varlink
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+)
+
+// AuthorizationRequest describes a call to be authorized.
+type AuthorizationRequest struct {
+	// Credentials are the ids of the caller on a unix socket, nil if they are
+	// not known, see Call.Credentials.
+	Credentials *Credentials
+	// TLS is the state of the TLS connection of the caller, nil for other
+	// connections, see Call.TLSConnectionState.
+	TLS *tls.ConnectionState
+	// Peer is the address of the caller, nil for calls not received on a
+	// connection of the service.
+	Peer net.Addr
+	// Tenant is the tenant of the call, see Call.Tenant.
+	Tenant string
+	// Interface is the name of the called interface, Method the name of the
+	// method without the interface.
+	Interface  string
+	Method     string
+	Parameters json.RawMessage
+}
+
+// Authorizer decides whether a call may be dispatched to the method.
+type Authorizer interface {
+	// Authorize returns nil to allow the call. Any error rejects it with an
+	// org.varlink.service.PermissionDenied error reply, the error itself is not
+	// sent to the caller.
+	Authorize(ctx context.Context, r *AuthorizationRequest) error
+}
+
+// AuthorizerFunc is a function implementing Authorizer.
+type AuthorizerFunc func(ctx context.Context, r *AuthorizationRequest) error
+
+// Authorize calls f.
+func (f AuthorizerFunc) Authorize(ctx context.Context, r *AuthorizationRequest) error {
+	return f(ctx, r)
+}
+
+// Authorize sets the authorizer of the calls of the method, by its
+// fully-qualified name like org.example.Interface.Method, or of all methods of
+// the interface, by its name. A call is dispatched only if all authorizers
+// which apply allow it: ServiceConfig.Authorizer, the one of the interface and
+// the one of the method. The calls of org.varlink.service are not authorized.
+// It fails once the service is running.
+func (s *Service) Authorize(name string, a Authorizer) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.state != serviceNew {
+		return fmt.Errorf("service is already running")
+	}
+	if s.authorizers == nil {
+		s.authorizers = make(map[string]Authorizer)
+	}
+	s.authorizers[name] = a
+	return nil
+}
+
+// authorized reports whether the authorizers of the method of the interface
+// allow the call, and records on the call whether any authorizer applied.
+func (s *Service) authorized(ctx context.Context, c *Call, iface string, method string) bool {
+	authorizers := []Authorizer{s.config.Authorizer, s.authorizers[iface], s.authorizers[iface+"."+method]}
+	r := AuthorizationRequest{
+		TLS:        c.tlsState,
+		Peer:       peerAddrFromContext(ctx),
+		Tenant:     c.tenant,
+		Interface:  iface,
+		Method:     method,
+		Parameters: c.Parameters(),
+	}
+	if c.peer != nil {
+		cred := c.peer.credentials
+		r.Credentials = &cred
+	}
+	for _, a := range authorizers {
+		if a == nil {
+			continue
+		}
+		if a.Authorize(ctx, &r) != nil {
+			return false
+		}
+		c.authorized = true
+	}
+	return true
+}
@@ -0,0 +1,121 @@
+package varlink
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+)
+
+// StreamBlocked is the error of a reply with the continues flag which the
+// client did not take within the stream timeout, see ServiceConfig.StreamTimeout.
+// The connection stays usable: the rest of the reply is written before the next
+// reply of the call, so the method can wait and send again, or end the stream,
+// like with a StreamCanceled error. While the rest of the reply is pending, the
+// next reply with the continues flag is not sent and fails with StreamBlocked
+// as well, so a method producing faster than the client reads does not buffer
+// its replies without limit. The final reply, replies passing files and the
+// replies of TLS connections, which do not survive a failed write, are not
+// limited.
+type StreamBlocked struct {
+	// Method is the fully-qualified name of the called method.
+	Method string
+	// Timeout is the stream timeout of the call.
+	Timeout time.Duration
+	// Pending is the size of the replies not taken by the client.
+	Pending int
+}
+
+func (e *StreamBlocked) Error() string {
+	return fmt.Sprintf("%s: the client did not take the reply within %s, %d bytes pending", e.Method, e.Timeout, e.Pending)
+}
+
+// streamWriteKey marks the context of a write limited by the stream timeout,
+// whose rest is kept by the call when it passes.
+type streamWriteKey struct{}
+
+// SetStreamTimeout overrides the stream timeout of the service for this call;
+// zero means no limit.
+func (c *Call) SetStreamTimeout(timeout time.Duration) {
+	if c.state == nil || c.In.Upgrade {
+		return
+	}
+	c.state.mutex.Lock()
+	c.state.streamTimeout = timeout
+	c.state.mutex.Unlock()
+}
+
+func (s *callState) timeout() time.Duration {
+	if s == nil {
+		return 0
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.streamTimeout
+}
+
+func (s *callState) hasFiles() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.files) > 0
+}
+
+func (s *callState) isBlocked() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.blocked
+}
+
+// block keeps the rest of a write which passed the stream timeout, ahead of
+// the buffered replies, and returns the size of the pending replies.
+func (s *callState) block(rest []byte) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.pending = append(append([]byte(nil), rest...), s.pending...)
+	s.blocked = true
+	return len(s.pending)
+}
+
+func (s *callState) unblock() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.blocked = false
+}
+
+// sendStream sends a reply with the continues flag, waiting at most the
+// timeout for the client to take it and the rest of the previous one.
+func (c *Call) sendStream(ctx context.Context, b []byte, timeout time.Duration) error {
+	wctx, cancel := context.WithTimeout(context.WithValue(ctx, streamWriteKey{}, true), timeout)
+	defer cancel()
+
+	if c.state.isBlocked() {
+		if err := c.writeStream(ctx, wctx, c.state.buffer(nil), timeout); err != nil {
+			return err
+		}
+	}
+
+	c.state.sent(len(b), "")
+	if c.metrics != nil {
+		c.metrics.BytesSent(len(b))
+	}
+	return c.writeStream(ctx, wctx, c.state.buffer(b), timeout)
+}
+
+func (c *Call) writeStream(ctx context.Context, wctx context.Context, b []byte, timeout time.Duration) error {
+	if len(b) == 0 {
+		return nil
+	}
+
+	n, err := c.Conn.Write(wctx, b)
+	switch {
+	case err == nil:
+		c.state.unblock()
+		return nil
+	case ctx.Err() == nil && isTimeout(err):
+		return &StreamBlocked{Method: c.In.Method, Timeout: timeout, Pending: c.state.block(b[n:])}
+	case err == io.EOF:
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
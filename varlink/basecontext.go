@@ -0,0 +1,33 @@
+package varlink
+
+import (
+	"context"
+	"net"
+)
+
+// valuesContext looks up values in the values context first, everything else
+// comes from the parent, so the context returned by ServiceConfig.BaseContext
+// does not replace the cancellation of the context passed to Listen.
+type valuesContext struct {
+	context.Context
+	values context.Context
+}
+
+func (c valuesContext) Value(key interface{}) interface{} {
+	if v := c.values.Value(key); v != nil {
+		return v
+	}
+	return c.Context.Value(key)
+}
+
+// baseContext returns the context of the connections accepted by the listener.
+func (s *Service) baseContext(ctx context.Context, l net.Listener) context.Context {
+	if s.config.BaseContext == nil {
+		return ctx
+	}
+	base := s.config.BaseContext(l)
+	if base == nil {
+		panic("ServiceConfig.BaseContext returned a nil context")
+	}
+	return valuesContext{Context: ctx, values: base}
+}
@@ -0,0 +1,64 @@
+package varlink
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+var bindings = struct {
+	sync.Mutex
+	bind map[string]func(impl interface{}) interface{}
+}{bind: make(map[string]func(impl interface{}) interface{})}
+
+// RegisterBinding makes a generated interface known to RegisterAll. It is called
+// from the init function of the packages generated by varlink-go-interface-generator;
+// bind returns the generated interface for impl, or nil if impl does not implement
+// all of its methods.
+func RegisterBinding(name string, bind func(impl interface{}) interface{}) {
+	bindings.Lock()
+	defer bindings.Unlock()
+	bindings.bind[name] = bind
+}
+
+// RegisterAll registers every generated interface implemented by impl, in the
+// order of their names. A type implements many small interfaces by embedding the
+// VarlinkBase of every generated package, which replies MethodNotImplemented, and
+// overriding the methods it handles. Only the interfaces of the generated packages
+// linked into the program are known; methods with the same name in two interfaces
+// need different signatures and cannot be implemented by the same type.
+func RegisterAll(s *Service, impl interface{}) error {
+	bindings.Lock()
+	names := make([]string, 0, len(bindings.bind))
+	for name := range bindings.bind {
+		names = append(names, name)
+	}
+	bind := make(map[string]func(impl interface{}) interface{}, len(names))
+	for name, b := range bindings.bind {
+		bind[name] = b
+	}
+	bindings.Unlock()
+	sort.Strings(names)
+
+	registered := 0
+	for _, name := range names {
+		v := bind[name](impl)
+		if v == nil {
+			continue
+		}
+
+		iface, ok := v.(dispatcher)
+		if !ok {
+			return fmt.Errorf("binding of interface '%s' returned no varlink interface", name)
+		}
+		if err := s.RegisterInterface(iface); err != nil {
+			return err
+		}
+		registered++
+	}
+
+	if registered == 0 {
+		return fmt.Errorf("%T implements no generated varlink interface", impl)
+	}
+	return nil
+}
@@ -1,22 +1,60 @@
 package varlink
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net"
+	"os"
 	"strings"
+	"sync"
+	"time"
 )
 
+// ErrStreamClosed is the error of a reply sent after the final reply of the
+// call, or after its method returned.
+var ErrStreamClosed = errors.New("varlink: stream closed")
+
 // Call is a method call retrieved by a Service. The connection from the
 // client can be terminated by returning an error from the call instead
 // of sending a reply or error reply.
+//
+// The replies of a connection are in the order of its calls: a call ends with its
+// final reply, which is the only reply without the continues flag, and must end
+// before its method returns. Replies after the final reply, or after the method
+// returned, fail with ErrStreamClosed. SendContinues and SendFinal send the
+// replies of a call wanting more without setting the Continues field. A method
+// returning neither a final reply nor an error closes the connection, as the
+// client could not tell the replies of later calls apart. A method which panics
+// ends the call with an InternalError, the panic is reported as a
+// HandlerPanicked warning.
 type Call struct {
-	Conn      ReadWriterContext
-	Request   *[]byte
-	In        *serviceCall
-	Continues bool
-	Upgrade   bool
+	Conn       ReadWriterContext
+	Request    *[]byte
+	In         *serviceCall
+	Continues  bool
+	Upgrade    bool
+	tenant     string
+	useNumber  bool
+	details    *parameterDetails
+	listener   net.Listener
+	peer       *peer
+	tlsState   *tls.ConnectionState
+	authorized bool
+	extensions *negotiated
+	state      *callState
+	metrics    MetricsCollector
+}
+
+// Tenant returns the tenant of the called interface, see Service.RegisterTenantInterface.
+// If ServiceConfig.TenantFunc is set, it is the tenant of the caller for all other
+// interfaces.
+func (c *Call) Tenant() string {
+	return c.tenant
 }
 
 // WantsMore indicates if the calling client accepts more than one reply to this method call.
@@ -34,12 +72,21 @@ func (c *Call) IsOneway() bool {
 	return c.In.Oneway
 }
 
-// GetParameters retrieves the method call parameters.
+// GetParameters retrieves the method call parameters. Numbers with a fraction or
+// an exponent, like 1.5 or 1e2, are rejected for integer fields with a
+// *json.UnmarshalTypeError, which ReplyParameterError answers with
+// InvalidParameter.
 func (c *Call) GetParameters(p interface{}) error {
 	if c.In.Parameters == nil {
 		return fmt.Errorf("empty parameters")
 	}
-	return json.Unmarshal(*c.In.Parameters, p)
+	if !c.useNumber {
+		return json.Unmarshal(*c.In.Parameters, p)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(*c.In.Parameters))
+	dec.UseNumber()
+	return dec.Decode(p)
 }
 
 func (c *Call) sendMessage(ctx context.Context, r *serviceReply) error {
@@ -47,20 +94,278 @@ func (c *Call) sendMessage(ctx context.Context, r *serviceReply) error {
 		return nil
 	}
 
+	if err := c.state.send(c.In.Method, r.Continues); err != nil {
+		return err
+	}
+
 	b, err := json.Marshal(r)
 	if err != nil {
 		return err
 	}
 
 	b = append(b, 0)
+	if timeout := c.state.timeout(); r.Continues && timeout > 0 && c.tlsState == nil && !c.state.hasFiles() {
+		return c.sendStream(ctx, b, timeout)
+	}
+	c.state.sent(len(b), r.Error)
+	if c.metrics != nil {
+		c.metrics.BytesSent(len(b))
+	}
+
+	if files := c.state.takeFiles(); len(files) > 0 {
+		// The files go along their own reply, after the buffered ones
+		if err = c.Flush(ctx); err == nil {
+			_, err = c.Conn.(fileWriter).WriteFiles(ctx, b, files)
+		}
+		for _, f := range files {
+			f.Close()
+		}
+	} else if b = c.state.buffer(b); len(b) > 0 {
+		_, err = c.Conn.Write(ctx, b)
+	}
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// FlushPolicy decides when the replies of a method call are written to the
+// connection.
+type FlushPolicy int
+
+const (
+	// FlushEveryReply writes every reply right away.
+	FlushEveryReply FlushPolicy = iota
+
+	// FlushOnReturn buffers the replies until the method returns, Flush is
+	// called, or 64 KiB of replies are buffered. Streaming methods write their
+	// replies in fewer, larger writes, but the client receives them later.
+	FlushOnReturn
+)
+
+// flushSize is the size of the buffered replies which are written without
+// waiting for the method to return.
+const flushSize = 64 << 10
+
+// SetFlushPolicy overrides the flush policy of the service for this call.
+// Replies of calls upgrading the connection are always written right away.
+func (c *Call) SetFlushPolicy(policy FlushPolicy) {
+	if c.state == nil || c.In.Upgrade {
+		return
+	}
+	c.state.mutex.Lock()
+	c.state.policy = policy
+	c.state.mutex.Unlock()
+}
 
-	_, err = c.Conn.Write(ctx, b)
+// Flush writes the buffered replies of the call to the connection.
+func (c *Call) Flush(ctx context.Context) error {
+	b := c.state.buffer(nil)
+	if len(b) == 0 {
+		return nil
+	}
+	_, err := c.Conn.Write(ctx, b)
 	if err == io.EOF {
 		return io.ErrUnexpectedEOF
 	}
 	return err
 }
 
+// callState tracks the replies of a call, it is shared by the copies of the Call.
+type callState struct {
+	mutex    sync.Mutex
+	final    bool
+	returned bool
+	// continued is set by the first reply with the continues flag
+	continued bool
+	files     []*os.File
+	// ctx is the context of the call, group the context of its goroutines,
+	// cancelled with the first error
+	ctx     context.Context
+	group   context.Context
+	cancel  context.CancelFunc
+	running sync.WaitGroup
+	err     error
+	// policy is the flush policy of the replies, pending the buffered ones
+	policy  FlushPolicy
+	pending []byte
+	// streamTimeout limits the writes of the replies with the continues flag,
+	// blocked is set while the rest of one is pending
+	streamTimeout time.Duration
+	blocked       bool
+	// callCtx is returned by Call.Context, watching is closed when its watch
+	// returned
+	callCtx    context.Context
+	callCancel context.CancelFunc
+	watching   chan struct{}
+	// replySize and errorName are the size of the replies sent and the name of
+	// the error replied
+	replySize int
+	errorName string
+}
+
+// Go runs the function in a new goroutine tied to the call, like an errgroup.
+// Its context is derived from the context of the call and cancelled when a
+// function started by Go returns an error, or when the method returns; the method call only ends
+// after all its goroutines returned. Functions passed after the method returned
+// are not run. Errors which are not collected with Wait are dropped.
+func (c *Call) Go(f func(ctx context.Context) error) {
+	if c.state == nil {
+		c.state = &callState{}
+	}
+	c.state.start(f)
+}
+
+// Wait waits until all functions started by Go returned and returns the first
+// error.
+func (c *Call) Wait() error {
+	if c.state == nil {
+		return nil
+	}
+	c.state.running.Wait()
+
+	c.state.mutex.Lock()
+	defer c.state.mutex.Unlock()
+	return c.state.err
+}
+
+func (s *callState) start(f func(ctx context.Context) error) {
+	s.mutex.Lock()
+	if s.returned {
+		s.mutex.Unlock()
+		return
+	}
+	if s.group == nil {
+		ctx := s.ctx
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		s.group, s.cancel = context.WithCancel(ctx)
+	}
+	ctx := s.group
+	s.running.Add(1)
+	s.mutex.Unlock()
+
+	go func() {
+		defer s.running.Done()
+		if err := f(ctx); err != nil {
+			s.mutex.Lock()
+			if s.err == nil {
+				s.err = err
+				s.cancel()
+			}
+			s.mutex.Unlock()
+		}
+	}()
+}
+
+// attach adds a file to pass along the next reply and returns its index.
+func (s *callState) attach(f *os.File) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.files = append(s.files, f)
+	return len(s.files) - 1
+}
+
+// takeFiles returns the files to pass along the reply being sent.
+func (s *callState) takeFiles() []*os.File {
+	if s == nil {
+		return nil
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	files := s.files
+	s.files = nil
+	return files
+}
+
+// buffer adds the reply to the buffered ones, and returns the replies to be
+// written now; nil if they stay buffered. A nil reply takes all buffered ones.
+func (s *callState) buffer(b []byte) []byte {
+	if s == nil {
+		return b
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if len(s.pending) == 0 && (s.policy == FlushEveryReply || b == nil) {
+		return b
+	}
+
+	s.pending = append(s.pending, b...)
+	if b != nil && s.policy == FlushOnReturn && len(s.pending) < flushSize {
+		return nil
+	}
+	b = s.pending
+	s.pending = nil
+	return b
+}
+
+// send checks that a reply may be sent and records the final reply.
+func (s *callState) send(method string, continues bool) error {
+	if s == nil {
+		return nil
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.returned {
+		return fmt.Errorf("%w: reply of %s after the method returned", ErrStreamClosed, method)
+	}
+	if s.final {
+		return fmt.Errorf("%w: reply of %s after its final reply", ErrStreamClosed, method)
+	}
+	if !continues {
+		s.final = true
+	} else {
+		s.continued = true
+	}
+	return nil
+}
+
+// sent records the size of a reply and the error it replied.
+func (s *callState) sent(size int, errorName string) {
+	if s == nil {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.replySize += size
+	if errorName != "" {
+		s.errorName = errorName
+	}
+}
+
+// end records that the method returned, cancels and waits for its goroutines
+// and reports whether the final reply was sent. Files attached after the last
+// reply are closed.
+func (s *callState) end() bool {
+	s.mutex.Lock()
+	s.returned = true
+	if s.cancel != nil {
+		s.cancel()
+	}
+	if s.callCancel != nil {
+		s.callCancel()
+	}
+	watching := s.watching
+	s.mutex.Unlock()
+	s.running.Wait()
+	if watching != nil {
+		<-watching
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, f := range s.files {
+		f.Close()
+	}
+	s.files = nil
+	return s.final
+}
+
 // Reply sends a reply to this method call.
 func (c *Call) Reply(ctx context.Context, parameters interface{}) error {
 	if !c.Continues {
@@ -79,6 +384,27 @@ func (c *Call) Reply(ctx context.Context, parameters interface{}) error {
 	})
 }
 
+// SendContinues sends a reply with the continues flag to a call which wants more
+// replies, regardless of the Continues field; the call goes on until SendFinal.
+// It fails with ErrStreamClosed after the final reply.
+func (c *Call) SendContinues(ctx context.Context, parameters interface{}) error {
+	if !c.In.More {
+		return fmt.Errorf("call did not set more, it does not expect continues")
+	}
+	return c.sendMessage(ctx, &serviceReply{
+		Continues:  true,
+		Parameters: parameters,
+	})
+}
+
+// SendFinal sends the final reply of the call, regardless of the Continues
+// field. It fails with ErrStreamClosed after the final reply.
+func (c *Call) SendFinal(ctx context.Context, parameters interface{}) error {
+	return c.sendMessage(ctx, &serviceReply{
+		Parameters: parameters,
+	})
+}
+
 // ReplyError sends an error reply to this method call.
 func (c *Call) ReplyError(ctx context.Context, name string, parameters interface{}) error {
 	r := strings.LastIndex(name, ".")
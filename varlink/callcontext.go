@@ -0,0 +1,110 @@
+package varlink
+
+import (
+	"context"
+)
+
+type callContextKey struct{}
+
+// peeker is a connection telling whether the next message arrived, or the
+// client disconnected, without consuming it.
+type peeker interface {
+	Peek(ctx context.Context, n int) ([]byte, error)
+}
+
+// connectionContext holds what cancels the contexts of the calls of a
+// connection: the service shutting down, and the client disconnecting.
+type connectionContext struct {
+	stop context.Context
+	conn peeker
+}
+
+func connectionContextFromContext(ctx context.Context) *connectionContext {
+	c, _ := ctx.Value(callContextKey{}).(*connectionContext)
+	return c
+}
+
+// Context returns the context of the call, which is canceled when the client
+// disconnects, the service shuts down, or the method returns. Long-running
+// methods, like ones streaming replies, abort their work when it is done;
+// their replies are sent with the context passed to the method, which stays
+// usable to send a final error reply.
+//
+// Disconnects are only noticed while the connection has no more calls queued
+// behind this one, and not on upgraded connections.
+func (c *Call) Context() context.Context {
+	if c.state == nil {
+		return context.Background()
+	}
+	return c.state.context(c.In.Upgrade)
+}
+
+func (s *callState) context(upgrade bool) context.Context {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.callCtx != nil {
+		return s.callCtx
+	}
+
+	parent := s.ctx
+	if parent == nil {
+		parent = context.Background()
+	}
+	s.callCtx, s.callCancel = context.WithCancel(parent)
+	if s.returned {
+		s.callCancel()
+		return s.callCtx
+	}
+
+	if w := connectionContextFromContext(parent); w != nil {
+		conn := w.conn
+		if upgrade {
+			conn = nil
+		}
+		s.watching = make(chan struct{})
+		go s.watch(w.stop, conn)
+	}
+	return s.callCtx
+}
+
+// cancelContext cancels the context of the call.
+func (s *callState) cancelContext(upgrade bool) {
+	s.context(upgrade)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.callCancel()
+}
+
+// watch cancels the context of the call when the service stops or the client
+// disconnects, until the method returns.
+func (s *callState) watch(stop context.Context, conn peeker) {
+	defer close(s.watching)
+	defer s.callCancel()
+
+	var peeked chan error
+	if conn != nil {
+		peeked = make(chan error, 1)
+		go func() {
+			_, err := conn.Peek(s.callCtx, 1)
+			peeked <- err
+		}()
+	}
+
+	for done := false; !done; {
+		select {
+		case <-stop.Done():
+			done = true
+		case <-s.callCtx.Done():
+			done = true
+		case err := <-peeked:
+			// Without an error the next call is queued, the client is still there
+			peeked = nil
+			done = err != nil
+		}
+	}
+
+	if peeked != nil {
+		s.callCancel()
+		<-peeked
+	}
+}
@@ -0,0 +1,123 @@
+package varlink
+
+import (
+	"context"
+	"time"
+)
+
+// CallMetadata is the extension passing metadata, like request or trace ids, along
+// method calls in their metadata object. Services offer it with
+// ServiceConfig.Extensions, handlers read the metadata with Call.Metadata.
+const CallMetadata = "org.varlink.call-metadata"
+
+// CallOption sets an optional setting of a method call, see SendWithOptions.
+// The generated clients take them as their last arguments, so calls can use new
+// settings without changes to the generated signatures.
+type CallOption func(*callOptions)
+
+type callOptions struct {
+	flags    uint64
+	timeout  time.Duration
+	metadata map[string]string
+}
+
+// WithFlags adds the message flags to the call, like More or Oneway.
+func WithFlags(flags uint64) CallOption {
+	return func(o *callOptions) {
+		o.flags |= flags
+	}
+}
+
+// WithMore lets the service send more than one reply. The replies are received by
+// calling the receive function of Send until it stops returning Continues.
+func WithMore() CallOption {
+	return WithFlags(More)
+}
+
+// WithOneway asks the service to not reply to the call.
+func WithOneway() CallOption {
+	return WithFlags(Oneway)
+}
+
+// WithTimeout fails the call if it is not sent and all its replies are received
+// within the duration after the call was started.
+func WithTimeout(timeout time.Duration) CallOption {
+	return func(o *callOptions) {
+		o.timeout = timeout
+	}
+}
+
+// WithMetadata passes the value with the call. Metadata is only sent on
+// connections which negotiated the CallMetadata extension, and left out on the
+// others.
+func WithMetadata(key string, value string) CallOption {
+	return func(o *callOptions) {
+		if o.metadata == nil {
+			o.metadata = make(map[string]string)
+		}
+		o.metadata[key] = value
+	}
+}
+
+// SendWithOptions sends a method call like Send, with the flags and further
+// settings given as options.
+func (c *Connection) SendWithOptions(ctx context.Context, method string, parameters interface{}, opts ...CallOption) (func(context.Context, interface{}) (uint64, error), error) {
+	var o callOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if o.timeout <= 0 {
+		return c.send(ctx, method, parameters, o)
+	}
+
+	// The deadline covers sending and every receive
+	deadline := time.Now().Add(o.timeout)
+	sendCtx, cancel := context.WithDeadline(ctx, deadline)
+	defer cancel()
+
+	receive, err := c.send(sendCtx, method, parameters, o)
+	if err != nil {
+		return nil, timeoutError(err, deadline)
+	}
+
+	return func(ctx context.Context, out interface{}) (uint64, error) {
+		ctx, cancel := context.WithDeadline(ctx, deadline)
+		defer cancel()
+		flags, err := receive(ctx, out)
+		return flags, timeoutError(err, deadline)
+	}, nil
+}
+
+// timeoutError returns context.DeadlineExceeded for the errors after the
+// deadline; the deadline of the connection may expire before the context.
+func timeoutError(err error, deadline time.Time) error {
+	if err != nil && !time.Now().Before(deadline) {
+		return context.DeadlineExceeded
+	}
+	return err
+}
+
+// UpgradeWithOptions upgrades the connection like Upgrade, with further settings
+// of the call given as options.
+func (c *Connection) UpgradeWithOptions(ctx context.Context, method string, parameters interface{}, opts ...CallOption) (func(context.Context, interface{}) (uint64, ReadWriterContext, error), error) {
+	reply, err := c.SendWithOptions(ctx, method, parameters, append(opts[:len(opts):len(opts)], WithFlags(Upgrade))...)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(ctx context.Context, out interface{}) (uint64, ReadWriterContext, error) {
+		flags, err := reply(ctx, out)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		return flags, c.stream(), nil
+	}, nil
+}
+
+// Metadata returns the value passed with the call by a client which negotiated
+// the CallMetadata extension, or the empty string.
+func (c *Call) Metadata(key string) string {
+	return c.In.Metadata[key]
+}
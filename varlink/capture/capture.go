@@ -0,0 +1,146 @@
+// Package capture writes and reads captures of varlink connections, for the
+// post-mortem analysis of protocol issues. Captures are decoded with the
+// "varlink decode" command.
+//
+// A capture starts with the line "varlink-capture 1", followed by the records.
+// Every record starts with a header of the direction byte, the big-endian uint64
+// connection id, the big-endian int64 time in nanoseconds since the Unix epoch
+// and the big-endian uint32 length of the data.
+package capture
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+const magic = "varlink-capture 1\n"
+
+const maxRecordSize = 1 << 26
+
+// Direction tells whether the data was sent or received by the capturing side.
+type Direction byte
+
+// Valid Direction values.
+const (
+	Received Direction = iota
+	Sent
+)
+
+func (d Direction) String() string {
+	switch d {
+	case Received:
+		return "<-"
+	case Sent:
+		return "->"
+	}
+	return fmt.Sprintf("Direction(%d)", byte(d))
+}
+
+// Record is a captured message, or a chunk of data of an upgraded connection.
+type Record struct {
+	Direction  Direction
+	Connection uint64
+	Time       time.Time
+	Data       []byte
+}
+
+// Writer writes a capture. It is safe for concurrent use.
+type Writer struct {
+	mutex      sync.Mutex
+	w          io.Writer
+	started    bool
+	connection uint64
+}
+
+// NewWriter returns a Writer writing the capture to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// NewConnection returns a new id to tell the records of a connection apart.
+func (w *Writer) NewConnection() uint64 {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.connection++
+	return w.connection
+}
+
+// Write writes a record.
+func (w *Writer) Write(r Record) error {
+	b := make([]byte, 21+len(r.Data))
+	b[0] = byte(r.Direction)
+	binary.BigEndian.PutUint64(b[1:9], r.Connection)
+	binary.BigEndian.PutUint64(b[9:17], uint64(r.Time.UnixNano()))
+	binary.BigEndian.PutUint32(b[17:21], uint32(len(r.Data)))
+	copy(b[21:], r.Data)
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if !w.started {
+		if _, err := io.WriteString(w.w, magic); err != nil {
+			return err
+		}
+		w.started = true
+	}
+
+	_, err := w.w.Write(b)
+	return err
+}
+
+// Reader reads a capture.
+type Reader struct {
+	r       *bufio.Reader
+	started bool
+}
+
+// NewReader returns a Reader reading the capture from r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r)}
+}
+
+// Next returns the next record, or io.EOF at the end of the capture.
+func (r *Reader) Next() (*Record, error) {
+	if !r.started {
+		header := make([]byte, len(magic))
+		if _, err := io.ReadFull(r.r, header); err != nil {
+			if err == io.EOF {
+				return nil, io.EOF
+			}
+			return nil, fmt.Errorf("capture: reading header: %v", err)
+		}
+		if string(header) != magic {
+			return nil, fmt.Errorf("capture: not a varlink capture")
+		}
+		r.started = true
+	}
+
+	header := make([]byte, 21)
+	if _, err := io.ReadFull(r.r, header); err != nil {
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("capture: truncated record: %v", err)
+	}
+
+	size := binary.BigEndian.Uint32(header[17:21])
+	if size > maxRecordSize {
+		return nil, fmt.Errorf("capture: record of %d bytes exceeds the limit", size)
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r.r, data); err != nil {
+		return nil, fmt.Errorf("capture: truncated record: %v", err)
+	}
+
+	return &Record{
+		Direction:  Direction(header[0]),
+		Connection: binary.BigEndian.Uint64(header[1:9]),
+		Time:       time.Unix(0, int64(binary.BigEndian.Uint64(header[9:17]))),
+		Data:       data,
+	}, nil
+}
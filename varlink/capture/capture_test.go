@@ -0,0 +1,56 @@
+package capture_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/varlink/go/varlink/capture"
+)
+
+func TestCapture(t *testing.T) {
+	var buf bytes.Buffer
+	w := capture.NewWriter(&buf)
+
+	now := time.Unix(1500000000, 123456789)
+	records := []capture.Record{
+		{capture.Received, w.NewConnection(), now, []byte(`{"method":"org.example.Ping"}` + "\x00")},
+		{capture.Sent, 1, now.Add(time.Millisecond), []byte(`{}` + "\x00")},
+		{capture.Received, w.NewConnection(), now, []byte{}},
+	}
+	for _, r := range records {
+		if err := w.Write(r); err != nil {
+			t.Fatalf("Write(): %v", err)
+		}
+	}
+
+	r := capture.NewReader(bytes.NewReader(buf.Bytes()))
+	for i, expected := range records {
+		record, err := r.Next()
+		if err != nil {
+			t.Fatalf("Next(): %v", err)
+		}
+		if record.Direction != expected.Direction || record.Connection != uint64(i/2+1) ||
+			!record.Time.Equal(expected.Time) || !bytes.Equal(record.Data, expected.Data) {
+			t.Fatalf("Unexpected record %v, expected %v", record, expected)
+		}
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Fatalf("Expected EOF, got %v", err)
+	}
+
+	// Truncated captures and other files are rejected
+	r = capture.NewReader(bytes.NewReader(buf.Bytes()[:buf.Len()-1]))
+	for {
+		if _, err := r.Next(); err != nil {
+			if err == io.EOF {
+				t.Fatal("Truncated capture was accepted")
+			}
+			break
+		}
+	}
+	if _, err := capture.NewReader(bytes.NewReader([]byte("hello world, no capture"))).Next(); err == nil {
+		t.Fatal("Next() accepted a file without header")
+	}
+}
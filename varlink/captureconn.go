@@ -0,0 +1,89 @@
+package varlink
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/varlink/go/varlink/capture"
+)
+
+// captureConn records all data passing through the connection.
+type captureConn struct {
+	ReadWriterContext
+	w  *capture.Writer
+	id uint64
+}
+
+func newCaptureConn(conn ReadWriterContext, w *capture.Writer) *captureConn {
+	return &captureConn{
+		ReadWriterContext: conn,
+		w:                 w,
+		id:                w.NewConnection(),
+	}
+}
+
+// record writes the data to the capture; failures must not break the connection.
+func (c *captureConn) record(d capture.Direction, b []byte) {
+	if len(b) == 0 {
+		return
+	}
+	data := make([]byte, len(b))
+	copy(data, b)
+	c.w.Write(capture.Record{
+		Direction:  d,
+		Connection: c.id,
+		Time:       time.Now(),
+		Data:       data,
+	})
+}
+
+func (c *captureConn) Write(ctx context.Context, b []byte) (int, error) {
+	n, err := c.ReadWriterContext.Write(ctx, b)
+	c.record(capture.Sent, b[:n])
+	return n, err
+}
+
+func (c *captureConn) Read(ctx context.Context, b []byte) (int, error) {
+	n, err := c.ReadWriterContext.Read(ctx, b)
+	c.record(capture.Received, b[:n])
+	return n, err
+}
+
+func (c *captureConn) ReadBytes(ctx context.Context, delim byte) ([]byte, error) {
+	b, err := c.ReadWriterContext.ReadBytes(ctx, delim)
+	c.record(capture.Received, b)
+	return b, err
+}
+
+// SetCapture records the messages of the connection, for the analysis of
+// protocol issues with "varlink decode"; nil stops the recording.
+func (c *Connection) SetCapture(w *capture.Writer) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if w == nil {
+		c.capture = nil
+		return
+	}
+	c.capture = newCaptureConn(c.conn, w)
+}
+
+// stream returns the connection to the service, recorded if requested.
+func (c *Connection) stream() ReadWriterContext {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.capture != nil {
+		return c.capture
+	}
+	return c.conn
+}
+
+func (c *captureConn) CanPassFiles() bool {
+	return canPassFiles(c.ReadWriterContext)
+}
+
+func (c *captureConn) WriteFiles(ctx context.Context, b []byte, files []*os.File) (int, error) {
+	n, err := c.ReadWriterContext.(fileWriter).WriteFiles(ctx, b, files)
+	c.record(capture.Sent, b[:n])
+	return n, err
+}
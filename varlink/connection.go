@@ -5,9 +5,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"net"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/varlink/go/varlink/idl"
 	"github.com/varlink/go/varlink/internal/ctxio"
 )
 
@@ -67,6 +69,55 @@ func (e *Error) DispatchError() error {
 			}
 		}
 		return &param
+	case "org.varlink.service.PermissionDenied":
+		return &PermissionDenied{}
+	case "org.varlink.service.ServiceNotAvailable":
+		return &ServiceNotAvailable{}
+	case "org.varlink.maintenance.Unavailable":
+		var param MaintenanceUnavailable
+		if errorRawParameters != nil {
+			err := json.Unmarshal(*errorRawParameters, &param)
+			if err != nil {
+				return e
+			}
+		}
+		return &param
+	case "org.varlink.parameters.Invalid":
+		var param InvalidParameterDetails
+		if errorRawParameters != nil {
+			err := json.Unmarshal(*errorRawParameters, &param)
+			if err != nil {
+				return e
+			}
+		}
+		return &param
+	case "org.varlink.ratelimit.Exceeded":
+		var param RateLimitExceeded
+		if errorRawParameters != nil {
+			err := json.Unmarshal(*errorRawParameters, &param)
+			if err != nil {
+				return e
+			}
+		}
+		return &param
+	case "org.varlink.stream.Canceled":
+		var param StreamCanceled
+		if errorRawParameters != nil {
+			err := json.Unmarshal(*errorRawParameters, &param)
+			if err != nil {
+				return e
+			}
+		}
+		return &param
+	case "org.varlink.internal.Error":
+		var param InternalError
+		if errorRawParameters != nil {
+			err := json.Unmarshal(*errorRawParameters, &param)
+			if err != nil {
+				return e
+			}
+		}
+		return &param
 	}
 	return e
 }
@@ -76,6 +127,19 @@ func (e *Error) Error() string {
 	return e.Name
 }
 
+// Is reports whether the target is an error of the same name, an Error or a
+// generated error type, like the sentinel errors of the generated packages, for
+// errors.Is.
+func (e *Error) Is(target error) bool {
+	switch t := target.(type) {
+	case *Error:
+		return t.Name == e.Name
+	case interface{ VarlinkErrorName() string }:
+		return t.VarlinkErrorName() == e.Name
+	}
+	return false
+}
+
 // ReadWriterContext describes the capabilities of the
 // underlying varlink connection.
 type ReadWriterContext interface {
@@ -84,25 +148,106 @@ type ReadWriterContext interface {
 	ReadBytes(ctx context.Context, delim byte) ([]byte, error)
 }
 
-// Connection is a connection from a client to a service.
+// Connection is a connection from a client to a service. It is safe for concurrent
+// use; as a service answers the calls of a connection one after the other, a call
+// waits until the replies of the previous call have been received.
 type Connection struct {
 	io.Closer
-	address string
-	conn    *ctxio.Conn
+	address  string
+	conn     *ctxio.Conn
+	mutex    sync.Mutex
+	idls     map[string]*idl.IDL
+	lock     chan struct{}
+	closed   chan struct{}
+	unusable error
+	// strict, useNumber and diagnostics are set with SetStrict, SetUseNumber and SetDiagnostics
+	strict      bool
+	useNumber   bool
+	diagnostics func(Diagnostic)
+	capture     *captureConn
+	extensions  map[string]int
+	// maxMessageSize is set with SetMaxMessageSize
+	maxMessageSize int
+	stats          StatsHandler
+	tracer         Tracer
+	// schemaCache holds the interface descriptions of version, the version of
+	// the service, set once known
+	schemaCache *SchemaCache
+	version     *string
+}
+
+// channels returns the lock of the calls and the channel closed by Close.
+func (c *Connection) channels() (chan struct{}, chan struct{}) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.lock == nil {
+		c.lock = make(chan struct{}, 1)
+		c.closed = make(chan struct{})
+	}
+	return c.lock, c.closed
+}
+
+// acquire waits until the connection is free to send a new method call, or
+// the connection is closed.
+func (c *Connection) acquire(ctx context.Context) error {
+	lock, closed := c.channels()
+
+	select {
+	case lock <- struct{}{}:
+	case <-closed:
+		c.mutex.Lock()
+		err := c.unusable
+		c.mutex.Unlock()
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	c.mutex.Lock()
+	err := c.unusable
+	c.mutex.Unlock()
+	if err != nil {
+		<-lock
+		return err
+	}
+	return nil
+}
+
+func (c *Connection) release() {
+	<-c.lock
+}
+
+// disable fails all further calls, after the connection has been upgraded or
+// got out of sync with the service.
+func (c *Connection) disable(err error) {
+	c.mutex.Lock()
+	c.unusable = err
+	c.mutex.Unlock()
 }
 
 // Send sends a method call. It returns a receive() function which is called to retrieve the method reply.
 // If Send() is called with the `More` flag and the receive() function carries the `Continues` flag, receive()
-// can be called multiple times to retrieve multiple replies.
+// can be called multiple times to retrieve multiple replies. Other calls on the connection wait until
+// the last reply has been received, or an error was returned, so the receive() function of a call
+// which is not oneway must be called until it returns no Continues flag. A call which is abandoned
+// instead leaves the connection out of sync with the service; it must be closed, which fails the
+// waiting calls.
 func (c *Connection) Send(ctx context.Context, method string, parameters interface{}, flags uint64) (func(context.Context, interface{}) (uint64, error), error) {
+	return c.send(ctx, method, parameters, callOptions{flags: flags})
+}
+
+func (c *Connection) send(ctx context.Context, method string, parameters interface{}, options callOptions) (func(context.Context, interface{}) (uint64, error), error) {
 	type call struct {
-		Method     string      `json:"method"`
-		Parameters interface{} `json:"parameters,omitempty"`
-		More       bool        `json:"more,omitempty"`
-		Oneway     bool        `json:"oneway,omitempty"`
-		Upgrade    bool        `json:"upgrade,omitempty"`
+		Method     string            `json:"method"`
+		Parameters interface{}       `json:"parameters,omitempty"`
+		More       bool              `json:"more,omitempty"`
+		Oneway     bool              `json:"oneway,omitempty"`
+		Upgrade    bool              `json:"upgrade,omitempty"`
+		Metadata   map[string]string `json:"metadata,omitempty"`
 	}
 
+	flags := options.flags
+
 	if (flags&More != 0) && (flags&Oneway != 0) {
 		return nil, &Error{
 			Name:       "org.varlink.InvalidParameter",
@@ -117,6 +262,7 @@ func (c *Connection) Send(ctx context.Context, method string, parameters interfa
 		}
 	}
 
+	upgrade := flags&Upgrade != 0
 	m := call{
 		Method:     method,
 		Parameters: parameters,
@@ -124,21 +270,82 @@ func (c *Connection) Send(ctx context.Context, method string, parameters interfa
 		Oneway:     flags&Oneway != 0,
 		Upgrade:    flags&Upgrade != 0,
 	}
+	_, metadata := c.Extension(CallMetadata)
+	if metadata {
+		m.Metadata = options.metadata
+	}
+
+	var span Span
+	if c.tracer != nil {
+		ctx, span = c.tracer.Start(ctx, method, ClientSpan)
+		if metadata {
+			m.Metadata = make(map[string]string, len(options.metadata)+2)
+			for k, v := range options.metadata {
+				m.Metadata[k] = v
+			}
+			c.tracer.Inject(ctx, m.Metadata)
+		}
+	}
+	end := func(err error) {
+		if span != nil {
+			span.End(err)
+		}
+	}
+
 	b, err := json.Marshal(m)
 	if err != nil {
+		end(err)
 		return nil, err
 	}
 
 	b = append(b, 0)
 
-	_, err = c.conn.Write(ctx, b)
+	c.mutex.Lock()
+	strict := c.strict
+	useNumber := c.useNumber
+	report := c.diagnostics
+	c.mutex.Unlock()
+
+	// The interface description is requested before this call occupies the connection
+	var d *diagnoser
+	if r := strings.LastIndex(method, "."); report != nil && r > 0 && method[:r] != "org.varlink.service" {
+		midl, err := c.GetInterfaceIDL(ctx, method[:r])
+		if err != nil {
+			report(Diagnostic{Method: method, Path: "interface", Problem: fmt.Sprintf("no interface description: %v", err)})
+		} else {
+			d = &diagnoser{method: method, idl: midl, report: report}
+		}
+	}
+
+	if err := c.acquire(ctx); err != nil {
+		end(err)
+		return nil, err
+	}
+
+	start := time.Now()
+	last := start
+	completed := func(err error) {
+		handleStats(c.stats, Stats{Kind: CallCompleted, Address: c.address, Method: method, Duration: time.Since(start), Err: err})
+		end(err)
+	}
+
+	_, err = c.stream().Write(ctx, b)
 	if err != nil {
+		c.release()
 		if err == io.EOF {
-			return nil, io.ErrUnexpectedEOF
+			err = io.ErrUnexpectedEOF
 		}
+		completed(err)
 		return nil, err
 	}
 
+	done := false
+	if m.Oneway {
+		c.release()
+		done = true
+		completed(nil)
+	}
+
 	receive := func(ctx context.Context, outParameters interface{}) (uint64, error) {
 		type reply struct {
 			Parameters *json.RawMessage `json:"parameters"`
@@ -146,37 +353,82 @@ func (c *Connection) Send(ctx context.Context, method string, parameters interfa
 			Error      string           `json:"error"`
 		}
 
-		out, err := c.conn.ReadBytes(ctx, '\x00')
+		if done {
+			return 0, fmt.Errorf("no more replies")
+		}
+
+		c.conn.SetReadLimit(c.readLimit())
+		out, err := c.stream().ReadBytes(ctx, '\x00')
 		if err != nil {
+			done = true
+			c.disable(fmt.Errorf("connection failed receiving a reply: %v", err))
+			c.release()
 			if err == io.EOF {
-				return 0, io.ErrUnexpectedEOF
+				err = io.ErrUnexpectedEOF
 			}
+			completed(err)
 			return 0, err
 		}
+		if c.stats != nil && m.More {
+			now := time.Now()
+			handleStats(c.stats, Stats{Kind: StreamReply, Address: c.address, Method: method, Duration: now.Sub(last)})
+			last = now
+		}
+
+		files := c.conn.Files()
+		fileReply, _ := outParameters.(*FileReply)
+		if fileReply != nil {
+			outParameters = fileReply.Parameters
+		}
 
 		var m reply
 		err = json.Unmarshal(out[:len(out)-1], &m)
+		if !m.Continues || err != nil {
+			done = true
+			if upgrade && err == nil && m.Error == "" {
+				c.disable(fmt.Errorf("connection has been upgraded"))
+			}
+			c.release()
+		}
+		if err != nil || m.Error != "" || fileReply == nil {
+			for _, f := range files {
+				f.Close()
+			}
+		} else {
+			fileReply.Files = files
+		}
 		if err != nil {
+			completed(err)
 			return 0, err
 		}
 
+		if d != nil {
+			d.reply(m.Error, m.Parameters)
+		}
+
 		if m.Error != "" {
 			e := &Error{
 				Name:       m.Error,
 				Parameters: m.Parameters,
 			}
-			return 0, e.DispatchError()
+			err := e.DispatchError()
+			completed(err)
+			return 0, err
 		}
-
-		if m.Parameters != nil {
-			json.Unmarshal(*m.Parameters, outParameters)
+		if done {
+			completed(nil)
 		}
 
+		var flags uint64
 		if m.Continues {
-			return Continues, nil
+			flags = Continues
+		}
+
+		if err := decode(method, m.Parameters, outParameters, strict, useNumber); err != nil {
+			return flags, err
 		}
 
-		return 0, nil
+		return flags, nil
 	}
 
 	return receive, nil
@@ -194,12 +446,15 @@ func (c *Connection) Call(ctx context.Context, method string, parameters interfa
 }
 
 // GetInterfaceDescription requests the interface description string from the service.
+// Descriptions compressed with the CompressedDescriptions extension, after it was
+// negotiated, are decompressed.
 func (c *Connection) GetInterfaceDescription(ctx context.Context, name string) (string, error) {
 	type request struct {
 		Interface string `json:"interface"`
 	}
 	type reply struct {
-		Description string `json:"description"`
+		Description     string `json:"description"`
+		DescriptionGzip []byte `json:"description_gzip"`
 	}
 
 	var r reply
@@ -208,21 +463,106 @@ func (c *Connection) GetInterfaceDescription(ctx context.Context, name string) (
 		return "", err
 	}
 
+	if r.DescriptionGzip != nil {
+		return decompressDescription(r.DescriptionGzip)
+	}
 	return r.Description, nil
 }
 
-// GetInfo requests information about the service.
-func (c *Connection) GetInfo(ctx context.Context, vendor *string, product *string, version *string, url *string, interfaces *[]string) error {
-	type reply struct {
-		Vendor     string   `json:"vendor"`
-		Product    string   `json:"product"`
-		Version    string   `json:"version"`
-		URL        string   `json:"url"`
-		Interfaces []string `json:"interfaces"`
+// GetInterfaceIDL requests the interface description from the service and
+// returns it parsed. The result is cached for the lifetime of the connection
+// and shared between callers, it must not be modified. A new connection starts
+// with an empty cache, as the service might have been updated in between,
+// unless it was configured with a SchemaCache holding the description for the
+// current version of the service.
+func (c *Connection) GetInterfaceIDL(ctx context.Context, name string) (*idl.IDL, error) {
+	c.mutex.Lock()
+	midl, ok := c.idls[name]
+	c.mutex.Unlock()
+	if ok {
+		return midl, nil
 	}
 
-	var r reply
+	description, err := c.cachedDescription(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	midl, err = idl.New(description)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mutex.Lock()
+	if c.idls == nil {
+		c.idls = make(map[string]*idl.IDL)
+	}
+	c.idls[name] = midl
+	c.mutex.Unlock()
+
+	return midl, nil
+}
+
+// HasMethod returns whether the service implements the fully-qualified method,
+// like org.example.Interface.Method. Interface descriptions are cached, so
+// repeated checks for the same interface do not cause further calls.
+func (c *Connection) HasMethod(ctx context.Context, method string) (bool, error) {
+	r := strings.LastIndex(method, ".")
+	if r <= 0 {
+		return false, fmt.Errorf("invalid method name '%s'", method)
+	}
+
+	midl, err := c.GetInterfaceIDL(ctx, method[:r])
+	if err != nil {
+		switch e := err.(type) {
+		case *InterfaceNotFound:
+			return false, nil
+		case *InvalidParameter:
+			if e.Parameter == "interface" {
+				return false, nil
+			}
+		}
+		return false, err
+	}
+
+	for _, m := range midl.Methods {
+		if m.Name == method[r+1:] {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// ServiceInfo is the information about a service returned by GetServiceInfo.
+type ServiceInfo struct {
+	Vendor     string   `json:"vendor"`
+	Product    string   `json:"product"`
+	Version    string   `json:"version"`
+	URL        string   `json:"url"`
+	Interfaces []string `json:"interfaces"`
+}
+
+// GetServiceInfo requests information about the service. A connection
+// configured with a SchemaCache stores it there.
+func (c *Connection) GetServiceInfo(ctx context.Context) (*ServiceInfo, error) {
+	var r ServiceInfo
 	err := c.Call(ctx, "org.varlink.service.GetInfo", nil, &r)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.schemaCache != nil {
+		c.mutex.Lock()
+		c.version = &r.Version
+		c.mutex.Unlock()
+		c.schemaCache.StoreInfo(c.address, &r)
+	}
+	return &r, nil
+}
+
+// GetInfo requests information about the service.
+func (c *Connection) GetInfo(ctx context.Context, vendor *string, product *string, version *string, url *string, interfaces *[]string) error {
+	r, err := c.GetServiceInfo(ctx)
 	if err != nil {
 		return err
 	}
@@ -249,30 +589,39 @@ func (c *Connection) GetInfo(ctx context.Context, vendor *string, product *strin
 // Upgrade attempts to upgrade the connection using the provided method and parameters.
 // If successful, the connection cannot be reused later, and must be closed.
 func (c *Connection) Upgrade(ctx context.Context, method string, parameters interface{}) (func(context.Context, interface{}) (uint64, ReadWriterContext, error), error) {
-	reply, err := c.Send(ctx, method, parameters, Upgrade)
-	if err != nil {
-		return nil, err
-	}
-
-	return func(ctx context.Context, out interface{}) (uint64, ReadWriterContext, error) {
-		flags, err := reply(ctx, out)
-		if err != nil {
-			return 0, nil, err
-		}
-
-		return flags, c.conn, nil
-	}, nil
+	return c.UpgradeWithOptions(ctx, method, parameters)
 }
 
-// Close terminates the connection.
+// Close terminates the connection. Calls waiting for the connection, and
+// all further calls, fail.
 func (c *Connection) Close() error {
+	_, closed := c.channels()
+	c.mutex.Lock()
+	if c.unusable == nil {
+		c.unusable = fmt.Errorf("connection closed")
+	}
+	select {
+	case <-closed:
+	default:
+		close(closed)
+	}
+	c.mutex.Unlock()
 	return c.conn.Close()
 }
 
 // NewConnection returns a new connection to the given address. The context
 // is used when dialling. Once successfully connected, any expiration
-// of the context will not affect the connection.
+// of the context will not affect the connection. An address of the form
+// varlink://interface@domain is resolved with DNSResolver. The "tls:host:port"
+// addresses, and "tcp:" ones with the ";tls" parameter, connect over TLS,
+// verified with the system roots; NewTLSConnection takes a configuration.
+// The "vsock:cid:port" addresses connect to virtual machines and their host,
+// "npipe:name" ones to Windows named pipes.
 func NewConnection(ctx context.Context, address string) (*Connection, error) {
+	return newConnection(ctx, address, DialConfig{})
+}
+
+func newConnection(ctx context.Context, address string, config DialConfig) (*Connection, error) {
 	words := strings.SplitN(address, ":", 2)
 
 	if len(words) != 2 {
@@ -281,6 +630,7 @@ func NewConnection(ctx context.Context, address string) (*Connection, error) {
 
 	protocol := words[0]
 	addr := words[1]
+	secure := protocol == "tls"
 
 	// Ignore parameters after ';'
 	words = strings.SplitN(addr, ";", 2)
@@ -288,23 +638,65 @@ func NewConnection(ctx context.Context, address string) (*Connection, error) {
 		addr = words[0]
 	}
 
+	if len(words) == 2 {
+		for _, parameter := range strings.Split(words[1], ";") {
+			if parameter == "tls" {
+				secure = true
+			}
+		}
+	}
+
 	switch protocol {
 	case "unix":
-		break
+		if secure {
+			return nil, fmt.Errorf("TLS is not supported on unix sockets")
+		}
 
 	case "tcp":
 		break
+
+	case "tls":
+		protocol = "tcp"
+
+	case "vsock", "npipe":
+		if secure {
+			return nil, fmt.Errorf("TLS is not supported on %s", protocol)
+		}
+
+	case "varlink":
+		iface, domain, err := parseRemoteName(addr)
+		if err != nil {
+			return nil, err
+		}
+		resolved, err := resolveDNS(ctx, iface, domain)
+		if err != nil {
+			return nil, err
+		}
+		if resolved == "" {
+			return nil, fmt.Errorf("interface '%s' is not published in '%s'", iface, domain)
+		}
+		return newConnection(ctx, resolved, config)
 	}
 
 	c := Connection{}
-	var d net.Dialer
-	conn, err := d.DialContext(ctx, protocol, addr)
+	conn, err := dial(ctx, protocol, addr, config)
 	if err != nil {
 		return nil, err
 	}
 
+	if secure {
+		conn, err = clientHandshake(ctx, conn, addr, config.TLSConfig)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	c.address = address
 	c.conn = ctxio.NewConn(conn)
+	c.conn.SetFileLimit(config.MaxFiles)
+	c.stats = config.Stats
+	c.tracer = config.Tracer
+	c.schemaCache = config.SchemaCache
 
 	return &c, nil
 }
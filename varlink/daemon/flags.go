@@ -0,0 +1,133 @@
+// Package daemon registers the command line flags every varlink daemon needs,
+// so the daemons built with this module are operated the same way. A flag
+// which is not given falls back to its environment variable, then to the
+// default of the daemon:
+//
+//	--varlink-address  VARLINK_ADDRESS    the address to listen on
+//	--timeout          VARLINK_TIMEOUT    exit after the time without a connection
+//	--log-level        VARLINK_LOG_LEVEL  debug, info, warning or error
+//
+// VARLINK_ADDRESS is the variable "varlink activate" starts a service with.
+//
+//	f := daemon.NewFlags(flag.CommandLine, daemon.Flags{Address: "unix:/run/org.example.this"})
+//	if err := f.Parse(os.Args[1:]); err != nil {
+//		log.Fatal(err)
+//	}
+//	err := f.Listen(ctx, service)
+package daemon
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/varlink/go/varlink"
+)
+
+// Level is the least severity of the messages a daemon logs.
+type Level int
+
+const (
+	Debug Level = iota + 1
+	Info
+	Warning
+	Error
+)
+
+func (l Level) String() string {
+	switch l {
+	case Debug:
+		return "debug"
+	case Info:
+		return "info"
+	case Warning:
+		return "warning"
+	case Error:
+		return "error"
+	}
+	return fmt.Sprintf("Level(%d)", int(l))
+}
+
+// Set sets the level from its name, so a Level is a flag.Value.
+func (l *Level) Set(value string) error {
+	for level := Debug; level <= Error; level++ {
+		if strings.EqualFold(value, level.String()) {
+			*l = level
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid log level '%s'", value)
+}
+
+// Enabled returns whether messages of the severity are logged.
+func (l Level) Enabled(severity Level) bool {
+	return severity >= l
+}
+
+// Flags are the standard settings of a daemon.
+type Flags struct {
+	// Address is the address the service listens on.
+	Address string
+	// Timeout ends the service after the time without a connection; zero
+	// means never.
+	Timeout time.Duration
+	// LogLevel is the least severity of the messages to log; zero means Info.
+	LogLevel Level
+
+	flags *flag.FlagSet
+}
+
+// The environment variables of the flags.
+const (
+	AddressEnv  = "VARLINK_ADDRESS"
+	TimeoutEnv  = "VARLINK_TIMEOUT"
+	LogLevelEnv = "VARLINK_LOG_LEVEL"
+)
+
+// NewFlags registers the flags in the flag set, with the defaults of the
+// daemon.
+func NewFlags(flags *flag.FlagSet, defaults Flags) *Flags {
+	f := defaults
+	f.flags = flags
+	if f.LogLevel == 0 {
+		f.LogLevel = Info
+	}
+	flags.StringVar(&f.Address, "varlink-address", f.Address, "listen on the `address`, like unix:/run/org.example.this; $"+AddressEnv)
+	flags.DurationVar(&f.Timeout, "timeout", f.Timeout, "exit after the time without a connection, zero means never; $"+TimeoutEnv)
+	flags.Var(&f.LogLevel, "log-level", "log the messages of the `level` and above: debug, info, warning or error; $"+LogLevelEnv)
+	return &f
+}
+
+// Parse parses the arguments with the flag set, and sets the flags which were
+// not given from their environment variables.
+func (f *Flags) Parse(args []string) error {
+	if err := f.flags.Parse(args); err != nil {
+		return err
+	}
+
+	given := make(map[string]bool)
+	f.flags.Visit(func(fl *flag.Flag) { given[fl.Name] = true })
+	for name, env := range map[string]string{
+		"varlink-address": AddressEnv,
+		"timeout":         TimeoutEnv,
+		"log-level":       LogLevelEnv,
+	} {
+		value, ok := os.LookupEnv(env)
+		if given[name] || !ok {
+			continue
+		}
+		if err := f.flags.Set(name, value); err != nil {
+			return fmt.Errorf("%s: %v", env, err)
+		}
+	}
+	return nil
+}
+
+// Listen runs the service at the address, with the timeout. Under socket
+// activation the passed socket is used instead of the address.
+func (f *Flags) Listen(ctx context.Context, s *varlink.Service) error {
+	return s.Listen(ctx, f.Address, f.Timeout)
+}
@@ -0,0 +1,63 @@
+package daemon
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestFlags(t *testing.T) {
+	parse := func(args ...string) (*Flags, error) {
+		flags := flag.NewFlagSet("daemon", flag.ContinueOnError)
+		flags.SetOutput(ioutil.Discard)
+		f := NewFlags(flags, Flags{Address: "unix:/run/org.example.this", Timeout: time.Minute})
+		return f, f.Parse(args)
+	}
+	settings := func(f *Flags) string {
+		return fmt.Sprint(f.Address, " ", f.Timeout, " ", f.LogLevel)
+	}
+
+	f, err := parse()
+	if err != nil {
+		t.Fatalf("Parse(): %v", err)
+	}
+	if s := settings(f); s != "unix:/run/org.example.this 1m0s info" {
+		t.Fatalf("The defaults are %s", s)
+	}
+
+	f, err = parse("--varlink-address", "tcp:127.0.0.1:12345", "--timeout=5s", "--log-level=Debug")
+	if err != nil {
+		t.Fatalf("Parse(): %v", err)
+	}
+	if s := settings(f); s != "tcp:127.0.0.1:12345 5s debug" {
+		t.Fatalf("The flags are %s", s)
+	}
+
+	// The environment is used for the flags which are not given
+	os.Setenv(AddressEnv, "unix:/run/activated")
+	os.Setenv(LogLevelEnv, "error")
+	defer os.Unsetenv(AddressEnv)
+	defer os.Unsetenv(LogLevelEnv)
+	f, err = parse("--log-level=warning")
+	if err != nil {
+		t.Fatalf("Parse(): %v", err)
+	}
+	if s := settings(f); s != "unix:/run/activated 1m0s warning" {
+		t.Fatalf("The flags are %s", s)
+	}
+	if !f.LogLevel.Enabled(Error) || f.LogLevel.Enabled(Info) {
+		t.Fatalf("Enabled() of %s is wrong", f.LogLevel)
+	}
+
+	os.Setenv(TimeoutEnv, "soon")
+	defer os.Unsetenv(TimeoutEnv)
+	if _, err := parse(); err == nil {
+		t.Fatal("Parse() accepted an invalid $" + TimeoutEnv)
+	}
+	if _, err := parse("--log-level=loud"); err == nil {
+		t.Fatal("Parse() accepted an invalid --log-level")
+	}
+}
@@ -0,0 +1,110 @@
+package varlink
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"io/ioutil"
+	"sync"
+)
+
+// CompressedDescriptions is the extension, in version 1, which lets
+// GetInterfaceDescription reply large descriptions gzip-compressed, as the
+// base64-encoded description_gzip instead of description.
+const CompressedDescriptions = "org.varlink.compressed-descriptions"
+
+// compressThreshold is the size from which descriptions are compressed,
+// smaller ones gain too little.
+const compressThreshold = 4096
+
+// storedDescription is an interface description as the service keeps it: large
+// ones gzip-compressed, and decompressed when they are requested. The services
+// of a process share one for equal descriptions, as every instance of a service
+// registers the same, often large, generated ones.
+type storedDescription struct {
+	sum        [sha256.Size]byte
+	plain      string
+	compressed []byte
+	refs       int
+}
+
+// descriptions are the stored descriptions of all services, by their checksum.
+var descriptions = struct {
+	mutex  sync.Mutex
+	stored map[[sha256.Size]byte]*storedDescription
+}{stored: make(map[[sha256.Size]byte]*storedDescription)}
+
+// storeDescription returns the stored description, which is shared until it is
+// released as often as it was stored.
+func storeDescription(description string) (*storedDescription, error) {
+	sum := sha256.Sum256([]byte(description))
+	descriptions.mutex.Lock()
+	d, ok := descriptions.stored[sum]
+	if ok {
+		d.refs++
+	}
+	descriptions.mutex.Unlock()
+	if ok {
+		return d, nil
+	}
+
+	d = &storedDescription{sum: sum, refs: 1}
+	if len(description) < compressThreshold {
+		d.plain = description
+	} else {
+		var b bytes.Buffer
+		w := gzip.NewWriter(&b)
+		if _, err := w.Write([]byte(description)); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		d.compressed = b.Bytes()
+	}
+
+	// Another service may have stored it meanwhile
+	descriptions.mutex.Lock()
+	defer descriptions.mutex.Unlock()
+	if stored, ok := descriptions.stored[sum]; ok {
+		stored.refs++
+		return stored, nil
+	}
+	descriptions.stored[sum] = d
+	return d, nil
+}
+
+func (d *storedDescription) release() {
+	descriptions.mutex.Lock()
+	defer descriptions.mutex.Unlock()
+	if d.refs--; d.refs == 0 {
+		delete(descriptions.stored, d.sum)
+	}
+}
+
+// is returns whether the description is the stored one.
+func (d *storedDescription) is(description string) bool {
+	return d.sum == sha256.Sum256([]byte(description))
+}
+
+// text returns the description.
+func (d *storedDescription) text() (string, error) {
+	if d.compressed == nil {
+		return d.plain, nil
+	}
+	return decompressDescription(d.compressed)
+}
+
+func decompressDescription(compressed []byte) (string, error) {
+	r, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
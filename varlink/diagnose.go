@@ -0,0 +1,258 @@
+package varlink
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/varlink/go/varlink/idl"
+)
+
+// DecodeError is returned by strict connections if the reply parameters do not
+// match the output parameters of the call.
+type DecodeError struct {
+	Method string
+	Err    error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("decoding reply of %s: %v", e.Method, e.Err)
+}
+
+// Diagnostic describes a difference between a reply and the interface description
+// of the called method, usually caused by a client built against another version
+// of the interface than the service.
+type Diagnostic struct {
+	Method string
+	// Path of the offending value, like "parameters.devices[0].name".
+	Path    string
+	Problem string
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s: %s: %s", d.Method, d.Path, d.Problem)
+}
+
+// SetStrict enables strict decoding of reply parameters. Unknown fields and values
+// not matching the output parameters fail the call with a DecodeError, instead of
+// being silently ignored.
+func (c *Connection) SetStrict(strict bool) {
+	c.mutex.Lock()
+	c.strict = strict
+	c.mutex.Unlock()
+}
+
+// SetUseNumber decodes numbers in untyped reply parameters, like interface{} or
+// map[string]interface{} values, as json.Number instead of float64. This keeps
+// the full range of varlink ints, which float64 cannot represent exactly beyond 2^53.
+func (c *Connection) SetUseNumber(useNumber bool) {
+	c.mutex.Lock()
+	c.useNumber = useNumber
+	c.mutex.Unlock()
+}
+
+// SetDiagnostics reports every difference between the replies and the interface
+// descriptions of the called methods to the function; nil disables the reports.
+// The interface descriptions are requested from the service on first use.
+func (c *Connection) SetDiagnostics(report func(Diagnostic)) {
+	c.mutex.Lock()
+	c.diagnostics = report
+	c.mutex.Unlock()
+}
+
+// decode decodes the reply parameters into out.
+func decode(method string, parameters *json.RawMessage, out interface{}, strict bool, useNumber bool) error {
+	if parameters == nil || out == nil {
+		return nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(*parameters))
+	if useNumber {
+		dec.UseNumber()
+	}
+	if !strict {
+		dec.Decode(out)
+		return nil
+	}
+
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(out); err != nil {
+		return &DecodeError{Method: method, Err: err}
+	}
+	return nil
+}
+
+// diagnoser checks the replies of a method call against the interface description.
+type diagnoser struct {
+	method string
+	idl    *idl.IDL
+	report func(Diagnostic)
+}
+
+func (d *diagnoser) problem(path string, format string, args ...interface{}) {
+	d.report(Diagnostic{
+		Method:  d.method,
+		Path:    path,
+		Problem: fmt.Sprintf(format, args...),
+	})
+}
+
+// reply checks the parameters of a reply or error reply.
+func (d *diagnoser) reply(errorName string, parameters *json.RawMessage) {
+	var t *idl.Type
+	if errorName == "" {
+		name := d.method[strings.LastIndex(d.method, ".")+1:]
+		for _, m := range d.idl.Methods {
+			if m.Name == name {
+				t = m.Out
+			}
+		}
+		if t == nil {
+			d.problem("method", "not defined in the interface description")
+			return
+		}
+	} else {
+		if !strings.HasPrefix(errorName, d.idl.Name+".") {
+			return
+		}
+		name := errorName[len(d.idl.Name)+1:]
+		for _, e := range d.idl.Errors {
+			if e.Name == name {
+				t = e.Type
+			}
+		}
+		if t == nil {
+			d.problem("error", "%s is not defined in the interface description", errorName)
+			return
+		}
+	}
+
+	var v interface{} = map[string]interface{}{}
+	if parameters != nil {
+		dec := json.NewDecoder(bytes.NewReader(*parameters))
+		dec.UseNumber()
+		if err := dec.Decode(&v); err != nil {
+			d.problem("parameters", "invalid JSON: %v", err)
+			return
+		}
+	}
+
+	d.value("parameters", t, v)
+}
+
+// value checks a decoded JSON value against a type.
+func (d *diagnoser) value(path string, t *idl.Type, v interface{}) {
+	if v == nil && t.Kind != idl.TypeMaybe && t.Kind != idl.TypeObject {
+		d.problem(path, "null is not allowed")
+		return
+	}
+
+	switch t.Kind {
+	case idl.TypeBool:
+		if _, ok := v.(bool); !ok {
+			d.problem(path, "expected bool, got %T", v)
+		}
+
+	case idl.TypeInt:
+		n, ok := v.(json.Number)
+		if !ok {
+			d.problem(path, "expected int, got %T", v)
+		} else if _, err := n.Int64(); err != nil {
+			d.problem(path, "expected int, got %s", n)
+		}
+
+	case idl.TypeFloat:
+		if _, ok := v.(json.Number); !ok {
+			d.problem(path, "expected float, got %T", v)
+		}
+
+	case idl.TypeString:
+		if _, ok := v.(string); !ok {
+			d.problem(path, "expected string, got %T", v)
+		}
+
+	case idl.TypeObject:
+
+	case idl.TypeMaybe:
+		if v != nil {
+			d.value(path, t.ElementType, v)
+		}
+
+	case idl.TypeArray:
+		a, ok := v.([]interface{})
+		if !ok {
+			d.problem(path, "expected array, got %T", v)
+			return
+		}
+		for i, e := range a {
+			d.value(fmt.Sprintf("%s[%d]", path, i), t.ElementType, e)
+		}
+
+	case idl.TypeMap:
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			d.problem(path, "expected map, got %T", v)
+			return
+		}
+		for _, k := range sortedKeys(m) {
+			d.value(fmt.Sprintf("%s[%q]", path, k), t.ElementType, m[k])
+		}
+
+	case idl.TypeEnum:
+		s, ok := v.(string)
+		if !ok {
+			d.problem(path, "expected enum, got %T", v)
+			return
+		}
+		for _, f := range t.Fields {
+			if f.Name == s {
+				return
+			}
+		}
+		d.problem(path, "unknown enum value %q", s)
+
+	case idl.TypeStruct:
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			d.problem(path, "expected object, got %T", v)
+			return
+		}
+		known := make(map[string]bool, len(t.Fields))
+		for _, f := range t.Fields {
+			known[f.Name] = true
+			e, ok := m[f.Name]
+			if !ok {
+				if f.Type.Kind != idl.TypeMaybe {
+					d.problem(path+"."+f.Name, "missing")
+				}
+				continue
+			}
+			d.value(path+"."+f.Name, f.Type, e)
+		}
+		for _, k := range sortedKeys(m) {
+			if !known[k] {
+				d.problem(path+"."+k, "unknown field")
+			}
+		}
+
+	case idl.TypeAlias:
+		for _, a := range d.idl.Aliases {
+			if a.Name == t.Alias {
+				d.value(path, a.Type, v)
+				return
+			}
+		}
+		d.problem(path, "unknown type %s", t.Alias)
+	}
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
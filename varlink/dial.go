@@ -0,0 +1,121 @@
+package varlink
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"os"
+	"syscall"
+	"time"
+)
+
+// DialConfig configures how NewConnectionWithConfig connects to a service.
+type DialConfig struct {
+	// Timeout limits the time to connect, including retries and the TLS
+	// handshake; zero leaves it to the context.
+	Timeout time.Duration
+
+	// Retry keeps retrying for that long while the service refuses the connection
+	// or its unix socket does not exist yet, like when a socket-activated unit or
+	// a just started service did not bind its socket yet.
+	Retry time.Duration
+
+	// TLSConfig verifies the service of "tls:" addresses, like NewTLSConnection.
+	TLSConfig *tls.Config
+
+	// DialContext connects to "tcp:" and "tls:" addresses instead of a
+	// net.Dialer, like through a SOCKS5 proxy with the DialContext method of a
+	// golang.org/x/net/proxy dialer, or from a bound local address.
+	DialContext func(ctx context.Context, network, address string) (net.Conn, error)
+
+	// Stats receives the connection attempts and the method calls of the
+	// connection, for metrics.
+	Stats StatsHandler
+
+	// Tracer starts a span for every method call of the connection, and sends
+	// its trace context in the metadata of the call, if the service negotiated
+	// the CallMetadata extension.
+	Tracer Tracer
+
+	// SchemaCache keeps the interface descriptions requested by GetInterfaceIDL
+	// for the next connections to the address, as long as the service keeps its
+	// version.
+	SchemaCache *SchemaCache
+
+	// MaxFiles lets the connection receive the files passed along the replies
+	// on unix sockets, like the ones of blobs, up to that many per reply; zero
+	// receives none. A service passing more is disconnected and its files are
+	// closed.
+	MaxFiles int
+}
+
+// retryInterval is the longest wait between two connection attempts.
+const retryInterval = time.Second / 4
+
+// NewConnectionWithConfig returns a new connection to the given address, like
+// NewConnection, connecting as configured.
+func NewConnectionWithConfig(ctx context.Context, address string, config DialConfig) (*Connection, error) {
+	if config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.Timeout)
+		defer cancel()
+	}
+	return newConnection(ctx, address, config)
+}
+
+// dial connects to the address, retrying until the deadline while the service
+// is not there yet.
+func dial(ctx context.Context, protocol string, addr string, config DialConfig) (net.Conn, error) {
+	dialContext := config.DialContext
+	if dialContext == nil {
+		var d net.Dialer
+		dialContext = d.DialContext
+	}
+
+	deadline := time.Now().Add(config.Retry)
+	wait := retryInterval / 16
+	for {
+		var conn net.Conn
+		var err error
+		start := time.Now()
+		switch protocol {
+		case "unix":
+			conn, err = dialUnix(ctx, addr)
+		case "npipe":
+			conn, err = dialPipe(ctx, pipeName(addr))
+		case "vsock":
+			var vsockAddr *VsockAddr
+			if vsockAddr, err = parseVsockAddr(addr, false); err != nil {
+				return nil, err
+			}
+			conn, err = dialVsock(ctx, vsockAddr)
+		default:
+			conn, err = dialContext(ctx, protocol, addr)
+		}
+		handleStats(config.Stats, Stats{
+			Kind:     DialAttempt,
+			Address:  protocol + ":" + addr,
+			Duration: time.Since(start),
+			Err:      err,
+		})
+		if err == nil || !notListening(err) || time.Now().Add(wait).After(deadline) {
+			return conn, err
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil, err
+		}
+		if wait *= 2; wait > retryInterval {
+			wait = retryInterval
+		}
+	}
+}
+
+// notListening returns whether the error tells that nobody listens on the
+// address yet.
+func notListening(err error) bool {
+	return errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, os.ErrNotExist)
+}
@@ -0,0 +1,69 @@
+package varlink
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Replaced by tests.
+var (
+	lookupSRV = net.DefaultResolver.LookupSRV
+	lookupTXT = net.DefaultResolver.LookupTXT
+)
+
+// DNSResolver resolves interface names published in the DNS of a domain. The
+// service implementing io.example.service in example.com is published with an SRV
+// record named _varlink._tcp.io.example.service.example.com. A TXT record
+// "tls=required" with the same name requires TLS for the connection. Without
+// DNSSEC, the records are only as trustworthy as the network path to the DNS server.
+func DNSResolver(domain string) NameResolver {
+	return NameResolverFunc(func(ctx context.Context, iface string) (string, error) {
+		return resolveDNS(ctx, iface, domain)
+	})
+}
+
+func resolveDNS(ctx context.Context, iface string, domain string) (string, error) {
+	name := iface + "." + strings.TrimSuffix(domain, ".")
+
+	_, records, err := lookupSRV(ctx, "varlink", "tcp", name)
+	if err != nil {
+		if e, ok := err.(*net.DNSError); ok && e.IsNotFound {
+			return "", nil
+		}
+		return "", err
+	}
+	if len(records) == 0 {
+		return "", nil
+	}
+
+	// The records are sorted by priority and weight
+	host := strings.TrimSuffix(records[0].Target, ".")
+	address := "tcp:" + net.JoinHostPort(host, strconv.Itoa(int(records[0].Port)))
+
+	// Failing to learn whether TLS is required must not downgrade to plaintext
+	txts, err := lookupTXT(ctx, "_varlink._tcp."+name)
+	if err != nil {
+		if e, ok := err.(*net.DNSError); !ok || !e.IsNotFound {
+			return "", err
+		}
+	}
+	for _, txt := range txts {
+		if txt == "tls=required" {
+			address += ";tls"
+		}
+	}
+
+	return address, nil
+}
+
+// parseRemoteName splits a varlink://interface@domain address.
+func parseRemoteName(address string) (string, string, error) {
+	words := strings.SplitN(strings.TrimPrefix(address, "//"), "@", 2)
+	if len(words) != 2 || words[0] == "" || words[1] == "" {
+		return "", "", fmt.Errorf("invalid remote address 'varlink:%s'", address)
+	}
+	return words[0], words[1], nil
+}
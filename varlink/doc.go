@@ -59,5 +59,39 @@ Service implementing the interface and its method:
 
 	service.RegisterInterface(orgexamplethis.VarlinkNew(&data))
 	err := service.Listen("unix:/run/org.example.this", 0)
+
+The generator maps the varlink types to Go types: bool to bool, int to int64, float to
+float64, string and enums to string, arrays to slices, maps to maps with string keys,
+and maybe types to pointers. The foreign object type is passed through opaquely as
+json.RawMessage, which needs no pointer for ?object; the -object-type and -object-import
+flags of the generator select another Go type.
+
+Varlink field names are kept on the wire in the json tags. The Go field names capitalize
+the first letter by default; -field-names camel converts snake_case names to CamelCase,
+active_engines becomes ActiveEngines, for all nested types alike. In the other direction,
+varlink-go-type-generator uses the json tags of Go struct fields, and -field-names snake
+converts the names of untagged fields to snake_case.
+
+A description file may hold several related interfaces, each starting with its
+interface keyword. The generator then writes the package of every interface to a
+subdirectory named after the package, next to the file.
+
+A daemon exposing many small interfaces can implement them all with one type. Every
+generated package has a VarlinkBase type replying MethodNotImplemented to all methods;
+the type embeds the bases and overrides the methods it handles, and RegisterAll
+registers every generated interface it implements:
+
+	type daemon struct {
+		orgexamplethis.VarlinkBase
+		orgexamplethat.VarlinkBase
+	}
+
+	err := varlink.RegisterAll(service, &daemon{})
+
+With -skeleton, the generator also writes an implementation of all methods, the
+VarlinkService type in the <package>_service.go file, to be edited. The file is only
+written if it does not exist; regenerating after the interface changed updates the
+generated file alone, and VarlinkService serves new methods through its embedded
+VarlinkBase until they are implemented.
 */
 package varlink
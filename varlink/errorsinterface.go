@@ -0,0 +1,24 @@
+package varlink
+
+import "context"
+
+// errorsInterface is an interface of this package declaring errors the service
+// replies on its own, so clients can look them up with GetInterfaceDescription.
+// They are kept out of org.varlink.service, whose description is the one of the
+// varlink specification. It has no methods.
+type errorsInterface struct {
+	name        string
+	description string
+}
+
+func (e *errorsInterface) VarlinkDispatch(ctx context.Context, call Call, methodname string) error {
+	return call.ReplyMethodNotFound(ctx, methodname)
+}
+
+func (e *errorsInterface) VarlinkGetName() string {
+	return e.name
+}
+
+func (e *errorsInterface) VarlinkGetDescription() string {
+	return e.description
+}
@@ -0,0 +1,210 @@
+package varlink
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Extension is an optional protocol feature, like multiplexing, another codec
+// or compression, with the versions of it a peer supports.
+type Extension struct {
+	Name     string `json:"name"`
+	Versions []int  `json:"versions"`
+}
+
+// agreedExtension is an extension both peers use, in the highest common version.
+type agreedExtension struct {
+	Name    string `json:"name"`
+	Version int    `json:"version"`
+}
+
+// negotiate returns the extensions of offered which are supported, in the order
+// of offered, each in the highest version both support.
+func negotiate(supported []Extension, offered []Extension) []agreedExtension {
+	agreed := []agreedExtension{}
+	for _, o := range offered {
+		for _, s := range supported {
+			if s.Name != o.Name {
+				continue
+			}
+
+			version := -1
+			for _, v := range o.Versions {
+				for _, w := range s.Versions {
+					if v == w && v > version {
+						version = v
+					}
+				}
+			}
+			if version >= 0 {
+				agreed = append(agreed, agreedExtension{Name: o.Name, Version: version})
+			}
+			break
+		}
+	}
+	return agreed
+}
+
+type extensionsKey struct{}
+
+// negotiated holds the extensions agreed on a connection.
+type negotiated struct {
+	mutex    sync.Mutex
+	versions map[string]int
+}
+
+func (n *negotiated) set(agreed []agreedExtension) {
+	versions := make(map[string]int, len(agreed))
+	for _, a := range agreed {
+		versions[a.Name] = a.Version
+	}
+
+	n.mutex.Lock()
+	n.versions = versions
+	n.mutex.Unlock()
+}
+
+func (n *negotiated) get(name string) (int, bool) {
+	if n == nil {
+		return 0, false
+	}
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	v, ok := n.versions[name]
+	return v, ok
+}
+
+func negotiatedFromContext(ctx context.Context) *negotiated {
+	n, _ := ctx.Value(extensionsKey{}).(*negotiated)
+	return n
+}
+
+// Extension returns the version of the extension agreed on the connection of the
+// call. Until the client negotiated it, the connection speaks baseline varlink.
+func (c *Call) Extension(name string) (int, bool) {
+	return c.extensions.get(name)
+}
+
+// extensionsInterface implements org.varlink.extensions, which is registered
+// with ServiceConfig.Extensions.
+type extensionsInterface struct {
+	supported []Extension
+}
+
+func newExtensionsInterface(supported []Extension) (*extensionsInterface, error) {
+	names := make(map[string]bool)
+	for _, e := range supported {
+		if e.Name == "" || len(e.Versions) == 0 {
+			return nil, fmt.Errorf("extension '%s' needs a name and versions", e.Name)
+		}
+		if names[e.Name] {
+			return nil, fmt.Errorf("extension '%s' listed twice", e.Name)
+		}
+		names[e.Name] = true
+	}
+	return &extensionsInterface{supported: supported}, nil
+}
+
+func (e *extensionsInterface) VarlinkDispatch(ctx context.Context, call Call, methodname string) error {
+	switch methodname {
+	case "Negotiate":
+		var in struct {
+			Offered []Extension `json:"offered"`
+		}
+		err := call.GetParameters(&in)
+		if err != nil {
+			return call.ReplyInvalidParameter(ctx, "offered")
+		}
+
+		agreed := negotiate(e.supported, in.Offered)
+		if call.extensions != nil {
+			call.extensions.set(agreed)
+		}
+
+		var out struct {
+			Accepted []agreedExtension `json:"accepted"`
+		}
+		out.Accepted = agreed
+		return call.Reply(ctx, &out)
+
+	default:
+		return call.ReplyMethodNotFound(ctx, methodname)
+	}
+}
+
+func (e *extensionsInterface) VarlinkGetName() string {
+	return `org.varlink.extensions`
+}
+
+func (e *extensionsInterface) VarlinkGetDescription() string {
+	return `# Negotiation of optional protocol extensions of a connection. Clients which
+# do not negotiate, and services without this interface, speak baseline varlink.
+interface org.varlink.extensions
+
+type Extension (name: string, versions: []int)
+
+type Agreed (name: string, version: int)
+
+# Agree on the extensions used on this connection, each in the highest version
+# both sides support. A new negotiation replaces the previous one.
+method Negotiate(offered: []Extension) -> (accepted: []Agreed)`
+}
+
+// Negotiate offers the extensions to the service and returns the agreed
+// versions, which are then available with Extension. A service which does not
+// implement org.varlink.extensions agrees on none, the connection stays baseline
+// varlink; only other errors are returned.
+func (c *Connection) Negotiate(ctx context.Context, offered ...Extension) (map[string]int, error) {
+	if offered == nil {
+		offered = []Extension{}
+	}
+	in := struct {
+		Offered []Extension `json:"offered"`
+	}{offered}
+	var out struct {
+		Accepted []agreedExtension `json:"accepted"`
+	}
+
+	err := c.Call(ctx, "org.varlink.extensions.Negotiate", &in, &out)
+	switch err.(type) {
+	case nil:
+	case *InterfaceNotFound, *MethodNotFound:
+		out.Accepted = nil
+	default:
+		return nil, err
+	}
+
+	// Only accept what was offered, in an offered version
+	versions := make(map[string]int)
+	for _, a := range negotiate(offered, toExtensions(out.Accepted)) {
+		versions[a.Name] = a.Version
+	}
+
+	c.mutex.Lock()
+	c.extensions = versions
+	c.mutex.Unlock()
+
+	result := make(map[string]int, len(versions))
+	for name, v := range versions {
+		result[name] = v
+	}
+	return result, nil
+}
+
+func toExtensions(agreed []agreedExtension) []Extension {
+	extensions := make([]Extension, 0, len(agreed))
+	for _, a := range agreed {
+		extensions = append(extensions, Extension{Name: a.Name, Versions: []int{a.Version}})
+	}
+	return extensions
+}
+
+// Extension returns the version of the extension agreed with Negotiate.
+func (c *Connection) Extension(name string) (int, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	v, ok := c.extensions[name]
+	return v, ok
+}
@@ -3,14 +3,36 @@ package varlink_test
 // test with no internal access
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
 	"os"
+	"os/exec"
+	"path/filepath"
 	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/varlink/go/varlink"
+	"github.com/varlink/go/varlink/capture"
 )
 
 type VarlinkInterface struct{}
@@ -23,7 +45,7 @@ func (s *VarlinkInterface) VarlinkGetName() string {
 }
 
 func (s *VarlinkInterface) VarlinkGetDescription() string {
-	return "#"
+	return "interface org.example.test\nmethod Ping() -> ()"
 }
 
 type VarlinkInterface2 struct{}
@@ -39,6 +61,74 @@ func (s *VarlinkInterface2) VarlinkGetDescription() string {
 	return "#"
 }
 
+// SkewInterface replies with parameters not matching its description.
+type SkewInterface struct{}
+
+func (s *SkewInterface) VarlinkDispatch(ctx context.Context, call varlink.Call, methodname string) error {
+	return call.Reply(ctx, map[string]interface{}{
+		"devices": []interface{}{map[string]interface{}{"name": "a", "size": 1.5, "extra": true}},
+		"state":   "broken",
+		"unknown": "x",
+	})
+}
+func (s *SkewInterface) VarlinkGetName() string {
+	return `org.example.skew`
+}
+
+func (s *SkewInterface) VarlinkGetDescription() string {
+	return `interface org.example.skew
+type Device (name: string, size: int)
+method Get() -> (devices: []Device, state: (on, off), label: ?string)`
+}
+
+// StreamInterface streams four events; the first call fails after two of them,
+// later calls replay the last delivered event.
+type StreamInterface struct {
+	mutex sync.Mutex
+	calls int
+}
+
+func (s *StreamInterface) callCount() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.calls
+}
+
+func (s *StreamInterface) VarlinkDispatch(ctx context.Context, call varlink.Call, methodname string) error {
+	var in struct {
+		After int `json:"after"`
+	}
+	call.GetParameters(&in)
+
+	s.mutex.Lock()
+	s.calls++
+	calls := s.calls
+	s.mutex.Unlock()
+	first := in.After
+	if first == 0 {
+		first = 1
+	}
+	for id := first; id <= 4; id++ {
+		if calls == 1 && id == 3 {
+			return fmt.Errorf("connection lost")
+		}
+		call.Continues = id < 4
+		if err := call.Reply(ctx, map[string]int{"id": id}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *StreamInterface) VarlinkGetName() string {
+	return `org.example.stream`
+}
+
+func (s *StreamInterface) VarlinkGetDescription() string {
+	return `interface org.example.stream
+method Watch(after: int) -> (id: int)`
+}
+
 func TestRegisterService(t *testing.T) {
 	newTestInterface := new(VarlinkInterface)
 	service, err := varlink.NewService(
@@ -72,12 +162,53 @@ func TestRegisterService(t *testing.T) {
 
 	time.Sleep(time.Second / 5)
 
-	n := new(VarlinkInterface2)
+	c, err := varlink.NewConnection(ctx, "unix:varlinkexternal_TestRegisterService")
+	if err != nil {
+		t.Fatalf("NewConnection(): %v", err)
+	}
+	defer c.Close()
+	interfaces := func() string {
+		var interfaces []string
+		if err := c.GetInfo(ctx, nil, nil, nil, nil, &interfaces); err != nil {
+			t.Fatalf("GetInfo(): %v", err)
+		}
+		return fmt.Sprint(interfaces)
+	}
+
+	if err := service.RegisterInterface(&MetadataInterface{}); err != nil {
+		t.Fatalf("Couldn't register service while running: %v", err)
+	}
+	if got := interfaces(); got != "[org.varlink.service org.varlink.internal org.varlink.stream org.example.test org.example.metadata]" {
+		t.Fatalf("GetInfo() returned %s", got)
+	}
+	if err := c.Call(ctx, "org.example.metadata.Get", nil, nil); err != nil {
+		t.Fatalf("Get() returned %v", err)
+	}
 
-	if err := service.RegisterInterface(n); err == nil {
-		t.Fatal("Could register service while running")
+	if err := service.UnregisterInterface("org.example.test"); err != nil {
+		t.Fatalf("Couldn't unregister service: %v", err)
+	}
+	if err := service.UnregisterInterface("org.example.test"); err == nil {
+		t.Fatal("Could unregister service twice")
+	}
+	if err := service.UnregisterInterface("org.varlink.service"); err == nil {
+		t.Fatal("Could unregister org.varlink.service")
+	}
+	if got := interfaces(); got != "[org.varlink.service org.varlink.internal org.varlink.stream org.example.metadata]" {
+		t.Fatalf("GetInfo() returned %s", got)
+	}
+	if _, err := c.GetInterfaceDescription(ctx, "org.example.test"); err == nil {
+		t.Fatal("GetInterfaceDescription() found the unregistered service")
+	}
+	if err := c.Call(ctx, "org.example.test.Ping", nil, nil); err == nil {
+		t.Fatal("Unregistered service was called")
+	} else if _, ok := err.(*varlink.InterfaceNotFound); !ok {
+		t.Fatalf("Ping() returned %v", err)
+	}
+
+	if err := service.RegisterInterface(new(VarlinkInterface2)); err == nil {
+		t.Fatal("Could register service with an invalid description")
 	}
-	time.Sleep(time.Second / 5)
 	service.Shutdown()
 
 	if err := <-servererror; err != nil {
@@ -119,57 +250,212 @@ func TestUnix(t *testing.T) {
 	}
 }
 
-func TestInvalidAddress(t *testing.T) {
-	newTestInterface := new(VarlinkInterface)
+func TestServiceInfo(t *testing.T) {
 	service, err := varlink.NewService(
 		"Varlink",
 		"Varlink Test",
 		"1",
 		"https://github.com/varlink/go/varlink",
 	)
+	if err != nil {
+		t.Fatalf("NewService(): %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	servererror := make(chan error)
+	go func() {
+		servererror <- service.Listen(ctx, "unix:varlinkexternal_TestServiceInfo", 0)
+	}()
+	time.Sleep(time.Second / 5)
+
+	c, err := varlink.NewConnection(ctx, "unix:varlinkexternal_TestServiceInfo")
+	if err != nil {
+		t.Fatalf("NewConnection(): %v", err)
+	}
+
+	info, err := c.GetServiceInfo(ctx)
+	if err != nil {
+		t.Fatalf("GetServiceInfo(): %v", err)
+	}
+	if info.Product != "Varlink Test" || fmt.Sprint(info.Interfaces) != "[org.varlink.service org.varlink.internal org.varlink.stream]" {
+		t.Fatalf("Unexpected info %v", info)
+	}
+
+	midl, err := c.GetInterfaceIDL(ctx, "org.varlink.service")
+	if err != nil {
+		t.Fatalf("GetInterfaceIDL(): %v", err)
+	}
+	if midl.Name != "org.varlink.service" || len(midl.Methods) != 2 {
+		t.Fatalf("Unexpected interface %s with %d methods", midl.Name, len(midl.Methods))
+	}
+
+	if _, err := c.GetInterfaceIDL(ctx, "org.example.missing"); err == nil {
+		t.Fatal("GetInterfaceIDL() should error")
+	}
+
+	if cached, _ := c.GetInterfaceIDL(ctx, "org.varlink.service"); cached != midl {
+		t.Fatal("GetInterfaceIDL() did not cache the interface")
+	}
 
+	for method, expected := range map[string]bool{
+		"org.varlink.service.GetInfo": true,
+		"org.varlink.service.Missing": false,
+		"org.example.missing.Method":  false,
+	} {
+		found, err := c.HasMethod(ctx, method)
+		if err != nil {
+			t.Fatalf("HasMethod(): %v", err)
+		}
+		if found != expected {
+			t.Fatalf("HasMethod(%s) returned %v", method, found)
+		}
+	}
+	if _, err := c.HasMethod(ctx, "GetInfo"); err == nil {
+		t.Fatal("HasMethod() should error")
+	}
+
+	c.Close()
+	service.Shutdown()
+	if err := <-servererror; err != nil {
+		t.Fatalf("service.Listen(): %v", err)
+	}
+}
+
+func TestConcurrentCalls(t *testing.T) {
+	service, err := varlink.NewService(
+		"Varlink",
+		"Varlink Test",
+		"1",
+		"https://github.com/varlink/go/varlink",
+	)
 	if err != nil {
 		t.Fatalf("NewService(): %v", err)
 	}
 
-	if err = service.RegisterInterface(newTestInterface); err != nil {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	servererror := make(chan error)
+	go func() {
+		servererror <- service.Listen(ctx, "unix:varlinkexternal_TestConcurrentCalls", 0)
+	}()
+	time.Sleep(time.Second / 5)
+
+	c, err := varlink.NewConnection(ctx, "unix:varlinkexternal_TestConcurrentCalls")
+	if err != nil {
+		t.Fatalf("NewConnection(): %v", err)
+	}
+
+	errc := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		go func(i int) {
+			if i%2 == 0 {
+				info, err := c.GetServiceInfo(ctx)
+				if err == nil && info.Product != "Varlink Test" {
+					err = fmt.Errorf("unexpected info %v", info)
+				}
+				errc <- err
+				return
+			}
+			description, err := c.GetInterfaceDescription(ctx, "org.varlink.service")
+			if err == nil && !strings.HasPrefix(description, "# The Varlink Service Interface") {
+				err = fmt.Errorf("unexpected description %s", description)
+			}
+			errc <- err
+		}(i)
+	}
+	for i := 0; i < 20; i++ {
+		if err := <-errc; err != nil {
+			t.Fatalf("Concurrent call: %v", err)
+		}
+	}
+
+	c.Close()
+	service.Shutdown()
+	if err := <-servererror; err != nil {
+		t.Fatalf("service.Listen(): %v", err)
+	}
+}
+
+func TestCloseAbandonedStream(t *testing.T) {
+	service, err := varlink.NewService(
+		"Varlink",
+		"Varlink Test",
+		"1",
+		"https://github.com/varlink/go/varlink",
+	)
+	if err != nil {
+		t.Fatalf("NewService(): %v", err)
+	}
+	if err := service.RegisterInterface(&StreamInterface{calls: 1}); err != nil {
 		t.Fatalf("RegisterInterface(): %v", err)
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	if err = service.Listen(ctx, "foo", 0); err == nil {
-		t.Fatalf("service.Listen() should error")
+	servererror := make(chan error)
+	go func() {
+		servererror <- service.Listen(ctx, "unix:varlinkexternal_TestCloseAbandonedStream", 0)
+	}()
+	time.Sleep(time.Second / 5)
+
+	c, err := varlink.NewConnection(ctx, "unix:varlinkexternal_TestCloseAbandonedStream")
+	if err != nil {
+		t.Fatalf("NewConnection(): %v", err)
 	}
 
-	if err = service.Listen(ctx, "", 0); err == nil {
-		t.Fatalf("service.Listen() should error")
+	receive, err := c.Send(ctx, "org.example.stream.Watch", nil, varlink.More)
+	if err != nil {
+		t.Fatalf("Send(): %v", err)
+	}
+	var out struct {
+		ID int `json:"id"`
+	}
+	if _, err := receive(ctx, &out); err != nil {
+		t.Fatalf("receive(): %v", err)
 	}
 
-	if err = service.Listen(ctx, "unix", 0); err == nil {
-		t.Fatalf("service.Listen() should error")
+	// The stream is abandoned, the next call waits for it until Close.
+	errc := make(chan error)
+	go func() {
+		_, err := c.GetServiceInfo(context.Background())
+		errc <- err
+	}()
+	time.Sleep(time.Second / 10)
+	c.Close()
+
+	select {
+	case err := <-errc:
+		if err == nil {
+			t.Fatal("GetServiceInfo() succeeded on a closed connection")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("GetServiceInfo() still waits after Close()")
+	}
+	if _, err := c.GetServiceInfo(context.Background()); err == nil {
+		t.Fatal("GetServiceInfo() succeeded on a closed connection")
 	}
-}
 
-func TestAnonUnix(t *testing.T) {
-	if runtime.GOOS != "linux" {
-		return
+	service.Shutdown()
+	if err := <-servererror; err != nil {
+		t.Fatalf("service.Listen(): %v", err)
 	}
+}
 
-	newTestInterface := new(VarlinkInterface)
+func TestReplyDiagnostics(t *testing.T) {
 	service, err := varlink.NewService(
 		"Varlink",
 		"Varlink Test",
 		"1",
 		"https://github.com/varlink/go/varlink",
 	)
-
 	if err != nil {
 		t.Fatalf("NewService(): %v", err)
 	}
-
-	if err := service.RegisterInterface(newTestInterface); err != nil {
+	if err := service.RegisterInterface(&SkewInterface{}); err != nil {
 		t.Fatalf("RegisterInterface(): %v", err)
 	}
 
@@ -177,53 +463,3189 @@ func TestAnonUnix(t *testing.T) {
 	defer cancel()
 
 	servererror := make(chan error)
-
 	go func() {
-		servererror <- service.Listen(ctx, "unix:@varlinkexternal_TestAnonUnix", 0)
+		servererror <- service.Listen(ctx, "unix:varlinkexternal_TestReplyDiagnostics", 0)
 	}()
-
 	time.Sleep(time.Second / 5)
+
+	c, err := varlink.NewConnection(ctx, "unix:varlinkexternal_TestReplyDiagnostics")
+	if err != nil {
+		t.Fatalf("NewConnection(): %v", err)
+	}
+
+	var out struct {
+		Devices []struct {
+			Name string `json:"name"`
+		} `json:"devices"`
+		State string `json:"state"`
+	}
+
+	var diagnostics []string
+	c.SetDiagnostics(func(d varlink.Diagnostic) {
+		diagnostics = append(diagnostics, d.String())
+	})
+	if err := c.Call(ctx, "org.example.skew.Get", nil, &out); err != nil {
+		t.Fatalf("Call(): %v", err)
+	}
+	if out.State != "broken" || len(out.Devices) != 1 {
+		t.Fatalf("Unexpected reply %v", out)
+	}
+	expected := strings.Join([]string{
+		"org.example.skew.Get: parameters.devices[0].size: expected int, got 1.5",
+		"org.example.skew.Get: parameters.devices[0].extra: unknown field",
+		"org.example.skew.Get: parameters.state: unknown enum value \"broken\"",
+		"org.example.skew.Get: parameters.unknown: unknown field",
+	}, "\n")
+	if returned := strings.Join(diagnostics, "\n"); returned != expected {
+		t.Fatalf("Expected diagnostics:\n%s\nGot:\n%s", expected, returned)
+	}
+
+	c.SetStrict(true)
+	err = c.Call(ctx, "org.example.skew.Get", nil, &out)
+	if _, ok := err.(*varlink.DecodeError); !ok {
+		t.Fatalf("Expected DecodeError, got %v", err)
+	}
+
+	c.Close()
 	service.Shutdown()
+	if err := <-servererror; err != nil {
+		t.Fatalf("service.Listen(): %v", err)
+	}
+}
+
+func TestCapture(t *testing.T) {
+	var serverCapture, clientCapture bytes.Buffer
+	service, err := varlink.NewServiceWithConfig(
+		"Varlink",
+		"Varlink Test",
+		"1",
+		"https://github.com/varlink/go/varlink",
+		varlink.ServiceConfig{Capture: capture.NewWriter(&serverCapture)},
+	)
+	if err != nil {
+		t.Fatalf("NewService(): %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	servererror := make(chan error)
+	go func() {
+		servererror <- service.Listen(ctx, "unix:varlinkexternal_TestCapture", 0)
+	}()
+	time.Sleep(time.Second / 5)
 
+	c, err := varlink.NewConnection(ctx, "unix:varlinkexternal_TestCapture")
+	if err != nil {
+		t.Fatalf("NewConnection(): %v", err)
+	}
+	c.SetCapture(capture.NewWriter(&clientCapture))
+	if _, err := c.GetServiceInfo(ctx); err != nil {
+		t.Fatalf("GetServiceInfo(): %v", err)
+	}
+	c.Close()
+	service.Shutdown()
 	if err := <-servererror; err != nil {
 		t.Fatalf("service.Listen(): %v", err)
 	}
+
+	for _, test := range []struct {
+		capture    *bytes.Buffer
+		directions []capture.Direction
+	}{
+		{&clientCapture, []capture.Direction{capture.Sent, capture.Received}},
+		{&serverCapture, []capture.Direction{capture.Received, capture.Sent}},
+	} {
+		r := capture.NewReader(test.capture)
+		for i, direction := range test.directions {
+			record, err := r.Next()
+			if err != nil {
+				t.Fatalf("Next(): %v", err)
+			}
+			if record.Direction != direction || record.Connection != 1 {
+				t.Fatalf("Unexpected record %v", record)
+			}
+			if i == 0 && string(record.Data) != `{"method":"org.varlink.service.GetInfo","parameters":null}`+"\x00" {
+				t.Fatalf("Unexpected data %q", record.Data)
+			}
+		}
+		if _, err := r.Next(); err != io.EOF {
+			t.Fatalf("Expected EOF, got %v", err)
+		}
+	}
 }
 
-func TestListenFDSNotInt(t *testing.T) {
-	newTestInterface := new(VarlinkInterface)
+func TestSubscription(t *testing.T) {
 	service, err := varlink.NewService(
 		"Varlink",
 		"Varlink Test",
 		"1",
 		"https://github.com/varlink/go/varlink",
 	)
-
 	if err != nil {
 		t.Fatalf("NewService(): %v", err)
 	}
-
-	if err := service.RegisterInterface(newTestInterface); err != nil {
-		t.Fatalf("Couldn't register service: %v", err)
+	stream := &StreamInterface{}
+	if err := service.RegisterInterface(stream); err != nil {
+		t.Fatalf("RegisterInterface(): %v", err)
 	}
-	os.Setenv("LISTEN_FDS", "foo")
-	os.Setenv("LISTEN_PID", fmt.Sprint(os.Getpid()))
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
 	servererror := make(chan error)
-
 	go func() {
-		servererror <- service.Listen(ctx, "unix:varlinkexternal_TestListenFDSNotInt", 0)
+		servererror <- service.Listen(ctx, "unix:varlinkexternal_TestSubscription", 0)
 	}()
+	time.Sleep(time.Second / 5)
+
+	s := varlink.NewSubscription(varlink.SubscriptionConfig{
+		Dial: func(ctx context.Context) (*varlink.Connection, error) {
+			return varlink.NewConnection(ctx, "unix:varlinkexternal_TestSubscription")
+		},
+		Method: "org.example.stream.Watch",
+		Parameters: func(resume string) interface{} {
+			var after int
+			fmt.Sscan(resume, &after)
+			return map[string]int{"after": after}
+		},
+		Token: func(event json.RawMessage) string {
+			var e struct {
+				ID int `json:"id"`
+			}
+			json.Unmarshal(event, &e)
+			return fmt.Sprint(e.ID)
+		},
+		RetryInterval: time.Millisecond,
+	})
+	defer s.Close()
+
+	var ids []string
+	for {
+		event, err := s.Next(ctx)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Next(): %v", err)
+		}
+		ids = append(ids, string(event))
+	}
+	if strings.Join(ids, " ") != `{"id":1} {"id":2} {"id":3} {"id":4}` || stream.callCount() != 2 {
+		t.Fatalf("Unexpected events %v after %d calls", ids, stream.callCount())
+	}
+
+	service.Shutdown()
+	if err := <-servererror; err != nil {
+		t.Fatalf("service.Listen(): %v", err)
+	}
+}
+
+func TestResolverChain(t *testing.T) {
+	// Windows does not report unix sockets as such
+	if runtime.GOOS == "windows" {
+		return
+	}
+
+	ctx := context.Background()
+
+	os.Setenv("VARLINK_ADDRESS_ORG_EXAMPLE_ENV", "unix:/run/org.example.env")
+	defer os.Unsetenv("VARLINK_ADDRESS_ORG_EXAMPLE_ENV")
+
+	l, err := net.Listen("unix", "org.example.socket")
+	if err != nil {
+		t.Fatalf("net.Listen(): %v", err)
+	}
+	defer l.Close()
+
+	chain := varlink.ResolverChain{
+		varlink.EnvironmentResolver(),
+		varlink.SocketPathResolver("."),
+		varlink.NameResolverFunc(func(ctx context.Context, iface string) (string, error) {
+			return "tcp:127.0.0.1:12345", nil
+		}),
+	}
+
+	for iface, expected := range map[string]string{
+		"org.example.env":    "unix:/run/org.example.env",
+		"org.example.socket": "unix:org.example.socket",
+		"org.example.other":  "tcp:127.0.0.1:12345",
+	} {
+		address, err := chain.ResolveName(ctx, iface)
+		if err != nil {
+			t.Fatalf("ResolveName(): %v", err)
+		}
+		if address != expected {
+			t.Fatalf("ResolveName(%s) returned %s", iface, address)
+		}
+	}
+
+	if _, err := varlink.NewInterfaceConnection(ctx, "org.example.other", chain[:2]); err == nil {
+		t.Fatal("NewInterfaceConnection() should error")
+	}
+}
+
+func TestDuplicateInstance(t *testing.T) {
+	newService := func() *varlink.Service {
+		service, err := varlink.NewService(
+			"Varlink",
+			"Varlink Test",
+			"1",
+			"https://github.com/varlink/go/varlink",
+		)
+		if err != nil {
+			t.Fatalf("NewService(): %v", err)
+		}
+		return service
+	}
+
+	// A stale socket is replaced
+	l, err := net.Listen("unix", "varlinkexternal_TestDuplicateInstance")
+	if err != nil {
+		t.Fatalf("net.Listen(): %v", err)
+	}
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	l.Close()
 
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	service := newService()
+	servererror := make(chan error)
+	go func() {
+		servererror <- service.Listen(ctx, "unix:varlinkexternal_TestDuplicateInstance", 0)
+	}()
 	time.Sleep(time.Second / 5)
+
+	err = newService().Listen(ctx, "unix:varlinkexternal_TestDuplicateInstance", 0)
+	if e, ok := err.(varlink.ServiceRunningError); !ok || e.Product != "Varlink Test" {
+		t.Fatalf("Expected ServiceRunningError, got %v", err)
+	}
+
 	service.Shutdown()
+	if err := <-servererror; err != nil {
+		t.Fatalf("service.Listen(): %v", err)
+	}
+}
 
-	err = <-servererror
+func TestInvalidAddress(t *testing.T) {
+	newTestInterface := new(VarlinkInterface)
+	service, err := varlink.NewService(
+		"Varlink",
+		"Varlink Test",
+		"1",
+		"https://github.com/varlink/go/varlink",
+	)
 
 	if err != nil {
-		t.Fatalf("service.Run(): %v", err)
+		t.Fatalf("NewService(): %v", err)
+	}
+
+	if err = service.RegisterInterface(newTestInterface); err != nil {
+		t.Fatalf("RegisterInterface(): %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err = service.Listen(ctx, "foo", 0); err == nil {
+		t.Fatalf("service.Listen() should error")
+	}
+
+	if err = service.Listen(ctx, "", 0); err == nil {
+		t.Fatalf("service.Listen() should error")
+	}
+
+	if err = service.Listen(ctx, "unix", 0); err == nil {
+		t.Fatalf("service.Listen() should error")
+	}
+}
+
+func TestAnonUnix(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		return
+	}
+
+	newTestInterface := new(VarlinkInterface)
+	service, err := varlink.NewService(
+		"Varlink",
+		"Varlink Test",
+		"1",
+		"https://github.com/varlink/go/varlink",
+	)
+
+	if err != nil {
+		t.Fatalf("NewService(): %v", err)
+	}
+
+	if err := service.RegisterInterface(newTestInterface); err != nil {
+		t.Fatalf("RegisterInterface(): %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	servererror := make(chan error)
+
+	go func() {
+		servererror <- service.Listen(ctx, "unix:@varlinkexternal_TestAnonUnix", 0)
+	}()
+
+	time.Sleep(time.Second / 5)
+	service.Shutdown()
+
+	if err := <-servererror; err != nil {
+		t.Fatalf("service.Listen(): %v", err)
+	}
+}
+
+func TestListenFDSNotInt(t *testing.T) {
+	newTestInterface := new(VarlinkInterface)
+	service, err := varlink.NewService(
+		"Varlink",
+		"Varlink Test",
+		"1",
+		"https://github.com/varlink/go/varlink",
+	)
+
+	if err != nil {
+		t.Fatalf("NewService(): %v", err)
+	}
+
+	if err := service.RegisterInterface(newTestInterface); err != nil {
+		t.Fatalf("Couldn't register service: %v", err)
+	}
+	os.Setenv("LISTEN_FDS", "foo")
+	os.Setenv("LISTEN_PID", fmt.Sprint(os.Getpid()))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	servererror := make(chan error)
+
+	go func() {
+		servererror <- service.Listen(ctx, "unix:varlinkexternal_TestListenFDSNotInt", 0)
+	}()
+
+	time.Sleep(time.Second / 5)
+	service.Shutdown()
+
+	err = <-servererror
+
+	if err != nil {
+		t.Fatalf("service.Run(): %v", err)
+	}
+}
+
+func TestRunActivatedWithoutSockets(t *testing.T) {
+	service, err := varlink.NewService(
+		"Varlink",
+		"Varlink Test",
+		"1",
+		"https://github.com/varlink/go/varlink",
+	)
+	if err != nil {
+		t.Fatalf("NewService(): %v", err)
+	}
+	os.Unsetenv("LISTEN_PID")
+
+	if names := varlink.ActivationNames(); names != nil {
+		t.Fatalf("ActivationNames(): %v", names)
+	}
+	if err := service.RunActivated(context.Background(), "varlink", 0); err == nil {
+		t.Fatalf("RunActivated() without activation sockets succeeded")
+	}
+}
+
+func TestOtherProtocol(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unix sockets are not available")
+	}
+
+	l := varlink.NewProtocolListener(nil)
+	defer l.Close()
+	service, err := varlink.NewServiceWithConfig(
+		"Varlink",
+		"Varlink Test",
+		"1",
+		"https://github.com/varlink/go/varlink",
+		varlink.ServiceConfig{OtherProtocol: l.Handoff},
+	)
+	if err != nil {
+		t.Fatalf("NewService(): %v", err)
+	}
+
+	go http.Serve(l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "hello %s", r.URL.Path)
+	}))
+
+	ctx := context.Background()
+	servererror := make(chan error)
+	go func() {
+		servererror <- service.Listen(ctx, "unix:varlinkexternal_TestOtherProtocol", 0)
+	}()
+	time.Sleep(time.Second / 5)
+
+	client := http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return net.Dial("unix", "varlinkexternal_TestOtherProtocol")
+		},
+		DisableKeepAlives: true,
+	}}
+	resp, err := client.Get("http://varlink/world")
+	if err != nil {
+		t.Fatalf("Get(): %v", err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || string(body) != "hello /world" {
+		t.Fatalf("Unexpected HTTP reply '%s': %v", body, err)
+	}
+
+	c, err := varlink.NewConnection(ctx, "unix:varlinkexternal_TestOtherProtocol")
+	if err != nil {
+		t.Fatalf("NewConnection(): %v", err)
+	}
+	if _, err := c.GetServiceInfo(ctx); err != nil {
+		t.Fatalf("GetServiceInfo(): %v", err)
+	}
+	c.Close()
+
+	service.Shutdown()
+	if err := <-servererror; err != nil {
+		t.Fatalf("service.Listen(): %v", err)
+	}
+}
+
+// testCertificate returns a self-signed certificate for the host name varlink.
+func testCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		DNSNames:     []string{"varlink"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+func TestALPN(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unix sockets are not available")
+	}
+
+	cert, pool := testCertificate(t)
+	l := varlink.NewProtocolListener(nil)
+	defer l.Close()
+	service, err := varlink.NewServiceWithConfig(
+		"Varlink",
+		"Varlink Test",
+		"1",
+		"https://github.com/varlink/go/varlink",
+		varlink.ServiceConfig{
+			OtherProtocol: l.Handoff,
+			TLSConfig: &tls.Config{
+				Certificates: []tls.Certificate{cert},
+				NextProtos:   []string{"http/1.1"},
+			},
+		},
+	)
+	if err != nil {
+		t.Fatalf("NewService(): %v", err)
+	}
+
+	go http.Serve(l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "hello %s", r.URL.Path)
+	}))
+
+	ctx := context.Background()
+	servererror := make(chan error)
+	go func() {
+		servererror <- service.Listen(ctx, "unix:varlinkexternal_TestALPN", 0)
+	}()
+	time.Sleep(time.Second / 5)
+
+	dial := func(protocols ...string) *tls.Conn {
+		conn, err := net.Dial("unix", "varlinkexternal_TestALPN")
+		if err != nil {
+			t.Fatalf("Dial(): %v", err)
+		}
+		c := tls.Client(conn, &tls.Config{ServerName: "varlink", RootCAs: pool, NextProtos: protocols})
+		if err := c.Handshake(); err != nil {
+			t.Fatalf("Handshake(): %v", err)
+		}
+		return c
+	}
+
+	getInfo := func(c *tls.Conn) {
+		defer c.Close()
+		if _, err := c.Write([]byte(`{"method":"org.varlink.service.GetInfo"}` + "\000")); err != nil {
+			t.Fatalf("Write(): %v", err)
+		}
+		reply, err := bufio.NewReader(c).ReadString(0)
+		if err != nil || !strings.Contains(reply, `"product":"Varlink Test"`) {
+			t.Fatalf("Unexpected reply '%s': %v", reply, err)
+		}
+	}
+
+	// Negotiated varlink, and no protocol at all
+	c := dial(varlink.ALPNProtocol)
+	if p := c.ConnectionState().NegotiatedProtocol; p != varlink.ALPNProtocol {
+		t.Fatalf("Negotiated '%s'", p)
+	}
+	getInfo(c)
+	getInfo(dial())
+
+	client := http.Client{Transport: &http.Transport{
+		DialTLS: func(network, addr string) (net.Conn, error) {
+			return dial("http/1.1"), nil
+		},
+		DisableKeepAlives: true,
+	}}
+	resp, err := client.Get("https://varlink/world")
+	if err != nil {
+		t.Fatalf("Get(): %v", err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || string(body) != "hello /world" {
+		t.Fatalf("Unexpected HTTP reply '%s': %v", body, err)
+	}
+
+	service.Shutdown()
+	if err := <-servererror; err != nil {
+		t.Fatalf("service.Listen(): %v", err)
+	}
+}
+
+type PeerInterface struct{}
+
+func (s *PeerInterface) VarlinkDispatch(ctx context.Context, call varlink.Call, methodname string) error {
+	p, err := call.PeerProcess()
+	if err != nil {
+		return call.ReplyError(ctx, "org.example.peer.Unknown", nil)
+	}
+	cred, err := call.Credentials()
+	if err != nil || cred.PID != p.Pid {
+		return call.ReplyError(ctx, "org.example.peer.Unknown", nil)
+	}
+	return call.Reply(ctx, map[string]interface{}{"pid": p.Pid, "executable": p.Executable, "uid": cred.UID, "gid": cred.GID})
+}
+
+func (s *PeerInterface) VarlinkGetName() string {
+	return `org.example.peer`
+}
+
+func (s *PeerInterface) VarlinkGetDescription() string {
+	return `interface org.example.peer
+method Get() -> (pid: int, executable: string, uid: int, gid: int)
+error Unknown ()`
+}
+
+func TestPeerProcess(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("peer processes are only known on linux")
+	}
+
+	service, _ := varlink.NewService(
+		"Varlink",
+		"Varlink Test",
+		"1",
+		"https://github.com/varlink/go/varlink",
+	)
+	if err := service.RegisterInterface(&PeerInterface{}); err != nil {
+		t.Fatalf("RegisterInterface(): %v", err)
+	}
+
+	ctx := context.Background()
+	servererror := make(chan error)
+	go func() {
+		servererror <- service.Listen(ctx, "unix:varlinkexternal_TestPeerProcess", 0)
+	}()
+	time.Sleep(time.Second / 5)
+
+	c, err := varlink.NewConnection(ctx, "unix:varlinkexternal_TestPeerProcess")
+	if err != nil {
+		t.Fatalf("NewConnection(): %v", err)
+	}
+	var out struct {
+		Pid        int    `json:"pid"`
+		Executable string `json:"executable"`
+		UID        int    `json:"uid"`
+		GID        int    `json:"gid"`
+	}
+	if err := c.Call(ctx, "org.example.peer.Get", nil, &out); err != nil {
+		t.Fatalf("Call(): %v", err)
+	}
+	c.Close()
+
+	exe, _ := os.Executable()
+	if out.Pid != os.Getpid() || out.Executable != exe || out.UID != os.Getuid() || out.GID != os.Getgid() {
+		t.Fatalf("Unexpected peer %+v, expected %d %s %d %d", out, os.Getpid(), exe, os.Getuid(), os.Getgid())
+	}
+
+	service.Shutdown()
+	if err := <-servererror; err != nil {
+		t.Fatalf("service.Listen(): %v", err)
+	}
+}
+
+// TestReexecHelper is the service program of TestReexec.
+func TestReexecHelper(t *testing.T) {
+	address := os.Getenv("VARLINK_TEST_REEXEC")
+	if address == "" {
+		t.Skip("run by TestReexec")
+	}
+
+	product := "first"
+	state, inherited := varlink.ReexecState()
+	if inherited {
+		product = string(state)
+	}
+	service, _ := varlink.NewService("Varlink", product, "1", "https://github.com/varlink/go/varlink")
+
+	if !inherited {
+		go func() {
+			time.Sleep(time.Second / 2)
+			err := service.Reexec(context.Background(), varlink.ReexecConfig{State: []byte("second")})
+			fmt.Fprintf(os.Stderr, "Reexec(): %v\n", err)
+			os.Exit(1)
+		}()
+	}
+
+	if err := service.Listen(context.Background(), address, 0); err != nil {
+		fmt.Fprintf(os.Stderr, "Listen(): %v\n", err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+func TestReexec(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("re-exec is not supported")
+	}
+
+	address := "unix:varlinkexternal_TestReexec"
+	cmd := exec.Command(os.Args[0], "-test.run=TestReexecHelper")
+	cmd.Env = append(os.Environ(), "VARLINK_TEST_REEXEC="+address)
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Start(): %v", err)
+	}
+	defer func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+		os.Remove("varlinkexternal_TestReexec")
+	}()
+
+	// The product changes when the program was executed again on the same socket
+	var products []string
+	for i := 0; i < 100 && (len(products) == 0 || products[len(products)-1] != "second"); i++ {
+		time.Sleep(time.Second / 20)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		c, err := varlink.NewConnection(ctx, address)
+		if err != nil {
+			cancel()
+			continue
+		}
+		info, err := c.GetServiceInfo(ctx)
+		c.Close()
+		cancel()
+		if err != nil {
+			continue
+		}
+		if len(products) == 0 || products[len(products)-1] != info.Product {
+			products = append(products, info.Product)
+		}
+	}
+
+	if strings.Join(products, " ") != "first second" {
+		t.Fatalf("Unexpected products %v", products)
+	}
+}
+
+func TestReexecFailure(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("re-exec is not supported")
+	}
+
+	service, _ := varlink.NewService("Varlink", "Varlink Test", "1", "https://github.com/varlink/go/varlink")
+	ctx := context.Background()
+	servererror := make(chan error)
+	go func() {
+		servererror <- service.Listen(ctx, "unix:varlinkexternal_TestReexecFailure", 0)
+	}()
+	time.Sleep(time.Second / 5)
+
+	if err := service.Reexec(ctx, varlink.ReexecConfig{Path: "/nonexistent/program"}); err == nil {
+		t.Fatalf("Reexec() of a missing program succeeded")
+	}
+
+	// The service still accepts connections
+	c, err := varlink.NewConnection(ctx, "unix:varlinkexternal_TestReexecFailure")
+	if err != nil {
+		t.Fatalf("NewConnection(): %v", err)
+	}
+	if _, err := c.GetServiceInfo(ctx); err != nil {
+		t.Fatalf("GetServiceInfo(): %v", err)
+	}
+	c.Close()
+
+	service.Shutdown()
+	if err := <-servererror; err != nil {
+		t.Fatalf("service.Listen(): %v", err)
+	}
+}
+
+type ExtensionInterface struct{}
+
+func (s *ExtensionInterface) VarlinkDispatch(ctx context.Context, call varlink.Call, methodname string) error {
+	version, _ := call.Extension("org.example.compression")
+	return call.Reply(ctx, map[string]interface{}{"version": version})
+}
+
+func (s *ExtensionInterface) VarlinkGetName() string {
+	return `org.example.extension`
+}
+
+func (s *ExtensionInterface) VarlinkGetDescription() string {
+	return `interface org.example.extension
+method Get() -> (version: int)`
+}
+
+func TestNegotiate(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("skipping test on windows")
+	}
+
+	service, err := varlink.NewServiceWithConfig(
+		"Varlink",
+		"Varlink Test",
+		"1",
+		"https://github.com/varlink/go/varlink",
+		varlink.ServiceConfig{Extensions: []varlink.Extension{
+			{Name: "org.example.compression", Versions: []int{1, 2}},
+			{Name: "org.example.multiplex", Versions: []int{1}},
+		}},
+	)
+	if err != nil {
+		t.Fatalf("NewServiceWithConfig(): %v", err)
+	}
+	if err := service.RegisterInterface(&ExtensionInterface{}); err != nil {
+		t.Fatalf("RegisterInterface(): %v", err)
+	}
+	baseline, _ := varlink.NewService(
+		"Varlink",
+		"Varlink Test",
+		"1",
+		"https://github.com/varlink/go/varlink",
+	)
+
+	ctx := context.Background()
+	servererror := make(chan error, 2)
+	go func() {
+		servererror <- service.Listen(ctx, "unix:varlinkexternal_TestNegotiate", 0)
+	}()
+	go func() {
+		servererror <- baseline.Listen(ctx, "unix:varlinkexternal_TestNegotiateBaseline", 0)
+	}()
+	time.Sleep(time.Second / 5)
+
+	c, err := varlink.NewConnection(ctx, "unix:varlinkexternal_TestNegotiate")
+	if err != nil {
+		t.Fatalf("NewConnection(): %v", err)
+	}
+
+	get := func() int {
+		var out struct {
+			Version int `json:"version"`
+		}
+		if err := c.Call(ctx, "org.example.extension.Get", nil, &out); err != nil {
+			t.Fatalf("Call(): %v", err)
+		}
+		return out.Version
+	}
+
+	if v := get(); v != 0 {
+		t.Fatalf("Extension used before negotiation: %d", v)
+	}
+
+	agreed, err := c.Negotiate(ctx,
+		varlink.Extension{Name: "org.example.compression", Versions: []int{2, 3}},
+		varlink.Extension{Name: "org.example.codec", Versions: []int{1}},
+	)
+	if err != nil {
+		t.Fatalf("Negotiate(): %v", err)
+	}
+	if len(agreed) != 1 || agreed["org.example.compression"] != 2 {
+		t.Fatalf("Unexpected agreement %v", agreed)
+	}
+	if v, ok := c.Extension("org.example.compression"); !ok || v != 2 {
+		t.Fatalf("Extension() returned %d %v", v, ok)
+	}
+	if v := get(); v != 2 {
+		t.Fatalf("Service uses version %d", v)
+	}
+	c.Close()
+
+	// A service without extensions is baseline varlink
+	c, err = varlink.NewConnection(ctx, "unix:varlinkexternal_TestNegotiateBaseline")
+	if err != nil {
+		t.Fatalf("NewConnection(): %v", err)
+	}
+	agreed, err = c.Negotiate(ctx, varlink.Extension{Name: "org.example.compression", Versions: []int{1}})
+	if err != nil || len(agreed) != 0 {
+		t.Fatalf("Negotiate() with baseline service returned %v %v", agreed, err)
+	}
+	if _, ok := c.Extension("org.example.compression"); ok {
+		t.Fatal("Extension agreed with baseline service")
+	}
+	c.Close()
+
+	service.Shutdown()
+	baseline.Shutdown()
+	for i := 0; i < 2; i++ {
+		if err := <-servererror; err != nil {
+			t.Fatalf("service.Listen(): %v", err)
+		}
+	}
+}
+
+func TestUnsealedBlob(t *testing.T) {
+	w, err := ioutil.TempFile("", "varlink-blob")
+	if err != nil {
+		t.Fatalf("TempFile(): %v", err)
+	}
+	defer os.Remove(w.Name())
+	defer w.Close()
+	if _, err := w.Write([]byte("varlink")); err != nil {
+		t.Fatalf("Write(): %v", err)
+	}
+
+	// A file the peer can still shrink is copied instead of mapped
+	reply := varlink.FileReply{Files: []*os.File{w}}
+	data, release, err := reply.MapBlob(varlink.Blob{Fd: 0, Size: 7})
+	if err != nil || string(data) != "varlink" {
+		t.Fatalf("MapBlob() returned %q: %v", data, err)
+	}
+	if err := w.Truncate(0); err != nil {
+		t.Fatalf("Truncate(): %v", err)
+	}
+	if string(data) != "varlink" {
+		t.Fatalf("Blob changed to %q", data)
+	}
+	release()
+}
+
+type BlobInterface struct{}
+
+func (s *BlobInterface) VarlinkDispatch(ctx context.Context, call varlink.Call, methodname string) error {
+	blob, err := call.AttachBlob(bytes.Repeat([]byte("varlink "), 1<<17))
+	if err != nil {
+		return call.ReplyError(ctx, "org.example.blob.NoFiles", nil)
+	}
+	return call.Reply(ctx, map[string]interface{}{"blob": blob})
+}
+
+func (s *BlobInterface) VarlinkGetName() string {
+	return `org.example.blob`
+}
+
+func (s *BlobInterface) VarlinkGetDescription() string {
+	return `interface org.example.blob
+type Blob (fd: int, size: int)
+method Get() -> (blob: Blob)
+error NoFiles ()`
+}
+
+func TestBlob(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("files are only passed on unix sockets")
+	}
+
+	service, _ := varlink.NewService(
+		"Varlink",
+		"Varlink Test",
+		"1",
+		"https://github.com/varlink/go/varlink",
+	)
+	if err := service.RegisterInterface(&BlobInterface{}); err != nil {
+		t.Fatalf("RegisterInterface(): %v", err)
+	}
+
+	ctx := context.Background()
+	servererror := make(chan error)
+	go func() {
+		servererror <- service.Listen(ctx, "unix:varlinkexternal_TestBlob", 0)
+	}()
+	time.Sleep(time.Second / 5)
+
+	var out struct {
+		Blob varlink.Blob `json:"blob"`
+	}
+	reply := varlink.FileReply{Parameters: &out}
+
+	// Files are not received unless the connection is configured for them
+	c, err := varlink.NewConnection(ctx, "unix:varlinkexternal_TestBlob")
+	if err != nil {
+		t.Fatalf("NewConnection(): %v", err)
+	}
+	if err := c.Call(ctx, "org.example.blob.Get", nil, &reply); err != nil {
+		t.Fatalf("Call(): %v", err)
+	}
+	if len(reply.Files) != 0 {
+		t.Fatalf("Received %d files", len(reply.Files))
+	}
+	if _, err := reply.ReadBlob(out.Blob); err == nil {
+		t.Fatal("ReadBlob() succeeded without files")
+	}
+	c.Close()
+
+	c, err = varlink.NewConnectionWithConfig(ctx, "unix:varlinkexternal_TestBlob", varlink.DialConfig{MaxFiles: 1})
+	if err != nil {
+		t.Fatalf("NewConnectionWithConfig(): %v", err)
+	}
+	if err := c.Call(ctx, "org.example.blob.Get", nil, &reply); err != nil {
+		t.Fatalf("Call(): %v", err)
+	}
+	expected := bytes.Repeat([]byte("varlink "), 1<<17)
+	if len(reply.Files) != 1 || out.Blob.Size != int64(len(expected)) {
+		t.Fatalf("Unexpected reply %+v with %d files", out, len(reply.Files))
+	}
+
+	data, err := reply.ReadBlob(out.Blob)
+	if err != nil || !bytes.Equal(data, expected) {
+		t.Fatalf("ReadBlob() returned %d bytes: %v", len(data), err)
+	}
+	data, release, err := reply.MapBlob(out.Blob)
+	if err != nil || !bytes.Equal(data, expected) {
+		t.Fatalf("MapBlob() returned %d bytes: %v", len(data), err)
+	}
+	if err := release(); err != nil {
+		t.Fatalf("release(): %v", err)
+	}
+
+	// Sizes forged by the peer are refused
+	for _, size := range []int64{-1, out.Blob.Size + 1, 1 << 62} {
+		forged := varlink.Blob{Fd: out.Blob.Fd, Size: size}
+		if _, err := reply.ReadBlob(forged); err == nil {
+			t.Fatalf("ReadBlob() accepted the size %d", size)
+		}
+		if _, _, err := reply.MapBlob(forged); err == nil {
+			t.Fatalf("MapBlob() accepted the size %d", size)
+		}
+	}
+
+	// The memory file is sealed
+	f, _ := reply.File(out.Blob)
+	if runtime.GOOS == "linux" {
+		if name, _ := os.Readlink(fmt.Sprintf("/proc/self/fd/%d", f.Fd())); !strings.HasPrefix(name, "/memfd:varlink-blob") {
+			t.Fatalf("Blob is passed as '%s'", name)
+		}
+	}
+	if _, err := f.WriteAt([]byte("changed"), 0); err == nil {
+		t.Fatal("Blob file is writable")
+	}
+	reply.Close()
+
+	// Files passed with replies to plain calls are closed
+	if err := c.Call(ctx, "org.example.blob.Get", nil, &out); err != nil {
+		t.Fatalf("Call(): %v", err)
+	}
+	if _, err := reply.File(out.Blob); err == nil {
+		t.Fatal("File() of a closed reply succeeded")
+	}
+	c.Close()
+
+	service.Shutdown()
+	if err := <-servererror; err != nil {
+		t.Fatalf("service.Listen(): %v", err)
+	}
+}
+
+type LargeInterface struct {
+	description string
+}
+
+func (s *LargeInterface) VarlinkDispatch(ctx context.Context, call varlink.Call, methodname string) error {
+	return call.ReplyMethodNotImplemented(ctx, methodname)
+}
+
+func (s *LargeInterface) VarlinkGetName() string {
+	return `org.example.large`
+}
+
+func (s *LargeInterface) VarlinkGetDescription() string {
+	return s.description
+}
+
+func TestCompressedDescriptions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("skipping test on windows")
+	}
+
+	var b strings.Builder
+	b.WriteString("interface org.example.large\n")
+	for i := 0; i < 500; i++ {
+		fmt.Fprintf(&b, "\n# Method number %d.\nmethod Method%d(argument: string) -> (result: string)\n", i, i)
+	}
+	large := &LargeInterface{description: b.String()}
+
+	service, err := varlink.NewServiceWithConfig(
+		"Varlink",
+		"Varlink Test",
+		"1",
+		"https://github.com/varlink/go/varlink",
+		varlink.ServiceConfig{CompressDescriptions: true},
+	)
+	if err != nil {
+		t.Fatalf("NewServiceWithConfig(): %v", err)
+	}
+	if err := service.RegisterInterface(large); err != nil {
+		t.Fatalf("RegisterInterface(): %v", err)
+	}
+
+	ctx := context.Background()
+	servererror := make(chan error)
+	go func() {
+		servererror <- service.Listen(ctx, "unix:varlinkexternal_TestCompressedDescriptions", 0)
+	}()
+	time.Sleep(time.Second / 5)
+
+	c, err := varlink.NewConnection(ctx, "unix:varlinkexternal_TestCompressedDescriptions")
+	if err != nil {
+		t.Fatalf("NewConnection(): %v", err)
+	}
+
+	raw := func() map[string]json.RawMessage {
+		var out map[string]json.RawMessage
+		if err := c.Call(ctx, "org.varlink.service.GetInterfaceDescription", map[string]string{"interface": "org.example.large"}, &out); err != nil {
+			t.Fatalf("Call(): %v", err)
+		}
+		return out
+	}
+
+	if _, ok := raw()["description"]; !ok {
+		t.Fatal("Description compressed without negotiation")
+	}
+
+	agreed, err := c.Negotiate(ctx, varlink.Extension{Name: varlink.CompressedDescriptions, Versions: []int{1}})
+	if err != nil || agreed[varlink.CompressedDescriptions] != 1 {
+		t.Fatalf("Negotiate() returned %v %v", agreed, err)
+	}
+	out := raw()
+	if _, ok := out["description_gzip"]; !ok || len(out["description_gzip"]) >= len(large.description) {
+		t.Fatalf("Description not compressed: %d bytes", len(out["description_gzip"]))
+	}
+
+	description, err := c.GetInterfaceDescription(ctx, "org.example.large")
+	if err != nil || description != large.description {
+		t.Fatalf("GetInterfaceDescription() returned %d bytes: %v", len(description), err)
+	}
+	if _, err := c.GetInterfaceIDL(ctx, "org.example.large"); err != nil {
+		t.Fatalf("GetInterfaceIDL(): %v", err)
+	}
+
+	// A reload replaces the compressed description
+	large.description += "\n# Added on reload.\nmethod Reloaded() -> ()\n"
+	if err := service.Reload(); err != nil {
+		t.Fatalf("Reload(): %v", err)
+	}
+	description, err = c.GetInterfaceDescription(ctx, "org.example.large")
+	if err != nil || description != large.description {
+		t.Fatalf("GetInterfaceDescription() after Reload() returned %d bytes: %v", len(description), err)
+	}
+	c.Close()
+
+	service.Shutdown()
+	if err := <-servererror; err != nil {
+		t.Fatalf("service.Listen(): %v", err)
+	}
+}
+
+type MetadataInterface struct{}
+
+func (s *MetadataInterface) VarlinkDispatch(ctx context.Context, call varlink.Call, methodname string) error {
+	if methodname == "Sleep" {
+		time.Sleep(time.Second / 2)
+	}
+	return call.Reply(ctx, map[string]string{"value": call.Metadata("id")})
+}
+
+func (s *MetadataInterface) VarlinkGetName() string {
+	return `org.example.metadata`
+}
+
+func (s *MetadataInterface) VarlinkGetDescription() string {
+	return `interface org.example.metadata
+method Get() -> (value: string)
+method Sleep() -> (value: string)`
+}
+
+func TestCallOptions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("skipping test on windows")
+	}
+
+	service, err := varlink.NewServiceWithConfig(
+		"Varlink",
+		"Varlink Test",
+		"1",
+		"https://github.com/varlink/go/varlink",
+		varlink.ServiceConfig{Extensions: []varlink.Extension{{Name: varlink.CallMetadata, Versions: []int{1}}}},
+	)
+	if err != nil {
+		t.Fatalf("NewServiceWithConfig(): %v", err)
+	}
+	if err := service.RegisterInterface(&MetadataInterface{}); err != nil {
+		t.Fatalf("RegisterInterface(): %v", err)
+	}
+
+	ctx := context.Background()
+	servererror := make(chan error)
+	go func() {
+		servererror <- service.Listen(ctx, "unix:varlinkexternal_TestCallOptions", 0)
+	}()
+	time.Sleep(time.Second / 5)
+
+	c, err := varlink.NewConnection(ctx, "unix:varlinkexternal_TestCallOptions")
+	if err != nil {
+		t.Fatalf("NewConnection(): %v", err)
+	}
+
+	get := func(method string, opts ...varlink.CallOption) (string, error) {
+		receive, err := c.SendWithOptions(ctx, "org.example.metadata."+method, nil, opts...)
+		if err != nil {
+			return "", err
+		}
+		var out struct {
+			Value string `json:"value"`
+		}
+		_, err = receive(ctx, &out)
+		return out.Value, err
+	}
+
+	// Metadata is left out until the extension was negotiated
+	if v, err := get("Get", varlink.WithMetadata("id", "a")); err != nil || v != "" {
+		t.Fatalf("Get() returned '%s' %v", v, err)
+	}
+	if _, err := c.Negotiate(ctx, varlink.Extension{Name: varlink.CallMetadata, Versions: []int{1}}); err != nil {
+		t.Fatalf("Negotiate(): %v", err)
+	}
+	if v, err := get("Get", varlink.WithMetadata("id", "a")); err != nil || v != "a" {
+		t.Fatalf("Get() returned '%s' %v", v, err)
+	}
+
+	if _, err := get("Sleep", varlink.WithTimeout(time.Second/10)); err != context.DeadlineExceeded {
+		t.Fatalf("Sleep() returned %v", err)
+	}
+	c.Close()
+
+	// The options of the caller are not appended to in place
+	c, err = varlink.NewConnection(ctx, "unix:varlinkexternal_TestCallOptions")
+	if err != nil {
+		t.Fatalf("NewConnection(): %v", err)
+	}
+	opts := make([]varlink.CallOption, 1, 2)
+	opts[0] = varlink.WithMetadata("id", "b")
+	receive, err := c.UpgradeWithOptions(ctx, "org.example.metadata.Get", nil, opts...)
+	if err != nil {
+		t.Fatalf("UpgradeWithOptions(): %v", err)
+	}
+	if _, _, err := receive(ctx, nil); err != nil {
+		t.Fatalf("Get() returned %v", err)
+	}
+	if opts[:2][1] != nil {
+		t.Fatal("UpgradeWithOptions() wrote into the options of the caller")
+	}
+	c.Close()
+
+	service.Shutdown()
+	if err := <-servererror; err != nil {
+		t.Fatalf("service.Listen(): %v", err)
+	}
+}
+
+type namedError struct{}
+
+func (e *namedError) Error() string            { return "named" }
+func (e *namedError) VarlinkErrorName() string { return "org.example.errors.Named" }
+
+func TestErrorIs(t *testing.T) {
+	err := fmt.Errorf("call failed: %w", &varlink.Error{Name: "org.example.errors.Named"})
+	if !errors.Is(err, &varlink.Error{Name: "org.example.errors.Named"}) || !errors.Is(err, &namedError{}) {
+		t.Fatal("Error does not match its name")
+	}
+	if errors.Is(err, &varlink.Error{Name: "org.example.errors.Other"}) {
+		t.Fatal("Error matches another name")
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("skipping test on windows")
+	}
+
+	newService := func() *varlink.Service {
+		service, err := varlink.NewService("Varlink", "Varlink Test", "1", "https://github.com/varlink/go/varlink")
+		if err != nil {
+			t.Fatalf("NewService(): %v", err)
+		}
+		if err := service.RegisterInterface(&MetadataInterface{}); err != nil {
+			t.Fatalf("RegisterInterface(): %v", err)
+		}
+		return service
+	}
+
+	// A service shut down before it started does not start
+	service := newService()
+	if err := service.Shutdown(); err != nil {
+		t.Fatalf("Shutdown(): %v", err)
+	}
+	ctx := context.Background()
+	if err := service.Listen(ctx, "unix:varlinkexternal_TestShutdown", 0); err != (varlink.ServiceStoppedError{}) {
+		t.Fatalf("Listen() returned %v", err)
+	}
+
+	service = newService()
+	servererror := make(chan error)
+	go func() {
+		servererror <- service.Listen(ctx, "unix:varlinkexternal_TestShutdown", 0)
+	}()
+	time.Sleep(time.Second / 5)
+
+	idle, err := varlink.NewConnection(ctx, "unix:varlinkexternal_TestShutdown")
+	if err != nil {
+		t.Fatalf("NewConnection(): %v", err)
+	}
+	defer idle.Close()
+	c, err := varlink.NewConnection(ctx, "unix:varlinkexternal_TestShutdown")
+	if err != nil {
+		t.Fatalf("NewConnection(): %v", err)
+	}
+	defer c.Close()
+	receive, err := c.Send(ctx, "org.example.metadata.Sleep", nil, 0)
+	if err != nil {
+		t.Fatalf("Send(): %v", err)
+	}
+	time.Sleep(time.Second / 10)
+
+	// The idle connection is closed right away, the call in progress is handled
+	if err := service.Shutdown(); err != nil {
+		t.Fatalf("Shutdown(): %v", err)
+	}
+	if err := service.Shutdown(); err != nil {
+		t.Fatalf("second Shutdown(): %v", err)
+	}
+	if _, err := receive(ctx, nil); err != nil {
+		t.Fatalf("Sleep() returned %v", err)
+	}
+	if err := <-servererror; err != nil {
+		t.Fatalf("service.Listen(): %v", err)
+	}
+	if err := idle.GetInfo(ctx, nil, nil, nil, nil, nil); err == nil {
+		t.Fatal("idle connection still open after Shutdown()")
+	}
+
+	if err := service.Listen(ctx, "unix:varlinkexternal_TestShutdown", 0); err != (varlink.ServiceStoppedError{}) {
+		t.Fatalf("Listen() after Shutdown() returned %v", err)
+	}
+}
+
+func TestShutdownContext(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("skipping test on windows")
+	}
+
+	ctx := context.Background()
+	shutdown := func(timeout time.Duration) (error, error) {
+		service, err := varlink.NewService("Varlink", "Varlink Test", "1", "https://github.com/varlink/go/varlink")
+		if err != nil {
+			t.Fatalf("NewService(): %v", err)
+		}
+		if err := service.RegisterInterface(&MetadataInterface{}); err != nil {
+			t.Fatalf("RegisterInterface(): %v", err)
+		}
+
+		servererror := make(chan error)
+		go func() {
+			servererror <- service.Listen(ctx, "unix:varlinkexternal_TestShutdownContext", 0)
+		}()
+		time.Sleep(time.Second / 5)
+
+		c, err := varlink.NewConnection(ctx, "unix:varlinkexternal_TestShutdownContext")
+		if err != nil {
+			t.Fatalf("NewConnection(): %v", err)
+		}
+		defer c.Close()
+		receive, err := c.Send(ctx, "org.example.metadata.Sleep", nil, 0)
+		if err != nil {
+			t.Fatalf("Send(): %v", err)
+		}
+		time.Sleep(time.Second / 10)
+
+		shutdownCtx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		err = service.ShutdownContext(shutdownCtx)
+		if err := <-servererror; err != nil {
+			t.Fatalf("service.Listen(): %v", err)
+		}
+		_, callErr := receive(ctx, nil)
+		return err, callErr
+	}
+
+	// The call in progress is waited for
+	if err, callErr := shutdown(2 * time.Second); err != nil || callErr != nil {
+		t.Fatalf("ShutdownContext() returned %v, the call %v", err, callErr)
+	}
+
+	// The connection is closed when the context expires
+	if err, callErr := shutdown(time.Second / 10); err != context.DeadlineExceeded || callErr == nil {
+		t.Fatalf("ShutdownContext() returned %v, the call %v", err, callErr)
+	}
+}
+
+type ContextInterface struct {
+	waiting  chan struct{}
+	canceled chan struct{}
+}
+
+func (s *ContextInterface) VarlinkDispatch(ctx context.Context, call varlink.Call, methodname string) error {
+	s.waiting <- struct{}{}
+	<-call.Context().Done()
+	s.canceled <- struct{}{}
+	return call.Reply(ctx, nil)
+}
+
+func (s *ContextInterface) VarlinkGetName() string {
+	return `org.example.context`
+}
+
+func (s *ContextInterface) VarlinkGetDescription() string {
+	return `interface org.example.context
+method Wait() -> ()`
+}
+
+func TestCallContext(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("skipping test on windows")
+	}
+
+	service, err := varlink.NewService("Varlink", "Varlink Test", "1", "https://github.com/varlink/go/varlink")
+	if err != nil {
+		t.Fatalf("NewService(): %v", err)
+	}
+	iface := &ContextInterface{make(chan struct{}), make(chan struct{})}
+	if err := service.RegisterInterface(iface); err != nil {
+		t.Fatalf("RegisterInterface(): %v", err)
+	}
+
+	ctx := context.Background()
+	servererror := make(chan error)
+	go func() {
+		servererror <- service.Listen(ctx, "unix:varlinkexternal_TestCallContext", 0)
+	}()
+	time.Sleep(time.Second / 5)
+
+	// A call pipelined behind the waiting one does not cancel it
+	wait := func() *bufio.Reader {
+		conn, err := net.Dial("unix", "varlinkexternal_TestCallContext")
+		if err != nil {
+			t.Fatalf("Dial(): %v", err)
+		}
+		call := "{\"method\":\"org.example.context.Wait\"}\000"
+		if _, err := conn.Write([]byte(call + call)); err != nil {
+			t.Fatalf("Write(): %v", err)
+		}
+		<-iface.waiting
+		select {
+		case <-iface.canceled:
+			t.Fatal("call canceled by the next call")
+		case <-time.After(time.Second / 10):
+		}
+		return bufio.NewReader(conn)
+	}
+
+	// The client disconnecting cancels the call
+	c, err := varlink.NewConnection(ctx, "unix:varlinkexternal_TestCallContext")
+	if err != nil {
+		t.Fatalf("NewConnection(): %v", err)
+	}
+	if _, err := c.Send(ctx, "org.example.context.Wait", nil, 0); err != nil {
+		t.Fatalf("Send(): %v", err)
+	}
+	<-iface.waiting
+	c.Close()
+	select {
+	case <-iface.canceled:
+	case <-time.After(time.Second):
+		t.Fatal("call not canceled by the disconnect")
+	}
+
+	// Shutting down cancels the call, which is still handled
+	r := wait()
+	service.Shutdown()
+	select {
+	case <-iface.canceled:
+	case <-time.After(time.Second):
+		t.Fatal("call not canceled by Shutdown()")
+	}
+	if reply, err := r.ReadString(0); err != nil || reply != "{}\000" {
+		t.Fatalf("Wait() returned %q %v", reply, err)
+	}
+	if err := <-servererror; err != nil {
+		t.Fatalf("service.Listen(): %v", err)
+	}
+}
+
+func TestMaxMessageSize(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("skipping test on windows")
+	}
+
+	l, err := net.Listen("unix", "varlinkexternal_TestMaxMessageSize")
+	if err != nil {
+		t.Fatalf("Listen(): %v", err)
+	}
+	defer l.Close()
+
+	large := strings.Repeat("x", 1000)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for _, s := range []string{"small", large} {
+			if _, err := r.ReadString(0); err != nil {
+				return
+			}
+			conn.Write([]byte(`{"parameters":{"s":"` + s + `"}}` + "\000"))
+		}
+		// Wait for the client to hang up
+		r.ReadString(0)
+	}()
+
+	ctx := context.Background()
+	c, err := varlink.NewConnection(ctx, "unix:varlinkexternal_TestMaxMessageSize")
+	if err != nil {
+		t.Fatalf("NewConnection(): %v", err)
+	}
+	defer c.Close()
+	c.SetMaxMessageSize(100)
+
+	var out struct{ S string }
+	if err := c.Call(ctx, "org.example.size.Get", nil, &out); err != nil || out.S != "small" {
+		t.Fatalf("Call() returned %q %v", out.S, err)
+	}
+	if err := c.Call(ctx, "org.example.size.Get", nil, &out); err != varlink.ErrMessageTooLarge {
+		t.Fatalf("Call() of the large reply returned %v", err)
+	}
+	if err := c.Call(ctx, "org.example.size.Get", nil, &out); err == nil {
+		t.Fatal("Call() succeeded after the large reply")
+	}
+}
+
+type TLSInterface struct{}
+
+func (s *TLSInterface) VarlinkDispatch(ctx context.Context, call varlink.Call, methodname string) error {
+	state := call.TLSConnectionState()
+	if state == nil {
+		return call.ReplyError(ctx, "org.example.tls.NotSecured", nil)
+	}
+	var names []string
+	if len(state.VerifiedChains) > 0 {
+		names = state.VerifiedChains[0][0].DNSNames
+	}
+	return call.Reply(ctx, map[string]interface{}{"protocol": state.NegotiatedProtocol, "names": names})
+}
+
+func (s *TLSInterface) VarlinkGetName() string {
+	return `org.example.tls`
+}
+
+func (s *TLSInterface) VarlinkGetDescription() string {
+	return `interface org.example.tls
+method Get() -> (protocol: string, names: ?[]string)
+error NotSecured ()`
+}
+
+func TestTLS(t *testing.T) {
+	cert, pool := testCertificate(t)
+	ctx := context.Background()
+
+	plain, err := varlink.NewService("Varlink", "Varlink Test", "1", "https://github.com/varlink/go/varlink")
+	if err != nil {
+		t.Fatalf("NewService(): %v", err)
+	}
+	if err := plain.Listen(ctx, "tls:127.0.0.1:0", 0); err == nil {
+		t.Fatal("Listen() on a TLS address without TLSConfig should error")
+	}
+
+	service, err := varlink.NewServiceWithConfig(
+		"Varlink",
+		"Varlink Test",
+		"1",
+		"https://github.com/varlink/go/varlink",
+		varlink.ServiceConfig{TLSConfig: &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			ClientAuth:   tls.VerifyClientCertIfGiven,
+			ClientCAs:    pool,
+		}},
+	)
+	if err != nil {
+		t.Fatalf("NewService(): %v", err)
+	}
+	if err := service.RegisterInterface(&TLSInterface{}); err != nil {
+		t.Fatalf("RegisterInterface(): %v", err)
+	}
+	if err := service.Bind(ctx, "tls:127.0.0.1:0"); err != nil {
+		t.Fatalf("Bind(): %v", err)
+	}
+	l, err := service.GetListener()
+	if err != nil {
+		t.Fatalf("GetListener(): %v", err)
+	}
+	address := "tls:" + l.Addr().String()
+	servererror := make(chan error)
+	go func() {
+		servererror <- service.DoListen(ctx, 0)
+	}()
+
+	c, err := varlink.NewTLSConnection(ctx, address, &tls.Config{ServerName: "varlink", RootCAs: pool})
+	if err != nil {
+		t.Fatalf("NewTLSConnection(): %v", err)
+	}
+	var product string
+	err = c.GetInfo(ctx, nil, &product, nil, nil, nil)
+	c.Close()
+	if err != nil || product != "Varlink Test" {
+		t.Fatalf("GetInfo() returned %q %v", product, err)
+	}
+
+	// The handler sees the verified certificate of the client, if it sent one
+	for _, certificates := range [][]tls.Certificate{nil, {cert}} {
+		c, err := varlink.NewTLSConnection(ctx, address, &tls.Config{ServerName: "varlink", RootCAs: pool, Certificates: certificates})
+		if err != nil {
+			t.Fatalf("NewTLSConnection(): %v", err)
+		}
+		var out struct {
+			Protocol string   `json:"protocol"`
+			Names    []string `json:"names"`
+		}
+		err = c.Call(ctx, "org.example.tls.Get", nil, &out)
+		c.Close()
+		expected := "varlink []"
+		if certificates != nil {
+			expected = "varlink [varlink]"
+		}
+		if err != nil || fmt.Sprint(out.Protocol, " ", out.Names) != expected {
+			t.Fatalf("Get() returned %+v %v, expected %s", out, err, expected)
+		}
+	}
+
+	// The certificate is not trusted by the system roots
+	if c, err := varlink.NewConnection(ctx, address); err == nil {
+		c.Close()
+		t.Fatal("NewConnection() should fail to verify the service")
+	}
+
+	service.Shutdown()
+	if err := <-servererror; err != nil {
+		t.Fatalf("service.DoListen(): %v", err)
+	}
+}
+
+func TestVsock(t *testing.T) {
+	service, err := varlink.NewService("Varlink", "Varlink Test", "1", "https://github.com/varlink/go/varlink")
+	if err != nil {
+		t.Fatalf("NewService(): %v", err)
+	}
+
+	// The loopback of the local context id needs the vsock_loopback module
+	ctx := context.Background()
+	if err := service.Bind(ctx, "vsock:1:44321"); err != nil {
+		t.Skipf("vsock is not available: %v", err)
+	}
+	servererror := make(chan error)
+	go func() {
+		servererror <- service.DoListen(ctx, 0)
+	}()
+
+	c, err := varlink.NewConnection(ctx, "vsock:1:44321")
+	if err != nil {
+		t.Fatalf("NewConnection(): %v", err)
+	}
+	var product string
+	err = c.GetInfo(ctx, nil, &product, nil, nil, nil)
+	c.Close()
+	if err != nil || product != "Varlink Test" {
+		t.Fatalf("GetInfo() returned %q %v", product, err)
+	}
+
+	service.Shutdown()
+	if err := <-servererror; err != nil {
+		t.Fatalf("service.DoListen(): %v", err)
+	}
+}
+
+func TestDialRetry(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("skipping test on windows")
+	}
+
+	ctx := context.Background()
+	address := "unix:varlinkexternal_TestDialRetry"
+	if _, err := varlink.NewConnection(ctx, address); err == nil {
+		t.Fatal("NewConnection() without a service should error")
+	}
+
+	start := time.Now()
+	if _, err := varlink.NewConnectionWithConfig(ctx, address, varlink.DialConfig{Timeout: time.Second / 5, Retry: time.Minute}); err == nil {
+		t.Fatal("NewConnectionWithConfig() should time out")
+	} else if time.Since(start) > time.Second {
+		t.Fatalf("NewConnectionWithConfig() timed out after %v", time.Since(start))
+	}
+
+	service, err := varlink.NewService("Varlink", "Varlink Test", "1", "https://github.com/varlink/go/varlink")
+	if err != nil {
+		t.Fatalf("NewService(): %v", err)
+	}
+	servererror := make(chan error)
+	go func() {
+		time.Sleep(time.Second / 5)
+		servererror <- service.Listen(ctx, address, 0)
+	}()
+
+	// The connection is retried until the service listens
+	c, err := varlink.NewConnectionWithConfig(ctx, address, varlink.DialConfig{Retry: 5 * time.Second})
+	if err != nil {
+		t.Fatalf("NewConnectionWithConfig(): %v", err)
+	}
+	c.Close()
+
+	service.Shutdown()
+	if err := <-servererror; err != nil {
+		t.Fatalf("service.Listen(): %v", err)
+	}
+}
+
+func TestLongUnixPath(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("long socket paths are only supported on linux")
+	}
+
+	dir, err := ioutil.TempDir("", "varlink")
+	if err != nil {
+		t.Fatalf("TempDir(): %v", err)
+	}
+	defer os.RemoveAll(dir)
+	dir += "/" + strings.Repeat("d", 100)
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatalf("Mkdir(): %v", err)
+	}
+	path := dir + "/socket"
+
+	service, err := varlink.NewService("Varlink", "Varlink Test", "1", "https://github.com/varlink/go/varlink")
+	if err != nil {
+		t.Fatalf("NewService(): %v", err)
+	}
+	ctx := context.Background()
+	servererror := make(chan error)
+	go func() {
+		servererror <- service.Listen(ctx, "unix:"+path, 0)
+	}()
+	time.Sleep(time.Second / 5)
+
+	c, err := varlink.NewConnection(ctx, "unix:"+path)
+	if err != nil {
+		t.Fatalf("NewConnection(): %v", err)
+	}
+	var product string
+	err = c.GetInfo(ctx, nil, &product, nil, nil, nil)
+	c.Close()
+	if err != nil || product != "Varlink Test" {
+		t.Fatalf("GetInfo() returned %q %v", product, err)
+	}
+
+	service.Shutdown()
+	if err := <-servererror; err != nil {
+		t.Fatalf("service.Listen(): %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("socket left behind: %v", err)
+	}
+
+	// Too long even below /proc/self/fd
+	long := dir + "/" + strings.Repeat("s", 100)
+	if _, err := varlink.NewConnection(ctx, "unix:"+long); err == nil || !strings.Contains(err.Error(), "sun_path") {
+		t.Fatalf("NewConnection() returned %v", err)
+	}
+}
+
+func TestNamedPipe(t *testing.T) {
+	service, err := varlink.NewService("Varlink", "Varlink Test", "1", "https://github.com/varlink/go/varlink")
+	if err != nil {
+		t.Fatalf("NewService(): %v", err)
+	}
+	ctx := context.Background()
+	if runtime.GOOS != "windows" {
+		if err := service.Listen(ctx, "npipe:varlinkexternal_TestNamedPipe", 0); err == nil {
+			t.Fatal("Listen() on a named pipe should error")
+		}
+		t.Skip("named pipes are only supported on windows")
+	}
+
+	servererror := make(chan error)
+	go func() {
+		servererror <- service.Listen(ctx, "npipe:varlinkexternal_TestNamedPipe", 0)
+	}()
+	time.Sleep(time.Second / 5)
+
+	// Two clients connected to instances of the pipe at the same time
+	var conns []*varlink.Connection
+	for i := 0; i < 2; i++ {
+		c, err := varlink.NewConnection(ctx, "npipe:varlinkexternal_TestNamedPipe")
+		if err != nil {
+			t.Fatalf("NewConnection(): %v", err)
+		}
+		conns = append(conns, c)
+	}
+	for _, c := range conns {
+		var product string
+		err = c.GetInfo(ctx, nil, &product, nil, nil, nil)
+		c.Close()
+		if err != nil || product != "Varlink Test" {
+			t.Fatalf("GetInfo() returned %q %v", product, err)
+		}
+	}
+
+	service.Shutdown()
+	if err := <-servererror; err != nil {
+		t.Fatalf("service.Listen(): %v", err)
+	}
+}
+
+func TestWarnings(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("skipping test on windows")
+	}
+
+	// A socket left over by a dead instance
+	stale, err := net.ListenUnix("unix", &net.UnixAddr{Name: "varlinkexternal_TestWarnings", Net: "unix"})
+	if err != nil {
+		t.Fatalf("Listen(): %v", err)
+	}
+	stale.SetUnlinkOnClose(false)
+	stale.Close()
+
+	warnings := make(chan varlink.Warning, 10)
+	service, err := varlink.NewServiceWithConfig(
+		"Varlink",
+		"Varlink Test",
+		"1",
+		"https://github.com/varlink/go/varlink",
+		varlink.ServiceConfig{
+			Warnings:       func(w varlink.Warning) { warnings <- w },
+			MaxMessageSize: 100,
+		},
+	)
+	if err != nil {
+		t.Fatalf("NewServiceWithConfig(): %v", err)
+	}
+
+	os.Setenv("LISTEN_PID", fmt.Sprint(os.Getpid()))
+	os.Setenv("LISTEN_FDS", "foo")
+	defer os.Unsetenv("LISTEN_PID")
+
+	servererror := make(chan error)
+	go func() {
+		servererror <- service.Listen(context.Background(), "unix:varlinkexternal_TestWarnings", 0)
+	}()
+	time.Sleep(time.Second / 5)
+
+	conn, err := net.Dial("unix", "varlinkexternal_TestWarnings")
+	if err != nil {
+		t.Fatalf("Dial(): %v", err)
+	}
+	defer conn.Close()
+	conn.Write([]byte(`{"method":"org.varlink.service.GetInfo","parameters":{"x":"` + strings.Repeat("x", 100) + `"}}` + "\000"))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("Read() after the large request returned %v", err)
+	}
+
+	service.Shutdown()
+	if err := <-servererror; err != nil {
+		t.Fatalf("service.Listen(): %v", err)
+	}
+
+	for _, kind := range []varlink.WarningKind{varlink.InvalidActivation, varlink.StaleSocketRemoved, varlink.MessageTooLarge} {
+		if w := <-warnings; w.Kind != kind {
+			t.Fatalf("expected warning %q, got %q", kind, w)
+		}
+	}
+}
+
+func TestCustomDialer(t *testing.T) {
+	ctx := context.Background()
+	service, err := varlink.NewService("Varlink", "Varlink Test", "1", "https://github.com/varlink/go/varlink")
+	if err != nil {
+		t.Fatalf("NewService(): %v", err)
+	}
+	if err := service.Bind(ctx, "tcp:127.0.0.1:0"); err != nil {
+		t.Fatalf("Bind(): %v", err)
+	}
+	l, _ := service.GetListener()
+	servererror := make(chan error)
+	go func() {
+		servererror <- service.DoListen(ctx, 0)
+	}()
+
+	// The dialer decides where to connect, like a proxy
+	var dialed string
+	config := varlink.DialConfig{
+		DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+			dialed = network + ":" + address
+			var d net.Dialer
+			return d.DialContext(ctx, "tcp", l.Addr().String())
+		},
+	}
+	c, err := varlink.NewConnectionWithConfig(ctx, "tcp:varlink.example:1234", config)
+	if err != nil {
+		t.Fatalf("NewConnectionWithConfig(): %v", err)
+	}
+	defer c.Close()
+	if dialed != "tcp:varlink.example:1234" {
+		t.Fatalf("dialer called with %q", dialed)
+	}
+
+	var vendor string
+	if err := c.GetInfo(ctx, &vendor, nil, nil, nil, nil); err != nil || vendor != "Varlink" {
+		t.Fatalf("GetInfo(): %q %v", vendor, err)
+	}
+
+	service.Shutdown()
+	if err := <-servererror; err != nil {
+		t.Fatalf("service.DoListen(): %v", err)
+	}
+}
+
+func TestServiceManagerNotifications(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("skipping test on windows")
+	}
+
+	os.Remove("varlinkexternal_TestServiceManagerNotifications.notify")
+	manager, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: "varlinkexternal_TestServiceManagerNotifications.notify", Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("ListenUnixgram(): %v", err)
+	}
+	defer os.Remove("varlinkexternal_TestServiceManagerNotifications.notify")
+	defer manager.Close()
+
+	os.Setenv("NOTIFY_SOCKET", "varlinkexternal_TestServiceManagerNotifications.notify")
+	os.Setenv("WATCHDOG_USEC", "20000")
+	defer os.Unsetenv("NOTIFY_SOCKET")
+	defer os.Unsetenv("WATCHDOG_USEC")
+
+	service, err := varlink.NewService("Varlink", "Varlink Test", "1", "https://github.com/varlink/go/varlink")
+	if err != nil {
+		t.Fatalf("NewService(): %v", err)
+	}
+	servererror := make(chan error)
+	go func() {
+		servererror <- service.Listen(context.Background(), "unix:varlinkexternal_TestServiceManagerNotifications", 0)
+	}()
+
+	receive := func() string {
+		manager.SetReadDeadline(time.Now().Add(5 * time.Second))
+		b := make([]byte, 64)
+		n, err := manager.Read(b)
+		if err != nil {
+			t.Fatalf("Read(): %v", err)
+		}
+		return string(b[:n])
+	}
+	states := map[string]bool{}
+	for !states["READY=1"] || !states["WATCHDOG=1"] {
+		states[receive()] = true
+	}
+
+	service.Shutdown()
+	for receive() != "STOPPING=1" {
+	}
+	if err := <-servererror; err != nil {
+		t.Fatalf("service.Listen(): %v", err)
+	}
+}
+
+type contextKey string
+
+// ValuesInterface replies with the values of the call context.
+type ValuesInterface struct{}
+
+func (s *ValuesInterface) VarlinkDispatch(ctx context.Context, call varlink.Call, methodname string) error {
+	return call.Reply(ctx, map[string]interface{}{
+		"listener": call.Context().Value(contextKey("listener")),
+		"conn":     ctx.Value(contextKey("conn")),
+	})
+}
+func (s *ValuesInterface) VarlinkGetName() string {
+	return `org.example.context`
+}
+
+func (s *ValuesInterface) VarlinkGetDescription() string {
+	return "interface org.example.context\nmethod Values() -> (listener: string, conn: bool)"
+}
+
+func TestBaseContext(t *testing.T) {
+	var conns int32
+	service, err := varlink.NewServiceWithConfig(
+		"Varlink",
+		"Varlink Test",
+		"1",
+		"https://github.com/varlink/go/varlink",
+		varlink.ServiceConfig{
+			BaseContext: func(l net.Listener) context.Context {
+				return context.WithValue(context.Background(), contextKey("listener"), l.Addr().Network())
+			},
+			ConnContext: func(ctx context.Context, conn net.Conn) context.Context {
+				atomic.AddInt32(&conns, 1)
+				return context.WithValue(ctx, contextKey("conn"), true)
+			},
+		},
+	)
+	if err != nil {
+		t.Fatalf("NewServiceWithConfig(): %v", err)
+	}
+	if err := service.RegisterInterface(&ValuesInterface{}); err != nil {
+		t.Fatalf("Couldn't register service: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := service.Bind(ctx, "tcp:127.0.0.1:0"); err != nil {
+		t.Fatalf("Bind(): %v", err)
+	}
+	l, _ := service.GetListener()
+	servererror := make(chan error)
+	go func() {
+		servererror <- service.DoListen(ctx, 0)
+	}()
+
+	c, err := varlink.NewConnection(ctx, "tcp:"+l.Addr().String())
+	if err != nil {
+		t.Fatalf("NewConnection(): %v", err)
+	}
+	defer c.Close()
+
+	var out struct {
+		Listener string `json:"listener"`
+		Conn     bool   `json:"conn"`
+	}
+	if err := c.Call(ctx, "org.example.context.Values", nil, &out); err != nil {
+		t.Fatalf("Call(): %v", err)
+	}
+	if out.Listener != "tcp" || !out.Conn || atomic.LoadInt32(&conns) != 1 {
+		t.Fatalf("call context values %+v after %d connections", out, conns)
+	}
+
+	// The connections still end with the context passed to DoListen
+	cancel()
+	if err := c.Call(context.Background(), "org.example.context.Values", nil, &out); err == nil {
+		t.Fatal("Call() succeeded after the context was canceled")
+	}
+	service.Shutdown()
+	<-servererror
+}
+
+func TestServe(t *testing.T) {
+	service, err := varlink.NewService("Varlink", "Varlink Test", "1", "https://github.com/varlink/go/varlink")
+	if err != nil {
+		t.Fatalf("NewService(): %v", err)
+	}
+
+	// A listener of in-memory connections
+	l := varlink.NewProtocolListener(nil)
+	ctx := context.Background()
+	servererror := make(chan error)
+	go func() {
+		servererror <- service.Serve(ctx, l)
+	}()
+
+	config := varlink.DialConfig{
+		DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+			client, server := net.Pipe()
+			go l.Handoff(server)
+			return client, nil
+		},
+	}
+	c, err := varlink.NewConnectionWithConfig(ctx, "tcp:pipe:0", config)
+	if err != nil {
+		t.Fatalf("NewConnectionWithConfig(): %v", err)
+	}
+	defer c.Close()
+
+	var vendor string
+	if err := c.GetInfo(ctx, &vendor, nil, nil, nil, nil); err != nil || vendor != "Varlink" {
+		t.Fatalf("GetInfo(): %q %v", vendor, err)
+	}
+	if err := service.Serve(ctx, l); err == nil {
+		t.Fatal("Serve() of a running service succeeded")
+	}
+
+	service.Shutdown()
+	if err := <-servererror; err != nil {
+		t.Fatalf("service.Serve(): %v", err)
+	}
+}
+
+func TestServeConn(t *testing.T) {
+	service, err := varlink.NewService("Varlink", "Varlink Test", "1", "https://github.com/varlink/go/varlink")
+	if err != nil {
+		t.Fatalf("NewService(): %v", err)
+	}
+
+	// A stream without deadlines, like stdin and stdout
+	client, server := net.Pipe()
+	stream := struct {
+		io.Reader
+		io.Writer
+		io.Closer
+	}{server, server, server}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	servererror := make(chan error)
+	go func() {
+		servererror <- service.ServeConn(ctx, stream)
+	}()
+
+	config := varlink.DialConfig{
+		DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+			return client, nil
+		},
+	}
+	c, err := varlink.NewConnectionWithConfig(context.Background(), "tcp:stdio:0", config)
+	if err != nil {
+		t.Fatalf("NewConnectionWithConfig(): %v", err)
+	}
+	defer c.Close()
+
+	var vendor string
+	if err := c.GetInfo(context.Background(), &vendor, nil, nil, nil, nil); err != nil || vendor != "Varlink" {
+		t.Fatalf("GetInfo(): %q %v", vendor, err)
+	}
+
+	// The context ends the connection
+	cancel()
+	if err := <-servererror; err != nil {
+		t.Fatalf("ServeConn(): %v", err)
+	}
+	if err := c.GetInfo(context.Background(), &vendor, nil, nil, nil, nil); err == nil {
+		t.Fatal("GetInfo() succeeded after the context was canceled")
+	}
+}
+
+func TestConnectionLimit(t *testing.T) {
+	listen := func(policy varlink.ConnectionLimitPolicy) (string, func()) {
+		service, err := varlink.NewServiceWithConfig(
+			"Varlink",
+			"Varlink Test",
+			"1",
+			"https://github.com/varlink/go/varlink",
+			varlink.ServiceConfig{MaxConnections: 1, ConnectionLimit: policy},
+		)
+		if err != nil {
+			t.Fatalf("NewServiceWithConfig(): %v", err)
+		}
+		if err := service.Bind(context.Background(), "tcp:127.0.0.1:0"); err != nil {
+			t.Fatalf("Bind(): %v", err)
+		}
+		l, _ := service.GetListener()
+		servererror := make(chan error)
+		go func() {
+			servererror <- service.DoListen(context.Background(), 0)
+		}()
+		return "tcp:" + l.Addr().String(), func() {
+			service.Shutdown()
+			if err := <-servererror; err != nil {
+				t.Fatalf("service.DoListen(): %v", err)
+			}
+		}
+	}
+	connect := func(address string) *varlink.Connection {
+		c, err := varlink.NewConnection(context.Background(), address)
+		if err != nil {
+			t.Fatalf("NewConnection(): %v", err)
+		}
+		return c
+	}
+	getInfo := func(c *varlink.Connection) error {
+		var vendor string
+		return c.GetInfo(context.Background(), &vendor, nil, nil, nil, nil)
+	}
+
+	// The client over the limit learns that the service is not available
+	address, shutdown := listen(varlink.ReplyAtLimit)
+	first := connect(address)
+	if err := getInfo(first); err != nil {
+		t.Fatalf("GetInfo(): %v", err)
+	}
+	second := connect(address)
+	if _, ok := getInfo(second).(*varlink.ServiceNotAvailable); !ok {
+		t.Fatal("GetInfo() over the limit did not fail with ServiceNotAvailable")
+	}
+	first.Close()
+	second.Close()
+	shutdown()
+
+	// The client over the limit waits until a connection is closed
+	address, shutdown = listen(varlink.WaitAtLimit)
+	defer shutdown()
+	first = connect(address)
+	if err := getInfo(first); err != nil {
+		t.Fatalf("GetInfo(): %v", err)
+	}
+	second = connect(address)
+	defer second.Close()
+	waited := make(chan error)
+	go func() {
+		waited <- getInfo(second)
+	}()
+	select {
+	case err := <-waited:
+		t.Fatalf("GetInfo() over the limit returned %v", err)
+	case <-time.After(time.Second / 5):
+	}
+	first.Close()
+	if err := <-waited; err != nil {
+		t.Fatalf("GetInfo() after a connection was closed: %v", err)
+	}
+}
+
+func TestStats(t *testing.T) {
+	var mutex sync.Mutex
+	counts := make(map[string]int)
+	collect := func(side string) varlink.StatsHandler {
+		return varlink.StatsFunc(func(s varlink.Stats) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			key := side + " " + s.Kind.String()
+			if s.Err != nil {
+				key += " failed"
+			}
+			counts[key]++
+		})
+	}
+
+	ctx := context.Background()
+	service, err := varlink.NewServiceWithConfig("Varlink", "Varlink Test", "1", "https://github.com/varlink/go/varlink",
+		varlink.ServiceConfig{Stats: collect("service")})
+	if err != nil {
+		t.Fatalf("NewServiceWithConfig(): %v", err)
+	}
+	if err := service.RegisterInterface(&StreamInterface{}); err != nil {
+		t.Fatalf("RegisterInterface(): %v", err)
+	}
+	if err := service.Bind(ctx, "tcp:127.0.0.1:0"); err != nil {
+		t.Fatalf("Bind(): %v", err)
+	}
+	l, _ := service.GetListener()
+	servererror := make(chan error)
+	go func() {
+		servererror <- service.DoListen(ctx, 0)
+	}()
+
+	// The first call fails after two replies, the second starts again with the
+	// second one
+	s := varlink.NewSubscription(varlink.SubscriptionConfig{
+		Dial: func(ctx context.Context) (*varlink.Connection, error) {
+			return varlink.NewConnectionWithConfig(ctx, "tcp:"+l.Addr().String(), varlink.DialConfig{Stats: collect("client")})
+		},
+		Method: "org.example.stream.Watch",
+		Parameters: func(resume string) interface{} {
+			var after int
+			fmt.Sscan(resume, &after)
+			return map[string]int{"after": after}
+		},
+		Token: func(event json.RawMessage) string {
+			var e struct {
+				ID int `json:"id"`
+			}
+			json.Unmarshal(event, &e)
+			return fmt.Sprint(e.ID)
+		},
+		RetryInterval: time.Millisecond,
+		Stats:         collect("client"),
+	})
+	defer s.Close()
+	for {
+		if _, err := s.Next(ctx); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Next(): %v", err)
+		}
+	}
+
+	service.Shutdown()
+	if err := <-servererror; err != nil {
+		t.Fatalf("service.DoListen(): %v", err)
+	}
+
+	expected := map[string]int{
+		"client dial attempt":           2,
+		"client reconnected":            1,
+		"client stream reply":           6,
+		"client call completed failed":  1,
+		"client call completed":         1,
+		"service call completed failed": 1,
+		"service call completed":        1,
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if fmt.Sprint(counts) != fmt.Sprint(expected) {
+		t.Fatalf("Unexpected stats %v", counts)
+	}
+}
+
+func TestTimeouts(t *testing.T) {
+	ctx := context.Background()
+	service, err := varlink.NewServiceWithConfig("Varlink", "Varlink Test", "1", "https://github.com/varlink/go/varlink",
+		varlink.ServiceConfig{ReadTimeout: time.Second / 4, IdleTimeout: time.Second})
+	if err != nil {
+		t.Fatalf("NewServiceWithConfig(): %v", err)
+	}
+	if err := service.Bind(ctx, "tcp:127.0.0.1:0"); err != nil {
+		t.Fatalf("Bind(): %v", err)
+	}
+	l, _ := service.GetListener()
+	servererror := make(chan error)
+	go func() {
+		servererror <- service.DoListen(ctx, 0)
+	}()
+
+	closed := func(conn net.Conn) time.Duration {
+		start := time.Now()
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if _, err := ioutil.ReadAll(conn); err != nil {
+			t.Fatalf("connection not closed: %v", err)
+		}
+		return time.Since(start)
+	}
+
+	// A client sending nothing is closed after the idle timeout
+	idle, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial(): %v", err)
+	}
+	defer idle.Close()
+	if d := closed(idle); d < time.Second {
+		t.Fatalf("idle connection closed after %v", d)
+	}
+
+	// A client stalling within a request is closed after the read timeout
+	stalled, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial(): %v", err)
+	}
+	defer stalled.Close()
+	stalled.Write([]byte(`{"method":`))
+	if d := closed(stalled); d < time.Second/4 || d >= time.Second {
+		t.Fatalf("stalled connection closed after %v", d)
+	}
+
+	// The timeouts start again with every request
+	c, err := varlink.NewConnection(ctx, "tcp:"+l.Addr().String())
+	if err != nil {
+		t.Fatalf("NewConnection(): %v", err)
+	}
+	defer c.Close()
+	for i := 0; i < 3; i++ {
+		time.Sleep(time.Second / 2)
+		var vendor string
+		if err := c.GetInfo(ctx, &vendor, nil, nil, nil, nil); err != nil {
+			t.Fatalf("GetInfo(): %v", err)
+		}
+	}
+
+	service.Shutdown()
+	if err := <-servererror; err != nil {
+		t.Fatalf("service.DoListen(): %v", err)
+	}
+}
+
+func TestUse(t *testing.T) {
+	ctx := context.Background()
+	service, err := varlink.NewService("Varlink", "Varlink Test", "1", "https://github.com/varlink/go/varlink")
+	if err != nil {
+		t.Fatalf("NewService(): %v", err)
+	}
+	if err := service.RegisterInterface(&StreamInterface{}); err != nil {
+		t.Fatalf("RegisterInterface(): %v", err)
+	}
+
+	var seen []string
+	service.Use(func(next varlink.Handler) varlink.Handler {
+		return func(ctx context.Context, c varlink.Call, iface string, method string) error {
+			seen = append(seen, iface+" "+method+" "+c.Method()+" "+string(c.Parameters()))
+			return next(ctx, c, iface, method)
+		}
+	})
+	service.Use(func(next varlink.Handler) varlink.Handler {
+		return func(ctx context.Context, c varlink.Call, iface string, method string) error {
+			var in struct {
+				After int `json:"after"`
+			}
+			if c.GetParameters(&in) == nil && in.After > 4 {
+				return c.ReplyPermissionDenied(ctx)
+			}
+			return next(ctx, c, iface, method)
+		}
+	})
+
+	if err := service.Bind(ctx, "tcp:127.0.0.1:0"); err != nil {
+		t.Fatalf("Bind(): %v", err)
+	}
+	l, _ := service.GetListener()
+	servererror := make(chan error)
+	go func() {
+		servererror <- service.DoListen(ctx, 0)
+	}()
+
+	c, err := varlink.NewConnection(ctx, "tcp:"+l.Addr().String())
+	if err != nil {
+		t.Fatalf("NewConnection(): %v", err)
+	}
+	defer c.Close()
+
+	var out struct {
+		ID int `json:"id"`
+	}
+	if err := c.Call(ctx, "org.example.stream.Watch", map[string]int{"after": 4}, &out); err != nil || out.ID != 4 {
+		t.Fatalf("Watch(): %d %v", out.ID, err)
+	}
+	if err := c.Call(ctx, "org.example.stream.Watch", map[string]int{"after": 5}, &out); err == nil {
+		t.Fatal("Watch() was not denied by the middleware")
+	} else if _, ok := err.(*varlink.PermissionDenied); !ok {
+		t.Fatalf("Watch(): %v", err)
+	}
+	var vendor string
+	if err := c.GetInfo(ctx, &vendor, nil, nil, nil, nil); err != nil {
+		t.Fatalf("GetInfo(): %v", err)
+	}
+
+	if err := service.Use(func(next varlink.Handler) varlink.Handler { return next }); err == nil {
+		t.Fatal("Use() accepted a middleware of a running service")
+	}
+
+	service.Shutdown()
+	if err := <-servererror; err != nil {
+		t.Fatalf("service.DoListen(): %v", err)
+	}
+
+	expected := `org.example.stream Watch org.example.stream.Watch {"after":4} ` +
+		`org.example.stream Watch org.example.stream.Watch {"after":5}`
+	if strings.Join(seen, " ") != expected {
+		t.Fatalf("Unexpected calls %q", seen)
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	var mutex sync.Mutex
+	var entries []varlink.AccessLogEntry
+	ctx := context.Background()
+	service, err := varlink.NewServiceWithConfig("Varlink", "Varlink Test", "1", "https://github.com/varlink/go/varlink",
+		varlink.ServiceConfig{AccessLog: func(e varlink.AccessLogEntry) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			entries = append(entries, e)
+		}})
+	if err != nil {
+		t.Fatalf("NewServiceWithConfig(): %v", err)
+	}
+	if err := service.RegisterInterface(&StreamInterface{}); err != nil {
+		t.Fatalf("RegisterInterface(): %v", err)
+	}
+	if err := service.Bind(ctx, "tcp:127.0.0.1:0"); err != nil {
+		t.Fatalf("Bind(): %v", err)
+	}
+	l, _ := service.GetListener()
+	servererror := make(chan error)
+	go func() {
+		servererror <- service.DoListen(ctx, 0)
+	}()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial(): %v", err)
+	}
+	defer conn.Close()
+	conn.Write([]byte(`{"method":"org.example.stream.Watch","parameters":{"after":4},"more":true}` + "\000" +
+		`{"method":"org.example.missing.Call"}` + "\000"))
+	var replies []byte
+	for bytes.Count(replies, []byte("\000")) < 2 {
+		b := make([]byte, 1024)
+		n, err := conn.Read(b)
+		if err != nil {
+			t.Fatalf("Read(): %v", err)
+		}
+		replies = append(replies, b[:n]...)
+	}
+	conn.Close()
+
+	service.Shutdown()
+	if err := <-servererror; err != nil {
+		t.Fatalf("service.DoListen(): %v", err)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(entries) != 2 {
+		t.Fatalf("Unexpected entries %v", entries)
+	}
+	watch, missing := entries[0], entries[1]
+	if watch.Method != "org.example.stream.Watch" || watch.Error != "" || watch.Err != nil ||
+		watch.ReplySize != bytes.IndexByte(replies, 0)+1 || watch.Peer.String() != conn.LocalAddr().String() {
+		t.Fatalf("Unexpected entry %+v of the replies %q", watch, replies)
+	}
+	if missing.Method != "org.example.missing.Call" || missing.Error != "org.varlink.service.InterfaceNotFound" ||
+		missing.ReplySize != len(replies)-watch.ReplySize {
+		t.Fatalf("Unexpected entry %+v of the replies %q", missing, replies)
+	}
+}
+
+type traceKey struct{}
+
+// testTracer records the spans with the trace of their parent, which travels as
+// the traceparent of the metadata.
+type testTracer struct {
+	side  string
+	mutex *sync.Mutex
+	spans *[]string
+}
+
+type testSpan struct {
+	tracer testTracer
+	name   string
+}
+
+func (t testTracer) Start(ctx context.Context, method string, kind varlink.SpanKind) (context.Context, varlink.Span) {
+	name := t.side + " " + method
+	if parent, ok := ctx.Value(traceKey{}).(string); ok {
+		name += " in " + parent
+	}
+	return context.WithValue(ctx, traceKey{}, t.side+"-span"), testSpan{t, name}
+}
+
+func (t testTracer) Inject(ctx context.Context, metadata map[string]string) {
+	metadata["traceparent"] = ctx.Value(traceKey{}).(string)
+}
+
+func (t testTracer) Extract(ctx context.Context, metadata map[string]string) context.Context {
+	if parent, ok := metadata["traceparent"]; ok {
+		return context.WithValue(ctx, traceKey{}, parent)
+	}
+	return ctx
+}
+
+func (s testSpan) End(err error) {
+	s.tracer.mutex.Lock()
+	defer s.tracer.mutex.Unlock()
+	*s.tracer.spans = append(*s.tracer.spans, fmt.Sprintf("%s: %T", s.name, err))
+}
+
+func TestTracer(t *testing.T) {
+	var mutex sync.Mutex
+	var spans []string
+	ctx := context.Background()
+	service, err := varlink.NewServiceWithConfig("Varlink", "Varlink Test", "1", "https://github.com/varlink/go/varlink",
+		varlink.ServiceConfig{
+			Extensions: []varlink.Extension{{Name: varlink.CallMetadata, Versions: []int{1}}},
+			Tracer:     testTracer{"service", &mutex, &spans},
+		})
+	if err != nil {
+		t.Fatalf("NewServiceWithConfig(): %v", err)
+	}
+	if err := service.RegisterInterface(&MetadataInterface{}); err != nil {
+		t.Fatalf("RegisterInterface(): %v", err)
+	}
+	if err := service.Bind(ctx, "tcp:127.0.0.1:0"); err != nil {
+		t.Fatalf("Bind(): %v", err)
+	}
+	l, _ := service.GetListener()
+	servererror := make(chan error)
+	go func() {
+		servererror <- service.DoListen(ctx, 0)
+	}()
+
+	c, err := varlink.NewConnectionWithConfig(ctx, "tcp:"+l.Addr().String(),
+		varlink.DialConfig{Tracer: testTracer{"client", &mutex, &spans}})
+	if err != nil {
+		t.Fatalf("NewConnectionWithConfig(): %v", err)
+	}
+	if _, err := c.Negotiate(ctx, varlink.Extension{Name: varlink.CallMetadata, Versions: []int{1}}); err != nil {
+		t.Fatalf("Negotiate(): %v", err)
+	}
+
+	// The metadata of the caller is sent along with the trace context
+	var out struct {
+		Value string `json:"value"`
+	}
+	receive, err := c.SendWithOptions(ctx, "org.example.metadata.Get", nil, varlink.WithMetadata("id", "a"))
+	if err != nil {
+		t.Fatalf("SendWithOptions(): %v", err)
+	}
+	if _, err := receive(ctx, &out); err != nil || out.Value != "a" {
+		t.Fatalf("Get() returned '%s' %v", out.Value, err)
+	}
+	if err := c.Call(ctx, "org.example.missing.Call", nil, nil); err == nil {
+		t.Fatalf("Call() succeeded")
+	}
+	c.Close()
+
+	service.Shutdown()
+	if err := <-servererror; err != nil {
+		t.Fatalf("service.DoListen(): %v", err)
+	}
+
+	// The span of the service may end after the client received the reply
+	mutex.Lock()
+	defer mutex.Unlock()
+	sort.Strings(spans)
+	expected := []string{
+		"client org.example.metadata.Get: <nil>",
+		"client org.example.missing.Call: *varlink.InterfaceNotFound",
+		"client org.varlink.extensions.Negotiate: <nil>",
+		"service org.example.metadata.Get in client-span: <nil>",
+		"service org.example.missing.Call in client-span: *varlink.Error",
+		"service org.varlink.extensions.Negotiate: <nil>",
+	}
+	if fmt.Sprint(spans) != fmt.Sprint(expected) {
+		t.Fatalf("Unexpected spans %q", spans)
+	}
+}
+
+func TestSchemaCache(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("skipping test on windows")
+	}
+
+	dir, err := ioutil.TempDir("", "schemacache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cache, err := varlink.NewSchemaCache(dir)
+	if err != nil {
+		t.Fatalf("NewSchemaCache(): %v", err)
+	}
+
+	var mutex sync.Mutex
+	requested := 0
+	ctx := context.Background()
+	serve := func(version string) (string, func()) {
+		service, err := varlink.NewServiceWithConfig("Varlink", "Varlink Test", version, "https://github.com/varlink/go/varlink",
+			varlink.ServiceConfig{AccessLog: func(e varlink.AccessLogEntry) {
+				if e.Method == "org.varlink.service.GetInterfaceDescription" {
+					mutex.Lock()
+					requested++
+					mutex.Unlock()
+				}
+			}})
+		if err != nil {
+			t.Fatalf("NewServiceWithConfig(): %v", err)
+		}
+		if err := service.RegisterInterface(&MetadataInterface{}); err != nil {
+			t.Fatalf("RegisterInterface(): %v", err)
+		}
+		if err := service.Bind(ctx, "unix:"+filepath.Join(dir, "service")); err != nil {
+			t.Fatalf("Bind(): %v", err)
+		}
+		servererror := make(chan error)
+		go func() {
+			servererror <- service.DoListen(ctx, 0)
+		}()
+		return "unix:" + filepath.Join(dir, "service"), func() {
+			service.Shutdown()
+			if err := <-servererror; err != nil {
+				t.Fatalf("service.DoListen(): %v", err)
+			}
+		}
+	}
+	describe := func(address string) {
+		c, err := varlink.NewConnectionWithConfig(ctx, address, varlink.DialConfig{SchemaCache: cache})
+		if err != nil {
+			t.Fatalf("NewConnectionWithConfig(): %v", err)
+		}
+		defer c.Close()
+		midl, err := c.GetInterfaceIDL(ctx, "org.example.metadata")
+		if err != nil || midl.Name != "org.example.metadata" {
+			t.Fatalf("GetInterfaceIDL() returned %v %v", midl, err)
+		}
+	}
+
+	// The next connection finds the description of the same version in the
+	// cache, not the one to the updated service
+	address, stop := serve("1")
+	describe(address)
+	describe(address)
+	stop()
+	address, stop = serve("2")
+	describe(address)
+	stop()
+	mutex.Lock()
+	if requested != 2 {
+		t.Fatalf("The description was requested %d times", requested)
+	}
+	mutex.Unlock()
+
+	// The service is gone, the cache is still there
+	info, ok := cache.Info(address)
+	if !ok || info.Version != "2" || fmt.Sprint(info.Interfaces) != "[org.varlink.service org.varlink.internal org.varlink.stream org.example.metadata]" {
+		t.Fatalf("Info() returned %+v %v", info, ok)
+	}
+	if _, ok := cache.Description(address, info.Version, "org.example.metadata"); !ok {
+		t.Fatalf("Description() found nothing")
+	}
+	if _, ok := cache.Description(address, info.Version, "../info.json"); ok {
+		t.Fatalf("Description() found a file outside of the cache")
+	}
+}
+
+type CancelInterface struct {
+	streaming chan struct{}
+}
+
+func (s *CancelInterface) VarlinkDispatch(ctx context.Context, call varlink.Call, methodname string) error {
+	call.Continues = true
+	if err := call.Reply(ctx, nil); err != nil {
+		return err
+	}
+	switch methodname {
+	case "Fail":
+		return fmt.Errorf("failed")
+	case "Stop":
+		return &varlink.StreamCanceled{Reason: varlink.CanceledPolicy}
+	}
+	s.streaming <- struct{}{}
+	<-call.Context().Done()
+	return nil
+}
+
+func (s *CancelInterface) VarlinkGetName() string {
+	return `org.example.cancel`
+}
+
+func (s *CancelInterface) VarlinkGetDescription() string {
+	return `interface org.example.cancel
+method Fail() -> ()
+method Stop() -> ()
+method Wait() -> ()`
+}
+
+func TestStreamCanceled(t *testing.T) {
+	ctx := context.Background()
+	iface := &CancelInterface{streaming: make(chan struct{})}
+	service, err := varlink.NewService("Varlink", "Varlink Test", "1", "https://github.com/varlink/go/varlink")
+	if err != nil {
+		t.Fatalf("NewService(): %v", err)
+	}
+	if err := service.RegisterInterface(iface); err != nil {
+		t.Fatalf("RegisterInterface(): %v", err)
+	}
+	if err := service.Bind(ctx, "tcp:127.0.0.1:0"); err != nil {
+		t.Fatalf("Bind(): %v", err)
+	}
+	l, _ := service.GetListener()
+	servererror := make(chan error)
+	go func() {
+		servererror <- service.DoListen(ctx, 0)
+	}()
+
+	c, err := varlink.NewConnection(ctx, "tcp:"+l.Addr().String())
+	if err != nil {
+		t.Fatalf("NewConnection(): %v", err)
+	}
+	defer c.Close()
+	stream := func(c *varlink.Connection, method string) error {
+		receive, err := c.Send(ctx, "org.example.cancel."+method, nil, varlink.More)
+		if err != nil {
+			return err
+		}
+		if flags, err := receive(ctx, nil); err != nil || flags&varlink.Continues == 0 {
+			t.Fatalf("%s() returned %d %v", method, flags, err)
+		}
+		_, err = receive(ctx, nil)
+		return err
+	}
+	reason := func(err error) string {
+		if e, ok := err.(*varlink.StreamCanceled); ok {
+			return e.Reason
+		}
+		return fmt.Sprint(err)
+	}
+
+	// The connection stays open after the handler stopped the stream
+	if err := stream(c, "Stop"); reason(err) != varlink.CanceledPolicy {
+		t.Fatalf("Stop() returned %v", err)
+	}
+	if midl, err := c.GetInterfaceIDL(ctx, "org.varlink.stream"); err != nil || len(midl.Errors) != 1 || midl.Errors[0].Name != "Canceled" {
+		t.Fatalf("GetInterfaceIDL() returned %v, %v", midl, err)
+	}
+
+	// A failed handler ends the stream before the connection is closed
+	failing, err := varlink.NewConnection(ctx, "tcp:"+l.Addr().String())
+	if err != nil {
+		t.Fatalf("NewConnection(): %v", err)
+	}
+	if err := stream(failing, "Fail"); reason(err) != varlink.CanceledError {
+		t.Fatalf("Fail() returned %v", err)
+	}
+	if err := failing.Call(ctx, "org.varlink.service.GetInfo", nil, nil); err == nil {
+		t.Fatal("GetInfo() succeeded after the failed handler")
+	}
+	failing.Close()
+
+	errs := make(chan error)
+	go func() {
+		errs <- stream(c, "Wait")
+	}()
+	<-iface.streaming
+	service.Shutdown()
+	if err := <-errs; reason(err) != varlink.CanceledShutdown {
+		t.Fatalf("Wait() returned %v", err)
+	}
+	if err := <-servererror; err != nil {
+		t.Fatalf("service.DoListen(): %v", err)
+	}
+}
+
+func TestAuthorizer(t *testing.T) {
+	var mutex sync.Mutex
+	var requests []string
+	record := func(name string, allow bool) varlink.Authorizer {
+		return varlink.AuthorizerFunc(func(ctx context.Context, r *varlink.AuthorizationRequest) error {
+			mutex.Lock()
+			defer mutex.Unlock()
+			requests = append(requests, fmt.Sprintf("%s %s.%s %s %v", name, r.Interface, r.Method, r.Parameters, r.Peer != nil))
+			if !allow {
+				return fmt.Errorf("denied")
+			}
+			return nil
+		})
+	}
+
+	ctx := context.Background()
+	service, err := varlink.NewServiceWithConfig("Varlink", "Varlink Test", "1", "https://github.com/varlink/go/varlink",
+		varlink.ServiceConfig{Authorizer: record("service", true)})
+	if err != nil {
+		t.Fatalf("NewServiceWithConfig(): %v", err)
+	}
+	if err := service.RegisterInterface(&MetadataInterface{}); err != nil {
+		t.Fatalf("RegisterInterface(): %v", err)
+	}
+	if err := service.Authorize("org.example.metadata", record("interface", true)); err != nil {
+		t.Fatalf("Authorize(): %v", err)
+	}
+	if err := service.Authorize("org.example.metadata.Sleep", record("method", false)); err != nil {
+		t.Fatalf("Authorize(): %v", err)
+	}
+	if err := service.Bind(ctx, "tcp:127.0.0.1:0"); err != nil {
+		t.Fatalf("Bind(): %v", err)
+	}
+	l, _ := service.GetListener()
+	servererror := make(chan error)
+	go func() {
+		servererror <- service.DoListen(ctx, 0)
+	}()
+
+	c, err := varlink.NewConnection(ctx, "tcp:"+l.Addr().String())
+	if err != nil {
+		t.Fatalf("NewConnection(): %v", err)
+	}
+	if err := c.Call(ctx, "org.example.metadata.Get", map[string]int{"n": 1}, nil); err != nil {
+		t.Fatalf("Get() returned %v", err)
+	}
+	if err := c.Call(ctx, "org.example.metadata.Sleep", nil, nil); err == nil {
+		t.Fatal("Sleep() succeeded")
+	} else if _, ok := err.(*varlink.PermissionDenied); !ok {
+		t.Fatalf("Sleep() returned %v", err)
+	}
+	if err := c.GetInfo(ctx, nil, nil, nil, nil, nil); err != nil {
+		t.Fatalf("GetInfo() returned %v", err)
+	}
+	c.Close()
+
+	if err := service.Authorize("org.example.metadata.Get", record("late", false)); err == nil {
+		t.Fatal("Authorize() succeeded on the running service")
+	}
+	service.Shutdown()
+	if err := <-servererror; err != nil {
+		t.Fatalf("service.DoListen(): %v", err)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	expected := []string{
+		`service org.example.metadata.Get {"n":1} true`,
+		`interface org.example.metadata.Get {"n":1} true`,
+		`service org.example.metadata.Sleep null true`,
+		`interface org.example.metadata.Sleep null true`,
+		`method org.example.metadata.Sleep null true`,
+	}
+	if fmt.Sprint(requests) != fmt.Sprint(expected) {
+		t.Fatalf("Unexpected requests %q", requests)
+	}
+}
+
+func TestPriorityBoost(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("credentials are only known on linux")
+	}
+
+	var mutex sync.Mutex
+	var events []string
+	boost := &varlink.PriorityBoost{
+		Boosted: func(cred *varlink.Credentials) bool {
+			return cred.UID == os.Getuid()
+		},
+		Raise: func() (func() error, error) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			events = append(events, "raised")
+			return func() error {
+				mutex.Lock()
+				defer mutex.Unlock()
+				events = append(events, "restored")
+				return nil
+			}, nil
+		},
+	}
+	service, err := varlink.NewServiceWithConfig("Varlink", "Varlink Test", "1", "https://github.com/varlink/go/varlink",
+		varlink.ServiceConfig{PriorityBoost: boost})
+	if err != nil {
+		t.Fatalf("NewServiceWithConfig(): %v", err)
+	}
+	if err := service.RegisterInterface(&MetadataInterface{}); err != nil {
+		t.Fatalf("RegisterInterface(): %v", err)
+	}
+	ctx := context.Background()
+	servererror := make(chan error)
+	go func() {
+		servererror <- service.Listen(ctx, "unix:varlinkexternal_TestPriorityBoost", 0)
+	}()
+	time.Sleep(time.Second / 5)
+
+	c, err := varlink.NewConnection(ctx, "unix:varlinkexternal_TestPriorityBoost")
+	if err != nil {
+		t.Fatalf("NewConnection(): %v", err)
+	}
+	if err := c.Call(ctx, "org.example.metadata.Get", nil, nil); err != nil {
+		t.Fatalf("Get() returned %v", err)
+	}
+	// The calls of org.varlink.service are not dispatched to an interface
+	if err := c.GetInfo(ctx, nil, nil, nil, nil, nil); err != nil {
+		t.Fatalf("GetInfo() returned %v", err)
+	}
+	c.Close()
+
+	service.Shutdown()
+	if err := <-servererror; err != nil {
+		t.Fatalf("service.Listen(): %v", err)
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if fmt.Sprint(events) != "[raised restored]" {
+		t.Fatalf("Unexpected events %q", events)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	ctx := context.Background()
+	serve := func(limit varlink.RateLimit, address string) (string, func()) {
+		service, err := varlink.NewServiceWithConfig("Varlink", "Varlink Test", "1", "https://github.com/varlink/go/varlink",
+			varlink.ServiceConfig{RateLimit: &limit})
+		if err != nil {
+			t.Fatalf("NewServiceWithConfig(): %v", err)
+		}
+		if err := service.Bind(ctx, address); err != nil {
+			t.Fatalf("Bind(): %v", err)
+		}
+		l, _ := service.GetListener()
+		servererror := make(chan error)
+		go func() {
+			servererror <- service.DoListen(ctx, 0)
+		}()
+		if strings.HasPrefix(address, "tcp:") {
+			address = "tcp:" + l.Addr().String()
+		}
+		return address, func() {
+			service.Shutdown()
+			if err := <-servererror; err != nil {
+				t.Fatalf("service.DoListen(): %v", err)
+			}
+		}
+	}
+	// calls returns the results of the calls of the connections, in turn
+	calls := func(address string, connections int, n int) string {
+		var conns []*varlink.Connection
+		for i := 0; i < connections; i++ {
+			c, err := varlink.NewConnection(ctx, address)
+			if err != nil {
+				t.Fatalf("NewConnection(): %v", err)
+			}
+			defer c.Close()
+			conns = append(conns, c)
+		}
+		var results []string
+		for i := 0; i < n; i++ {
+			for _, c := range conns {
+				err := c.GetInfo(ctx, nil, nil, nil, nil, nil)
+				if e, ok := err.(*varlink.RateLimitExceeded); ok && e.RetryAfter > 0 && e.RetryAfter <= 1 {
+					results = append(results, "exceeded")
+				} else {
+					results = append(results, fmt.Sprint(err))
+				}
+			}
+		}
+		return strings.Join(results, " ")
+	}
+
+	// Every connection has its own bucket
+	address, stop := serve(varlink.RateLimit{Rate: 1, Burst: 2}, "tcp:127.0.0.1:0")
+	if r := calls(address, 2, 3); r != "<nil> <nil> <nil> <nil> exceeded exceeded" {
+		t.Errorf("Unexpected results %s", r)
+	}
+	stop()
+
+	// The calls over the limit are delayed
+	address, stop = serve(varlink.RateLimit{Rate: 20, Burst: 1, MaxDelay: time.Second}, "tcp:127.0.0.1:0")
+	start := time.Now()
+	if r := calls(address, 1, 3); r != "<nil> <nil> <nil>" {
+		t.Errorf("Unexpected results %s", r)
+	}
+	if d := time.Since(start); d < 90*time.Millisecond {
+		t.Errorf("The calls were delayed by %v only", d)
+	}
+	stop()
+
+	// The connections of a user share the bucket
+	if runtime.GOOS == "linux" {
+		address, stop = serve(varlink.RateLimit{Rate: 1, Burst: 2, PerUser: true}, "unix:varlinkexternal_TestRateLimit")
+		if r := calls(address, 2, 2); r != "<nil> <nil> exceeded exceeded" {
+			t.Errorf("Unexpected results %s", r)
+		}
+		stop()
+	}
+}
+
+type PanicInterface struct{}
+
+func (s *PanicInterface) VarlinkDispatch(ctx context.Context, call varlink.Call, methodname string) error {
+	if methodname == "Panic" {
+		panic("on purpose")
+	}
+	return call.Reply(ctx, nil)
+}
+
+func (s *PanicInterface) VarlinkGetName() string {
+	return `org.example.panic`
+}
+
+func (s *PanicInterface) VarlinkGetDescription() string {
+	return `interface org.example.panic
+method Panic() -> ()
+method Ping() -> ()`
+}
+
+func TestHandlerPanicked(t *testing.T) {
+	warnings := make(chan varlink.Warning, 1)
+	ctx := context.Background()
+	service, err := varlink.NewServiceWithConfig("Varlink", "Varlink Test", "1", "https://github.com/varlink/go/varlink",
+		varlink.ServiceConfig{Warnings: func(w varlink.Warning) { warnings <- w }})
+	if err != nil {
+		t.Fatalf("NewServiceWithConfig(): %v", err)
+	}
+	if err := service.RegisterInterface(&PanicInterface{}); err != nil {
+		t.Fatalf("RegisterInterface(): %v", err)
+	}
+	if err := service.Bind(ctx, "tcp:127.0.0.1:0"); err != nil {
+		t.Fatalf("Bind(): %v", err)
+	}
+	l, _ := service.GetListener()
+	servererror := make(chan error)
+	go func() {
+		servererror <- service.DoListen(ctx, 0)
+	}()
+
+	c, err := varlink.NewConnection(ctx, "tcp:"+l.Addr().String())
+	if err != nil {
+		t.Fatalf("NewConnection(): %v", err)
+	}
+	defer c.Close()
+	err = c.Call(ctx, "org.example.panic.Panic", nil, nil)
+	e, ok := err.(*varlink.InternalError)
+	if !ok || e.ID == "" {
+		t.Fatalf("Panic() returned %v", err)
+	}
+	w := <-warnings
+	p, ok := w.Err.(*varlink.PanicError)
+	if w.Kind != varlink.HandlerPanicked || !ok || p.ID != e.ID || p.Value != "on purpose" ||
+		!strings.Contains(string(p.Stack), "PanicInterface") {
+		t.Fatalf("Unexpected warning %v", w)
+	}
+
+	// The error is declared by the service
+	if midl, err := c.GetInterfaceIDL(ctx, "org.varlink.internal"); err != nil || len(midl.Errors) != 1 || midl.Errors[0].Name != "Error" {
+		t.Fatalf("GetInterfaceIDL() returned %v, %v", midl, err)
+	}
+
+	// The connection is still there
+	if err := c.Call(ctx, "org.example.panic.Ping", nil, nil); err != nil {
+		t.Fatalf("Ping() returned %v", err)
+	}
+
+	service.Shutdown()
+	if err := <-servererror; err != nil {
+		t.Fatalf("service.DoListen(): %v", err)
+	}
+}
+
+type WatchdogInterface struct{}
+
+func (s *WatchdogInterface) VarlinkDispatch(ctx context.Context, call varlink.Call, methodname string) error {
+	var in struct {
+		Hang bool `json:"hang"`
+	}
+	if err := call.GetParameters(&in); err != nil {
+		return call.ReplyInvalidParameter(ctx, "parameters")
+	}
+	if in.Hang {
+		<-call.Context().Done()
+	}
+	return call.Reply(ctx, nil)
+}
+
+func (s *WatchdogInterface) VarlinkGetName() string {
+	return `org.example.watchdog`
+}
+
+func (s *WatchdogInterface) VarlinkGetDescription() string {
+	return `interface org.example.watchdog
+method Wait(hang: bool) -> ()`
+}
+
+func TestHandlerPanickedLogged(t *testing.T) {
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	service, err := varlink.NewService("Varlink", "Varlink Test", "1", "https://github.com/varlink/go/varlink")
+	if err != nil {
+		t.Fatalf("NewService(): %v", err)
+	}
+	if err := service.RegisterInterface(&PanicInterface{}); err != nil {
+		t.Fatalf("RegisterInterface(): %v", err)
+	}
+
+	config := varlink.DialConfig{
+		DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+			client, server := net.Pipe()
+			go service.ServeConn(context.Background(), server)
+			return client, nil
+		},
+	}
+	c, err := varlink.NewConnectionWithConfig(context.Background(), "tcp:pipe:0", config)
+	if err != nil {
+		t.Fatalf("NewConnectionWithConfig(): %v", err)
+	}
+	defer c.Close()
+
+	err = c.Call(context.Background(), "org.example.panic.Panic", nil, nil)
+	e, ok := err.(*varlink.InternalError)
+	if !ok {
+		t.Fatalf("Panic() returned %v", err)
+	}
+	// Without a Warnings hook, the panic is logged with its id and stack
+	if out := logged.String(); !strings.Contains(out, e.ID) || !strings.Contains(out, "on purpose") ||
+		!strings.Contains(out, "PanicInterface") {
+		t.Fatalf("Unexpected log %q", out)
+	}
+}
+
+func TestHandlerWatchdog(t *testing.T) {
+	warnings := make(chan varlink.Warning, 1)
+	ctx := context.Background()
+	service, err := varlink.NewServiceWithConfig("Varlink", "Varlink Test", "1", "https://github.com/varlink/go/varlink",
+		varlink.ServiceConfig{
+			Warnings:        func(w varlink.Warning) { warnings <- w },
+			HandlerWatchdog: &varlink.HandlerWatchdog{Factor: 2, MinDuration: 50 * time.Millisecond, MinSamples: 5, Cancel: true},
+		})
+	if err != nil {
+		t.Fatalf("NewServiceWithConfig(): %v", err)
+	}
+	if err := service.RegisterInterface(&WatchdogInterface{}); err != nil {
+		t.Fatalf("RegisterInterface(): %v", err)
+	}
+	if err := service.Bind(ctx, "tcp:127.0.0.1:0"); err != nil {
+		t.Fatalf("Bind(): %v", err)
+	}
+	l, _ := service.GetListener()
+	servererror := make(chan error)
+	go func() {
+		servererror <- service.DoListen(ctx, 0)
+	}()
+
+	c, err := varlink.NewConnection(ctx, "tcp:"+l.Addr().String())
+	if err != nil {
+		t.Fatalf("NewConnection(): %v", err)
+	}
+	defer c.Close()
+
+	// Too few calls were seen to tell a stuck one
+	for i := 0; i < 5; i++ {
+		if err := c.Call(ctx, "org.example.watchdog.Wait", map[string]bool{"hang": false}, nil); err != nil {
+			t.Fatalf("Wait() returned %v", err)
+		}
+	}
+	select {
+	case w := <-warnings:
+		t.Fatalf("Unexpected warning %v", w)
+	default:
+	}
+
+	// The stuck call is canceled
+	if err := c.Call(ctx, "org.example.watchdog.Wait", map[string]bool{"hang": true}, nil); err != nil {
+		t.Fatalf("Wait() returned %v", err)
+	}
+	w := <-warnings
+	e, ok := w.Err.(*varlink.StuckError)
+	if w.Kind != varlink.HandlerStuck || !ok || e.Method != "org.example.watchdog.Wait" || !e.Canceled ||
+		e.Duration < 50*time.Millisecond || !strings.Contains(string(e.Stack), "WatchdogInterface") {
+		t.Fatalf("Unexpected warning %v", w)
+	}
+
+	service.Shutdown()
+	if err := <-servererror; err != nil {
+		t.Fatalf("service.DoListen(): %v", err)
 	}
 }
@@ -0,0 +1,162 @@
+package varlink
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// Blob refers to a read-only file passed along a reply on a unix socket, which
+// carries large data instead of inlining it in the JSON of the reply. The
+// interface description declares it as the object (fd: int, size: int); fd is the
+// index of the file in the files passed with the reply.
+type Blob struct {
+	Fd   int   `json:"fd"`
+	Size int64 `json:"size"`
+}
+
+// fileWriter is a connection which can pass files along the data.
+type fileWriter interface {
+	CanPassFiles() bool
+	WriteFiles(ctx context.Context, b []byte, files []*os.File) (int, error)
+}
+
+func canPassFiles(conn ReadWriterContext) bool {
+	w, ok := conn.(fileWriter)
+	return ok && w.CanPassFiles()
+}
+
+// AttachFile passes the file along the next reply of the call and returns its
+// index in the files of the reply. The call owns the file and closes it after
+// the reply was sent. Files can only be passed on unix socket connections.
+func (c *Call) AttachFile(f *os.File) (int, error) {
+	if c.state == nil || !canPassFiles(c.Conn) {
+		f.Close()
+		return 0, fmt.Errorf("connection cannot pass files")
+	}
+	return c.state.attach(f), nil
+}
+
+// AttachBlob copies the data to a sealed memory file, or an unlinked read-only
+// temporary file on other systems than linux, and passes it along the next
+// reply.
+func (c *Call) AttachBlob(data []byte) (Blob, error) {
+	if c.state == nil || !canPassFiles(c.Conn) {
+		return Blob{}, fmt.Errorf("connection cannot pass files")
+	}
+
+	f, err := newBlobFile(data)
+	if err != nil {
+		return Blob{}, err
+	}
+	return Blob{Fd: c.state.attach(f), Size: int64(len(data))}, nil
+}
+
+// tempBlobFile writes the data to a temporary file, which is removed right away,
+// and returns it opened read-only.
+func tempBlobFile(data []byte) (*os.File, error) {
+	w, err := ioutil.TempFile("", "varlink-blob")
+	if err != nil {
+		return nil, err
+	}
+	defer w.Close()
+
+	f, err := os.Open(w.Name())
+	os.Remove(w.Name())
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := w.Write(data); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// FileReply receives the parameters of a reply together with the files passed
+// along it, when used as the out parameters of Call or of a receive function.
+// The files are only received by connections configured with
+// DialConfig.MaxFiles. The caller owns the files and closes them with Close.
+type FileReply struct {
+	Parameters interface{}
+	Files      []*os.File
+}
+
+// File returns the file the blob refers to.
+func (r *FileReply) File(b Blob) (*os.File, error) {
+	if b.Fd < 0 || b.Fd >= len(r.Files) {
+		return nil, fmt.Errorf("blob refers to file %d, the reply passed %d", b.Fd, len(r.Files))
+	}
+	return r.Files[b.Fd], nil
+}
+
+// blobFile returns the file of the blob, after checking that the file holds
+// the size the peer claims.
+func (r *FileReply) blobFile(b Blob) (*os.File, error) {
+	f, err := r.File(b)
+	if err != nil {
+		return nil, err
+	}
+
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if b.Size < 0 || b.Size > st.Size() {
+		return nil, fmt.Errorf("blob of size %d, its file holds %d bytes", b.Size, st.Size())
+	}
+	return f, nil
+}
+
+// ReadBlob returns a copy of the data of the blob.
+func (r *FileReply) ReadBlob(b Blob) ([]byte, error) {
+	f, err := r.blobFile(b)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, b.Size)
+	if _, err := f.ReadAt(data, 0); err != nil && !(err == io.EOF && b.Size == 0) {
+		return nil, err
+	}
+	return data, nil
+}
+
+// MapBlob maps the data of the blob into memory, read-only, until the returned
+// release function is called. Only memory files sealed against shrinking and
+// writing are mapped, which the peer cannot change under the mapping; of other
+// files, and on other systems than linux, it returns a copy.
+func (r *FileReply) MapBlob(b Blob) ([]byte, func() error, error) {
+	f, err := r.blobFile(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	if b.Size == 0 {
+		return []byte{}, func() error { return nil }, nil
+	}
+	return mapFile(f, b.Size)
+}
+
+// copyFile returns a copy of the first size bytes of the file.
+func copyFile(f *os.File, size int64) ([]byte, func() error, error) {
+	data := make([]byte, size)
+	if _, err := f.ReadAt(data, 0); err != nil {
+		return nil, nil, err
+	}
+	return data, func() error { return nil }, nil
+}
+
+// Close closes all files of the reply.
+func (r *FileReply) Close() error {
+	var err error
+	for _, f := range r.Files {
+		if e := f.Close(); err == nil {
+			err = e
+		}
+	}
+	r.Files = nil
+	return err
+}
@@ -0,0 +1,85 @@
+package varlink
+
+import (
+	"os"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+// memfdCreate is the system call number of memfd_create, which the syscall
+// package does not define on all architectures.
+var memfdCreate = map[string]uintptr{
+	"386":      356,
+	"amd64":    319,
+	"arm":      385,
+	"arm64":    279,
+	"mips":     4354,
+	"mipsle":   4354,
+	"mips64":   5314,
+	"mips64le": 5314,
+	"ppc64":    360,
+	"ppc64le":  360,
+	"riscv64":  279,
+	"s390x":    350,
+}
+
+const (
+	mfdCloexec      = 0x1
+	mfdAllowSealing = 0x2
+	fcntlAddSeals   = 1033
+	fcntlGetSeals   = 1034
+	sealShrink      = 0x2
+	sealWrite       = 0x8
+	sealAll         = 0x1 | sealShrink | 0x4 | sealWrite // seal, shrink, grow, write
+	blobFileName    = "varlink-blob"
+)
+
+// newBlobFile returns a memory file with the data, sealed against any change.
+func newBlobFile(data []byte) (*os.File, error) {
+	trap, ok := memfdCreate[runtime.GOARCH]
+	if !ok {
+		return tempBlobFile(data)
+	}
+
+	name, err := syscall.BytePtrFromString(blobFileName)
+	if err != nil {
+		return nil, err
+	}
+	fd, _, errno := syscall.Syscall(trap, uintptr(unsafe.Pointer(name)), mfdCloexec|mfdAllowSealing, 0)
+	if errno == syscall.ENOSYS {
+		return tempBlobFile(data)
+	}
+	if errno != 0 {
+		return nil, os.NewSyscallError("memfd_create", errno)
+	}
+
+	f := os.NewFile(fd, blobFileName)
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, _, errno := syscall.Syscall(syscall.SYS_FCNTL, f.Fd(), fcntlAddSeals, sealAll); errno != 0 {
+		f.Close()
+		return nil, os.NewSyscallError("fcntl", errno)
+	}
+	return f, nil
+}
+
+func mapFile(f *os.File, size int64) ([]byte, func() error, error) {
+	// Reading a mapping beyond the end of a shrunk file raises SIGBUS
+	seals, _, errno := syscall.Syscall(syscall.SYS_FCNTL, f.Fd(), fcntlGetSeals, 0)
+	if errno != 0 || seals&(sealShrink|sealWrite) != sealShrink|sealWrite {
+		return copyFile(f, size)
+	}
+
+	data, err := syscall.Mmap(int(f.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, nil, os.NewSyscallError("mmap", err)
+	}
+	return data, func() error { return syscall.Munmap(data) }, nil
+}
@@ -0,0 +1,13 @@
+// +build !linux
+
+package varlink
+
+import "os"
+
+func newBlobFile(data []byte) (*os.File, error) {
+	return tempBlobFile(data)
+}
+
+func mapFile(f *os.File, size int64) ([]byte, func() error, error) {
+	return copyFile(f, size)
+}
@@ -0,0 +1,95 @@
+package forward
+
+import (
+	"context"
+	"io"
+	"net"
+
+	"github.com/varlink/go/varlink"
+)
+
+// Tunnel is the client side of an upgraded org.varlink.forward connection.
+// The tunnel ends when it, or the underlying varlink connection, is closed.
+type Tunnel struct {
+	conn *varlink.Connection
+	mux  *mux
+}
+
+// NewTunnel upgrades the connection to a tunnel. The connection cannot be used
+// for other method calls afterwards.
+func NewTunnel(ctx context.Context, c *varlink.Connection) (*Tunnel, error) {
+	receive, err := c.Upgrade(ctx, "org.varlink.forward.Tunnel", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	_, rw, err := receive(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &Tunnel{conn: c, mux: newMux(rw)}
+	go t.mux.receive(context.Background(), nil)
+
+	return t, nil
+}
+
+// Dial connects to the address through the tunnel, the target is connected by
+// the server.
+func (t *Tunnel) Dial(ctx context.Context, network string, address string) (net.Conn, error) {
+	t.mux.mutex.Lock()
+	t.mux.nextID++
+	id := t.mux.nextID
+	t.mux.mutex.Unlock()
+
+	c := t.mux.add(id)
+	c.target = addr{network, address}
+	if err := t.mux.writeFrame(ctx, frameOpen, id, []byte(network+"\x00"+address)); err != nil {
+		t.mux.remove(id)
+		return nil, err
+	}
+
+	select {
+	case err := <-c.opened:
+		if err != nil {
+			t.mux.remove(id)
+			return nil, err
+		}
+		return c, nil
+
+	case <-ctx.Done():
+		c.Close()
+		return nil, ctx.Err()
+	}
+}
+
+// Close closes the channels of the tunnel and the underlying varlink
+// connection.
+func (t *Tunnel) Close() error {
+	t.mux.shutdown(io.ErrClosedPipe)
+	return t.conn.Close()
+}
+
+// Forward accepts connections from the listener and forwards each of them
+// through the tunnel to the address, until the listener fails.
+func (t *Tunnel) Forward(ctx context.Context, l net.Listener, network string, address string) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+
+		go func() {
+			defer conn.Close()
+
+			c, err := t.Dial(ctx, network, address)
+			if err != nil {
+				return
+			}
+			defer c.Close()
+
+			go io.Copy(c, conn)
+			io.Copy(conn, c)
+		}()
+	}
+}
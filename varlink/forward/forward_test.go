@@ -0,0 +1,297 @@
+package forward_test
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/varlink/go/varlink"
+	"github.com/varlink/go/varlink/forward"
+)
+
+func TestForward(t *testing.T) {
+	// The target echoes every line
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen(): %v", err)
+	}
+	defer target.Close()
+	go func() {
+		for {
+			conn, err := target.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+
+	service, err := varlink.NewService("Varlink", "Varlink Test", "1", "https://github.com/varlink/go/varlink")
+	if err != nil {
+		t.Fatalf("NewService(): %v", err)
+	}
+	s := &forward.Server{
+		Allow: func(network string, address string) bool {
+			return network == "tcp" && address == target.Addr().String()
+		},
+	}
+	if err := service.RegisterInterface(s); err != nil {
+		t.Fatalf("RegisterInterface(): %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	servererror := make(chan error)
+	go func() {
+		servererror <- service.Listen(ctx, "unix:varlinkforward_TestForward", 0)
+	}()
+	time.Sleep(time.Second / 5)
+
+	c, err := varlink.NewConnection(ctx, "unix:varlinkforward_TestForward")
+	if err != nil {
+		t.Fatalf("NewConnection(): %v", err)
+	}
+
+	tunnel, err := forward.NewTunnel(ctx, c)
+	if err != nil {
+		t.Fatalf("NewTunnel(): %v", err)
+	}
+
+	if _, err := tunnel.Dial(ctx, "tcp", "127.0.0.1:1"); err == nil {
+		t.Fatal("Dial() of a disallowed target should error")
+	}
+
+	// Two channels sharing the tunnel
+	var conns []net.Conn
+	for i := 0; i < 2; i++ {
+		conn, err := tunnel.Dial(ctx, "tcp", target.Addr().String())
+		if err != nil {
+			t.Fatalf("Dial(): %v", err)
+		}
+		conns = append(conns, conn)
+	}
+	for i, text := range []string{"hello\n", "world\n"} {
+		if _, err := conns[i].Write([]byte(text)); err != nil {
+			t.Fatalf("Write(): %v", err)
+		}
+	}
+	for i, text := range []string{"hello\n", "world\n"} {
+		line, err := bufio.NewReader(conns[i]).ReadString('\n')
+		if err != nil {
+			t.Fatalf("ReadString(): %v", err)
+		}
+		if line != text {
+			t.Fatalf("Unexpected reply `%q`", line)
+		}
+	}
+
+	// Local connections are forwarded to the target
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen(): %v", err)
+	}
+	go tunnel.Forward(ctx, l, "tcp", target.Addr().String())
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("net.Dial(): %v", err)
+	}
+	conn.Write([]byte("forwarded\n"))
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatalf("ReadString(): %v", err)
+	}
+	if line != "forwarded\n" {
+		t.Fatalf("Unexpected reply `%q`", line)
+	}
+	conn.Close()
+	l.Close()
+
+	for _, conn := range conns {
+		conn.Close()
+	}
+	c.Close()
+	service.Shutdown()
+
+	if err := <-servererror; err != nil {
+		t.Fatalf("service.Listen(): %v", err)
+	}
+}
+
+func TestForwardSlowReader(t *testing.T) {
+	// The flood target sends more than a channel buffers, the echo target
+	// echoes every line
+	flood, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen(): %v", err)
+	}
+	defer flood.Close()
+	go func() {
+		conn, err := flood.Accept()
+		if err != nil {
+			return
+		}
+		conn.Write(make([]byte, 8<<20))
+		io.Copy(ioutil.Discard, conn)
+		conn.Close()
+	}()
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen(): %v", err)
+	}
+	defer echo.Close()
+	go func() {
+		conn, err := echo.Accept()
+		if err != nil {
+			return
+		}
+		io.Copy(conn, conn)
+		conn.Close()
+	}()
+
+	service, err := varlink.NewService("Varlink", "Varlink Test", "1", "https://github.com/varlink/go/varlink")
+	if err != nil {
+		t.Fatalf("NewService(): %v", err)
+	}
+	s := &forward.Server{Allow: func(network string, address string) bool { return true }}
+	if err := service.RegisterInterface(s); err != nil {
+		t.Fatalf("RegisterInterface(): %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	servererror := make(chan error)
+	go func() {
+		servererror <- service.Listen(ctx, "unix:varlinkforward_TestForwardSlowReader", 0)
+	}()
+	time.Sleep(time.Second / 5)
+
+	c, err := varlink.NewConnection(ctx, "unix:varlinkforward_TestForwardSlowReader")
+	if err != nil {
+		t.Fatalf("NewConnection(): %v", err)
+	}
+	tunnel, err := forward.NewTunnel(ctx, c)
+	if err != nil {
+		t.Fatalf("NewTunnel(): %v", err)
+	}
+
+	// The flooded channel is not read, the other one still works
+	slow, err := tunnel.Dial(ctx, "tcp", flood.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial(): %v", err)
+	}
+	conn, err := tunnel.Dial(ctx, "tcp", echo.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial(): %v", err)
+	}
+	time.Sleep(time.Second / 5)
+	conn.Write([]byte("hello\n"))
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil || line != "hello\n" {
+		t.Fatalf("ReadString(): %q, %v", line, err)
+	}
+
+	// The flooded channel was reset once its buffer was full
+	n, err := io.Copy(ioutil.Discard, slow)
+	if err == nil || n >= 8<<20 {
+		t.Fatalf("Read %d bytes of the flooded channel: %v", n, err)
+	}
+
+	conn.Close()
+	slow.Close()
+	c.Close()
+	service.Shutdown()
+	if err := <-servererror; err != nil {
+		t.Fatalf("service.Listen(): %v", err)
+	}
+}
+
+func TestForwardCloseWhileDialing(t *testing.T) {
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen(): %v", err)
+	}
+	defer target.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := target.Accept()
+		if err != nil {
+			return
+		}
+		accepted <- conn
+	}()
+
+	service, err := varlink.NewService("Varlink", "Varlink Test", "1", "https://github.com/varlink/go/varlink")
+	if err != nil {
+		t.Fatalf("NewService(): %v", err)
+	}
+	// The server connects the target after the client gave up
+	s := &forward.Server{
+		Allow: func(network string, address string) bool { return true },
+		Dialer: &net.Dialer{Control: func(network, address string, c syscall.RawConn) error {
+			time.Sleep(time.Second / 2)
+			return nil
+		}},
+	}
+	if err := service.RegisterInterface(s); err != nil {
+		t.Fatalf("RegisterInterface(): %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	servererror := make(chan error)
+	go func() {
+		servererror <- service.Listen(ctx, "unix:varlinkforward_TestForwardCloseWhileDialing", 0)
+	}()
+	time.Sleep(time.Second / 5)
+
+	c, err := varlink.NewConnection(ctx, "unix:varlinkforward_TestForwardCloseWhileDialing")
+	if err != nil {
+		t.Fatalf("NewConnection(): %v", err)
+	}
+	tunnel, err := forward.NewTunnel(ctx, c)
+	if err != nil {
+		t.Fatalf("NewTunnel(): %v", err)
+	}
+
+	dialctx, dialcancel := context.WithTimeout(ctx, time.Second/10)
+	defer dialcancel()
+	if _, err := tunnel.Dial(dialctx, "tcp", target.Addr().String()); err == nil {
+		t.Fatal("Dial() did not time out")
+	}
+
+	// The target connected meanwhile is closed
+	select {
+	case conn := <-accepted:
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+			t.Fatalf("Target connection not closed: %v", err)
+		}
+		conn.Close()
+	case <-time.After(2 * time.Second):
+		t.Fatal("Target not connected")
+	}
+
+	if err := tunnel.Close(); err != nil {
+		t.Fatalf("Close(): %v", err)
+	}
+	if _, err := tunnel.Dial(ctx, "tcp", target.Addr().String()); err == nil {
+		t.Fatal("Dial() succeeded on a closed tunnel")
+	}
+
+	service.Shutdown()
+	if err := <-servererror; err != nil {
+		t.Fatalf("service.Listen(): %v", err)
+	}
+}
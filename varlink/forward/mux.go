@@ -0,0 +1,335 @@
+package forward
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/varlink/go/varlink"
+)
+
+// Frame types of the multiplexing protocol. Every frame starts with the type byte,
+// the big-endian uint32 channel id and the big-endian uint32 length of the payload.
+const (
+	frameOpen   = 0 // payload: network and address, separated by a null byte
+	frameOpened = 1
+	frameData   = 2
+	frameClose  = 3 // payload: optional error message
+)
+
+const maxFrameSize = 1 << 20
+
+// maxBuffered limits the received data a channel holds for its reader. The
+// frames of all channels are received by one loop, which must not wait for
+// the reader of one of them; a channel whose reader falls behind by more is
+// reset.
+const maxBuffered = 4 * maxFrameSize
+
+// mux carries the streams of many channels over one upgraded connection.
+type mux struct {
+	rw     varlink.ReadWriterContext
+	wmutex sync.Mutex
+
+	mutex    sync.Mutex
+	channels map[uint32]*channel
+	nextID   uint32
+	err      error
+	done     chan struct{}
+}
+
+func newMux(rw varlink.ReadWriterContext) *mux {
+	return &mux{
+		rw:       rw,
+		channels: make(map[uint32]*channel),
+		done:     make(chan struct{}),
+	}
+}
+
+func (m *mux) writeFrame(ctx context.Context, kind byte, id uint32, payload []byte) error {
+	b := make([]byte, 9+len(payload))
+	b[0] = kind
+	binary.BigEndian.PutUint32(b[1:5], id)
+	binary.BigEndian.PutUint32(b[5:9], uint32(len(payload)))
+	copy(b[9:], payload)
+
+	m.wmutex.Lock()
+	defer m.wmutex.Unlock()
+	for len(b) > 0 {
+		n, err := m.rw.Write(ctx, b)
+		if err != nil {
+			return err
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+func (m *mux) readFull(ctx context.Context, buf []byte) error {
+	for n := 0; n < len(buf); {
+		r, err := m.rw.Read(ctx, buf[n:])
+		n += r
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *mux) readFrame(ctx context.Context) (byte, uint32, []byte, error) {
+	header := make([]byte, 9)
+	if err := m.readFull(ctx, header); err != nil {
+		return 0, 0, nil, err
+	}
+
+	size := binary.BigEndian.Uint32(header[5:9])
+	if size > maxFrameSize {
+		return 0, 0, nil, fmt.Errorf("forward: frame of %d bytes exceeds the limit", size)
+	}
+
+	payload := make([]byte, size)
+	if err := m.readFull(ctx, payload); err != nil {
+		return 0, 0, nil, err
+	}
+	return header[0], binary.BigEndian.Uint32(header[1:5]), payload, nil
+}
+
+func (m *mux) add(id uint32) *channel {
+	c := &channel{
+		mux:    m,
+		id:     id,
+		opened: make(chan error, 1),
+	}
+	c.cond = sync.NewCond(&c.mutex)
+
+	m.mutex.Lock()
+	m.channels[id] = c
+	m.mutex.Unlock()
+	return c
+}
+
+func (m *mux) lookup(id uint32) *channel {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.channels[id]
+}
+
+func (m *mux) remove(id uint32) {
+	m.mutex.Lock()
+	delete(m.channels, id)
+	m.mutex.Unlock()
+}
+
+// shutdown terminates all channels after the connection failed.
+func (m *mux) shutdown(err error) {
+	m.mutex.Lock()
+	channels := m.channels
+	m.channels = make(map[uint32]*channel)
+	if m.err == nil {
+		m.err = err
+		close(m.done)
+	}
+	m.mutex.Unlock()
+
+	for _, c := range channels {
+		c.signal(err)
+		c.end(err)
+	}
+}
+
+// receive delivers the incoming frames to their channels. Open frames are
+// passed to the open function.
+func (m *mux) receive(ctx context.Context, open func(id uint32, network string, address string)) error {
+	for {
+		kind, id, payload, err := m.readFrame(ctx)
+		if err != nil {
+			m.shutdown(err)
+			return err
+		}
+
+		switch kind {
+		case frameOpen:
+			if open == nil {
+				continue
+			}
+			network, address := splitTarget(string(payload))
+			open(id, network, address)
+
+		case frameOpened:
+			if c := m.lookup(id); c != nil {
+				c.signal(nil)
+			}
+
+		case frameData:
+			if c := m.lookup(id); c != nil && !c.deliver(payload) {
+				m.remove(id)
+				err := fmt.Errorf("forward: channel %d exceeds its receive buffer", id)
+				c.end(err)
+				if err := m.writeFrame(ctx, frameClose, id, []byte(err.Error())); err != nil {
+					m.shutdown(err)
+					return err
+				}
+			}
+
+		case frameClose:
+			if c := m.lookup(id); c != nil {
+				m.remove(id)
+				if len(payload) > 0 {
+					err = fmt.Errorf("forward: %s", payload)
+					c.signal(err)
+					c.end(err)
+				} else {
+					c.signal(io.EOF)
+					c.end(io.EOF)
+				}
+			}
+		}
+	}
+}
+
+func splitTarget(target string) (string, string) {
+	for i := 0; i < len(target); i++ {
+		if target[i] == 0 {
+			return target[:i], target[i+1:]
+		}
+	}
+	return "tcp", target
+}
+
+// addr is the address of a forwarded channel.
+type addr struct {
+	network string
+	address string
+}
+
+func (a addr) Network() string { return a.network }
+func (a addr) String() string  { return a.address }
+
+// channel is one forwarded stream, it implements net.Conn.
+type channel struct {
+	mux    *mux
+	id     uint32
+	target addr
+	opened chan error
+	once   sync.Once
+
+	// received holds the data not read yet, err ends the reading once it is
+	// read
+	mutex    sync.Mutex
+	cond     *sync.Cond
+	received []byte
+	err      error
+}
+
+var _ net.Conn = &channel{}
+
+// signal reports the result of opening the channel.
+func (c *channel) signal(err error) {
+	select {
+	case c.opened <- err:
+	default:
+	}
+}
+
+// deliver queues received data for the reader; it returns false if the
+// reader fell too far behind.
+func (c *channel) deliver(payload []byte) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.err != nil {
+		return true
+	}
+	if len(c.received)+len(payload) > maxBuffered {
+		return false
+	}
+	c.received = append(c.received, payload...)
+	c.cond.Broadcast()
+	return true
+}
+
+// end ends the reading once the received data is read.
+func (c *channel) end(err error) {
+	c.mutex.Lock()
+	if c.err == nil {
+		c.err = err
+	}
+	c.cond.Broadcast()
+	c.mutex.Unlock()
+}
+
+// ended returns whether the channel was closed by the peer, or with the
+// connection.
+func (c *channel) ended() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.err != nil
+}
+
+func (c *channel) Read(b []byte) (int, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for len(c.received) == 0 && c.err == nil {
+		c.cond.Wait()
+	}
+	if len(c.received) == 0 {
+		return 0, c.err
+	}
+	n := copy(b, c.received)
+	c.received = c.received[n:]
+	if len(c.received) == 0 {
+		c.received = nil
+	}
+	return n, nil
+}
+
+func (c *channel) Write(b []byte) (int, error) {
+	written := 0
+	for len(b) > 0 {
+		n := len(b)
+		if n > maxFrameSize {
+			n = maxFrameSize
+		}
+		if err := c.mux.writeFrame(context.Background(), frameData, c.id, b[:n]); err != nil {
+			return written, err
+		}
+		written += n
+		b = b[n:]
+	}
+	return written, nil
+}
+
+func (c *channel) Close() error {
+	var err error
+	c.once.Do(func() {
+		c.mux.remove(c.id)
+		c.mutex.Lock()
+		c.received = nil
+		c.mutex.Unlock()
+		c.end(io.ErrClosedPipe)
+		err = c.mux.writeFrame(context.Background(), frameClose, c.id, nil)
+	})
+	return err
+}
+
+func (c *channel) LocalAddr() net.Addr {
+	return addr{"varlink", "tunnel"}
+}
+
+func (c *channel) RemoteAddr() net.Addr {
+	return c.target
+}
+
+func (c *channel) SetDeadline(t time.Time) error {
+	return fmt.Errorf("forward: deadlines are not supported")
+}
+
+func (c *channel) SetReadDeadline(t time.Time) error {
+	return fmt.Errorf("forward: deadlines are not supported")
+}
+
+func (c *channel) SetWriteDeadline(t time.Time) error {
+	return fmt.Errorf("forward: deadlines are not supported")
+}
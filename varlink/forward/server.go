@@ -0,0 +1,118 @@
+// Package forward tunnels TCP streams through a varlink connection. This is useful
+// where only the varlink socket crosses a trust boundary.
+//
+// The Server implements the org.varlink.forward interface. A client calls its
+// Tunnel method with the upgrade flag; the connection then carries a simple
+// multiplexing protocol, where each channel is a stream to a target address
+// connected by the server.
+package forward
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/varlink/go/varlink"
+)
+
+// Server implements the org.varlink.forward interface.
+type Server struct {
+	// Allow decides whether a target may be connected. If nil, no target is allowed.
+	Allow func(network string, address string) bool
+	// Dialer connects to the targets; if nil, a zero net.Dialer is used.
+	Dialer *net.Dialer
+}
+
+func (s *Server) open(ctx context.Context, m *mux, id uint32, network string, address string) {
+	if s.Allow == nil || !s.Allow(network, address) {
+		m.writeFrame(ctx, frameClose, id, []byte(fmt.Sprintf("target %s:%s not allowed", network, address)))
+		return
+	}
+
+	// The channel is registered before dialing, so a close of the client, or of
+	// the tunnel, is not lost while the target is connected
+	c := m.add(id)
+	c.target = addr{network, address}
+
+	go func() {
+		d := s.Dialer
+		if d == nil {
+			d = &net.Dialer{}
+		}
+
+		target, err := d.DialContext(ctx, network, address)
+		if err != nil {
+			m.remove(id)
+			m.writeFrame(ctx, frameClose, id, []byte(err.Error()))
+			return
+		}
+		if c.ended() {
+			target.Close()
+			return
+		}
+		if err := m.writeFrame(ctx, frameOpened, id, nil); err != nil {
+			target.Close()
+			return
+		}
+
+		go func() {
+			io.Copy(target, c)
+			target.Close()
+		}()
+
+		io.Copy(c, target)
+		c.Close()
+	}()
+}
+
+func (s *Server) serve(ctx context.Context, rw varlink.ReadWriterContext) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	m := newMux(rw)
+	err := m.receive(ctx, func(id uint32, network string, address string) {
+		s.open(ctx, m, id, network, address)
+	})
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+// VarlinkDispatch dispatches the org.varlink.forward method calls.
+func (s *Server) VarlinkDispatch(ctx context.Context, call varlink.Call, methodname string) error {
+	switch methodname {
+	case "Tunnel":
+		if !call.WantsUpgrade() {
+			return call.ReplyInvalidParameter(ctx, "upgrade")
+		}
+		if err := call.Reply(ctx, nil); err != nil {
+			return err
+		}
+		return s.serve(ctx, call.Conn)
+
+	default:
+		return call.ReplyMethodNotFound(ctx, methodname)
+	}
+}
+
+// VarlinkGetName returns the interface name.
+func (s *Server) VarlinkGetName() string {
+	return `org.varlink.forward`
+}
+
+// VarlinkGetMethods returns the names of all dispatched methods.
+func (s *Server) VarlinkGetMethods() []string {
+	return []string{"Tunnel"}
+}
+
+// VarlinkGetDescription returns the interface description.
+func (s *Server) VarlinkGetDescription() string {
+	return `# Tunnel streams through a varlink connection.
+interface org.varlink.forward
+
+# Upgrade the connection to a multiplexing protocol which connects
+# streams to target addresses on behalf of the client.
+method Tunnel() -> ()`
+}
@@ -0,0 +1,72 @@
+package idl
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Example is a method call with the replies expected to it, given in the
+// documentation of a method or the interface. The call is a line starting with
+// "->", each reply one starting with "<-", holding the varlink messages:
+//
+//	# Ping the service.
+//	# -> {"method": "org.example.ping.Ping", "parameters": {"ping": "hi"}}
+//	# <- {"parameters": {"pong": "hi"}}
+//	method Ping(ping: string) -> (pong: string)
+type Example struct {
+	// Member is the name of the documented method, empty for the interface.
+	Member  string
+	Call    string
+	Replies []string
+}
+
+// Examples returns the examples of the interface, in the order of the
+// description. Messages which are not valid JSON are an error.
+func (i *IDL) Examples() ([]Example, error) {
+	examples, err := parseExamples("", i.Doc)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, member := range i.Members {
+		m, ok := member.(*Method)
+		if !ok {
+			continue
+		}
+		e, err := parseExamples(m.Name, m.Doc)
+		if err != nil {
+			return nil, err
+		}
+		examples = append(examples, e...)
+	}
+	return examples, nil
+}
+
+func parseExamples(member string, doc string) ([]Example, error) {
+	var examples []Example
+	for _, line := range strings.Split(doc, "\n") {
+		line = strings.TrimSpace(line)
+
+		var message string
+		switch {
+		case strings.HasPrefix(line, "->"):
+			message = strings.TrimSpace(line[2:])
+			examples = append(examples, Example{Member: member, Call: message})
+		case strings.HasPrefix(line, "<-"):
+			message = strings.TrimSpace(line[2:])
+			if len(examples) == 0 {
+				return nil, fmt.Errorf("%s: reply without a call: %s", member, message)
+			}
+			e := &examples[len(examples)-1]
+			e.Replies = append(e.Replies, message)
+		default:
+			continue
+		}
+
+		if !json.Valid([]byte(message)) {
+			return nil, fmt.Errorf("%s: invalid example message: %s", member, message)
+		}
+	}
+	return examples, nil
+}
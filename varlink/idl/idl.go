@@ -5,6 +5,7 @@ import (
 	"bytes"
 	"fmt"
 	"regexp"
+	"strings"
 )
 
 // Valid TypeKind values.
@@ -37,6 +38,7 @@ type Type struct {
 // TypeField is a named member of a TypeStruct.
 type TypeField struct {
 	Name string
+	Doc  string
 	Type *Type
 }
 
@@ -79,6 +81,8 @@ type parser struct {
 	position    int
 	lineStart   int
 	lastComment bytes.Buffer
+	// commentStart is the position of the line starting the last comment
+	commentStart int
 }
 
 func (p *parser) next() int {
@@ -108,7 +112,13 @@ func (p *parser) advance() bool {
 			// ignore
 
 		} else if char == '#' {
-			p.next()
+			if p.lastComment.Len() == 0 {
+				p.commentStart = p.lineStart
+			}
+			// Skip the space separating the comment character from the text
+			if p.next() != ' ' {
+				p.backup()
+			}
 			start := p.position
 			for {
 				c := p.next()
@@ -121,7 +131,9 @@ func (p *parser) advance() bool {
 				p.lastComment.WriteByte('\n')
 			}
 			p.lastComment.WriteString(p.input[start:p.position])
-			p.next()
+			if p.next() < 0 {
+				p.backup()
+			}
 
 		} else {
 			p.backup()
@@ -222,6 +234,9 @@ func (p *parser) readStructType() *Type {
 	t := &Type{Kind: TypeStruct}
 	t.Fields = make([]TypeField, 0)
 
+	// The comment preceding the struct is not the one of its first field
+	p.lastComment.Reset()
+
 	char := p.next()
 	if char != ')' {
 		p.backup()
@@ -230,6 +245,7 @@ func (p *parser) readStructType() *Type {
 			field := TypeField{}
 
 			p.advance()
+			field.Doc = p.lastComment.String()
 			field.Name = p.readFieldName()
 			if field.Name == "" {
 				return nil
@@ -433,7 +449,15 @@ func (p *parser) readIDL() (*IDL, error) {
 			break
 		}
 
-		switch keyword := p.readKeyword(); keyword {
+		start := p.position
+		keyword := p.readKeyword()
+		if keyword == "interface" {
+			// The next interface of the description
+			p.position = start
+			break
+		}
+
+		switch keyword {
 		case "type":
 			a, err := p.readAlias(idl)
 			if err != nil {
@@ -488,10 +512,60 @@ func New(description string) (*IDL, error) {
 		return nil, err
 	}
 
-	if len(idl.Methods) == 0 {
-		return nil, fmt.Errorf("no methods defined")
+	// An interface of errors declares the errors replied by other interfaces
+	if len(idl.Methods) == 0 && len(idl.Errors) == 0 {
+		return nil, fmt.Errorf("no methods or errors defined")
+	}
+	if p.advance() {
+		return nil, fmt.Errorf("more than one interface defined, use NewAll")
 	}
 
 	idl.Description = description
 	return idl, nil
 }
+
+// NewAll parses a description file with one or more interfaces, each starting
+// with its interface keyword. The Description of every interface is its part of
+// the file, from the comment preceding the interface keyword on.
+func NewAll(description string) ([]*IDL, error) {
+	p := &parser{input: description}
+	names := make(map[string]bool)
+
+	var idls []*IDL
+	start := 0
+	p.advance()
+	for {
+		idl, err := p.readIDL()
+		if err != nil {
+			if len(idls) > 0 {
+				return nil, fmt.Errorf("interface %d: %v", len(idls)+1, err)
+			}
+			return nil, err
+		}
+
+		if len(idl.Methods) == 0 && len(idl.Errors) == 0 {
+			return nil, fmt.Errorf("interface `%s`: no methods or errors defined", idl.Name)
+		}
+		if names[idl.Name] {
+			return nil, fmt.Errorf("interface `%s` already defined", idl.Name)
+		}
+		names[idl.Name] = true
+
+		next := len(description)
+		more := p.advance()
+		if more {
+			next = p.position
+			if p.lastComment.Len() > 0 {
+				next = p.commentStart
+			}
+		}
+
+		idl.Description = strings.TrimRight(description[start:next], " \t\r\n") + "\n"
+		idls = append(idls, idl)
+
+		if !more {
+			return idls, nil
+		}
+		start = next
+	}
+}
@@ -39,6 +39,11 @@ func TestOneMethod(t *testing.T) {
 	testParse(t, true, "interface foo.bar\nmethod Foo()->()")
 }
 
+func TestOnlyErrors(t *testing.T) {
+	testParse(t, true, "interface foo.bar\nerror Failed (id: string)")
+	testParse(t, false, "interface foo.bar\n")
+}
+
 func TestOneMethodNoType(t *testing.T) {
 	testParse(t, false, "interface foo.bar\nmethod Foo()->(b:)")
 }
@@ -148,3 +153,114 @@ interface foo.example
 	method F() -> ()
 `)
 }
+
+func TestTrailingComment(t *testing.T) {
+	if _, err := New("#"); err == nil {
+		t.Fatal("New() accepted a description without interface")
+	}
+
+	midl, err := New("#\n# Doc\ninterface org.example.comment\nmethod F() -> ()\n#")
+	if err != nil {
+		t.Fatalf("New(): %v", err)
+	}
+	if midl.Doc != "Doc" {
+		t.Fatalf("Unexpected doc `%s`", midl.Doc)
+	}
+}
+
+func TestMultipleInterfaces(t *testing.T) {
+	description := `# The first interface.
+interface org.example.first
+
+method First() -> ()
+
+# The second interface,
+# documented on two lines.
+interface org.example.second
+
+type Value (value: int)
+
+method Second(value: Value) -> ()
+`
+
+	testParse(t, false, description)
+
+	idls, err := NewAll(description)
+	if err != nil {
+		t.Fatalf("NewAll(): %v", err)
+	}
+	if len(idls) != 2 || idls[0].Name != "org.example.first" || idls[1].Name != "org.example.second" {
+		t.Fatalf("Unexpected interfaces %v", idls)
+	}
+	if idls[1].Doc != "The second interface,\ndocumented on two lines." || len(idls[1].Aliases) != 1 {
+		t.Fatalf("Unexpected second interface %+v", idls[1])
+	}
+
+	first := "# The first interface.\ninterface org.example.first\n\nmethod First() -> ()\n"
+	if idls[0].Description != first {
+		t.Fatalf("Unexpected description `%s`", idls[0].Description)
+	}
+	for _, idl := range idls {
+		if _, err := New(idl.Description); err != nil {
+			t.Fatalf("Description of %s does not parse: %v", idl.Name, err)
+		}
+	}
+
+	if _, err := NewAll("interface foo.bar\nmethod Foo()->()\ninterface foo.bar\nmethod Bar()->()\n"); err == nil {
+		t.Fatal("NewAll() accepted a duplicate interface")
+	}
+	if _, err := NewAll("interface foo.bar\nmethod Foo()->()\ninterface foo.baz\n"); err == nil {
+		t.Fatal("NewAll() accepted an interface without methods")
+	}
+}
+
+func TestFieldDoc(t *testing.T) {
+	midl, err := New(`interface org.example.doc
+# The type
+type T (
+  # The first field
+  a: int,
+  b: int
+)
+method F() -> ()
+`)
+	if err != nil {
+		t.Fatalf("New(): %v", err)
+	}
+	fields := midl.Aliases[0].Type.Fields
+	if fields[0].Doc != "The first field" || fields[1].Doc != "" {
+		t.Fatalf("Unexpected field docs %+v", fields)
+	}
+}
+
+func TestExamples(t *testing.T) {
+	midl, err := New(`# The interface.
+# -> {"method": "org.example.examples.F"}
+# <- {}
+interface org.example.examples
+
+# F does nothing.
+#   -> {"method": "org.example.examples.F", "more": true}
+#   <- {"continues": true}
+#   <- {}
+method F() -> ()
+
+method G() -> ()
+`)
+	if err != nil {
+		t.Fatalf("New(): %v", err)
+	}
+	examples, err := midl.Examples()
+	if err != nil {
+		t.Fatalf("Examples(): %v", err)
+	}
+	if len(examples) != 2 || examples[0].Member != "" || examples[1].Member != "F" ||
+		examples[1].Call != `{"method": "org.example.examples.F", "more": true}` || len(examples[1].Replies) != 2 {
+		t.Fatalf("Unexpected examples %+v", examples)
+	}
+
+	midl, _ = New("interface org.example.examples\n# -> {\nmethod F() -> ()\n")
+	if _, err := midl.Examples(); err == nil {
+		t.Fatal("Examples() accepted invalid JSON")
+	}
+}
@@ -3,22 +3,39 @@ package ctxio
 import (
 	"bufio"
 	"context"
+	"errors"
 	"net"
 	"time"
 )
 
+// ErrTooLarge is returned by ReadBytes for data longer than the read limit.
+var ErrTooLarge = errors.New("message exceeds the size limit")
+
 // Conn wraps net.Conn with context aware functionality.
 type Conn struct {
 	conn   net.Conn
 	reader *bufio.Reader
+	// files receives the files passed on unix sockets, consumed is the stream
+	// offset of the next message
+	files    *fileReader
+	consumed int64
+	// limit is the maximum length of the data returned by ReadBytes
+	limit int
+	// readTimeout and writeTimeout are set with SetTimeouts
+	readTimeout  time.Duration
+	writeTimeout time.Duration
 }
 
 // NewConn creates a new context aware Conn.
 func NewConn(c net.Conn) *Conn {
-	return &Conn{
-		conn:   c,
-		reader: bufio.NewReader(c),
+	conn := &Conn{conn: c}
+	if r := newFileReader(c); r != nil {
+		conn.files = r
+		conn.reader = bufio.NewReader(r)
+	} else {
+		conn.reader = bufio.NewReader(c)
 	}
+	return conn
 }
 
 type ioret struct {
@@ -37,23 +54,32 @@ var aLongTimeAgo = time.Unix(1, 0)
 
 // Close releases the Conns resources.
 func (c *Conn) Close() error {
+	if c.files != nil {
+		c.files.close()
+	}
 	return c.conn.Close()
 }
 
 // Write writes to the underlying connection.
 // It is not safe for concurrent use with itself.
 func (c *Conn) Write(ctx context.Context, buf []byte) (int, error) {
+	return c.write(ctx, func() (int, error) {
+		return c.conn.Write(buf)
+	})
+}
+
+func (c *Conn) write(ctx context.Context, write func() (int, error)) (int, error) {
 	// Enable immediate connection cancelation via context by using the context's
 	// deadline and also setting a deadline in the past if/when the context is
 	// canceled. This pattern courtesy of @acln from #networking on Gophers Slack.
-	dl, _ := ctx.Deadline()
+	dl := deadline(ctx, c.writeTimeout)
 	if err := c.conn.SetWriteDeadline(dl); err != nil {
 		return 0, err
 	}
 
 	ch := make(chan ioret, 1)
 	go func() {
-		n, err := c.conn.Write(buf)
+		n, err := write()
 		ch <- ioret{n, err}
 	}()
 
@@ -63,13 +89,14 @@ func (c *Conn) Write(ctx context.Context, buf []byte) (int, error) {
 		if err := c.conn.SetWriteDeadline(aLongTimeAgo); err != nil {
 			return 0, err
 		}
-		// Wait for goroutine to exit, throwing away the error.
-		<-ch
+		// Wait for goroutine to exit, keeping the count of the bytes written
+		// before the deadline, throwing away the error.
+		ret := <-ch
 		// Reset deadline again.
 		if err := c.conn.SetWriteDeadline(time.Time{}); err != nil {
-			return 0, err
+			return ret.n, err
 		}
-		return 0, ctx.Err()
+		return ret.n, ctx.Err()
 	case ret := <-ch:
 		return ret.n, ret.err
 	}
@@ -81,14 +108,16 @@ func (c *Conn) Read(ctx context.Context, buf []byte) (int, error) {
 	// Enable immediate connection cancelation via context by using the context's
 	// deadline and also setting a deadline in the past if/when the context is
 	// canceled. This pattern courtesy of @acln from #networking on Gophers Slack.
-	dl, _ := ctx.Deadline()
+	dl := deadline(ctx, c.readTimeout)
 	if err := c.conn.SetReadDeadline(dl); err != nil {
 		return 0, err
 	}
 
+	// Read through the buffered reader, it might already hold data
+	// following a message read with ReadBytes.
 	ch := make(chan ioret, 1)
 	go func() {
-		n, err := c.conn.Read(buf)
+		n, err := c.reader.Read(buf)
 		ch <- ioret{n, err}
 	}()
 
@@ -106,24 +135,73 @@ func (c *Conn) Read(ctx context.Context, buf []byte) (int, error) {
 		}
 		return 0, ctx.Err()
 	case ret := <-ch:
+		c.consumed += int64(ret.n)
 		return ret.n, ret.err
 	}
 }
 
+// SetReadLimit limits the length of the data returned by ReadBytes, including
+// the delimiter; zero or less means no limit. Beyond the limit, ReadBytes fails
+// with ErrTooLarge and the connection is not usable anymore.
+// It is not safe for concurrent use with ReadBytes.
+func (c *Conn) SetReadLimit(limit int) {
+	c.limit = limit
+}
+
+// SetTimeouts limits the time of every Read and ReadBytes, and of every Write,
+// in addition to the deadline of their context; zero means no limit. Peek is not
+// limited. It is not safe for concurrent use with Read, ReadBytes and Write.
+func (c *Conn) SetTimeouts(read, write time.Duration) {
+	c.readTimeout = read
+	c.writeTimeout = write
+}
+
+// deadline returns the deadline of the context, or the end of the timeout if
+// that is earlier.
+func deadline(ctx context.Context, timeout time.Duration) time.Time {
+	dl, ok := ctx.Deadline()
+	if timeout > 0 {
+		if t := time.Now().Add(timeout); !ok || t.Before(dl) {
+			dl = t
+		}
+	}
+	return dl
+}
+
+// readBytes reads until the delimiter, like bufio.Reader.ReadBytes, without
+// buffering more than the limit.
+func (c *Conn) readBytes(delim byte) ([]byte, error) {
+	if c.limit <= 0 {
+		return c.reader.ReadBytes(delim)
+	}
+
+	var out []byte
+	for {
+		frag, err := c.reader.ReadSlice(delim)
+		if len(out)+len(frag) > c.limit {
+			return nil, ErrTooLarge
+		}
+		out = append(out, frag...)
+		if err != bufio.ErrBufferFull {
+			return out, err
+		}
+	}
+}
+
 // ReadBytes reads from the connection until the bytes are found.
 // It is not safe for concurrent use with itself or Read.
 func (c *Conn) ReadBytes(ctx context.Context, delim byte) ([]byte, error) {
 	// Enable immediate connection cancelation via context by using the context's
 	// deadline and also setting a deadline in the past if/when the context is
 	// canceled. This pattern courtesy of @acln from #networking on Gophers Slack.
-	dl, _ := ctx.Deadline()
+	dl := deadline(ctx, c.readTimeout)
 	if err := c.conn.SetReadDeadline(dl); err != nil {
 		return nil, err
 	}
 
 	ch := make(chan rret, 1)
 	go func() {
-		out, err := c.reader.ReadBytes(delim)
+		out, err := c.readBytes(delim)
 		ch <- rret{out, err}
 	}()
 
@@ -140,7 +218,62 @@ func (c *Conn) ReadBytes(ctx context.Context, delim byte) ([]byte, error) {
 			return nil, err
 		}
 		return nil, ctx.Err()
+	case ret := <-ch:
+		c.claim(len(ret.val))
+		return ret.val, ret.err
+	}
+}
+
+// Peek returns the next n bytes without consuming them.
+// It is not safe for concurrent use with Read or ReadBytes.
+func (c *Conn) Peek(ctx context.Context, n int) ([]byte, error) {
+	dl, _ := ctx.Deadline()
+	if err := c.conn.SetReadDeadline(dl); err != nil {
+		return nil, err
+	}
+
+	ch := make(chan rret, 1)
+	go func() {
+		out, err := c.reader.Peek(n)
+		ch <- rret{out, err}
+	}()
+
+	select {
+	case <-ctx.Done():
+		// Set deadline to unblock pending Peek.
+		if err := c.conn.SetReadDeadline(aLongTimeAgo); err != nil {
+			return nil, err
+		}
+		// Wait for goroutine to exit, throwing away the error.
+		<-ch
+		// Reset deadline again.
+		if err := c.conn.SetReadDeadline(time.Time{}); err != nil {
+			return nil, err
+		}
+		return nil, ctx.Err()
 	case ret := <-ch:
 		return ret.val, ret.err
 	}
 }
+
+// bufferedConn reads through the buffered reader of a Conn.
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (b *bufferedConn) Read(buf []byte) (int, error) {
+	return b.reader.Read(buf)
+}
+
+// NetConn returns the underlying connection, which still returns the buffered
+// data. The Conn must not be used afterwards.
+func (c *Conn) NetConn() net.Conn {
+	return &bufferedConn{c.conn, c.reader}
+}
+
+// Underlying returns the underlying connection, to inspect it only: reading
+// from it would skip the buffered data.
+func (c *Conn) Underlying() net.Conn {
+	return c.conn
+}
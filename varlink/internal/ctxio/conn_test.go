@@ -4,7 +4,11 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"io/ioutil"
 	"net"
+	"os"
+	"path/filepath"
+	"runtime"
 	"sync"
 	"testing"
 	"time"
@@ -94,6 +98,23 @@ func TestBlockingWrite(t *testing.T) {
 	}
 }
 
+func TestPartialWrite(t *testing.T) {
+	cl, srv := net.Pipe()
+
+	// The count of the bytes written before the cancellation is returned
+	ctxC := ctxio.NewConn(cl)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		srv.Read(make([]byte, 5))
+		time.Sleep(time.Millisecond)
+		cancel()
+	}()
+	n, err := ctxC.Write(ctx, []byte("hello world\n"))
+	if err != context.Canceled || n != 5 {
+		t.Fatalf("Write() returned %d, %v", n, err)
+	}
+}
+
 func TestBlockingRead(t *testing.T) {
 	cl, _ := net.Pipe()
 
@@ -111,3 +132,190 @@ func TestBlockingRead(t *testing.T) {
 		t.Fatalf("Got unexpected error: %T, %s", err, err)
 	}
 }
+
+func TestPeek(t *testing.T) {
+	server, client := net.Pipe()
+	go func() {
+		client.Write([]byte("GET / HTTP/1.0\r\n"))
+		client.Close()
+	}()
+
+	c := ctxio.NewConn(server)
+	b, err := c.Peek(context.Background(), 3)
+	if err != nil || string(b) != "GET" {
+		t.Fatalf("Unexpected peek '%s': %v", b, err)
+	}
+
+	line, err := bufio.NewReader(c.NetConn()).ReadString('\n')
+	if err != nil || line != "GET / HTTP/1.0\r\n" {
+		t.Fatalf("Unexpected line '%s': %v", line, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	idle, peer := net.Pipe()
+	defer peer.Close()
+	if _, err := ctxio.NewConn(idle).Peek(ctx, 1); err == nil {
+		t.Fatalf("Peek did not time out: %v", err)
+	}
+}
+
+func TestFiles(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("files are only passed on unix sockets")
+	}
+
+	dir, err := ioutil.TempDir("", "ctxio")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	l, err := net.Listen("unix", filepath.Join(dir, "socket"))
+	if err != nil {
+		t.Fatalf("Unexpected error creating a listener: %v", err)
+	}
+	defer l.Close()
+
+	f, err := ioutil.TempFile(dir, "file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("content")
+
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		ctxC := ctxio.NewConn(c)
+		defer ctxC.Close()
+
+		ctx := context.Background()
+		ctxC.Write(ctx, []byte("first\x00"))
+		if _, err := ctxC.WriteFiles(ctx, []byte("second\x00"), []*os.File{f}); err != nil {
+			t.Errorf("WriteFiles(): %v", err)
+		}
+		ctxC.Write(ctx, []byte("third\x00"))
+	}()
+
+	c, err := net.Dial("unix", filepath.Join(dir, "socket"))
+	if err != nil {
+		t.Fatalf("Failed to dial server: %v", err)
+	}
+	ctxC := ctxio.NewConn(c)
+	defer ctxC.Close()
+	if !ctxC.CanPassFiles() {
+		t.Fatal("Unix socket cannot pass files")
+	}
+	ctxC.SetFileLimit(1)
+
+	// All messages are sent before the first is read, the files must still be
+	// returned with the second
+	time.Sleep(time.Second / 10)
+	for i, expected := range []int{0, 1, 0} {
+		if _, err := ctxC.ReadBytes(context.Background(), '\x00'); err != nil {
+			t.Fatalf("Failed to read message: %v", err)
+		}
+		files := ctxC.Files()
+		if len(files) != expected {
+			t.Fatalf("Message %d passed %d files, expected %d", i, len(files), expected)
+		}
+		if expected == 0 {
+			continue
+		}
+
+		b := make([]byte, 7)
+		if _, err := files[0].ReadAt(b, 0); err != nil || string(b) != "content" {
+			t.Fatalf("Unexpected file content '%s': %v", b, err)
+		}
+		files[0].Close()
+	}
+}
+
+func TestFileLimit(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("files are only passed on unix sockets")
+	}
+
+	dir, err := ioutil.TempDir("", "ctxio")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	l, err := net.Listen("unix", filepath.Join(dir, "socket"))
+	if err != nil {
+		t.Fatalf("Unexpected error creating a listener: %v", err)
+	}
+	defer l.Close()
+
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			ctxC := ctxio.NewConn(c)
+			ctxC.WriteFiles(context.Background(), []byte("files\x00"), []*os.File{os.Stdin, os.Stdout})
+			ctxC.Write(context.Background(), []byte("next\x00"))
+			ctxC.Close()
+		}
+	}()
+
+	for _, limit := range []int{0, 1, 2} {
+		c, err := net.Dial("unix", filepath.Join(dir, "socket"))
+		if err != nil {
+			t.Fatalf("Failed to dial server: %v", err)
+		}
+		ctxC := ctxio.NewConn(c)
+		ctxC.SetFileLimit(limit)
+
+		_, err = ctxC.ReadBytes(context.Background(), '\x00')
+		files := ctxC.Files()
+		switch limit {
+		case 0:
+			// Without a limit no files are received
+			if err != nil || len(files) != 0 {
+				t.Fatalf("Received %d files: %v", len(files), err)
+			}
+		case 1:
+			if err != ctxio.ErrTooManyFiles {
+				t.Fatalf("ReadBytes() returned %v", err)
+			}
+			if _, err := ctxC.ReadBytes(context.Background(), '\x00'); err == nil {
+				t.Fatal("Connection still usable after too many files")
+			}
+		case 2:
+			if err != nil || len(files) != 2 {
+				t.Fatalf("Received %d files: %v", len(files), err)
+			}
+		}
+		for _, f := range files {
+			f.Close()
+		}
+		ctxC.Close()
+	}
+}
+
+func TestNoFiles(t *testing.T) {
+	l, err := net.Listen("tcp", ":")
+	if err != nil {
+		t.Fatalf("Unexpected error creating a listener: %v", err)
+	}
+	defer l.Close()
+
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to dial server: %v", err)
+	}
+	ctxC := ctxio.NewConn(c)
+	defer ctxC.Close()
+
+	if ctxC.CanPassFiles() {
+		t.Fatal("TCP connection can pass files")
+	}
+	if _, err := ctxC.WriteFiles(context.Background(), []byte("message\x00"), []*os.File{os.Stdin}); err != ctxio.ErrNoFiles {
+		t.Fatalf("WriteFiles() returned %v", err)
+	}
+}
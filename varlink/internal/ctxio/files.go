@@ -0,0 +1,182 @@
+package ctxio
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"sync"
+)
+
+// ErrNoFiles is returned when files are written to a connection which cannot
+// pass them, which is any connection but a unix socket.
+var ErrNoFiles = errors.New("connection cannot pass files")
+
+// ErrTooManyFiles is returned by reads receiving more files than the limit set
+// with SetFileLimit.
+var ErrTooManyFiles = errors.New("peer passed too many files")
+
+// maxFiles is the maximum number of files received with one read, the limit of
+// the kernel.
+const maxFiles = 253
+
+// fileBatch holds the files received with one read, whose data was at the
+// stream offsets from start to end.
+type fileBatch struct {
+	start int64
+	end   int64
+	files []*os.File
+}
+
+// fileReader reads from a unix socket and keeps the files passed along the data.
+type fileReader struct {
+	conn    *net.UnixConn
+	oob     []byte
+	mutex   sync.Mutex
+	offset  int64
+	batches []fileBatch
+	// received are the files of the last message
+	received []*os.File
+	// limit is the maximum number of files received and not yet claimed by a
+	// message; zero receives none
+	limit int
+	// overflowed is set once the limit was exceeded, reads fail from then on
+	overflowed bool
+}
+
+// add records the n bytes read and the files passed along them. Beyond the
+// limit of the files not yet claimed, all of them are closed and the
+// connection is shut down, it is out of sync with the files of the peer.
+func (r *fileReader) add(n int, files []*os.File) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	start := r.offset
+	r.offset += int64(n)
+	if len(files) == 0 {
+		return nil
+	}
+
+	pending := len(files)
+	for _, b := range r.batches {
+		pending += len(b.files)
+	}
+	if pending > r.limit {
+		closeFiles(files)
+		r.overflow()
+		return ErrTooManyFiles
+	}
+	r.batches = append(r.batches, fileBatch{start: start, end: r.offset, files: files})
+	return nil
+}
+
+// overflow closes the files not yet claimed and shuts the connection down. It
+// is called with the mutex held.
+func (r *fileReader) overflow() {
+	for _, b := range r.batches {
+		closeFiles(b.files)
+	}
+	r.batches = nil
+	r.overflowed = true
+	r.conn.CloseRead()
+	r.conn.CloseWrite()
+}
+
+// take sets the received files to the ones passed with the message at the
+// stream offsets from start to end. Files are sent with the first byte of a
+// message, and the kernel ends a read after the data the files came with, so
+// they belong to the last message starting within that read. Files of earlier
+// messages, which were not claimed, are closed.
+func (r *fileReader) take(start int64, end int64) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	closeFiles(r.received)
+	var files []*os.File
+	kept := r.batches[:0]
+	for _, b := range r.batches {
+		switch {
+		case b.end > end:
+			kept = append(kept, b)
+		case b.start <= start && start < b.end:
+			files = append(files, b.files...)
+		default:
+			closeFiles(b.files)
+		}
+	}
+	r.batches = kept
+	r.received = files
+}
+
+func (r *fileReader) close() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, b := range r.batches {
+		closeFiles(b.files)
+	}
+	r.batches = nil
+	closeFiles(r.received)
+	r.received = nil
+}
+
+func closeFiles(files []*os.File) {
+	for _, f := range files {
+		f.Close()
+	}
+}
+
+// CanPassFiles returns whether files can be passed on the connection.
+func (c *Conn) CanPassFiles() bool {
+	return c.files != nil
+}
+
+// SetFileLimit lets the connection receive the files passed on unix sockets, up
+// to limit files not yet returned with their message by Files; zero, the
+// default, receives none, the kernel closes them. Beyond the limit, the read
+// fails with ErrTooManyFiles, the files are closed and the connection is shut
+// down. It is not safe for concurrent use with Read and ReadBytes.
+func (c *Conn) SetFileLimit(limit int) {
+	if c.files == nil {
+		return
+	}
+	if limit > maxFiles {
+		limit = maxFiles
+	}
+	c.files.setLimit(limit)
+}
+
+// Files returns the files received with the message last returned by
+// ReadBytes. The caller owns them; files not taken before the next ReadBytes
+// are closed.
+func (c *Conn) Files() []*os.File {
+	if c.files == nil {
+		return nil
+	}
+
+	c.files.mutex.Lock()
+	defer c.files.mutex.Unlock()
+	files := c.files.received
+	c.files.received = nil
+	return files
+}
+
+// claim records that a message of n bytes was read and collects its files.
+func (c *Conn) claim(n int) {
+	start := c.consumed
+	c.consumed += int64(n)
+	if c.files != nil {
+		c.files.take(start, c.consumed)
+	}
+}
+
+// WriteFiles writes the buffer and passes the files along its first byte.
+// It is not safe for concurrent use with itself or Write.
+func (c *Conn) WriteFiles(ctx context.Context, buf []byte, files []*os.File) (int, error) {
+	if c.files == nil {
+		return 0, ErrNoFiles
+	}
+
+	return c.write(ctx, func() (int, error) {
+		return writeFiles(c.files.conn, buf, files)
+	})
+}
@@ -0,0 +1,24 @@
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package ctxio
+
+import (
+	"net"
+	"os"
+)
+
+func newFileReader(conn net.Conn) *fileReader {
+	return nil
+}
+
+func (r *fileReader) setLimit(limit int) {
+	r.limit = limit
+}
+
+func (r *fileReader) Read(p []byte) (int, error) {
+	return r.conn.Read(p)
+}
+
+func writeFiles(conn *net.UnixConn, buf []byte, files []*os.File) (int, error) {
+	return 0, ErrNoFiles
+}
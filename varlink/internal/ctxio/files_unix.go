@@ -0,0 +1,98 @@
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package ctxio
+
+import (
+	"net"
+	"os"
+	"runtime"
+	"syscall"
+)
+
+func newFileReader(conn net.Conn) *fileReader {
+	u, ok := conn.(*net.UnixConn)
+	if !ok {
+		return nil
+	}
+	return &fileReader{conn: u}
+}
+
+func (r *fileReader) setLimit(limit int) {
+	r.limit = limit
+	r.oob = nil
+	if limit > 0 {
+		r.oob = make([]byte, syscall.CmsgSpace(limit*4))
+	}
+}
+
+func (r *fileReader) Read(p []byte) (int, error) {
+	if r.overflowed {
+		return 0, ErrTooManyFiles
+	}
+	if r.limit == 0 {
+		n, err := r.conn.Read(p)
+		r.add(n, nil)
+		return n, err
+	}
+
+	n, oobn, flags, _, err := r.conn.ReadMsgUnix(p, r.oob)
+	if n < 0 {
+		// Failed reads report -1 on some systems
+		n = 0
+	}
+
+	var files []*os.File
+	if oobn > 0 {
+		files = parseFiles(r.oob[:oobn])
+	}
+	if flags&syscall.MSG_CTRUNC != 0 {
+		// More files than the limit, the kernel closed the ones not received
+		closeFiles(files)
+		r.mutex.Lock()
+		r.overflow()
+		r.mutex.Unlock()
+		return 0, ErrTooManyFiles
+	}
+
+	if e := r.add(n, files); e != nil {
+		// The data is dropped, the message it starts never completes
+		return 0, e
+	}
+	return n, err
+}
+
+func parseFiles(oob []byte) []*os.File {
+	messages, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return nil
+	}
+
+	var files []*os.File
+	for i := range messages {
+		fds, err := syscall.ParseUnixRights(&messages[i])
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			syscall.CloseOnExec(fd)
+			files = append(files, os.NewFile(uintptr(fd), "varlink-file"))
+		}
+	}
+	return files
+}
+
+func writeFiles(conn *net.UnixConn, buf []byte, files []*os.File) (int, error) {
+	fds := make([]int, len(files))
+	for i, f := range files {
+		fds[i] = int(f.Fd())
+	}
+
+	n, _, err := conn.WriteMsgUnix(buf, syscall.UnixRights(fds...), nil)
+	runtime.KeepAlive(files)
+	if err == nil && n < len(buf) {
+		var m int
+		m, err = conn.Write(buf[n:])
+		n += m
+	}
+	return n, err
+}
@@ -0,0 +1,406 @@
+package varlink_test
+
+// Interoperability with libvarlink, the C implementation. The tests run the
+// varlink command of libvarlink, taken from $VARLINK_C_CLI or the PATH, as a
+// client of a Go service, and as a bridge between a Go client and a Go service,
+// so the C library parses and writes every call and reply. They are skipped if
+// the command is not installed, and fail if $VARLINK_C_CLI names a command which
+// does not work, so CI cannot skip them silently.
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/varlink/go/varlink"
+	"github.com/varlink/go/varlink/capture"
+)
+
+// libvarlinkCLI returns the path of the varlink command of libvarlink. The
+// command of this module has the same name, but no --version.
+func libvarlinkCLI(t *testing.T) string {
+	if runtime.GOOS == "windows" {
+		t.Skip("libvarlink is not available on windows")
+	}
+	path := os.Getenv("VARLINK_C_CLI")
+	required := path != ""
+	if !required {
+		var err error
+		if path, err = exec.LookPath("varlink"); err != nil {
+			t.Skip("the varlink command of libvarlink is not installed")
+		}
+	}
+	out, err := exec.Command(path, "--version").Output()
+	if err != nil || len(bytes.TrimSpace(out)) == 0 {
+		if required {
+			t.Fatalf("VARLINK_C_CLI=%s is not the varlink command of libvarlink: %v", path, err)
+		}
+		t.Skipf("%s is not the varlink command of libvarlink", path)
+	}
+	return path
+}
+
+// cliSupports returns whether the help of the command mentions the option.
+func cliSupports(cli string, command string, option string) bool {
+	out, _ := exec.Command(cli, command, "--help").CombinedOutput()
+	return bytes.Contains(out, []byte(option))
+}
+
+type InteropInterface struct {
+	mutex    sync.Mutex
+	notified []string
+}
+
+func (s *InteropInterface) VarlinkDispatch(ctx context.Context, call varlink.Call, methodname string) error {
+	switch methodname {
+	case "Ping":
+		var in struct {
+			Ping string `json:"ping"`
+		}
+		if err := call.GetParameters(&in); err != nil {
+			return call.ReplyInvalidParameter(ctx, "ping")
+		}
+		return call.Reply(ctx, map[string]string{"pong": in.Ping})
+
+	case "Echo":
+		return call.Reply(ctx, call.Parameters())
+
+	case "Count":
+		var in struct {
+			N int `json:"n"`
+		}
+		call.GetParameters(&in)
+		if !call.WantsMore() {
+			return call.ReplyInvalidParameter(ctx, "more")
+		}
+		for i := 1; i <= in.N; i++ {
+			call.Continues = i < in.N
+			if err := call.Reply(ctx, map[string]int{"i": i}); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case "Notify":
+		var in struct {
+			Message string `json:"message"`
+		}
+		call.GetParameters(&in)
+		s.mutex.Lock()
+		s.notified = append(s.notified, in.Message)
+		s.mutex.Unlock()
+		return call.Reply(ctx, nil)
+
+	case "Fail":
+		return call.ReplyError(ctx, "org.example.interop.Failed", map[string]string{"reason": "on purpose"})
+	case "Deny":
+		return call.ReplyPermissionDenied(ctx)
+	case "Unimplemented":
+		return call.ReplyMethodNotImplemented(ctx, "org.example.interop."+methodname)
+	case "Busy":
+		return call.ReplyServiceNotAvailable(ctx)
+
+	case "Upgrade":
+		if err := call.Reply(ctx, nil); err != nil {
+			return err
+		}
+		if _, err := call.Conn.Write(ctx, []byte("upgraded\n")); err != nil {
+			return err
+		}
+		// The upgraded protocol ends with the connection
+		return fmt.Errorf("upgraded protocol ended")
+	}
+	return call.ReplyMethodNotFound(ctx, "org.example.interop."+methodname)
+}
+
+func (s *InteropInterface) VarlinkGetName() string {
+	return `org.example.interop`
+}
+
+func (s *InteropInterface) VarlinkGetDescription() string {
+	return `interface org.example.interop
+
+type Item (name: string, tags: []string, size: ?int, kind: (small, large))
+
+method Ping(ping: string) -> (pong: string)
+method Echo(item: Item, data: [string]string, flags: [string]()) -> (item: Item, data: [string]string, flags: [string]())
+method Count(n: int) -> (i: int)
+method Notify(message: string) -> ()
+method Fail() -> ()
+method Deny() -> ()
+method Unimplemented() -> ()
+method Busy() -> ()
+method Upgrade() -> ()
+
+error Failed (reason: string)`
+}
+
+// echoParameters use all kinds of types, and characters JSON escapes.
+const echoParameters = `{"item":{"name":"tab\tquote\"unicodeé☃","tags":["a","b"],"size":null,"kind":"large"},` +
+	`"data":{"key":"value","empty":""},"flags":{"x":{}}}`
+
+// serveInterop serves the interop interface, recording the messages of the
+// connections, until the returned function is called.
+func serveInterop(t *testing.T) (string, *InteropInterface, func() []*capture.Record) {
+	var recorded bytes.Buffer
+	var mutex sync.Mutex
+	w := capture.NewWriter(writerFunc(func(b []byte) (int, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return recorded.Write(b)
+	}))
+
+	iface := &InteropInterface{}
+	service, err := varlink.NewServiceWithConfig("Varlink", "Varlink Test", "1", "https://github.com/varlink/go/varlink",
+		varlink.ServiceConfig{Capture: w})
+	if err != nil {
+		t.Fatalf("NewServiceWithConfig(): %v", err)
+	}
+	if err := service.RegisterInterface(iface); err != nil {
+		t.Fatalf("RegisterInterface(): %v", err)
+	}
+	ctx := context.Background()
+	if err := service.Bind(ctx, "tcp:127.0.0.1:0"); err != nil {
+		t.Fatalf("Bind(): %v", err)
+	}
+	l, _ := service.GetListener()
+	servererror := make(chan error)
+	go func() {
+		servererror <- service.DoListen(ctx, 0)
+	}()
+
+	return "tcp:" + l.Addr().String(), iface, func() []*capture.Record {
+		service.Shutdown()
+		if err := <-servererror; err != nil {
+			t.Fatalf("service.DoListen(): %v", err)
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		var records []*capture.Record
+		r := capture.NewReader(&recorded)
+		for {
+			record, err := r.Next()
+			if err != nil {
+				return records
+			}
+			records = append(records, record)
+		}
+	}
+}
+
+type writerFunc func([]byte) (int, error)
+
+func (f writerFunc) Write(b []byte) (int, error) {
+	return f(b)
+}
+
+// checkFraming fails unless every call received is one JSON object terminated
+// by a single NUL byte, and returns the calls by method.
+func checkFraming(t *testing.T, records []*capture.Record) map[string]map[string]json.RawMessage {
+	calls := make(map[string]map[string]json.RawMessage)
+	for _, r := range records {
+		if r.Direction != capture.Received {
+			continue
+		}
+		if bytes.IndexByte(r.Data, 0) != len(r.Data)-1 {
+			t.Errorf("the call %q is not terminated by a single NUL byte", r.Data)
+			continue
+		}
+		var call map[string]json.RawMessage
+		if err := json.Unmarshal(r.Data[:len(r.Data)-1], &call); err != nil {
+			t.Errorf("the call %q is no JSON object: %v", r.Data, err)
+			continue
+		}
+		var method string
+		json.Unmarshal(call["method"], &method)
+		calls[method] = call
+	}
+	return calls
+}
+
+// TestInteropClient calls the Go service with the varlink command of libvarlink.
+func TestInteropClient(t *testing.T) {
+	cli := libvarlinkCLI(t)
+	address, iface, stop := serveInterop(t)
+
+	run := func(options []string, method string, parameters string) (string, string, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		args := append([]string{"call"}, options...)
+		args = append(args, address+"/org.example.interop."+method)
+		if parameters != "" {
+			args = append(args, parameters)
+		}
+		cmd := exec.CommandContext(ctx, cli, args...)
+		var stdout, stderr bytes.Buffer
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		err := cmd.Run()
+		return stdout.String(), stderr.String(), err
+	}
+	// replies decodes the JSON values printed by the command
+	replies := func(out string) []interface{} {
+		var values []interface{}
+		dec := json.NewDecoder(strings.NewReader(out))
+		for {
+			var v interface{}
+			if err := dec.Decode(&v); err != nil {
+				return values
+			}
+			values = append(values, v)
+		}
+	}
+	same := func(a interface{}, b string) bool {
+		var v interface{}
+		json.Unmarshal([]byte(b), &v)
+		return fmt.Sprint(a) == fmt.Sprint(v)
+	}
+
+	if out, stderr, err := run(nil, "Ping", `{"ping":"hello"}`); err != nil || len(replies(out)) != 1 || !same(replies(out)[0], `{"pong":"hello"}`) {
+		t.Errorf("Ping returned %q %q %v", out, stderr, err)
+	}
+	if out, stderr, err := run(nil, "Echo", echoParameters); err != nil || len(replies(out)) != 1 || !same(replies(out)[0], echoParameters) {
+		t.Errorf("Echo returned %q %q %v", out, stderr, err)
+	}
+	if out, stderr, err := run([]string{"--more"}, "Count", `{"n":3}`); err != nil || fmt.Sprint(replies(out)) != "[map[i:1] map[i:2] map[i:3]]" {
+		t.Errorf("Count returned %q %q %v", out, stderr, err)
+	}
+
+	for _, e := range []struct {
+		method     string
+		parameters string
+		error      string
+	}{
+		{"Ping", `{"ping":1}`, "org.varlink.service.InvalidParameter"},
+		{"Missing", "", "org.varlink.service.MethodNotFound"},
+		{"Unimplemented", "", "org.varlink.service.MethodNotImplemented"},
+		{"Deny", "", "org.varlink.service.PermissionDenied"},
+		{"Busy", "", "org.varlink.service.ServiceNotAvailable"},
+		{"Fail", "", "org.example.interop.Failed"},
+	} {
+		if out, stderr, err := run(nil, e.method, e.parameters); err == nil || !strings.Contains(stderr, e.error) {
+			t.Errorf("%s returned %q %q %v, expected %s", e.method, out, stderr, err, e.error)
+		}
+	}
+
+	if cliSupports(cli, "call", "--oneway") {
+		if out, stderr, err := run([]string{"--oneway"}, "Notify", `{"message":"oneway"}`); err != nil || out != "" {
+			t.Errorf("Notify returned %q %q %v", out, stderr, err)
+		}
+	} else {
+		t.Log("the varlink command does not support --oneway")
+	}
+	if cliSupports(cli, "call", "--upgrade") {
+		if out, stderr, err := run([]string{"--upgrade"}, "Upgrade", ""); !strings.Contains(out, "upgraded") {
+			t.Errorf("Upgrade returned %q %q %v", out, stderr, err)
+		}
+	} else {
+		t.Log("the varlink command does not support --upgrade")
+	}
+
+	calls := checkFraming(t, stop())
+	if call, ok := calls["org.example.interop.Count"]; !ok || string(call["more"]) != "true" {
+		t.Errorf("Count was called with %q", call)
+	}
+	if call, ok := calls["org.example.interop.Notify"]; ok {
+		iface.mutex.Lock()
+		defer iface.mutex.Unlock()
+		if string(call["oneway"]) != "true" || fmt.Sprint(iface.notified) != "[oneway]" {
+			t.Errorf("Notify was called with %q, notified %q", call, iface.notified)
+		}
+	}
+}
+
+// TestInteropBridge calls the Go service through the bridge of libvarlink,
+// which parses the calls of the Go client and the replies of the service.
+func TestInteropBridge(t *testing.T) {
+	cli := libvarlinkCLI(t)
+	if !cliSupports(cli, "bridge", "--connect") {
+		t.Skip("the varlink command cannot bridge to an address")
+	}
+	address, iface, stop := serveInterop(t)
+
+	var stderr bytes.Buffer
+	c, err := varlink.NewBridgeWithStderr(cli+" bridge --connect "+address, &stderr)
+	if err != nil {
+		t.Fatalf("NewBridgeWithStderr(): %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var pong struct {
+		Pong string `json:"pong"`
+	}
+	if err := c.Call(ctx, "org.example.interop.Ping", map[string]string{"ping": "hello"}, &pong); err != nil || pong.Pong != "hello" {
+		t.Errorf("Ping returned %q %v %s", pong.Pong, err, stderr.String())
+	}
+	var echo json.RawMessage
+	if err := c.Call(ctx, "org.example.interop.Echo", json.RawMessage(echoParameters), &echo); err != nil {
+		t.Errorf("Echo returned %v", err)
+	} else {
+		var expected, got interface{}
+		json.Unmarshal([]byte(echoParameters), &expected)
+		json.Unmarshal(echo, &got)
+		if fmt.Sprint(expected) != fmt.Sprint(got) {
+			t.Errorf("Echo returned %s", echo)
+		}
+	}
+
+	receive, err := c.Send(ctx, "org.example.interop.Count", map[string]int{"n": 3}, varlink.More)
+	if err != nil {
+		t.Fatalf("Send(): %v", err)
+	}
+	for i := 1; i <= 3; i++ {
+		var out struct {
+			I int `json:"i"`
+		}
+		flags, err := receive(ctx, &out)
+		if err != nil || out.I != i || (flags&varlink.Continues != 0) != (i < 3) {
+			t.Fatalf("Count returned %d %d %v", out.I, flags, err)
+		}
+	}
+
+	if _, err := c.Send(ctx, "org.example.interop.Notify", map[string]string{"message": "oneway"}, varlink.Oneway); err != nil {
+		t.Errorf("Notify returned %v", err)
+	}
+
+	for _, e := range []struct {
+		method     string
+		parameters interface{}
+		check      func(error) bool
+	}{
+		{"org.example.missing.Call", nil, func(err error) bool { _, ok := err.(*varlink.InterfaceNotFound); return ok }},
+		{"org.example.interop.Ping", map[string]int{"ping": 1}, func(err error) bool { _, ok := err.(*varlink.InvalidParameter); return ok }},
+		{"org.example.interop.Missing", nil, func(err error) bool { _, ok := err.(*varlink.MethodNotFound); return ok }},
+		{"org.example.interop.Unimplemented", nil, func(err error) bool { _, ok := err.(*varlink.MethodNotImplemented); return ok }},
+		{"org.example.interop.Deny", nil, func(err error) bool { _, ok := err.(*varlink.PermissionDenied); return ok }},
+		{"org.example.interop.Busy", nil, func(err error) bool { _, ok := err.(*varlink.ServiceNotAvailable); return ok }},
+		{"org.example.interop.Fail", nil, func(err error) bool {
+			e, ok := err.(*varlink.Error)
+			return ok && e.Name == "org.example.interop.Failed"
+		}},
+	} {
+		if err := c.Call(ctx, e.method, e.parameters, nil); !e.check(err) {
+			t.Errorf("%s returned %v", e.method, err)
+		}
+	}
+	c.Close()
+
+	calls := checkFraming(t, stop())
+	if call := calls["org.example.interop.Notify"]; string(call["oneway"]) != "true" {
+		t.Errorf("Notify was called with %q", call)
+	}
+	iface.mutex.Lock()
+	defer iface.mutex.Unlock()
+	if fmt.Sprint(iface.notified) != "[oneway]" {
+		t.Errorf("Notify notified %q", iface.notified)
+	}
+}
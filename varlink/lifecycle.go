@@ -0,0 +1,159 @@
+package varlink
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+)
+
+// Reloader is implemented by interfaces which can reload their configuration.
+type Reloader interface {
+	VarlinkReload() error
+}
+
+// Reload reloads all registered interfaces implementing Reloader, and refreshes
+// the interface descriptions returned by GetInterfaceDescription. The first
+// error is returned after all interfaces have been reloaded.
+func (s *Service) Reload() error {
+	r := s.snapshot()
+
+	var first error
+	descriptions := make(map[string]*storedDescription)
+	for _, name := range r.names {
+		iface := r.interfaces[name]
+
+		if reloader, ok := implementation(iface).(Reloader); ok {
+			if err := reloader.VarlinkReload(); err != nil && first == nil {
+				first = fmt.Errorf("reloading '%s': %v", name, err)
+			}
+		}
+
+		if description := iface.VarlinkGetDescription(); !r.descriptions[name].is(description) {
+			stored, err := storeDescription(description)
+			if err != nil {
+				if first == nil {
+					first = fmt.Errorf("reloading '%s': %v", name, err)
+				}
+				continue
+			}
+			descriptions[name] = stored
+		}
+	}
+
+	if len(descriptions) > 0 {
+		s.mutex.Lock()
+		r = s.snapshot().copy()
+		for name, stored := range descriptions {
+			// The interface may have been unregistered meanwhile
+			if old, ok := r.descriptions[name]; ok {
+				old.release()
+				r.descriptions[name] = stored
+			} else {
+				stored.release()
+			}
+		}
+		s.publish(r)
+		s.mutex.Unlock()
+	}
+
+	return first
+}
+
+// WriteStats writes the current state of the service in a human readable form.
+func (s *Service) WriteStats(w io.Writer) error {
+	s.mutex.Lock()
+	address := s.protocol + ":" + s.address
+	connections := s.conncounter
+	names := s.snapshot().names
+	s.mutex.Unlock()
+
+	if _, err := fmt.Fprintf(w, "address: %s\nconnections: %d\n", address, connections); err != nil {
+		return err
+	}
+
+	if s.scheduler != nil {
+		s.scheduler.mutex.Lock()
+		active := s.scheduler.active
+		limit := s.scheduler.max
+		waiting := 0
+		for _, t := range s.scheduler.tenants {
+			waiting += len(t.waiting)
+		}
+		adaptive := s.scheduler.limit != nil
+		s.scheduler.mutex.Unlock()
+
+		if _, err := fmt.Fprintf(w, "calls: %d\nwaiting calls: %d\n", active, waiting); err != nil {
+			return err
+		}
+		if adaptive {
+			if _, err := fmt.Fprintf(w, "call limit: %d\n", limit); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, name := range names {
+		if _, err := fmt.Fprintf(w, "interface: %s\n", name); err != nil {
+			return err
+		}
+	}
+
+	if err := s.writePools(w); err != nil {
+		return err
+	}
+
+	if s.accounting != nil {
+		return s.accounting.write(w)
+	}
+	return nil
+}
+
+// RunWithSignals runs the service at the address until it receives SIGTERM or
+// SIGINT, which shut it down after the calls in progress are handled. SIGHUP
+// calls Reload and SIGUSR1 writes the stats to stderr; errors of the reload
+// are reported on stderr as well.
+func RunWithSignals(s *Service, address string) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	shutdown := make(chan os.Signal, 1)
+	signal.Notify(shutdown, shutdownSignals...)
+	defer signal.Stop(shutdown)
+
+	reload := make(chan os.Signal, 1)
+	if len(reloadSignals) > 0 {
+		signal.Notify(reload, reloadSignals...)
+		defer signal.Stop(reload)
+	}
+
+	stats := make(chan os.Signal, 1)
+	if len(statsSignals) > 0 {
+		signal.Notify(stats, statsSignals...)
+		defer signal.Stop(stats)
+	}
+
+	servererror := make(chan error, 1)
+	go func() {
+		servererror <- s.Listen(ctx, address, 0)
+	}()
+
+	for {
+		select {
+		case err := <-servererror:
+			return err
+
+		case <-shutdown:
+			s.Shutdown()
+
+		case <-reload:
+			if err := s.Reload(); err != nil {
+				fmt.Fprintf(os.Stderr, "varlink: reload: %v\n", err)
+			}
+
+		case <-stats:
+			s.WriteStats(os.Stderr)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package varlink
+
+import "time"
+
+// The service is in maintenance; the call can be retried after the given number
+// of seconds, if it is set.
+type MaintenanceUnavailable struct {
+	Message    string `json:"message"`
+	RetryAfter int64  `json:"retry_after,omitempty"`
+}
+
+func (e MaintenanceUnavailable) Error() string {
+	return "org.varlink.maintenance.Unavailable"
+}
+
+// orgvarlinkmaintenanceNew returns the interface declaring
+// MaintenanceUnavailable, which is registered when the service enters
+// maintenance for the first time.
+func orgvarlinkmaintenanceNew() *errorsInterface {
+	return &errorsInterface{
+		name: "org.varlink.maintenance",
+		description: `# Errors of the services in maintenance.
+interface org.varlink.maintenance
+
+# The service is in maintenance, for the reason of the message. The call can
+# be retried after retry_after seconds, if set.
+error Unavailable (message: string, retry_after: ?int)`,
+	}
+}
+
+// SetMaintenance puts the service into maintenance, like during a data
+// migration, or takes it out of it. In maintenance, the methods are answered
+// with an org.varlink.maintenance.Unavailable error reply carrying the message,
+// and the time after which the call can be retried, if it is not zero. The
+// methods and interfaces listed in ServiceConfig.MaintenanceAllowed, and
+// org.varlink.service, stay available.
+func (s *Service) SetMaintenance(enabled bool, message string, retryAfter time.Duration) {
+	if _, ok := s.snapshot().interfaces["org.varlink.maintenance"]; enabled && !ok {
+		s.RegisterInterface(orgvarlinkmaintenanceNew())
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !enabled {
+		s.maintenance = nil
+		return
+	}
+	s.maintenance = &MaintenanceUnavailable{
+		Message:    message,
+		RetryAfter: int64((retryAfter + time.Second - 1) / time.Second),
+	}
+}
+
+// inMaintenance returns the error reply for the method of the interface while
+// the service is in maintenance.
+func (s *Service) inMaintenance(iface string, method string) *MaintenanceUnavailable {
+	s.mutex.Lock()
+	m := s.maintenance
+	s.mutex.Unlock()
+	if m == nil {
+		return nil
+	}
+
+	for _, allowed := range s.config.MaintenanceAllowed {
+		if allowed == method || allowed == iface {
+			return nil
+		}
+	}
+	return m
+}
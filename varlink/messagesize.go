@@ -0,0 +1,28 @@
+package varlink
+
+import (
+	"github.com/varlink/go/varlink/internal/ctxio"
+)
+
+// ErrMessageTooLarge is returned when a reply exceeds the maximum message size
+// of the connection, which is then not usable anymore.
+var ErrMessageTooLarge = ctxio.ErrTooLarge
+
+// SetMaxMessageSize sets the maximum size in bytes of a reply, which is never
+// buffered beyond the limit; zero or less, the default, removes the limit.
+//
+// Replies are read from the socket only when the caller receives them, one at a
+// time, so a slow consumer of a streaming call leaves the unread replies to the
+// socket buffers, and the service is held back by them, or by the TCP window.
+func (c *Connection) SetMaxMessageSize(size int) {
+	c.mutex.Lock()
+	c.maxMessageSize = size
+	c.mutex.Unlock()
+}
+
+// readLimit returns the limit of the next reply.
+func (c *Connection) readLimit() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.maxMessageSize
+}
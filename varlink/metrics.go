@@ -0,0 +1,22 @@
+package varlink
+
+import "time"
+
+// MetricsCollector receives the measurements of a service, set with
+// ServiceConfig.Metrics, to keep counters and latency histograms, like the
+// Collector of the prometheus package. Its methods must be safe for
+// concurrent use and must not block, they are called from the goroutines of
+// the connections.
+type MetricsCollector interface {
+	// ObserveCall is called when a method call completed, with the name of the
+	// error it replied, empty if none, and the time from receiving the call to
+	// its end.
+	ObserveCall(method string, errorName string, duration time.Duration)
+	// ConnectionOpened and ConnectionClosed count the active connections.
+	ConnectionOpened()
+	ConnectionClosed()
+	// BytesReceived and BytesSent count the size of the messages; the data of
+	// upgraded connections is not counted.
+	BytesReceived(n int)
+	BytesSent(n int)
+}
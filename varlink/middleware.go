@@ -0,0 +1,53 @@
+package varlink
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// Handler handles a dispatched call of a method of the interface, like
+// VarlinkDispatch of the generated interfaces, which gets the method name
+// without the interface.
+type Handler func(ctx context.Context, c Call, iface string, method string) error
+
+// Use adds a middleware to the dispatch of the method calls, like authorization,
+// logging or the recovery from panics. The middleware returns the handler of
+// the calls, which calls next to dispatch the call, or replies on its own; the
+// middleware added first is called first. The calls of org.varlink.service are
+// not passed to the middleware. It fails once the service is running.
+func (s *Service) Use(middleware func(next Handler) Handler) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.state != serviceNew {
+		return fmt.Errorf("service is already running")
+	}
+	s.middleware = append(s.middleware, middleware)
+	return nil
+}
+
+// handler returns the handler dispatching the calls to the interface, wrapped
+// by the middleware.
+func (s *Service) handler(iface dispatcher) Handler {
+	var h Handler = func(ctx context.Context, c Call, _ string, method string) error {
+		return iface.VarlinkDispatch(ctx, c, method)
+	}
+	for i := len(s.middleware) - 1; i >= 0; i-- {
+		h = s.middleware[i](h)
+	}
+	return h
+}
+
+// Method returns the fully-qualified name of the called method.
+func (c *Call) Method() string {
+	return c.In.Method
+}
+
+// Parameters returns the parameters of the call as received, or nil if the
+// call has none.
+func (c *Call) Parameters() json.RawMessage {
+	if c.In.Parameters == nil {
+		return nil
+	}
+	return *c.In.Parameters
+}
@@ -0,0 +1,132 @@
+package varlink
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+var errListenerClosed = errors.New("use of closed listener")
+
+type listenerTimeoutError struct{}
+
+func (listenerTimeoutError) Error() string   { return "i/o timeout" }
+func (listenerTimeoutError) Timeout() bool   { return true }
+func (listenerTimeoutError) Temporary() bool { return true }
+
+type acceptResult struct {
+	conn net.Conn
+	err  error
+}
+
+// multiListener accepts the connections of several listeners, like the sockets
+// passed with socket activation. Its address is the one of the first listener.
+type multiListener struct {
+	listeners []net.Listener
+	accepts   chan acceptResult
+	done      chan struct{}
+	once      sync.Once
+	mutex     sync.Mutex
+	deadline  time.Time
+	// origins maps the accepted connections to their listener until origin
+	// is called
+	origins sync.Map
+}
+
+func newMultiListener(listeners []net.Listener) *multiListener {
+	l := &multiListener{
+		listeners: listeners,
+		accepts:   make(chan acceptResult),
+		done:      make(chan struct{}),
+	}
+	for _, listener := range listeners {
+		go l.accept(listener)
+	}
+	return l
+}
+
+func (l *multiListener) accept(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if conn != nil {
+			l.origins.Store(conn, listener)
+		}
+		select {
+		case l.accepts <- acceptResult{conn, err}:
+		case <-l.done:
+			if conn != nil {
+				l.origins.Delete(conn)
+				conn.Close()
+			}
+			return
+		}
+		if ne, ok := err.(net.Error); err != nil && (!ok || !ne.Temporary()) {
+			return
+		}
+	}
+}
+
+// Accept waits for the next connection of any of the listeners, or the error
+// of one of them.
+func (l *multiListener) Accept() (net.Conn, error) {
+	l.mutex.Lock()
+	deadline := l.deadline
+	l.mutex.Unlock()
+
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case r := <-l.accepts:
+		return r.conn, r.err
+	case <-timeout:
+		return nil, l.opError(listenerTimeoutError{})
+	case <-l.done:
+		return nil, l.opError(errListenerClosed)
+	}
+}
+
+// origin returns the listener which accepted the connection, once.
+func (l *multiListener) origin(conn net.Conn) net.Listener {
+	listener, ok := l.origins.Load(conn)
+	if !ok {
+		return nil
+	}
+	l.origins.Delete(conn)
+	return listener.(net.Listener)
+}
+
+// SetDeadline sets the deadline of the following Accept calls.
+func (l *multiListener) SetDeadline(t time.Time) error {
+	l.mutex.Lock()
+	l.deadline = t
+	l.mutex.Unlock()
+	return nil
+}
+
+// Close closes all listeners.
+func (l *multiListener) Close() error {
+	var err error
+	l.once.Do(func() {
+		close(l.done)
+		for _, listener := range l.listeners {
+			if e := listener.Close(); err == nil {
+				err = e
+			}
+		}
+	})
+	return err
+}
+
+func (l *multiListener) Addr() net.Addr {
+	return l.listeners[0].Addr()
+}
+
+func (l *multiListener) opError(err error) error {
+	return &net.OpError{Op: "accept", Net: l.Addr().Network(), Addr: l.Addr(), Err: err}
+}
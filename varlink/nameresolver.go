@@ -0,0 +1,116 @@
+package varlink
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// NameResolver translates a varlink interface name to the address of a service
+// implementing it. An empty address without error means the name is unknown to
+// the resolver, so the next resolver of a chain is consulted.
+type NameResolver interface {
+	ResolveName(ctx context.Context, iface string) (string, error)
+}
+
+// NameResolverFunc is an adapter to allow the use of ordinary functions as NameResolver.
+type NameResolverFunc func(ctx context.Context, iface string) (string, error)
+
+// ResolveName calls f(ctx, iface).
+func (f NameResolverFunc) ResolveName(ctx context.Context, iface string) (string, error) {
+	return f(ctx, iface)
+}
+
+// ResolverChain consults its resolvers in order and returns the first address found.
+type ResolverChain []NameResolver
+
+// ResolveName implements NameResolver.
+func (chain ResolverChain) ResolveName(ctx context.Context, iface string) (string, error) {
+	for _, r := range chain {
+		address, err := r.ResolveName(ctx, iface)
+		if err != nil {
+			return "", err
+		}
+		if address != "" {
+			return address, nil
+		}
+	}
+	return "", nil
+}
+
+// EnvironmentResolver resolves interface names from environment variables; the
+// address of org.example.Interface is read from VARLINK_ADDRESS_ORG_EXAMPLE_INTERFACE.
+func EnvironmentResolver() NameResolver {
+	return NameResolverFunc(func(ctx context.Context, iface string) (string, error) {
+		name := "VARLINK_ADDRESS_" + strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(iface))
+		return os.Getenv(name), nil
+	})
+}
+
+// SocketPathResolver resolves interface names to unix sockets named after the
+// interface, like /run/org.example.Interface, in the given directories.
+func SocketPathResolver(dirs ...string) NameResolver {
+	return NameResolverFunc(func(ctx context.Context, iface string) (string, error) {
+		if strings.ContainsAny(iface, "/\\") {
+			return "", nil
+		}
+		for _, dir := range dirs {
+			path := filepath.Join(dir, iface)
+			if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+				return "unix:" + path, nil
+			}
+		}
+		return "", nil
+	})
+}
+
+// ServiceResolver resolves interface names by asking the org.varlink.resolver
+// service at the address, or ResolverAddress if empty. A missing resolver service
+// or an unknown interface leave the name unresolved.
+func ServiceResolver(address string) NameResolver {
+	return NameResolverFunc(func(ctx context.Context, iface string) (string, error) {
+		r, err := NewResolver(ctx, address)
+		if err != nil {
+			return "", nil
+		}
+		defer r.Close()
+
+		resolved, err := r.Resolve(ctx, iface)
+		if err != nil {
+			if e, ok := err.(*Error); ok && e.Name == "org.varlink.resolver.InterfaceNotFound" {
+				return "", nil
+			}
+			return "", err
+		}
+		return resolved, nil
+	})
+}
+
+// DefaultResolverChain is consulted by NewInterfaceConnection if no resolver is
+// given: environment variables, sockets in /run/varlink and /run, and the
+// org.varlink.resolver service.
+var DefaultResolverChain = ResolverChain{
+	EnvironmentResolver(),
+	SocketPathResolver("/run/varlink", "/run"),
+	ServiceResolver(ResolverAddress),
+}
+
+// NewInterfaceConnection resolves the interface name with the resolver, or with
+// DefaultResolverChain if nil, and returns a new connection to the service.
+func NewInterfaceConnection(ctx context.Context, iface string, resolver NameResolver) (*Connection, error) {
+	if resolver == nil {
+		resolver = DefaultResolverChain
+	}
+
+	address, err := resolver.ResolveName(ctx, iface)
+	if err != nil {
+		return nil, err
+	}
+	if address == "" {
+		return nil, fmt.Errorf("interface '%s' could not be resolved", iface)
+	}
+
+	return NewConnection(ctx, address)
+}
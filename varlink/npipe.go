@@ -0,0 +1,25 @@
+package varlink
+
+import (
+	"strings"
+)
+
+// pipeAddr is the name of a Windows named pipe.
+type pipeAddr string
+
+func (a pipeAddr) Network() string {
+	return "npipe"
+}
+
+func (a pipeAddr) String() string {
+	return string(a)
+}
+
+// pipeName returns the full name of the pipe of an "npipe:" address, which is
+// either the full \\.\pipe\name, or only its name.
+func pipeName(address string) pipeAddr {
+	if strings.HasPrefix(address, `\\`) || strings.HasPrefix(address, "//") {
+		return pipeAddr(strings.Replace(address, "/", `\`, -1))
+	}
+	return pipeAddr(`\\.\pipe\` + address)
+}
@@ -0,0 +1,17 @@
+// +build !windows
+
+package varlink
+
+import (
+	"context"
+	"fmt"
+	"net"
+)
+
+func listenPipe(addr pipeAddr) (net.Listener, error) {
+	return nil, fmt.Errorf("named pipes are only supported on windows")
+}
+
+func dialPipe(ctx context.Context, addr pipeAddr) (net.Conn, error) {
+	return nil, fmt.Errorf("named pipes are only supported on windows")
+}
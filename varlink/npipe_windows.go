@@ -0,0 +1,351 @@
+package varlink
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+var (
+	kernel32             = syscall.NewLazyDLL("kernel32.dll")
+	procCreateNamedPipeW = kernel32.NewProc("CreateNamedPipeW")
+	procConnectNamedPipe = kernel32.NewProc("ConnectNamedPipe")
+	procCreateEventW     = kernel32.NewProc("CreateEventW")
+	procGetOverlapped    = kernel32.NewProc("GetOverlappedResult")
+)
+
+const (
+	pipeAccessDuplex          = 0x3
+	fileFlagFirstPipeInstance = 0x80000
+	fileFlagOverlapped        = 0x40000000
+	pipeRejectRemoteClients   = 0x8
+	pipeUnlimitedInstances    = 255
+	pipeBufferSize            = 64 << 10
+
+	// The client is identified, but cannot be impersonated by the service
+	securitySqosPresent    = 0x100000
+	securityIdentification = 0x10000
+
+	errorPipeBusy         = syscall.Errno(231)
+	errorNoData           = syscall.Errno(232)
+	errorPipeNotConnected = syscall.Errno(233)
+	errorPipeConnected    = syscall.Errno(535)
+)
+
+var errPipeClosed = errors.New("use of closed named pipe")
+
+// pipeTimeoutError is returned by operations exceeding their deadline.
+type pipeTimeoutError struct{}
+
+func (pipeTimeoutError) Error() string   { return "i/o timeout" }
+func (pipeTimeoutError) Timeout() bool   { return true }
+func (pipeTimeoutError) Temporary() bool { return true }
+
+func createPipe(addr pipeAddr, first bool) (syscall.Handle, error) {
+	name, err := syscall.UTF16PtrFromString(string(addr))
+	if err != nil {
+		return syscall.InvalidHandle, err
+	}
+	mode := uint32(pipeAccessDuplex | fileFlagOverlapped)
+	if first {
+		mode |= fileFlagFirstPipeInstance
+	}
+	h, _, e := procCreateNamedPipeW.Call(
+		uintptr(unsafe.Pointer(name)),
+		uintptr(mode),
+		pipeRejectRemoteClients,
+		pipeUnlimitedInstances,
+		pipeBufferSize,
+		pipeBufferSize,
+		0,
+		0,
+	)
+	if syscall.Handle(h) == syscall.InvalidHandle {
+		return syscall.InvalidHandle, os.NewSyscallError("CreateNamedPipe", e)
+	}
+	return syscall.Handle(h), nil
+}
+
+// overlapped runs the overlapped operation on the handle and waits for it.
+// The operation registers with the deadline, which cancels it when it expires.
+func overlapped(h syscall.Handle, d *pipeDeadline, op func(*syscall.Overlapped) error) (uint32, error) {
+	// A manual-reset event, which GetOverlappedResult waits for
+	event, _, e := procCreateEventW.Call(0, 1, 0, 0)
+	if event == 0 {
+		return 0, os.NewSyscallError("CreateEvent", e)
+	}
+	defer syscall.CloseHandle(syscall.Handle(event))
+
+	ov := &syscall.Overlapped{HEvent: syscall.Handle(event)}
+	if !d.start(h, ov) {
+		return 0, pipeTimeoutError{}
+	}
+	err := op(ov)
+	var n uint32
+	if err == nil || err == syscall.ERROR_IO_PENDING {
+		r, _, e := procGetOverlapped.Call(uintptr(h), uintptr(unsafe.Pointer(ov)), uintptr(unsafe.Pointer(&n)), 1)
+		err = nil
+		if r == 0 {
+			err = e
+		}
+	}
+	if !d.done() && err == syscall.ERROR_OPERATION_ABORTED {
+		return n, pipeTimeoutError{}
+	}
+	return n, err
+}
+
+// pipeDeadline cancels the pending operation of one direction when its deadline
+// expires.
+type pipeDeadline struct {
+	mutex   sync.Mutex
+	t       time.Time
+	timer   *time.Timer
+	handle  syscall.Handle
+	pending *syscall.Overlapped
+}
+
+func (d *pipeDeadline) expired() bool {
+	return !d.t.IsZero() && !time.Now().Before(d.t)
+}
+
+// start registers the operation, unless the deadline expired.
+func (d *pipeDeadline) start(h syscall.Handle, ov *syscall.Overlapped) bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.expired() {
+		return false
+	}
+	d.handle = h
+	d.pending = ov
+	return true
+}
+
+// done unregisters the operation and returns whether the deadline is still ahead.
+func (d *pipeDeadline) done() bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.pending = nil
+	return !d.expired()
+}
+
+func (d *pipeDeadline) cancel() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.pending != nil && d.expired() {
+		syscall.CancelIoEx(d.handle, d.pending)
+	}
+}
+
+func (d *pipeDeadline) set(t time.Time) {
+	d.mutex.Lock()
+	d.t = t
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+	}
+	if !t.IsZero() {
+		d.timer = time.AfterFunc(time.Until(t), d.cancel)
+	}
+	d.mutex.Unlock()
+}
+
+// pipeConn is a connected instance of a named pipe, opened for overlapped I/O.
+type pipeConn struct {
+	handle    syscall.Handle
+	addr      pipeAddr
+	read      pipeDeadline
+	write     pipeDeadline
+	closeOnce sync.Once
+}
+
+func (c *pipeConn) Read(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	n, err := overlapped(c.handle, &c.read, func(ov *syscall.Overlapped) error {
+		return syscall.ReadFile(c.handle, b, nil, ov)
+	})
+	switch err {
+	case nil:
+		if n == 0 {
+			return 0, io.EOF
+		}
+	case syscall.ERROR_BROKEN_PIPE, errorPipeNotConnected:
+		return 0, io.EOF
+	}
+	return int(n), err
+}
+
+func (c *pipeConn) Write(b []byte) (int, error) {
+	written := 0
+	for written < len(b) {
+		n, err := overlapped(c.handle, &c.write, func(ov *syscall.Overlapped) error {
+			return syscall.WriteFile(c.handle, b[written:], nil, ov)
+		})
+		written += int(n)
+		if err == syscall.ERROR_BROKEN_PIPE || err == errorNoData {
+			return written, syscall.EPIPE
+		}
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+func (c *pipeConn) Close() error {
+	err := errPipeClosed
+	c.closeOnce.Do(func() {
+		c.read.set(time.Time{})
+		c.write.set(time.Time{})
+		syscall.CancelIoEx(c.handle, nil)
+		err = syscall.CloseHandle(c.handle)
+	})
+	return err
+}
+
+func (c *pipeConn) LocalAddr() net.Addr {
+	return c.addr
+}
+
+func (c *pipeConn) RemoteAddr() net.Addr {
+	return c.addr
+}
+
+func (c *pipeConn) SetDeadline(t time.Time) error {
+	c.read.set(t)
+	c.write.set(t)
+	return nil
+}
+
+func (c *pipeConn) SetReadDeadline(t time.Time) error {
+	c.read.set(t)
+	return nil
+}
+
+func (c *pipeConn) SetWriteDeadline(t time.Time) error {
+	c.write.set(t)
+	return nil
+}
+
+// pipeListener accepts clients on the instances of a named pipe. An instance
+// waits for the next client at all times, so clients do not find the pipe
+// missing between two calls of Accept.
+type pipeListener struct {
+	addr    pipeAddr
+	mutex   sync.Mutex
+	next    syscall.Handle
+	closed  bool
+	connect pipeDeadline
+}
+
+func listenPipe(addr pipeAddr) (net.Listener, error) {
+	h, err := createPipe(addr, true)
+	if err != nil {
+		return nil, &net.OpError{Op: "listen", Net: "npipe", Addr: addr, Err: err}
+	}
+	return &pipeListener{addr: addr, next: h}, nil
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	l.mutex.Lock()
+	if l.closed {
+		l.mutex.Unlock()
+		return nil, &net.OpError{Op: "accept", Net: "npipe", Addr: l.addr, Err: errPipeClosed}
+	}
+	h := l.next
+	l.mutex.Unlock()
+
+	_, err := overlapped(h, &l.connect, func(ov *syscall.Overlapped) error {
+		r, _, e := procConnectNamedPipe.Call(uintptr(h), uintptr(unsafe.Pointer(ov)))
+		if r != 0 {
+			return nil
+		}
+		return e
+	})
+	if err == errorPipeConnected {
+		// The client connected before ConnectNamedPipe
+		err = nil
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.closed {
+		return nil, &net.OpError{Op: "accept", Net: "npipe", Addr: l.addr, Err: errPipeClosed}
+	}
+	if err != nil {
+		return nil, &net.OpError{Op: "accept", Net: "npipe", Addr: l.addr, Err: os.NewSyscallError("ConnectNamedPipe", err)}
+	}
+
+	l.next, err = createPipe(l.addr, false)
+	if err != nil {
+		l.next = syscall.InvalidHandle
+		syscall.CloseHandle(h)
+		l.closed = true
+		return nil, &net.OpError{Op: "accept", Net: "npipe", Addr: l.addr, Err: err}
+	}
+	return &pipeConn{handle: h, addr: l.addr}, nil
+}
+
+func (l *pipeListener) Close() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.closed {
+		return errPipeClosed
+	}
+	l.closed = true
+	l.connect.set(time.Time{})
+	// Closing the waiting instance ends a pending Accept
+	syscall.CancelIoEx(l.next, nil)
+	err := syscall.CloseHandle(l.next)
+	l.next = syscall.InvalidHandle
+	return err
+}
+
+func (l *pipeListener) Addr() net.Addr {
+	return l.addr
+}
+
+func (l *pipeListener) SetDeadline(t time.Time) error {
+	l.connect.set(t)
+	return nil
+}
+
+func dialPipe(ctx context.Context, addr pipeAddr) (net.Conn, error) {
+	name, err := syscall.UTF16PtrFromString(string(addr))
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		h, err := syscall.CreateFile(
+			name,
+			syscall.GENERIC_READ|syscall.GENERIC_WRITE,
+			0,
+			nil,
+			syscall.OPEN_EXISTING,
+			fileFlagOverlapped|securitySqosPresent|securityIdentification,
+			0,
+		)
+		if err == nil {
+			return &pipeConn{handle: h, addr: addr}, nil
+		}
+		if err != errorPipeBusy {
+			return nil, &net.OpError{Op: "dial", Net: "npipe", Addr: addr, Err: os.NewSyscallError("CreateFile", err)}
+		}
+
+		// All instances are connected, the service creates the next one
+		select {
+		case <-time.After(time.Second / 100):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,429 @@
+package varlink_test
+
+// The order of the replies of pipelined calls
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/varlink/go/varlink"
+)
+
+type OrderInterface struct {
+	late    chan error
+	twice   chan error
+	closed  chan error
+	blocked chan error
+	resume  chan struct{}
+}
+
+func (s *OrderInterface) VarlinkDispatch(ctx context.Context, call varlink.Call, methodname string) error {
+	var in struct {
+		Tag   string `json:"tag"`
+		Delay int    `json:"delay"`
+		Count int    `json:"count"`
+	}
+	if call.GetParameters(&in) != nil {
+		return call.ReplyInvalidParameter(ctx, "parameters")
+	}
+	out := map[string]string{"tag": in.Tag}
+
+	switch methodname {
+	case "Sleep":
+		time.Sleep(time.Duration(in.Delay) * time.Millisecond)
+		return call.Reply(ctx, out)
+
+	case "Stream":
+		for i := 0; i < in.Count; i++ {
+			call.Continues = i < in.Count-1
+			if err := call.Reply(ctx, out); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case "Send":
+		for i := 0; i < in.Count-1; i++ {
+			if err := call.SendContinues(ctx, out); err != nil {
+				return err
+			}
+		}
+		if err := call.SendFinal(ctx, out); err != nil {
+			return err
+		}
+		s.closed <- call.SendContinues(ctx, out)
+		return nil
+
+	case "Flood":
+		// The client does not read until the replies block
+		data := strings.Repeat("x", 64<<10)
+		for i := 0; i < 1000; i++ {
+			err := call.SendContinues(ctx, map[string]interface{}{"i": i, "data": data})
+			if _, ok := err.(*varlink.StreamBlocked); ok {
+				s.blocked <- err
+				s.blocked <- call.SendContinues(ctx, map[string]interface{}{"i": i + 1})
+				<-s.resume
+				return call.SendFinal(ctx, map[string]interface{}{"i": i + 1})
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return call.SendFinal(ctx, nil)
+
+	case "Fail":
+		return call.ReplyError(ctx, "org.example.order.Failed", out)
+
+	case "Late":
+		go func() {
+			time.Sleep(time.Second / 10)
+			s.late <- call.Reply(ctx, out)
+		}()
+		return nil
+
+	case "Twice":
+		if err := call.Reply(ctx, out); err != nil {
+			return err
+		}
+		s.twice <- call.Reply(ctx, out)
+		return nil
+
+	default:
+		return call.ReplyMethodNotFound(ctx, methodname)
+	}
+}
+
+func (s *OrderInterface) VarlinkGetName() string {
+	return `org.example.order`
+}
+
+func (s *OrderInterface) VarlinkGetDescription() string {
+	return `interface org.example.order
+method Sleep(tag: string, delay: int) -> (tag: string)
+method Stream(tag: string, count: int) -> (tag: string)
+method Send(tag: string, count: int) -> (tag: string)
+method Flood() -> (i: ?int, data: ?string)
+method Fail(tag: string) -> ()
+method Late(tag: string) -> (tag: string)
+method Twice(tag: string) -> (tag: string)
+error Failed (tag: string)`
+}
+
+func listenOrder(t *testing.T, address string) (*OrderInterface, func()) {
+	return listenOrderWithConfig(t, address, varlink.ServiceConfig{})
+}
+
+func listenOrderWithConfig(t *testing.T, address string, config varlink.ServiceConfig) (*OrderInterface, func()) {
+	if runtime.GOOS == "windows" {
+		t.Skip("skipping test on windows")
+	}
+
+	service, _ := varlink.NewServiceWithConfig(
+		"Varlink",
+		"Varlink Test",
+		"1",
+		"https://github.com/varlink/go/varlink",
+		config,
+	)
+	order := &OrderInterface{late: make(chan error, 1), twice: make(chan error, 1), closed: make(chan error, 1),
+		blocked: make(chan error, 2), resume: make(chan struct{})}
+	if err := service.RegisterInterface(order); err != nil {
+		t.Fatalf("RegisterInterface(): %v", err)
+	}
+
+	servererror := make(chan error)
+	go func() {
+		servererror <- service.Listen(context.Background(), address, 0)
+	}()
+	time.Sleep(time.Second / 5)
+
+	return order, func() {
+		service.Shutdown()
+		if err := <-servererror; err != nil {
+			t.Fatalf("service.Listen(): %v", err)
+		}
+	}
+}
+
+func TestPipelinedOrder(t *testing.T) {
+	testPipelinedOrder(t, "varlinkexternal_TestPipelinedOrder", varlink.ServiceConfig{})
+}
+
+func TestConcurrentCallsOrder(t *testing.T) {
+	testPipelinedOrder(t, "varlinkexternal_TestConcurrentCallsOrder", varlink.ServiceConfig{ConcurrentCalls: 4})
+}
+
+func testPipelinedOrder(t *testing.T, path string, config varlink.ServiceConfig) {
+	_, shutdown := listenOrderWithConfig(t, "unix:"+path, config)
+	defer shutdown()
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("Dial(): %v", err)
+	}
+	defer conn.Close()
+
+	// All calls are sent before the first reply is read, the slow first call
+	// must not be overtaken
+	requests := []string{
+		`{"method":"org.example.order.Sleep","parameters":{"tag":"a","delay":100}}`,
+		`{"method":"org.example.order.Sleep","parameters":{"tag":"b"},"oneway":true}`,
+		`{"method":"org.example.order.Stream","parameters":{"tag":"c","count":3},"more":true}`,
+		`{"method":"org.example.order.Fail","parameters":{"tag":"d"}}`,
+		`{"method":"org.example.missing.Call"}`,
+		`{"method":"org.example.order.Sleep","parameters":{"tag":"e"}}`,
+		`{"method":"org.varlink.service.GetInfo"}`,
+	}
+	if _, err := conn.Write([]byte(strings.Join(requests, "\x00") + "\x00")); err != nil {
+		t.Fatalf("Write(): %v", err)
+	}
+
+	expected := []string{
+		"a",
+		"c continues",
+		"c continues",
+		"c",
+		"org.example.order.Failed d",
+		"org.varlink.service.InterfaceNotFound",
+		"e",
+		"info",
+	}
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(conn)
+	for i, e := range expected {
+		b, err := reader.ReadBytes('\x00')
+		if err != nil {
+			t.Fatalf("ReadBytes(): %v", err)
+		}
+
+		var reply struct {
+			Parameters map[string]interface{} `json:"parameters"`
+			Continues  bool                   `json:"continues"`
+			Error      string                 `json:"error"`
+		}
+		if err := json.Unmarshal(b[:len(b)-1], &reply); err != nil {
+			t.Fatalf("Unmarshal(): %v", err)
+		}
+
+		var words []string
+		if reply.Error != "" {
+			words = append(words, reply.Error)
+		}
+		if tag, ok := reply.Parameters["tag"].(string); ok {
+			words = append(words, tag)
+		}
+		if _, ok := reply.Parameters["product"]; ok {
+			words = append(words, "info")
+		}
+		if reply.Continues {
+			words = append(words, "continues")
+		}
+		if got := strings.Join(words, " "); got != e {
+			t.Fatalf("Reply %d is '%s', expected '%s'", i, got, e)
+		}
+	}
+}
+
+func TestConcurrentDispatch(t *testing.T) {
+	_, shutdown := listenOrderWithConfig(t, "unix:varlinkexternal_TestConcurrentDispatch", varlink.ServiceConfig{ConcurrentCalls: 4})
+	defer shutdown()
+
+	conn, err := net.Dial("unix", "varlinkexternal_TestConcurrentDispatch")
+	if err != nil {
+		t.Fatalf("Dial(): %v", err)
+	}
+	defer conn.Close()
+
+	// The slow calls of one connection are handled at the same time
+	start := time.Now()
+	var requests []string
+	for _, tag := range []string{"a", "b", "c", "d"} {
+		requests = append(requests, `{"method":"org.example.order.Sleep","parameters":{"tag":"`+tag+`","delay":300}}`)
+	}
+	if _, err := conn.Write([]byte(strings.Join(requests, "\x00") + "\x00")); err != nil {
+		t.Fatalf("Write(): %v", err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(conn)
+	for _, tag := range []string{"a", "b", "c", "d"} {
+		b, err := reader.ReadBytes('\x00')
+		if err != nil {
+			t.Fatalf("ReadBytes(): %v", err)
+		}
+		if expected := `{"parameters":{"tag":"` + tag + `"}}` + "\x00"; string(b) != expected {
+			t.Fatalf("Reply is %q, expected %q", b, expected)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 900*time.Millisecond {
+		t.Fatalf("the calls took %v", elapsed)
+	}
+}
+
+func TestReplyAfterReturn(t *testing.T) {
+	warnings := make(chan varlink.Warning, 10)
+	order, shutdown := listenOrderWithConfig(t, "unix:varlinkexternal_TestReplyAfterReturn", varlink.ServiceConfig{
+		Warnings: func(w varlink.Warning) { warnings <- w },
+	})
+	defer shutdown()
+
+	c, err := varlink.NewConnection(context.Background(), "unix:varlinkexternal_TestReplyAfterReturn")
+	if err != nil {
+		t.Fatalf("NewConnection(): %v", err)
+	}
+	defer c.Close()
+
+	// The connection is closed, instead of waiting for a reply which might be
+	// taken by a later call
+	err = c.Call(context.Background(), "org.example.order.Late", map[string]string{"tag": "a"}, nil)
+	if err == nil {
+		t.Fatal("Call() succeeded without a reply")
+	}
+
+	if err := <-order.late; err == nil || !strings.Contains(err.Error(), "after the method returned") {
+		t.Fatalf("Late reply returned %v", err)
+	}
+	if w := <-warnings; w.Kind != varlink.ConnectionClosed || !strings.Contains(w.Err.Error(), "without a final reply") {
+		t.Fatalf("Unexpected warning %v", w)
+	}
+}
+
+func TestReplyAfterFinal(t *testing.T) {
+	order, shutdown := listenOrder(t, "unix:varlinkexternal_TestReplyAfterFinal")
+	defer shutdown()
+
+	c, err := varlink.NewConnection(context.Background(), "unix:varlinkexternal_TestReplyAfterFinal")
+	if err != nil {
+		t.Fatalf("NewConnection(): %v", err)
+	}
+	defer c.Close()
+
+	for _, tag := range []string{"a", "b"} {
+		var out struct {
+			Tag string `json:"tag"`
+		}
+		if err := c.Call(context.Background(), "org.example.order.Twice", map[string]string{"tag": tag}, &out); err != nil {
+			t.Fatalf("Call(): %v", err)
+		}
+		if out.Tag != tag {
+			t.Fatalf("Reply of '%s' is '%s'", tag, out.Tag)
+		}
+		if err := <-order.twice; err == nil || !strings.Contains(err.Error(), "after its final reply") {
+			t.Fatalf("Second reply returned %v", err)
+		}
+	}
+}
+
+func TestSendStream(t *testing.T) {
+	order, shutdown := listenOrder(t, "unix:varlinkexternal_TestSendStream")
+	defer shutdown()
+
+	c, err := varlink.NewConnection(context.Background(), "unix:varlinkexternal_TestSendStream")
+	if err != nil {
+		t.Fatalf("NewConnection(): %v", err)
+	}
+	defer c.Close()
+
+	receive, err := c.Send(context.Background(), "org.example.order.Send", map[string]interface{}{"tag": "a", "count": 3}, varlink.More)
+	if err != nil {
+		t.Fatalf("Send(): %v", err)
+	}
+	for i, continues := range []bool{true, true, false} {
+		var out struct {
+			Tag string `json:"tag"`
+		}
+		flags, err := receive(context.Background(), &out)
+		if err != nil {
+			t.Fatalf("receive(): %v", err)
+		}
+		if out.Tag != "a" || (flags&varlink.Continues != 0) != continues {
+			t.Fatalf("Reply %d is '%s' with flags %d", i, out.Tag, flags)
+		}
+	}
+	if err := <-order.closed; !errors.Is(err, varlink.ErrStreamClosed) {
+		t.Fatalf("Reply after the final reply returned %v", err)
+	}
+
+	// A call without more cannot continue, the connection is closed
+	err = c.Call(context.Background(), "org.example.order.Send", map[string]interface{}{"tag": "b", "count": 2}, nil)
+	if err == nil {
+		t.Fatal("Call() succeeded without more")
+	}
+}
+
+func TestStreamTimeout(t *testing.T) {
+	for _, config := range []varlink.ServiceConfig{
+		{StreamTimeout: time.Second / 10},
+		{StreamTimeout: time.Second / 10, ConcurrentCalls: 2},
+	} {
+		testStreamTimeout(t, "varlinkexternal_TestStreamTimeout", config)
+	}
+}
+
+func testStreamTimeout(t *testing.T, path string, config varlink.ServiceConfig) {
+	order, shutdown := listenOrderWithConfig(t, "unix:"+path, config)
+	defer shutdown()
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("Dial(): %v", err)
+	}
+	defer conn.Close()
+
+	requests := `{"method":"org.example.order.Flood","parameters":{},"more":true}` + "\x00" +
+		`{"method":"org.example.order.Sleep","parameters":{"tag":"a"}}` + "\x00"
+	if _, err := conn.Write([]byte(requests)); err != nil {
+		t.Fatalf("Write(): %v", err)
+	}
+
+	// The reply which blocked is kept, the next one is dropped
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-order.blocked:
+			if _, ok := err.(*varlink.StreamBlocked); !ok {
+				t.Fatalf("Reply %d to a client not reading returned %v", i, err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("The replies did not block")
+		}
+	}
+	close(order.resume)
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(conn)
+	for i := 0; ; i++ {
+		b, err := reader.ReadBytes('\x00')
+		if err != nil {
+			t.Fatalf("ReadBytes(): %v", err)
+		}
+		var reply struct {
+			Parameters struct {
+				I int `json:"i"`
+			} `json:"parameters"`
+			Continues bool `json:"continues"`
+		}
+		if err := json.Unmarshal(b[:len(b)-1], &reply); err != nil {
+			t.Fatalf("Unmarshal(): %v", err)
+		}
+		if reply.Parameters.I != i {
+			t.Fatalf("Reply %d is %d", i, reply.Parameters.I)
+		}
+		if !reply.Continues {
+			break
+		}
+	}
+
+	// The connection is still usable
+	b, err := reader.ReadBytes('\x00')
+	if expected := `{"parameters":{"tag":"a"}}` + "\x00"; err != nil || string(b) != expected {
+		t.Fatalf("Reply is %q, %v", b, err)
+	}
+}
@@ -0,0 +1,222 @@
+package varlink
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// The requested setting was not found.
+type ConfigSettingNotFound struct {
+	Name string `json:"name"`
+}
+
+func (e ConfigSettingNotFound) Error() string {
+	return "org.varlink.config.SettingNotFound"
+}
+
+// The value is not valid for the setting.
+type ConfigInvalidValue struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+func (e ConfigInvalidValue) Error() string {
+	return "org.varlink.config.InvalidValue"
+}
+
+// Setting is a runtime setting of a service, like the log level, a limit or a
+// feature flag. Get returns the current value, which must be marshallable to JSON.
+// Set validates and stores a new value; a setting without Set is read-only.
+type Setting struct {
+	Description string
+	Get         func() interface{}
+	Set         func(value json.RawMessage) error
+}
+
+// ConfigInterface implements the optional org.varlink.config interface, which
+// allows to read and update the registered runtime settings of a service.
+// Its calls are authorized like the calls of every other interface, by
+// ServiceConfig.Authorizer and the authorizers set with Service.Authorize for
+// org.varlink.config or its methods. Unless one of them allowed it, a call of
+// Set is rejected, so settings can be read but not updated.
+type ConfigInterface struct {
+	mutex    sync.Mutex
+	settings map[string]Setting
+	names    []string
+}
+
+// NewConfigInterface returns a new org.varlink.config interface. It needs to
+// be registered with Service.RegisterInterface.
+func NewConfigInterface() *ConfigInterface {
+	return &ConfigInterface{
+		settings: make(map[string]Setting),
+	}
+}
+
+// Register adds a setting with the given name.
+func (c *ConfigInterface) Register(name string, setting Setting) error {
+	if setting.Get == nil {
+		return fmt.Errorf("setting '%s' has no Get function", name)
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if _, ok := c.settings[name]; ok {
+		return fmt.Errorf("setting '%s' already registered", name)
+	}
+	c.settings[name] = setting
+	c.names = append(c.names, name)
+
+	return nil
+}
+
+func (c *ConfigInterface) lookup(name string) (Setting, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	s, ok := c.settings[name]
+	return s, ok
+}
+
+func (c *ConfigInterface) list(ctx context.Context, call Call) error {
+	type setting struct {
+		Name        string      `json:"name"`
+		Description string      `json:"description"`
+		Value       interface{} `json:"value"`
+	}
+	var out struct {
+		Settings []setting `json:"settings"`
+	}
+
+	c.mutex.Lock()
+	names := make([]string, len(c.names))
+	copy(names, c.names)
+	settings := make([]Setting, 0, len(c.names))
+	for _, name := range names {
+		settings = append(settings, c.settings[name])
+	}
+	c.mutex.Unlock()
+
+	out.Settings = make([]setting, 0, len(names))
+	for i, name := range names {
+		out.Settings = append(out.Settings, setting{
+			Name:        name,
+			Description: settings[i].Description,
+			Value:       settings[i].Get(),
+		})
+	}
+
+	return call.Reply(ctx, &out)
+}
+
+func (c *ConfigInterface) get(ctx context.Context, call Call, name string) error {
+	s, ok := c.lookup(name)
+	if !ok {
+		return call.ReplyError(ctx, "org.varlink.config.SettingNotFound", &ConfigSettingNotFound{Name: name})
+	}
+
+	var out struct {
+		Value interface{} `json:"value"`
+	}
+	out.Value = s.Get()
+	return call.Reply(ctx, &out)
+}
+
+func (c *ConfigInterface) set(ctx context.Context, call Call, name string, value json.RawMessage) error {
+	s, ok := c.lookup(name)
+	if !ok {
+		return call.ReplyError(ctx, "org.varlink.config.SettingNotFound", &ConfigSettingNotFound{Name: name})
+	}
+
+	if s.Set == nil {
+		return call.ReplyError(ctx, "org.varlink.config.InvalidValue", &ConfigInvalidValue{Name: name, Reason: "read-only"})
+	}
+
+	if err := s.Set(value); err != nil {
+		return call.ReplyError(ctx, "org.varlink.config.InvalidValue", &ConfigInvalidValue{Name: name, Reason: err.Error()})
+	}
+
+	return call.Reply(ctx, nil)
+}
+
+// VarlinkDispatch dispatches the org.varlink.config method calls.
+func (c *ConfigInterface) VarlinkDispatch(ctx context.Context, call Call, methodname string) error {
+	switch methodname {
+	case "List", "Get", "Set":
+	default:
+		return call.ReplyMethodNotFound(ctx, methodname)
+	}
+
+	if methodname == "Set" && !call.authorized {
+		return call.ReplyPermissionDenied(ctx)
+	}
+
+	switch methodname {
+	case "List":
+		return c.list(ctx, call)
+
+	case "Get":
+		var in struct {
+			Name string `json:"name"`
+		}
+		err := call.GetParameters(&in)
+		if err != nil {
+			return call.ReplyInvalidParameter(ctx, "parameters")
+		}
+		return c.get(ctx, call, in.Name)
+
+	default:
+		var in struct {
+			Name  string          `json:"name"`
+			Value json.RawMessage `json:"value"`
+		}
+		err := call.GetParameters(&in)
+		if err != nil {
+			return call.ReplyInvalidParameter(ctx, "parameters")
+		}
+		if in.Value == nil {
+			return call.ReplyInvalidParameter(ctx, "value")
+		}
+		return c.set(ctx, call, in.Name, in.Value)
+	}
+}
+
+// VarlinkGetName returns the interface name.
+func (c *ConfigInterface) VarlinkGetName() string {
+	return `org.varlink.config`
+}
+
+// VarlinkGetMethods returns the names of all dispatched methods.
+func (c *ConfigInterface) VarlinkGetMethods() []string {
+	return []string{"List", "Get", "Set"}
+}
+
+// VarlinkGetDescription returns the interface description.
+func (c *ConfigInterface) VarlinkGetDescription() string {
+	return `# The Varlink Config Interface allows to read and update the runtime
+# settings of a service, like the log level, limits or feature flags.
+interface org.varlink.config
+
+type Setting (
+  name: string,
+  description: string,
+  value: object
+)
+
+# Get all settings with their current values.
+method List() -> (settings: []Setting)
+
+# Get the current value of a setting.
+method Get(name: string) -> (value: object)
+
+# Update the value of a setting.
+method Set(name: string, value: object) -> ()
+
+# The requested setting was not found.
+error SettingNotFound (name: string)
+
+# The value is not valid for the setting.
+error InvalidValue (name: string, reason: string)`
+}
@@ -39,6 +39,20 @@ func (e InvalidParameter) Error() string {
 	return "org.varlink.service.InvalidParameter"
 }
 
+// The caller is not permitted to call the method.
+type PermissionDenied struct{}
+
+func (e PermissionDenied) Error() string {
+	return "org.varlink.service.PermissionDenied"
+}
+
+// The service is not ready to handle calls yet.
+type ServiceNotAvailable struct{}
+
+func (e ServiceNotAvailable) Error() string {
+	return "org.varlink.service.ServiceNotAvailable"
+}
+
 func doReplyError(ctx context.Context, c *Call, name string, parameters interface{}) error {
 	return c.sendMessage(ctx, &serviceReply{
 		Error:      name,
@@ -74,6 +88,18 @@ func (c *Call) ReplyInvalidParameter(ctx context.Context, parameter string) erro
 	return doReplyError(ctx, c, "org.varlink.service.InvalidParameter", &out)
 }
 
+// ReplyPermissionDenied sends a org.varlink.service error reply to this method call
+func (c *Call) ReplyPermissionDenied(ctx context.Context) error {
+	var out PermissionDenied
+	return doReplyError(ctx, c, "org.varlink.service.PermissionDenied", &out)
+}
+
+// ReplyServiceNotAvailable sends a org.varlink.service error reply to this method call
+func (c *Call) ReplyServiceNotAvailable(ctx context.Context) error {
+	var out ServiceNotAvailable
+	return doReplyError(ctx, c, "org.varlink.service.ServiceNotAvailable", &out)
+}
+
 func (c *Call) replyGetInfo(ctx context.Context, vendor string, product string, version string, url string, interfaces []string) error {
 	var out struct {
 		Vendor     string   `json:"vendor,omitempty"`
@@ -98,6 +124,14 @@ func (c *Call) replyGetInterfaceDescription(ctx context.Context, description str
 	return c.Reply(ctx, &out)
 }
 
+func (c *Call) replyGetCompressedInterfaceDescription(ctx context.Context, compressed []byte) error {
+	var out struct {
+		DescriptionGzip []byte `json:"description_gzip"`
+	}
+	out.DescriptionGzip = compressed
+	return c.Reply(ctx, &out)
+}
+
 func (s *Service) orgvarlinkserviceDispatch(ctx context.Context, c Call, methodname string) error {
 	switch methodname {
 	case "GetInfo":
@@ -108,7 +142,7 @@ func (s *Service) orgvarlinkserviceDispatch(ctx context.Context, c Call, methodn
 		}
 		err := c.GetParameters(&in)
 		if err != nil {
-			return c.ReplyInvalidParameter(ctx, "parameters")
+			return c.ReplyParameterError(ctx, err)
 		}
 		return s.getInterfaceDescription(ctx, c, in.Interface)
 
@@ -154,7 +188,13 @@ error MethodNotFound (method: string)
 error MethodNotImplemented (method: string)
 
 # One of the passed parameters is invalid.
-error InvalidParameter (parameter: string)`
+error InvalidParameter (parameter: string)
+
+# The caller is not permitted to call the method.
+error PermissionDenied ()
+
+# The service is not ready to handle calls yet.
+error ServiceNotAvailable ()`
 }
 
 type orgvarlinkserviceInterface struct{}
@@ -0,0 +1,83 @@
+package varlink
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"runtime/debug"
+)
+
+// The service failed to handle the call, because of a bug. The id identifies
+// the failure in the logs of the service.
+type InternalError struct {
+	ID string `json:"id"`
+}
+
+func (e InternalError) Error() string {
+	return "org.varlink.internal.Error"
+}
+
+// orgvarlinkinternalNew returns the interface declaring InternalError, which
+// every service registers.
+func orgvarlinkinternalNew() *errorsInterface {
+	return &errorsInterface{
+		name: "org.varlink.internal",
+		description: `# Errors of the services implemented with github.com/varlink/go.
+interface org.varlink.internal
+
+# The service failed to handle the call because of a bug. The id identifies
+# the failure in the logs of the service.
+error Error (id: string)`,
+	}
+}
+
+// PanicError is the error of a HandlerPanicked warning.
+type PanicError struct {
+	// Method is the fully-qualified name of the called method.
+	Method string
+	// ID is the id of the InternalError replied to the call.
+	ID string
+	// Value is the value passed to panic, Stack the stack of the goroutine
+	// which panicked.
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic in %s (%s): %v", e.Method, e.ID, e.Value)
+}
+
+func correlationID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// recoverPanic recovers from a panic of the handler of the call, which is
+// deferred. It reports the panic as a warning, or logs it with its stack if
+// the service has no Warnings hook, and ends the call with an InternalError, so
+// the connection and the service are kept.
+func (s *Service) recoverPanic(ctx context.Context, c *Call, err *error) {
+	v := recover()
+	if v == nil {
+		return
+	}
+
+	id := correlationID()
+	perr := &PanicError{Method: c.In.Method, ID: id, Value: v, Stack: debug.Stack()}
+	if s.config.Warnings == nil {
+		log.Printf("varlink: %v\n%s", perr, perr.Stack)
+	}
+	s.warn(HandlerPanicked, perr)
+
+	// A call which sent its final reply is over already
+	c.state.mutex.Lock()
+	final := c.state.final
+	c.state.mutex.Unlock()
+	*err = nil
+	if !final {
+		*err = c.ReplyError(ctx, "org.varlink.internal.Error", &InternalError{ID: id})
+	}
+}
@@ -0,0 +1,127 @@
+package varlink
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+// maxEchoSize caps the echoed value of an invalid parameter.
+const maxEchoSize = 128
+
+// One of the passed parameters has the wrong type. It is replied instead of
+// InvalidParameter by services configured with ServiceConfig.ParameterDetails.
+type InvalidParameterDetails struct {
+	Parameter string `json:"parameter"`
+	Value     string `json:"value,omitempty"`
+	Expected  string `json:"expected,omitempty"`
+}
+
+func (e InvalidParameterDetails) Error() string {
+	return "org.varlink.parameters.Invalid"
+}
+
+// orgvarlinkparametersNew returns the interface declaring
+// InvalidParameterDetails, which is registered with
+// ServiceConfig.ParameterDetails.
+func orgvarlinkparametersNew() *errorsInterface {
+	return &errorsInterface{
+		name: "org.varlink.parameters",
+		description: `# Errors of the services describing their invalid parameters.
+interface org.varlink.parameters
+
+# The parameter has the wrong type. The value is the received one, cut to 128
+# bytes, unless it is redacted; expected is the varlink type of the parameter.
+error Invalid (parameter: string, value: ?string, expected: ?string)`,
+	}
+}
+
+// parameterDetails configures the details of InvalidParameterDetails replies.
+type parameterDetails struct {
+	redact map[string]bool
+}
+
+func newParameterDetails(config ServiceConfig) *parameterDetails {
+	if !config.ParameterDetails {
+		return nil
+	}
+	d := parameterDetails{redact: make(map[string]bool)}
+	for _, name := range config.RedactParameters {
+		d.redact[name] = true
+	}
+	return &d
+}
+
+// ReplyParameterError sends an org.varlink.service.InvalidParameter error reply
+// for the error returned by GetParameters, naming the parameter of the wrong
+// type, or "parameters". With ServiceConfig.ParameterDetails, an
+// org.varlink.parameters.Invalid error reply carrying the received value, cut
+// to 128 bytes, and the expected type is sent instead.
+func (c *Call) ReplyParameterError(ctx context.Context, err error) error {
+	e, ok := err.(*json.UnmarshalTypeError)
+	if !ok || e.Field == "" {
+		return c.ReplyInvalidParameter(ctx, "parameters")
+	}
+	if c.details == nil {
+		return c.ReplyInvalidParameter(ctx, e.Field)
+	}
+	return c.ReplyError(ctx, "org.varlink.parameters.Invalid", &InvalidParameterDetails{
+		Parameter: e.Field,
+		Value:     c.details.value(c.In.Parameters, strings.Split(e.Field, ".")),
+		Expected:  varlinkKind(e.Type),
+	})
+}
+
+// value returns the value at the path of field names in the parameters, unless
+// one of them is redacted.
+func (d *parameterDetails) value(parameters *json.RawMessage, path []string) string {
+	if parameters == nil {
+		return ""
+	}
+	value := *parameters
+	for _, name := range path {
+		if d.redact[name] {
+			return ""
+		}
+		var fields map[string]json.RawMessage
+		if json.Unmarshal(value, &fields) != nil {
+			return ""
+		}
+		var ok bool
+		if value, ok = fields[name]; !ok {
+			return ""
+		}
+	}
+
+	if len(value) > maxEchoSize {
+		return string(value[:maxEchoSize]) + "..."
+	}
+	return string(value)
+}
+
+// varlinkKind returns the varlink type of a Go type.
+func varlinkKind(t reflect.Type) string {
+	if t == nil {
+		return ""
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return varlinkKind(t.Elem())
+	case reflect.Bool:
+		return "bool"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "int"
+	case reflect.Float32, reflect.Float64:
+		return "float"
+	case reflect.String:
+		return "string"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	case reflect.Map:
+		return "map"
+	default:
+		return "object"
+	}
+}
@@ -0,0 +1,135 @@
+package varlink
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+)
+
+// ErrNoPeerProcess is returned by Call.PeerProcess if the process of the peer
+// is not known, because the connection is not a local unix socket or the
+// platform does not support it.
+var ErrNoPeerProcess = errors.New("varlink: process of the peer is not known")
+
+// ErrNoCredentials is returned by Call.Credentials if the credentials of the
+// peer are not known, because the connection is not a local unix socket or the
+// platform does not support it.
+var ErrNoCredentials = errors.New("varlink: credentials of the peer are not known")
+
+// Credentials are the ids of the peer of a unix socket connection, recorded by
+// the kernel when the peer connected, as SO_PEERCRED returns them. PID is zero
+// if the peer runs in another process id namespace.
+type Credentials struct {
+	UID int
+	GID int
+	PID int
+}
+
+// Process describes the process at the other end of a unix socket connection.
+type Process struct {
+	Pid int
+	// Executable is the path of the program the process runs. If the
+	// program file was replaced or removed, the path ends with " (deleted)".
+	Executable string
+	// Cgroup is the path of the process in the unified cgroup hierarchy, like
+	// /system.slice/example.service.
+	Cgroup string
+}
+
+// Unit returns the systemd service or scope unit of the process, derived from
+// its cgroup, or an empty string.
+func (p *Process) Unit() string {
+	components := strings.Split(p.Cgroup, "/")
+	for i := len(components) - 1; i >= 0; i-- {
+		if strings.HasSuffix(components[i], ".service") || strings.HasSuffix(components[i], ".scope") {
+			return components[i]
+		}
+	}
+	return ""
+}
+
+// parseCgroup returns the cgroup path of a /proc/PID/cgroup file, the path in
+// the unified hierarchy or in the systemd named hierarchy.
+func parseCgroup(content string) string {
+	var named string
+	for _, line := range strings.Split(content, "\n") {
+		fields := strings.SplitN(line, ":", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		if fields[0] == "0" && fields[1] == "" {
+			return fields[2]
+		}
+		if fields[1] == "name=systemd" {
+			named = fields[2]
+		}
+	}
+	return named
+}
+
+type peerKey struct{}
+
+// peer is the peer of a connection, identified by its credentials.
+type peer struct {
+	credentials Credentials
+	once        sync.Once
+	process     *Process
+	err         error
+}
+
+func peerFromContext(ctx context.Context) *peer {
+	p, _ := ctx.Value(peerKey{}).(*peer)
+	return p
+}
+
+// PeerProcess returns the process at the other end of a unix socket connection;
+// the caveats of Call.PeerProcess apply.
+func PeerProcess(conn net.Conn) (*Process, error) {
+	cred, ok := peerCredentials(conn)
+	if !ok || cred.PID <= 0 {
+		return nil, ErrNoPeerProcess
+	}
+	return readProcess(cred.PID)
+}
+
+// PeerCredentials returns the credentials of the peer of a unix socket
+// connection.
+func PeerCredentials(conn net.Conn) (*Credentials, error) {
+	cred, ok := peerCredentials(conn)
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+	return cred, nil
+}
+
+// PeerProcess returns the process of the caller, for policies like "only allow
+// calls from program X or unit Y". The process id is recorded by the kernel
+// when the peer connects; the process is looked up when PeerProcess is first
+// called for a connection. The peer may have executed another program, or
+// exited and its id been reused, in between, so the result is only as
+// trustworthy as the peer and should be combined with checks of its user.
+func (c *Call) PeerProcess() (*Process, error) {
+	if c.peer == nil || c.peer.credentials.PID <= 0 {
+		return nil, ErrNoPeerProcess
+	}
+
+	c.peer.once.Do(func() {
+		c.peer.process, c.peer.err = readProcess(c.peer.credentials.PID)
+	})
+	return c.peer.process, c.peer.err
+}
+
+// Credentials returns the user and group ids and the process id of the caller
+// on a unix socket connection, for policies like "only allow calls from root
+// or the members of group X". Unlike the process, the ids are those of the
+// peer when it connected and cannot be changed by it afterwards; they are
+// known on linux.
+func (c *Call) Credentials() (*Credentials, error) {
+	if c.peer == nil {
+		return nil, ErrNoCredentials
+	}
+	cred := c.peer.credentials
+	return &cred, nil
+}
@@ -0,0 +1,50 @@
+// +build linux
+
+package varlink
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"syscall"
+)
+
+// peerCredentials returns the credentials of the peer of a unix socket
+// connection.
+func peerCredentials(conn net.Conn) (*Credentials, bool) {
+	u, ok := conn.(*net.UnixConn)
+	if !ok {
+		return nil, false
+	}
+
+	raw, err := u.SyscallConn()
+	if err != nil {
+		return nil, false
+	}
+
+	var cred *syscall.Ucred
+	err = raw.Control(func(fd uintptr) {
+		cred, err = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil || cred == nil {
+		return nil, false
+	}
+	return &Credentials{UID: int(cred.Uid), GID: int(cred.Gid), PID: int(cred.Pid)}, true
+}
+
+func readProcess(pid int) (*Process, error) {
+	dir := fmt.Sprintf("/proc/%d", pid)
+
+	exe, err := os.Readlink(dir + "/exe")
+	if err != nil {
+		return nil, err
+	}
+
+	cgroup, err := ioutil.ReadFile(dir + "/cgroup")
+	if err != nil {
+		return nil, err
+	}
+
+	return &Process{Pid: pid, Executable: exe, Cgroup: parseCgroup(string(cgroup))}, nil
+}
@@ -0,0 +1,13 @@
+// +build !linux
+
+package varlink
+
+import "net"
+
+func peerCredentials(conn net.Conn) (*Credentials, bool) {
+	return nil, false
+}
+
+func readProcess(pid int) (*Process, error) {
+	return nil, ErrNoPeerProcess
+}
@@ -0,0 +1,201 @@
+package varlink
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+
+	"github.com/varlink/go/varlink/internal/ctxio"
+)
+
+// pipeline keeps the replies of the calls of a connection, which are handled
+// concurrently, in the order of the calls: the replies of a call are written
+// once all calls before it ended. Until then, one reply of the call is
+// buffered, further replies, like the ones of a stream, wait.
+type pipeline struct {
+	mutex sync.Mutex
+	conn  ReadWriterContext
+	// head is the oldest call which did not end, tail the newest call
+	head *pipelineCall
+	tail *pipelineCall
+	err  error
+}
+
+// pipelineCall is the connection of a call in the pipeline.
+type pipelineCall struct {
+	p       *pipeline
+	next    *pipelineCall
+	pending []byte
+	ended   bool
+	// first is closed when the call becomes the head of the pipeline
+	first chan struct{}
+}
+
+// add appends a call to the pipeline.
+func (p *pipeline) add() *pipelineCall {
+	c := &pipelineCall{p: p, first: make(chan struct{})}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.tail == nil {
+		p.head = c
+		close(c.first)
+	} else {
+		p.tail.next = c
+	}
+	p.tail = c
+	return c
+}
+
+// write writes to the connection, the first error fails all following writes;
+// but for the end of the stream timeout, the call keeps the rest of the write.
+func (p *pipeline) write(ctx context.Context, write func() (int, error)) (int, error) {
+	if p.err != nil {
+		return 0, p.err
+	}
+	n, err := write()
+	if err != nil && (ctx.Value(streamWriteKey{}) == nil || !isTimeout(err)) {
+		p.err = err
+	}
+	return n, err
+}
+
+// end records that the call ended and writes the buffered replies of the calls
+// which are first now.
+func (c *pipelineCall) end(ctx context.Context) {
+	p := c.p
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	c.ended = true
+	for p.head != nil && p.head.ended {
+		p.head = p.head.next
+		if p.head == nil {
+			p.tail = nil
+			break
+		}
+		close(p.head.first)
+		if pending := p.head.pending; len(pending) > 0 {
+			p.head.pending = nil
+			p.write(ctx, func() (int, error) { return p.conn.Write(ctx, pending) })
+		}
+	}
+}
+
+// Write buffers the first reply of a call which is not first, and waits until
+// the call is first for the following ones, so a stream behind a long call
+// does not grow the buffer without bound.
+func (c *pipelineCall) Write(ctx context.Context, b []byte) (int, error) {
+	p := c.p
+	p.mutex.Lock()
+	if p.head != c && len(c.pending) == 0 {
+		c.pending = append(c.pending, b...)
+		p.mutex.Unlock()
+		return len(b), nil
+	}
+	p.mutex.Unlock()
+
+	select {
+	case <-c.first:
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.write(ctx, func() (int, error) { return p.conn.Write(ctx, b) })
+}
+
+// Read fails, calls upgrading the connection are not handled concurrently.
+func (c *pipelineCall) Read(ctx context.Context, b []byte) (int, error) {
+	return 0, errors.New("concurrent calls cannot read from the connection")
+}
+
+func (c *pipelineCall) ReadBytes(ctx context.Context, delim byte) ([]byte, error) {
+	return nil, errors.New("concurrent calls cannot read from the connection")
+}
+
+func (c *pipelineCall) CanPassFiles() bool {
+	return canPassFiles(c.p.conn)
+}
+
+// WriteFiles waits until the call is first, files are not buffered.
+func (c *pipelineCall) WriteFiles(ctx context.Context, b []byte, files []*os.File) (int, error) {
+	select {
+	case <-c.first:
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+
+	p := c.p
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.write(ctx, func() (int, error) { return p.conn.(fileWriter).WriteFiles(ctx, b, files) })
+}
+
+// serveConcurrently handles up to ServiceConfig.ConcurrentCalls calls of the
+// connection at once. Calls upgrading the connection wait for the calls before
+// them and are handled alone.
+func (s *Service) serveConcurrently(ctx context.Context, readCtx context.Context, c *ctxio.Conn, conn ReadWriterContext, t *trackedConn) {
+	// The connection is not peeked while reading concurrently, the contexts
+	// returned by Call.Context end when reading ends
+	readCtx, stopReading := context.WithCancel(readCtx)
+	ctx = context.WithValue(ctx, callContextKey{}, &connectionContext{stop: readCtx})
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer stopReading()
+
+	p := &pipeline{conn: conn}
+	slots := make(chan struct{}, s.config.ConcurrentCalls)
+	handle := func(conn ReadWriterContext, request []byte) error {
+		s.setBusy(t, true)
+		err := s.HandleMessage(ctx, conn, request[:len(request)-1])
+		s.setBusy(t, false)
+		if err != nil {
+			s.warn(ConnectionClosed, err)
+		}
+		return err
+	}
+
+	busy := func() bool { return len(slots) > 0 }
+	for readCtx.Err() == nil {
+		if err := s.awaitRequest(readCtx, c, busy); err != nil {
+			return
+		}
+		request, err := conn.ReadBytes(readCtx, '\x00')
+		if err != nil {
+			s.readFailed(c.NetConn(), err)
+			return
+		}
+
+		var header struct {
+			Upgrade bool `json:"upgrade"`
+		}
+		json.Unmarshal(request[:len(request)-1], &header)
+		if header.Upgrade {
+			wg.Wait()
+			if handle(conn, request) != nil {
+				return
+			}
+			continue
+		}
+
+		select {
+		case slots <- struct{}{}:
+		case <-readCtx.Done():
+			return
+		}
+		call := p.add()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := handle(call, request)
+			call.end(ctx)
+			<-slots
+			if err != nil {
+				stopReading()
+			}
+		}()
+	}
+}
@@ -0,0 +1,49 @@
+package varlink
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"runtime"
+	"sort"
+)
+
+// workerPool bounds the number of concurrently running calls of an interface.
+type workerPool struct {
+	workers chan struct{}
+}
+
+// newWorkerPool returns a pool of the given size; zero or less sizes it to the
+// number of usable cores.
+func newWorkerPool(size int) *workerPool {
+	if size <= 0 {
+		size = runtime.GOMAXPROCS(0)
+	}
+	return &workerPool{workers: make(chan struct{}, size)}
+}
+
+// acquire waits for a free worker and returns the function to release it.
+func (p *workerPool) acquire(ctx context.Context) (func(), error) {
+	select {
+	case p.workers <- struct{}{}:
+		return func() { <-p.workers }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (s *Service) writePools(w io.Writer) error {
+	names := make([]string, 0, len(s.pools))
+	for name := range s.pools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		p := s.pools[name]
+		if _, err := fmt.Fprintf(w, "pool: %s workers=%d busy=%d\n", name, cap(p.workers), len(p.workers)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
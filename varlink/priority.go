@@ -0,0 +1,49 @@
+package varlink
+
+import "runtime"
+
+// PriorityBoost raises the scheduling priority of the calls of latency-critical
+// callers, like the control paths of constrained devices, in the manner of the
+// priority inheritance of Android binder: the call of a high-priority caller is
+// handled with a high priority as well. The goroutine handling a boosted call is
+// locked to its OS thread, whose priority Raise changes for the duration of the
+// call; the goroutines started by the method are not boosted.
+type PriorityBoost struct {
+	// Boosted decides from the credentials of the caller whether its calls are
+	// boosted. It is only called for connections with known credentials, see
+	// Call.Credentials.
+	Boosted func(cred *Credentials) bool
+
+	// Raise raises the priority of the current OS thread, like NiceBoost, and
+	// returns the function restoring it, called on the same thread when the
+	// call was handled. If Raise fails, the call is handled without the boost.
+	// If restoring fails, the thread stays locked to the goroutine of the
+	// connection and exits with it, so no other goroutine runs with the raised
+	// priority.
+	Raise func() (restore func() error, err error)
+}
+
+// boost raises the priority of the thread handling the call if the caller is
+// boosted, and returns the function ending the boost.
+func (s *Service) boost(c *Call) func() {
+	b := s.config.PriorityBoost
+	if b == nil || b.Raise == nil || c.peer == nil {
+		return func() {}
+	}
+	cred := c.peer.credentials
+	if b.Boosted == nil || !b.Boosted(&cred) {
+		return func() {}
+	}
+
+	runtime.LockOSThread()
+	restore, err := b.Raise()
+	if err != nil {
+		runtime.UnlockOSThread()
+		return func() {}
+	}
+	return func() {
+		if restore() == nil {
+			runtime.UnlockOSThread()
+		}
+	}
+}
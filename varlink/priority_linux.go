@@ -0,0 +1,26 @@
+// +build linux
+
+package varlink
+
+import "syscall"
+
+// NiceBoost returns a PriorityBoost.Raise function setting the nice value of
+// the current thread, from -20, the highest priority, to 19. Raising the
+// priority above the one of the process needs the CAP_SYS_NICE capability, or
+// a RLIMIT_NICE allowing it.
+func NiceBoost(nice int) func() (func() error, error) {
+	return func() (func() error, error) {
+		tid := syscall.Gettid()
+		// The system call returns 20 - nice, so that it is never negative
+		prio, err := syscall.Getpriority(syscall.PRIO_PROCESS, tid)
+		if err != nil {
+			return nil, err
+		}
+		if err := syscall.Setpriority(syscall.PRIO_PROCESS, tid, nice); err != nil {
+			return nil, err
+		}
+		return func() error {
+			return syscall.Setpriority(syscall.PRIO_PROCESS, tid, 20-prio)
+		}, nil
+	}
+}
@@ -0,0 +1,13 @@
+// +build !linux
+
+package varlink
+
+import "errors"
+
+// NiceBoost returns a PriorityBoost.Raise function setting the nice value of
+// the current thread, which is only supported on linux.
+func NiceBoost(nice int) func() (func() error, error) {
+	return func() (func() error, error) {
+		return nil, errors.New("varlink: the priority of threads cannot be set on this platform")
+	}
+}
@@ -0,0 +1,210 @@
+// Package prometheus collects the metrics of a varlink service and serves them
+// in the Prometheus text format, without depending on the Prometheus client
+// library.
+//
+//	metrics := prometheus.NewCollector(nil)
+//	service, err := varlink.NewServiceWithConfig(vendor, product, version, url,
+//		varlink.ServiceConfig{Metrics: metrics})
+//	http.Handle("/metrics", metrics)
+package prometheus
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBuckets are the upper bounds in seconds of the buckets of the call
+// latency histograms, from a millisecond to ten seconds.
+var DefaultBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// histogram counts the observations below each bucket bound.
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// Collector is a varlink.MetricsCollector keeping the metrics of a service:
+//
+//	varlink_call_duration_seconds{method}  histogram of the call latency
+//	varlink_errors_total{error}             counter of the errors replied
+//	varlink_connections_active              gauge of the open connections
+//	varlink_received_bytes_total            counter of the bytes of the calls
+//	varlink_sent_bytes_total                counter of the bytes of the replies
+//
+// It serves them as an http.Handler for Prometheus to scrape.
+type Collector struct {
+	buckets     []float64
+	mutex       sync.Mutex
+	calls       map[string]*histogram
+	errors      map[string]uint64
+	connections int64
+	received    uint64
+	sent        uint64
+}
+
+// NewCollector returns a collector with the bucket bounds of the latency
+// histograms in seconds; nil means DefaultBuckets.
+func NewCollector(buckets []float64) *Collector {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	sorted := make([]float64, len(buckets))
+	copy(sorted, buckets)
+	sort.Float64s(sorted)
+	return &Collector{
+		buckets: sorted,
+		calls:   make(map[string]*histogram),
+		errors:  make(map[string]uint64),
+	}
+}
+
+// ObserveCall counts the call in the latency histogram of the method, and its
+// error.
+func (c *Collector) ObserveCall(method string, errorName string, duration time.Duration) {
+	seconds := duration.Seconds()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	h, ok := c.calls[method]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(c.buckets))}
+		c.calls[method] = h
+	}
+	for i, bound := range c.buckets {
+		if seconds <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += seconds
+
+	if errorName != "" {
+		c.errors[errorName]++
+	}
+}
+
+// ConnectionOpened counts an open connection.
+func (c *Collector) ConnectionOpened() {
+	c.mutex.Lock()
+	c.connections++
+	c.mutex.Unlock()
+}
+
+// ConnectionClosed counts a closed connection.
+func (c *Collector) ConnectionClosed() {
+	c.mutex.Lock()
+	c.connections--
+	c.mutex.Unlock()
+}
+
+// BytesReceived counts the bytes of a call.
+func (c *Collector) BytesReceived(n int) {
+	c.mutex.Lock()
+	c.received += uint64(n)
+	c.mutex.Unlock()
+}
+
+// BytesSent counts the bytes of a reply.
+func (c *Collector) BytesSent(n int) {
+	c.mutex.Lock()
+	c.sent += uint64(n)
+	c.mutex.Unlock()
+}
+
+// label returns the label with the value escaped for the text format.
+func label(name string, value string) string {
+	value = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+	return name + `="` + value + `"`
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func sortedKeys(m map[string]uint64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// WriteTo writes the metrics in the Prometheus text format.
+func (c *Collector) WriteTo(w io.Writer) (int64, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	methods := make([]string, 0, len(c.calls))
+	for method := range c.calls {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+
+	b := &countingWriter{w: bufio.NewWriter(w)}
+	fmt.Fprintln(b, "# HELP varlink_call_duration_seconds The latency of the method calls.")
+	fmt.Fprintln(b, "# TYPE varlink_call_duration_seconds histogram")
+	for _, method := range methods {
+		h := c.calls[method]
+		m := label("method", method)
+		for i, bound := range c.buckets {
+			fmt.Fprintf(b, "varlink_call_duration_seconds_bucket{%s,%s} %d\n", m, label("le", formatFloat(bound)), h.counts[i])
+		}
+		fmt.Fprintf(b, "varlink_call_duration_seconds_bucket{%s,%s} %d\n", m, label("le", "+Inf"), h.count)
+		fmt.Fprintf(b, "varlink_call_duration_seconds_sum{%s} %s\n", m, formatFloat(h.sum))
+		fmt.Fprintf(b, "varlink_call_duration_seconds_count{%s} %d\n", m, h.count)
+	}
+
+	fmt.Fprintln(b, "# HELP varlink_errors_total The errors replied to method calls.")
+	fmt.Fprintln(b, "# TYPE varlink_errors_total counter")
+	for _, name := range sortedKeys(c.errors) {
+		fmt.Fprintf(b, "varlink_errors_total{%s} %d\n", label("error", name), c.errors[name])
+	}
+
+	fmt.Fprintln(b, "# HELP varlink_connections_active The open connections.")
+	fmt.Fprintln(b, "# TYPE varlink_connections_active gauge")
+	fmt.Fprintf(b, "varlink_connections_active %d\n", c.connections)
+	fmt.Fprintln(b, "# HELP varlink_received_bytes_total The bytes of the received calls.")
+	fmt.Fprintln(b, "# TYPE varlink_received_bytes_total counter")
+	fmt.Fprintf(b, "varlink_received_bytes_total %d\n", c.received)
+	fmt.Fprintln(b, "# HELP varlink_sent_bytes_total The bytes of the sent replies.")
+	fmt.Fprintln(b, "# TYPE varlink_sent_bytes_total counter")
+	fmt.Fprintf(b, "varlink_sent_bytes_total %d\n", c.sent)
+
+	if b.err != nil {
+		return b.n, b.err
+	}
+	err := b.w.Flush()
+	return b.n, err
+}
+
+// ServeHTTP serves the metrics in the Prometheus text format.
+func (c *Collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	c.WriteTo(w)
+}
+
+// countingWriter counts the bytes written and keeps the first error.
+type countingWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	c.err = err
+	return n, err
+}
@@ -0,0 +1,76 @@
+package prometheus_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/varlink/go/varlink"
+	"github.com/varlink/go/varlink/prometheus"
+)
+
+var _ varlink.MetricsCollector = (*prometheus.Collector)(nil)
+
+func TestCollector(t *testing.T) {
+	metrics := prometheus.NewCollector([]float64{1, .5})
+	ctx := context.Background()
+	service, err := varlink.NewServiceWithConfig("Varlink", "Varlink Test", "1", "https://github.com/varlink/go/varlink",
+		varlink.ServiceConfig{Metrics: metrics})
+	if err != nil {
+		t.Fatalf("NewServiceWithConfig(): %v", err)
+	}
+	if err := service.Bind(ctx, "tcp:127.0.0.1:0"); err != nil {
+		t.Fatalf("Bind(): %v", err)
+	}
+	l, _ := service.GetListener()
+	servererror := make(chan error)
+	go func() {
+		servererror <- service.DoListen(ctx, 0)
+	}()
+
+	c, err := varlink.NewConnection(ctx, "tcp:"+l.Addr().String())
+	if err != nil {
+		t.Fatalf("NewConnection(): %v", err)
+	}
+	var vendor string
+	if err := c.GetInfo(ctx, &vendor, nil, nil, nil, nil); err != nil {
+		t.Fatalf("GetInfo(): %v", err)
+	}
+	if err := c.Call(ctx, "org.example.missing.Call", nil, nil); err == nil {
+		t.Fatal("Call() of a missing interface succeeded")
+	}
+
+	// The connection is counted until the service sees it closed
+	active := httptest.NewRecorder()
+	metrics.ServeHTTP(active, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(active.Body.String(), "\nvarlink_connections_active 1\n") {
+		t.Fatalf("Unexpected metrics\n%s", active.Body.String())
+	}
+	c.Close()
+
+	service.Shutdown()
+	if err := <-servererror; err != nil {
+		t.Fatalf("service.DoListen(): %v", err)
+	}
+
+	var out strings.Builder
+	if _, err := metrics.WriteTo(&out); err != nil {
+		t.Fatalf("WriteTo(): %v", err)
+	}
+	for _, line := range []string{
+		`varlink_call_duration_seconds_bucket{method="org.varlink.service.GetInfo",le="0.5"} 1`,
+		`varlink_call_duration_seconds_bucket{method="org.varlink.service.GetInfo",le="1"} 1`,
+		`varlink_call_duration_seconds_bucket{method="org.varlink.service.GetInfo",le="+Inf"} 1`,
+		`varlink_call_duration_seconds_count{method="org.example.missing.Call"} 1`,
+		`varlink_errors_total{error="org.varlink.service.InterfaceNotFound"} 1`,
+		`varlink_connections_active 0`,
+	} {
+		if !strings.Contains(out.String(), "\n"+line+"\n") {
+			t.Fatalf("%s is missing in the metrics\n%s", line, out.String())
+		}
+	}
+	if strings.Contains(out.String(), "_bytes_total 0\n") {
+		t.Fatalf("No messages counted\n%s", out.String())
+	}
+}
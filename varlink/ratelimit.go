@@ -0,0 +1,138 @@
+package varlink
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RateLimit limits the rate of the calls of each connection, or of each user,
+// with a token bucket, so a misbehaving client cannot starve the others. A call
+// over the limit is delayed until the bucket holds a token again, if that is
+// within MaxDelay, or rejected with RateLimitExceeded.
+type RateLimit struct {
+	// Rate is the number of calls per second the bucket is refilled with; zero
+	// means no limit.
+	Rate float64
+	// Burst is the size of the bucket, the number of calls allowed at once; it
+	// is at least one.
+	Burst int
+	// MaxDelay is the longest time a call over the limit is delayed; zero
+	// rejects all of them.
+	MaxDelay time.Duration
+	// PerUser shares the bucket of the connections of the same user, known
+	// from the credentials of unix socket connections; the other connections
+	// have a bucket of their own.
+	PerUser bool
+}
+
+// The caller exceeded the rate limit of the service, the call can be retried
+// after the given number of seconds.
+type RateLimitExceeded struct {
+	RetryAfter float64 `json:"retry_after"`
+}
+
+func (e RateLimitExceeded) Error() string {
+	return "org.varlink.ratelimit.Exceeded"
+}
+
+// orgvarlinkratelimitNew returns the interface declaring RateLimitExceeded,
+// which is registered with ServiceConfig.RateLimit.
+func orgvarlinkratelimitNew() *errorsInterface {
+	return &errorsInterface{
+		name: "org.varlink.ratelimit",
+		description: `# Errors of the services limiting the rate of the calls.
+interface org.varlink.ratelimit
+
+# The caller exceeded the rate limit of the service. The call can be retried
+# after retry_after seconds.
+error Exceeded (retry_after: float)`,
+	}
+}
+
+// tokenBucket holds the tokens of the calls of a connection or a user.
+type tokenBucket struct {
+	mutex  sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+type rateBucketKey struct{}
+
+// take takes a token and returns how long the caller must wait until it is
+// there; a wait longer than max leaves the bucket alone.
+func (b *tokenBucket) take(limit *RateLimit, now time.Time, max time.Duration) time.Duration {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	burst := float64(limit.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+	if b.last.IsZero() {
+		b.tokens = burst
+	} else if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens += elapsed * limit.Rate
+		if b.tokens > burst {
+			b.tokens = burst
+		}
+	}
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+	wait := time.Duration((1 - b.tokens) / limit.Rate * float64(time.Second))
+	if wait <= max {
+		b.tokens--
+	}
+	return wait
+}
+
+// bucket returns the bucket of the caller, nil for calls not received on a
+// connection of the service.
+func (s *Service) bucket(ctx context.Context, c *Call) *tokenBucket {
+	if s.config.RateLimit.PerUser && c.peer != nil {
+		uid := c.peer.credentials.UID
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		b, ok := s.userBuckets[uid]
+		if !ok {
+			if s.userBuckets == nil {
+				s.userBuckets = make(map[int]*tokenBucket)
+			}
+			b = &tokenBucket{}
+			s.userBuckets[uid] = b
+		}
+		return b
+	}
+	b, _ := ctx.Value(rateBucketKey{}).(*tokenBucket)
+	return b
+}
+
+// limitRate delays the call until the rate limit allows it. It returns the
+// error reply of a rejected call, or the error of the context done while the
+// call waits.
+func (s *Service) limitRate(ctx context.Context, c *Call) (*RateLimitExceeded, error) {
+	limit := s.config.RateLimit
+	b := s.bucket(ctx, c)
+	if b == nil || limit.Rate <= 0 {
+		return nil, nil
+	}
+
+	wait := b.take(limit, time.Now(), limit.MaxDelay)
+	if wait > limit.MaxDelay {
+		return &RateLimitExceeded{RetryAfter: wait.Seconds()}, nil
+	}
+	if wait > 0 {
+		t := time.NewTimer(wait)
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return nil, nil
+}
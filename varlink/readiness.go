@@ -0,0 +1,124 @@
+package varlink
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Readiness is implemented by interfaces which need to finish their initialization
+// before handling calls. Until VarlinkReady returns, calls to the interface are
+// answered with org.varlink.service.ServiceNotAvailable.
+type Readiness interface {
+	// VarlinkReady blocks until the interface is ready to handle calls.
+	VarlinkReady(ctx context.Context) error
+}
+
+// unavailable returns whether the interface is still waiting to become ready.
+func (s *Service) unavailable(name string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.pending[name]
+}
+
+// awaitReady waits until the registered interface is ready, and makes it
+// available.
+func (s *Service) awaitReady(ctx context.Context, name string) error {
+	iface, ok := s.snapshot().interfaces[name]
+	if !ok {
+		return nil
+	}
+	if err := implementation(iface).(Readiness).VarlinkReady(ctx); err != nil {
+		return fmt.Errorf("interface '%s' is not ready: %v", name, err)
+	}
+	s.mutex.Lock()
+	delete(s.pending, name)
+	s.mutex.Unlock()
+	return nil
+}
+
+// WaitReady waits until all registered interfaces implementing Readiness are ready.
+// Listen and DoListen call it in the background and notify the service manager
+// with READY=1 once it returns; services which only use HandleMessage need to call
+// it themselves. A failing interface stays unavailable and its error is returned.
+func (s *Service) WaitReady(ctx context.Context) error {
+	s.mutex.Lock()
+	names := make([]string, 0, len(s.pending))
+	for name := range s.pending {
+		names = append(names, name)
+	}
+	s.mutex.Unlock()
+
+	errc := make(chan error, len(names))
+	for _, name := range names {
+		go func(name string) {
+			errc <- s.awaitReady(ctx, name)
+		}(name)
+	}
+
+	var first error
+	for range names {
+		if err := <-errc; err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// notifyReady tells the service manager that the service is ready, or shuts the
+// service down if an interface failed to get ready. If the context is canceled
+// because DoListen returned, the service is left alone.
+func (s *Service) notifyReady(ctx context.Context) {
+	if err := s.WaitReady(ctx); err != nil {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		// DoListen cancels the context before its teardown, which takes the
+		// mutex, so the service is still the one this notifier belongs to.
+		if ctx.Err() != nil || s.state != serviceRunning {
+			return
+		}
+		s.readyError = err
+		s.shutdownLocked()
+		return
+	}
+	notify("READY=1")
+}
+
+// watchdog pets the watchdog of the service manager while the context is not
+// done, if the service manager enabled one with WatchdogSec=.
+func (s *Service) watchdog(ctx context.Context) {
+	interval := watchdogInterval()
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			notify("WATCHDOG=1")
+		}
+	}
+}
+
+func watchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+func (s *Service) readinessError() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.readyError
+}
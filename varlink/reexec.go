@@ -0,0 +1,187 @@
+// +build !windows,!js
+
+package varlink
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Environment variables passing the listener and the state to the new program.
+const (
+	reexecFDEnv    = "VARLINK_REEXEC_FD"
+	reexecStateEnv = "VARLINK_REEXEC_STATE"
+)
+
+// maxReexecState limits the size of the state passed to the new program.
+const maxReexecState = 64 * 1024
+
+// ReexecConfig describes a re-execution of the service program.
+type ReexecConfig struct {
+	// Path of the program to execute; empty means the path of the running
+	// program, which usually is the updated binary.
+	Path string
+	// Args of the new program; nil means the arguments of the running program.
+	Args []string
+	// State is passed to the new program, which retrieves it with ReexecState.
+	// It is limited to 64 KiB.
+	State []byte
+	// Drain is the time to wait for the calls in progress; zero means five seconds.
+	Drain time.Duration
+}
+
+// Reexec replaces the running program of a listening service in place, to
+// update it without closing the socket. The process id stays the same, so the
+// service manager keeps tracking it. The service stops accepting connections,
+// waits for the calls in progress to finish, or for the drain time to pass, and
+// executes the program, which inherits the listener; its Listen serves it
+// instead of the address. The open connections are closed by the execution; the
+// connections arriving in the meantime wait in the backlog of the socket. If the
+// execution fails, the service continues and Reexec returns the error.
+func (s *Service) Reexec(ctx context.Context, config ReexecConfig) error {
+	if len(config.State) > maxReexecState {
+		return fmt.Errorf("re-exec state of %d bytes exceeds the limit", len(config.State))
+	}
+
+	path := config.Path
+	if path == "" {
+		exe, err := os.Executable()
+		if err != nil {
+			return err
+		}
+		path = strings.TrimSuffix(exe, " (deleted)")
+	}
+	args := config.Args
+	if args == nil {
+		args = os.Args
+	}
+	drain := config.Drain
+	if drain <= 0 {
+		drain = 5 * time.Second
+	}
+
+	s.mutex.Lock()
+	l := s.listener
+	if l == nil || s.state != serviceRunning || s.resume != nil {
+		s.mutex.Unlock()
+		return fmt.Errorf("service is not listening")
+	}
+	filer, ok := l.(interface {
+		File() (*os.File, error)
+		SetDeadline(time.Time) error
+	})
+	if !ok {
+		s.mutex.Unlock()
+		return fmt.Errorf("listener of type %T cannot be inherited", l)
+	}
+	resume := make(chan struct{})
+	s.resume = resume
+	s.mutex.Unlock()
+
+	defer func() {
+		filer.SetDeadline(time.Time{})
+		s.mutex.Lock()
+		s.resume = nil
+		s.mutex.Unlock()
+		close(resume)
+	}()
+
+	// Interrupt Accept, the new connections wait in the backlog
+	if err := filer.SetDeadline(time.Unix(1, 0)); err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(drain)
+	for s.busyCalls() > 0 && time.Now().Before(deadline) {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	f, err := filer.File()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// File.Fd would put the shared socket into blocking mode
+	raw, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	fd := -1
+	err = raw.Control(func(sysfd uintptr) {
+		fd = int(sysfd)
+		if _, _, errno := syscall.Syscall(syscall.SYS_FCNTL, sysfd, syscall.F_SETFD, 0); errno != 0 {
+			fd = -1
+		}
+	})
+	if err != nil || fd < 0 {
+		return fmt.Errorf("cannot pass the listener to the new program")
+	}
+
+	env := []string{
+		reexecFDEnv + "=" + strconv.Itoa(fd),
+		reexecStateEnv + "=" + base64.StdEncoding.EncodeToString(config.State),
+	}
+	for _, e := range os.Environ() {
+		// The activation file descriptors are not inherited
+		if strings.HasPrefix(e, "LISTEN_") || strings.HasPrefix(e, reexecFDEnv+"=") || strings.HasPrefix(e, reexecStateEnv+"=") {
+			continue
+		}
+		env = append(env, e)
+	}
+
+	err = syscall.Exec(path, args, env)
+	runtime.KeepAlive(f)
+	return err
+}
+
+// reexecListener returns the listener inherited from Reexec.
+func reexecListener() net.Listener {
+	value, ok := os.LookupEnv(reexecFDEnv)
+	if !ok {
+		return nil
+	}
+	os.Unsetenv(reexecFDEnv)
+
+	fd, err := strconv.Atoi(value)
+	if err != nil || fd < 3 {
+		return nil
+	}
+	syscall.CloseOnExec(fd)
+
+	file := os.NewFile(uintptr(fd), "varlink")
+	defer file.Close()
+	listener, err := net.FileListener(file)
+	if err != nil {
+		return nil
+	}
+	return listener
+}
+
+// ReexecState returns the state passed by the program which executed the running
+// program with Reexec, and whether there was one.
+func ReexecState() ([]byte, bool) {
+	value, ok := os.LookupEnv(reexecStateEnv)
+	if !ok {
+		return nil, false
+	}
+	os.Unsetenv(reexecStateEnv)
+
+	state, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, false
+	}
+	return state, true
+}
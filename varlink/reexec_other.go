@@ -0,0 +1,34 @@
+// +build windows js
+
+package varlink
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"runtime"
+	"time"
+)
+
+// ReexecConfig describes a re-execution of the service program.
+type ReexecConfig struct {
+	Path  string
+	Args  []string
+	State []byte
+	Drain time.Duration
+}
+
+// Reexec is not supported on windows and js.
+func (s *Service) Reexec(ctx context.Context, config ReexecConfig) error {
+	return fmt.Errorf("re-exec is not supported on %s", runtime.GOOS)
+}
+
+func reexecListener() net.Listener {
+	return nil
+}
+
+// ReexecState returns the state passed by Reexec, which is not supported on windows
+// and js.
+func ReexecState() ([]byte, bool) {
+	return nil, false
+}
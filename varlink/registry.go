@@ -0,0 +1,43 @@
+package varlink
+
+// registry holds the registered interfaces. It is not changed once published,
+// changes publish a modified copy, so GetInfo and GetInterfaceDescription see
+// the names and descriptions of one moment.
+type registry struct {
+	names        []string
+	interfaces   map[string]dispatcher
+	descriptions map[string]*storedDescription
+}
+
+func newRegistry() *registry {
+	return &registry{
+		interfaces:   make(map[string]dispatcher),
+		descriptions: make(map[string]*storedDescription),
+	}
+}
+
+func (r *registry) copy() *registry {
+	c := &registry{
+		names:        make([]string, len(r.names)),
+		interfaces:   make(map[string]dispatcher, len(r.interfaces)),
+		descriptions: make(map[string]*storedDescription, len(r.descriptions)),
+	}
+	copy(c.names, r.names)
+	for name, iface := range r.interfaces {
+		c.interfaces[name] = iface
+	}
+	for name, description := range r.descriptions {
+		c.descriptions[name] = description
+	}
+	return c
+}
+
+// snapshot returns the current registry, which must not be modified.
+func (s *Service) snapshot() *registry {
+	return s.registry.Load().(*registry)
+}
+
+// publish replaces the registry, the service mutex must be held.
+func (s *Service) publish(r *registry) {
+	s.registry.Store(r)
+}
@@ -0,0 +1,132 @@
+// Package registry registers the socket of a varlink service with a host-level
+// registry directory, like node agents register with a local registry service.
+//
+// The service writes a registration file to the directory and keeps it there:
+// if the registry restarts and recreates the directory, or removes the file, the
+// file is written again. The file is removed when the service stops.
+//
+//	r := registry.Registration{
+//		Dir:     "/run/varlink/registry",
+//		Name:    "org.example.this",
+//		Address: "unix:/run/org.example.this",
+//	}
+//	go r.Run(ctx)
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Entry is the content of a registration file, encoded as JSON.
+type Entry struct {
+	Address    string   `json:"address"`
+	Interfaces []string `json:"interfaces,omitempty"`
+	Pid        int      `json:"pid"`
+}
+
+// Registration describes the registration of a service.
+type Registration struct {
+	// Dir is the registry directory.
+	Dir string
+	// Name of the registration file, without the .json suffix.
+	Name string
+	// Address clients connect to.
+	Address string
+	// Interfaces is the optional list of interfaces the service implements.
+	Interfaces []string
+	// Interval is the time between the checks of the registration. Zero means
+	// ten seconds.
+	Interval time.Duration
+	// Registered is called after the file was written, the first time and after
+	// every re-registration.
+	Registered func()
+}
+
+// Path returns the path of the registration file.
+func (r *Registration) Path() string {
+	return filepath.Join(r.Dir, r.Name+".json")
+}
+
+// write atomically replaces the registration file.
+func (r *Registration) write() error {
+	b, err := json.Marshal(Entry{Address: r.Address, Interfaces: r.Interfaces, Pid: os.Getpid()})
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(r.Dir, "."+r.Name)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), r.Path())
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	if r.Registered != nil {
+		r.Registered()
+	}
+	return nil
+}
+
+// Run registers the service and keeps the registration until the context is
+// cancelled, then removes the registration file. Failures to re-register, while
+// the registry is restarting, are retried at the next check; only a failure of
+// the first registration is returned.
+func (r *Registration) Run(ctx context.Context) error {
+	if r.Name == "" || strings.ContainsAny(r.Name, `/\`) {
+		return fmt.Errorf("registry: invalid name '%s'", r.Name)
+	}
+
+	interval := r.Interval
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+
+	dir, err := os.Stat(r.Dir)
+	if err != nil {
+		return fmt.Errorf("registry: %v", err)
+	}
+	if err := r.write(); err != nil {
+		return fmt.Errorf("registry: %v", err)
+	}
+	defer os.Remove(r.Path())
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case <-ticker.C:
+			current, err := os.Stat(r.Dir)
+			if err != nil {
+				// The registry is restarting
+				continue
+			}
+
+			_, err = os.Stat(r.Path())
+			if !os.SameFile(dir, current) || os.IsNotExist(err) {
+				if r.write() == nil {
+					dir = current
+				}
+			}
+		}
+	}
+}
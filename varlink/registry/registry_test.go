@@ -0,0 +1,84 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRegistration(t *testing.T) {
+	dir, err := ioutil.TempDir("", "varlink-registry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	registry := filepath.Join(dir, "registry")
+	if err := os.Mkdir(registry, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	registered := make(chan struct{}, 10)
+	r := Registration{
+		Dir:        registry,
+		Name:       "org.example.this",
+		Address:    "unix:/run/org.example.this",
+		Interfaces: []string{"org.example.this"},
+		Interval:   10 * time.Millisecond,
+		Registered: func() { registered <- struct{}{} },
+	}
+
+	wait := func(what string) {
+		select {
+		case <-registered:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("not registered %s", what)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- r.Run(ctx) }()
+	wait("initially")
+
+	b, err := ioutil.ReadFile(r.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entry Entry
+	if err := json.Unmarshal(b, &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry.Address != r.Address || entry.Pid != os.Getpid() || len(entry.Interfaces) != 1 {
+		t.Fatalf("Unexpected registration %+v", entry)
+	}
+
+	// The registry removes the file
+	os.Remove(r.Path())
+	wait("after the file was removed")
+
+	// The registry restarts with a new directory
+	if err := os.RemoveAll(registry); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(registry, 0755); err != nil {
+		t.Fatal(err)
+	}
+	wait("after the registry restarted")
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if _, err := os.Stat(r.Path()); !os.IsNotExist(err) {
+		t.Fatalf("Registration file was not removed: %v", err)
+	}
+
+	r.Dir = filepath.Join(dir, "missing")
+	if err := r.Run(context.Background()); err == nil {
+		t.Fatalf("Run succeeded without a registry directory")
+	}
+}
@@ -0,0 +1,211 @@
+package varlink
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// TenantLimits are the scheduling parameters of a tenant.
+type TenantLimits struct {
+	// Weight is the share of the dispatch slots relative to other tenants
+	// competing for them. Zero means a weight of one.
+	Weight int
+	// MaxCalls limits the number of concurrently dispatched calls of the
+	// tenant. Zero means no limit.
+	MaxCalls int
+}
+
+type schedulerWaiter struct {
+	start   float64
+	seq     uint64
+	ready   chan struct{}
+	granted bool
+}
+
+type schedulerTenant struct {
+	limits  TenantLimits
+	active  int
+	finish  float64
+	waiting []*schedulerWaiter
+}
+
+// scheduler limits the number of concurrently dispatched calls and hands out
+// free slots to waiting calls by start-time fair queuing across tenants. Every
+// call is tagged with a virtual start time, which advances by the inverse of the
+// weight for each call of a tenant; the waiting call with the smallest start
+// time is dispatched first.
+type scheduler struct {
+	mutex   sync.Mutex
+	max     int
+	limits  func(tenant string) TenantLimits
+	active  int
+	vtime   float64
+	seq     uint64
+	tenants map[string]*schedulerTenant
+	limit   *adaptiveLimit
+}
+
+func newScheduler(max int, limits func(tenant string) TenantLimits) *scheduler {
+	return &scheduler{
+		max:     max,
+		limits:  limits,
+		tenants: make(map[string]*schedulerTenant),
+	}
+}
+
+func (s *scheduler) tenant(name string) *schedulerTenant {
+	t, ok := s.tenants[name]
+	if !ok {
+		t = &schedulerTenant{finish: s.vtime}
+		if s.limits != nil {
+			t.limits = s.limits(name)
+		}
+		if t.limits.Weight <= 0 {
+			t.limits.Weight = 1
+		}
+		s.tenants[name] = t
+	}
+	return t
+}
+
+func (s *scheduler) eligible(t *schedulerTenant) bool {
+	return t.limits.MaxCalls <= 0 || t.active < t.limits.MaxCalls
+}
+
+func (s *scheduler) queued() bool {
+	for _, t := range s.tenants {
+		if len(t.waiting) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// acquire waits for a free slot for a call of the tenant. The returned function
+// must be called once the call has been handled.
+func (s *scheduler) acquire(ctx context.Context, tenant string) (func(), error) {
+	return s.acquireSampled(ctx, tenant, true)
+}
+
+// acquireSampled is acquire, which feeds the time the slot is held to the
+// adaptive limit if sample is set. Long running calls, like streams, should
+// not be sampled.
+func (s *scheduler) acquireSampled(ctx context.Context, tenant string, sample bool) (func(), error) {
+	s.mutex.Lock()
+	t := s.tenant(tenant)
+
+	start := t.finish
+	if s.vtime > start {
+		start = s.vtime
+	}
+	t.finish = start + 1/float64(t.limits.Weight)
+
+	if (s.max <= 0 || s.active < s.max) && s.eligible(t) && !s.queued() {
+		s.active++
+		t.active++
+		s.vtime = start
+		s.mutex.Unlock()
+		return s.releaser(tenant, sample), nil
+	}
+
+	s.seq++
+	w := &schedulerWaiter{
+		start: start,
+		seq:   s.seq,
+		ready: make(chan struct{}),
+	}
+	t.waiting = append(t.waiting, w)
+	s.dispatch()
+	s.mutex.Unlock()
+
+	select {
+	case <-w.ready:
+		return s.releaser(tenant, sample), nil
+
+	case <-ctx.Done():
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		if w.granted {
+			t.active--
+			s.active--
+			s.dispatch()
+			s.cleanup(tenant, t)
+			return nil, ctx.Err()
+		}
+		for i := range t.waiting {
+			if t.waiting[i] == w {
+				t.waiting = append(t.waiting[:i], t.waiting[i+1:]...)
+				break
+			}
+		}
+		s.cleanup(tenant, t)
+		return nil, ctx.Err()
+	}
+}
+
+func (s *scheduler) releaser(tenant string, sample bool) func() {
+	if s.limit == nil || !sample {
+		return func() { s.release(tenant, 0) }
+	}
+
+	granted := time.Now()
+	return func() { s.release(tenant, time.Since(granted)) }
+}
+
+// release frees the slot of a call, which held it for the latency if it is
+// sampled by the adaptive limit.
+func (s *scheduler) release(tenant string, latency time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.limit != nil && latency > 0 {
+		s.max = s.limit.update(latency, s.active >= s.max)
+	}
+	t := s.tenants[tenant]
+	t.active--
+	s.active--
+	s.dispatch()
+	s.cleanup(tenant, t)
+}
+
+func (s *scheduler) cleanup(name string, t *schedulerTenant) {
+	if t.active == 0 && len(t.waiting) == 0 {
+		delete(s.tenants, name)
+	}
+}
+
+// dispatch hands out free slots to the waiting calls with the smallest start times.
+func (s *scheduler) dispatch() {
+	for s.max <= 0 || s.active < s.max {
+		var next *schedulerTenant
+		for _, t := range s.tenants {
+			if len(t.waiting) == 0 || !s.eligible(t) {
+				continue
+			}
+			if next == nil || before(t.waiting[0], next.waiting[0]) {
+				next = t
+			}
+		}
+
+		if next == nil {
+			return
+		}
+
+		w := next.waiting[0]
+		next.waiting = next.waiting[1:]
+		next.active++
+		s.active++
+		if w.start > s.vtime {
+			s.vtime = w.start
+		}
+		w.granted = true
+		close(w.ready)
+	}
+}
+
+func before(a *schedulerWaiter, b *schedulerWaiter) bool {
+	if a.start != b.start {
+		return a.start < b.start
+	}
+	return a.seq < b.seq
+}
@@ -0,0 +1,168 @@
+package varlink
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func waitQueued(t *testing.T, s *scheduler, tenant string, n int) {
+	for i := 0; i < 100; i++ {
+		s.mutex.Lock()
+		q := 0
+		if st, ok := s.tenants[tenant]; ok {
+			q = len(st.waiting)
+		}
+		s.mutex.Unlock()
+		if q == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("tenant %s has not %d waiting calls", tenant, n)
+}
+
+func TestSchedulerFairness(t *testing.T) {
+	s := newScheduler(1, func(tenant string) TenantLimits {
+		if tenant == "b" {
+			return TenantLimits{Weight: 2}
+		}
+		return TenantLimits{}
+	})
+
+	release, err := s.acquire(context.Background(), "a")
+	if err != nil {
+		t.Fatalf("acquire(): %v", err)
+	}
+
+	var mutex sync.Mutex
+	var order []string
+	var wg sync.WaitGroup
+	enqueue := func(tenant string, n int) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r, err := s.acquire(context.Background(), tenant)
+			if err != nil {
+				t.Errorf("acquire(): %v", err)
+				return
+			}
+			mutex.Lock()
+			order = append(order, tenant)
+			mutex.Unlock()
+			r()
+		}()
+		waitQueued(t, s, tenant, n)
+	}
+
+	// The noisy tenant a queues first, the others must not wait for all of its calls
+	for i := 1; i <= 4; i++ {
+		enqueue("a", i)
+	}
+	enqueue("b", 1)
+	enqueue("b", 2)
+	enqueue("c", 1)
+
+	release()
+	wg.Wait()
+
+	expected := "b c b a a a a"
+	got := ""
+	for i, tenant := range order {
+		if i > 0 {
+			got += " "
+		}
+		got += tenant
+	}
+	if got != expected {
+		t.Fatalf("Expected order `%s`, got `%s`", expected, got)
+	}
+}
+
+func TestSchedulerQuota(t *testing.T) {
+	s := newScheduler(0, func(tenant string) TenantLimits {
+		return TenantLimits{MaxCalls: 1}
+	})
+
+	release, err := s.acquire(context.Background(), "a")
+	if err != nil {
+		t.Fatalf("acquire(): %v", err)
+	}
+
+	if _, err := s.acquire(context.Background(), "b"); err != nil {
+		t.Fatalf("acquire(): %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := s.acquire(ctx, "a"); err == nil {
+		t.Fatal("acquire() exceeded the tenant quota")
+	}
+
+	release()
+	if _, err := s.acquire(context.Background(), "a"); err != nil {
+		t.Fatalf("acquire(): %v", err)
+	}
+}
+
+func TestAdaptiveLimit(t *testing.T) {
+	a := newAdaptiveLimit(AdaptiveLimit{InitialCalls: 4, MaxCalls: 8})
+
+	// Fast calls grow the limit only while it is reached
+	a.update(time.Millisecond, false)
+	if a.current() != 4 {
+		t.Fatalf("Limit grew without saturation: %d", a.current())
+	}
+	for i := 0; i < 10; i++ {
+		a.update(time.Millisecond, true)
+	}
+	if a.current() != 8 {
+		t.Fatalf("Limit did not grow to the maximum: %d", a.current())
+	}
+
+	// Slow calls shrink it down to the minimum
+	a.update(3*time.Millisecond, true)
+	if a.current() != 7 {
+		t.Fatalf("Limit did not back off: %d", a.current())
+	}
+	for i := 0; i < 100; i++ {
+		a.update(10*time.Millisecond, true)
+	}
+	if a.current() != 1 {
+		t.Fatalf("Limit did not shrink to the minimum: %d", a.current())
+	}
+}
+
+func TestSchedulerAdaptiveLimit(t *testing.T) {
+	s := newScheduler(0, nil)
+	s.limit = newAdaptiveLimit(AdaptiveLimit{InitialCalls: 1})
+	s.max = s.limit.current()
+
+	release, err := s.acquire(context.Background(), "")
+	if err != nil {
+		t.Fatalf("acquire(): %v", err)
+	}
+	time.Sleep(time.Millisecond)
+	release()
+
+	s.mutex.Lock()
+	max := s.max
+	s.mutex.Unlock()
+	if max != 2 {
+		t.Fatalf("Limit did not grow after a saturated call: %d", max)
+	}
+
+	// Not sampled calls leave the limit alone
+	release, err = s.acquireSampled(context.Background(), "", false)
+	if err != nil {
+		t.Fatalf("acquire(): %v", err)
+	}
+	release()
+	s.mutex.Lock()
+	max = s.max
+	s.mutex.Unlock()
+	if max != 2 {
+		t.Fatalf("Limit changed after a call which was not sampled: %d", max)
+	}
+}
@@ -0,0 +1,157 @@
+package varlink
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SchemaCache keeps the information and the interface descriptions fetched from
+// services in a directory, by the address and the version of the service. The
+// clients using it need not fetch the descriptions again on every start, and
+// still know them while the service is briefly unreachable. Stale entries are
+// never read for another version of the service; an updated service, which
+// did not change its version, is not noticed.
+//
+// The directory holds a directory per address with the information last
+// received from the service in info.json, and a directory per version with a
+// file per interface description:
+//
+//	DIR/HASH(address)/info.json
+//	DIR/HASH(address)/HASH(version)/org.example.interface.varlink
+type SchemaCache struct {
+	dir string
+}
+
+// NewSchemaCache returns a cache in the directory, which is created when the
+// first entry is stored. An empty directory means the varlink directory in the
+// cache directory of the user, like $XDG_CACHE_HOME/varlink.
+func NewSchemaCache(dir string) (*SchemaCache, error) {
+	if dir == "" {
+		cache, err := os.UserCacheDir()
+		if err != nil {
+			return nil, err
+		}
+		dir = filepath.Join(cache, "varlink")
+	}
+	return &SchemaCache{dir: dir}, nil
+}
+
+// cacheKey returns the file name for the address or the version.
+func cacheKey(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:16])
+}
+
+func (s *SchemaCache) descriptionFile(address string, version string, name string) (string, error) {
+	if name == "" || name[0] == '.' || strings.ContainsAny(name, `/\`) {
+		return "", fmt.Errorf("invalid interface name '%s'", name)
+	}
+	return filepath.Join(s.dir, cacheKey(address), cacheKey(version), name+".varlink"), nil
+}
+
+// replaceFile replaces the file with one holding the data, so concurrent readers
+// never see a partly written one.
+func replaceFile(name string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(name), 0700); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(name), ".tmp-")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), name)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// Info returns the information last stored for the service at the address.
+func (s *SchemaCache) Info(address string) (*ServiceInfo, bool) {
+	b, err := ioutil.ReadFile(filepath.Join(s.dir, cacheKey(address), "info.json"))
+	if err != nil {
+		return nil, false
+	}
+	var info ServiceInfo
+	if err := json.Unmarshal(b, &info); err != nil {
+		return nil, false
+	}
+	return &info, true
+}
+
+// StoreInfo stores the information received from the service at the address.
+func (s *SchemaCache) StoreInfo(address string, info *ServiceInfo) error {
+	b, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	return replaceFile(filepath.Join(s.dir, cacheKey(address), "info.json"), b)
+}
+
+// Description returns the stored description of the interface of the service
+// at the address in the version.
+func (s *SchemaCache) Description(address string, version string, name string) (string, bool) {
+	file, err := s.descriptionFile(address, version, name)
+	if err != nil {
+		return "", false
+	}
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", false
+	}
+	return string(b), true
+}
+
+// StoreDescription stores the description of the interface received from the
+// service at the address in the version.
+func (s *SchemaCache) StoreDescription(address string, version string, name string, description string) error {
+	file, err := s.descriptionFile(address, version, name)
+	if err != nil {
+		return err
+	}
+	return replaceFile(file, []byte(description))
+}
+
+// cachedDescription returns the description of the interface from the schema
+// cache of the connection for the version of the service, else requests it
+// from the service and stores it. The cache is only a help: the description is
+// received even if it cannot be stored.
+func (c *Connection) cachedDescription(ctx context.Context, name string) (string, error) {
+	if c.schemaCache == nil {
+		return c.GetInterfaceDescription(ctx, name)
+	}
+
+	c.mutex.Lock()
+	version := c.version
+	c.mutex.Unlock()
+	if version == nil {
+		info, err := c.GetServiceInfo(ctx)
+		if err != nil {
+			return "", err
+		}
+		version = &info.Version
+	}
+
+	if description, ok := c.schemaCache.Description(c.address, *version, name); ok {
+		return description, nil
+	}
+	description, err := c.GetInterfaceDescription(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	c.schemaCache.StoreDescription(c.address, *version, name, description)
+	return description, nil
+}
@@ -0,0 +1,130 @@
+package varlink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/varlink/go/varlink/idl"
+)
+
+// methodLister is implemented by generated interfaces, it returns the
+// names of all methods known to the generated dispatcher.
+type methodLister interface {
+	VarlinkGetMethods() []string
+}
+
+// recorder is a ReadWriterContext which records all replies written to it.
+type recorder struct {
+	written []byte
+}
+
+func (r *recorder) Write(ctx context.Context, b []byte) (int, error) {
+	r.written = append(r.written, b...)
+	return len(b), nil
+}
+
+func (r *recorder) Read(ctx context.Context, b []byte) (int, error) {
+	return 0, fmt.Errorf("self-test connection is write-only")
+}
+
+func (r *recorder) ReadBytes(ctx context.Context, delim byte) ([]byte, error) {
+	return nil, fmt.Errorf("self-test connection is write-only")
+}
+
+func selfTestInterface(name string, iface dispatcher, description string) error {
+	midl, err := idl.New(description)
+	if err != nil {
+		return fmt.Errorf("interface '%s': invalid description: %v", name, err)
+	}
+
+	if midl.Name != name {
+		return fmt.Errorf("interface '%s': description declares interface '%s'", name, midl.Name)
+	}
+
+	ml, ok := implementation(iface).(methodLister)
+	if !ok {
+		return nil
+	}
+
+	methods := make(map[string]struct{})
+	for _, m := range ml.VarlinkGetMethods() {
+		methods[m] = struct{}{}
+	}
+
+	for _, m := range midl.Methods {
+		if _, ok := methods[m.Name]; !ok {
+			return fmt.Errorf("interface '%s': method '%s' is not dispatched", name, m.Name)
+		}
+		delete(methods, m.Name)
+	}
+
+	// The first undescribed method in sorted order is reported, for stable errors
+	undescribed := make([]string, 0, len(methods))
+	for m := range methods {
+		undescribed = append(undescribed, m)
+	}
+	if len(undescribed) > 0 {
+		sort.Strings(undescribed)
+		return fmt.Errorf("interface '%s': dispatched method '%s' is not described", name, undescribed[0])
+	}
+
+	return nil
+}
+
+// SelfTest verifies that the descriptions of all registered interfaces can be parsed,
+// that they declare the registered interface name, and that the generated dispatchers
+// know exactly the methods of their description. Additionally, the fully-qualified
+// methods passed in are called without parameters and must not reply with an error.
+// SelfTest is meant for container health probes and CI smoke tests.
+func (s *Service) SelfTest(ctx context.Context, methods ...string) error {
+	r := s.snapshot()
+	for _, name := range r.names {
+		description, err := r.descriptions[name].text()
+		if err != nil {
+			return fmt.Errorf("interface '%s': %v", name, err)
+		}
+		if err := selfTestInterface(name, r.interfaces[name], description); err != nil {
+			return err
+		}
+	}
+
+	for _, method := range methods {
+		r := strings.LastIndex(method, ".")
+		if r <= 0 {
+			return fmt.Errorf("self-check method '%s': not fully-qualified", method)
+		}
+
+		request, err := json.Marshal(serviceCall{Method: method})
+		if err != nil {
+			return err
+		}
+
+		var conn recorder
+		if err := s.HandleMessage(ctx, &conn, request); err != nil {
+			return fmt.Errorf("self-check method '%s': %v", method, err)
+		}
+
+		// Only the first reply of a streaming method is checked
+		end := bytes.IndexByte(conn.written, 0)
+		if end < 0 {
+			return fmt.Errorf("self-check method '%s': no reply", method)
+		}
+
+		var reply struct {
+			Error string `json:"error"`
+		}
+		if err := json.Unmarshal(conn.written[:end], &reply); err != nil {
+			return fmt.Errorf("self-check method '%s': %v", method, err)
+		}
+
+		if reply.Error != "" {
+			return fmt.Errorf("self-check method '%s': replied error '%s'", method, reply.Error)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,81 @@
+package varlink
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// rwAddr is the address of a connection which is not a socket.
+type rwAddr struct{}
+
+func (rwAddr) Network() string { return "rw" }
+func (rwAddr) String() string  { return "rw" }
+
+// rwConn is a net.Conn of a stream which is not a socket. Without deadline
+// support in the stream, a deadline in the past closes it, to interrupt
+// the pending reads and writes.
+type rwConn struct {
+	io.ReadWriteCloser
+}
+
+func (c rwConn) LocalAddr() net.Addr  { return rwAddr{} }
+func (c rwConn) RemoteAddr() net.Addr { return rwAddr{} }
+
+func (c rwConn) SetDeadline(t time.Time) error {
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.SetWriteDeadline(t)
+}
+
+func (c rwConn) SetReadDeadline(t time.Time) error {
+	if d, ok := c.ReadWriteCloser.(interface{ SetReadDeadline(time.Time) error }); ok && d.SetReadDeadline(t) == nil {
+		return nil
+	}
+	return c.expire(t)
+}
+
+func (c rwConn) SetWriteDeadline(t time.Time) error {
+	if d, ok := c.ReadWriteCloser.(interface{ SetWriteDeadline(time.Time) error }); ok && d.SetWriteDeadline(t) == nil {
+		return nil
+	}
+	return c.expire(t)
+}
+
+func (c rwConn) expire(t time.Time) error {
+	if !t.IsZero() && t.Before(time.Now()) {
+		c.Close()
+	}
+	return nil
+}
+
+// ServeConn serves a single established connection until the client closes it
+// or the context is done, like stdin and stdout of a service started by inetd,
+// or the connected socket of systemd socket activation with Accept=yes. It can
+// be called without Listen, and for several connections at once. The interfaces
+// implementing Readiness are not waited for, see WaitReady.
+func (s *Service) ServeConn(ctx context.Context, rw io.ReadWriteCloser) error {
+	s.mutex.Lock()
+	stopped := s.state == serviceStopped
+	if !stopped {
+		s.conncounter++
+	}
+	s.mutex.Unlock()
+	if stopped {
+		rw.Close()
+		return ServiceStoppedError{}
+	}
+
+	conn, ok := rw.(net.Conn)
+	if !ok {
+		conn = rwConn{rw}
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	s.handleConnection(ctx, ctx, nil, conn, &wg)
+	return nil
+}
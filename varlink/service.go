@@ -2,15 +2,19 @@ package varlink
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
 	"os"
+	"reflect"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/varlink/go/varlink/internal/ctxio"
+	"github.com/varlink/go/varlink/idl"
 )
 
 type dispatcher interface {
@@ -20,11 +24,12 @@ type dispatcher interface {
 }
 
 type serviceCall struct {
-	Method     string           `json:"method"`
-	Parameters *json.RawMessage `json:"parameters,omitempty"`
-	More       bool             `json:"more,omitempty"`
-	Oneway     bool             `json:"oneway,omitempty"`
-	Upgrade    bool             `json:"upgrade,omitempty"`
+	Method     string            `json:"method"`
+	Parameters *json.RawMessage  `json:"parameters,omitempty"`
+	More       bool              `json:"more,omitempty"`
+	Oneway     bool              `json:"oneway,omitempty"`
+	Upgrade    bool              `json:"upgrade,omitempty"`
+	Metadata   map[string]string `json:"metadata,omitempty"`
 }
 
 type serviceReply struct {
@@ -37,19 +42,60 @@ type serviceReply struct {
 // implements the org.varlink.service interface which allows clients to retrieve information about the
 // running service.
 type Service struct {
-	vendor       string
-	product      string
-	version      string
-	url          string
-	interfaces   map[string]dispatcher
-	names        []string
-	descriptions map[string]string
-	running      bool
-	listener     net.Listener
-	conncounter  int64
-	mutex        sync.Mutex
-	protocol     string
-	address      string
+	vendor      string
+	product     string
+	version     string
+	url         string
+	registry    atomic.Value
+	state       serviceState
+	stopReading context.CancelFunc
+	closeConns  context.CancelFunc
+	running     context.Context
+	stopped     chan struct{}
+	listener    net.Listener
+	conncounter int64
+	mutex       sync.Mutex
+	protocol    string
+	address     string
+	config      ServiceConfig
+	scheduler   *scheduler
+	pending     map[string]bool
+	readyError  error
+	tlsConfig   *tls.Config
+	details     *parameterDetails
+	maintenance *MaintenanceUnavailable
+	accounting  *accounting
+	stuckCalls  *handlerWatchdog
+	connections map[*trackedConn]struct{}
+	memoryUsage func() uint64
+	busy        int
+	resume      chan struct{}
+	pools       map[string]*workerPool
+	userBuckets map[int]*tokenBucket
+	negotiates  bool
+	middleware  []func(next Handler) Handler
+	authorizers map[string]Authorizer
+}
+
+// serviceState is the lifecycle of a Service. A new service starts running with
+// Listen or DoListen, and returns to new when it stops listening on its own,
+// like after a timeout. Shutdown stops it for good: a running service is
+// stopping until its connections are closed, a new one is stopped right away.
+type serviceState int
+
+const (
+	serviceNew serviceState = iota
+	serviceRunning
+	serviceStopping
+	serviceStopped
+)
+
+// ServiceStoppedError is returned by Bind, Listen and DoListen of a service
+// which was shut down.
+type ServiceStoppedError struct{}
+
+func (ServiceStoppedError) Error() string {
+	return "service has been shut down"
 }
 
 // ServiceTimeoutError helps API users to special-case timeouts.
@@ -59,8 +105,32 @@ func (ServiceTimeoutError) Error() string {
 	return "service timeout"
 }
 
+// ServiceRunningError is returned if another live service instance is already
+// listening on the unix socket. The fields describe the running instance, as far
+// as it answered the GetInfo probe.
+type ServiceRunningError struct {
+	Address string
+	Vendor  string
+	Product string
+	Version string
+}
+
+func (e ServiceRunningError) Error() string {
+	if e.Product == "" {
+		return fmt.Sprintf("service already running at %s", e.Address)
+	}
+	return fmt.Sprintf("service already running at %s: %s %s", e.Address, e.Product, e.Version)
+}
+
 func (s *Service) getInfo(ctx context.Context, c Call) error {
-	return c.replyGetInfo(ctx, s.vendor, s.product, s.version, s.url, s.names)
+	r := s.snapshot()
+	names := make([]string, 0, len(r.names))
+	for _, name := range r.names {
+		if s.visible(&c, r, name) {
+			names = append(names, name)
+		}
+	}
+	return c.replyGetInfo(ctx, s.vendor, s.product, s.version, s.url, names)
 }
 
 func (s *Service) getInterfaceDescription(ctx context.Context, c Call, name string) error {
@@ -68,26 +138,65 @@ func (s *Service) getInterfaceDescription(ctx context.Context, c Call, name stri
 		return c.ReplyInvalidParameter(ctx, "interface")
 	}
 
-	description, ok := s.descriptions[name]
-	if !ok {
+	r := s.snapshot()
+	stored, ok := r.descriptions[name]
+	if !ok || !s.visible(&c, r, name) {
 		return c.ReplyInvalidParameter(ctx, "interface")
 	}
 
+	if _, ok := c.Extension(CompressedDescriptions); ok && stored.compressed != nil {
+		return c.replyGetCompressedInterfaceDescription(ctx, stored.compressed)
+	}
+
+	description, err := stored.text()
+	if err != nil {
+		return err
+	}
 	return c.replyGetInterfaceDescription(ctx, description)
 }
 
-func (s *Service) HandleMessage(ctx context.Context, conn ReadWriterContext, request []byte) error {
+func (s *Service) HandleMessage(ctx context.Context, conn ReadWriterContext, request []byte) (err error) {
 	var in serviceCall
 
-	err := json.Unmarshal(request, &in)
+	err = json.Unmarshal(request, &in)
 	if err != nil {
 		return err
 	}
 
+	var span Span
+	if s.config.Tracer != nil {
+		ctx = s.config.Tracer.Extract(ctx, in.Metadata)
+		ctx, span = s.config.Tracer.Start(ctx, in.Method, ServerSpan)
+	}
+
 	c := Call{
-		Conn:    conn,
-		In:      &in,
-		Request: &request,
+		Conn:       conn,
+		In:         &in,
+		Request:    &request,
+		useNumber:  s.config.UseNumber,
+		details:    s.details,
+		peer:       peerFromContext(ctx),
+		extensions: negotiatedFromContext(ctx),
+		listener:   listenerFromContext(ctx),
+		tlsState:   tlsStateFromContext(ctx),
+		state:      &callState{ctx: ctx},
+	}
+
+	if span != nil {
+		defer func() { span.End(c.state.spanError(err)) }()
+	}
+
+	if s.config.Metrics != nil {
+		c.metrics = s.config.Metrics
+		c.metrics.BytesReceived(len(request) + 1)
+	}
+	if s.config.AccessLog != nil || s.config.Metrics != nil {
+		start := time.Now()
+		defer func() { s.completed(ctx, &c, start, err) }()
+	}
+
+	if s.config.TenantFunc != nil {
+		c.tenant = s.config.TenantFunc(ctx, &c)
 	}
 
 	r := strings.LastIndex(in.Method, ".")
@@ -98,57 +207,320 @@ func (s *Service) HandleMessage(ctx context.Context, conn ReadWriterContext, req
 	interfacename := in.Method[:r]
 	methodname := in.Method[r+1:]
 
+	if s.config.RateLimit != nil {
+		exceeded, err := s.limitRate(ctx, &c)
+		if err != nil {
+			return err
+		}
+		if exceeded != nil {
+			return c.ReplyError(ctx, "org.varlink.ratelimit.Exceeded", exceeded)
+		}
+	}
+
 	if interfacename == "org.varlink.service" {
 		return s.orgvarlinkserviceDispatch(ctx, c, methodname)
 	}
 
 	// Find the interface and method in our service
-	iface, ok := s.interfaces[interfacename]
-	if !ok {
+	reg := s.snapshot()
+	iface, ok := reg.interfaces[interfacename]
+	if !ok || !s.visible(&c, reg, interfacename) {
 		return c.ReplyInterfaceNotFound(ctx, interfacename)
 	}
 
-	return iface.VarlinkDispatch(ctx, c, methodname)
+	if t, ok := iface.(*tenantInterface); ok {
+		c.tenant = t.tenant
+	}
+
+	if !s.authorized(ctx, &c, interfacename, methodname) {
+		return c.ReplyPermissionDenied(ctx)
+	}
+
+	if m := s.inMaintenance(interfacename, in.Method); m != nil {
+		return c.ReplyError(ctx, "org.varlink.maintenance.Unavailable", m)
+	}
+
+	if s.unavailable(interfacename) {
+		return c.ReplyServiceNotAvailable(ctx)
+	}
+
+	// The worker is only taken once the scheduler admitted the call, a call
+	// waiting in its queue does not hold one
+	if s.scheduler != nil {
+		release, err := s.scheduler.acquireSampled(ctx, c.tenant, !in.More && !in.Upgrade)
+		if err != nil {
+			return err
+		}
+		defer release()
+	}
+
+	if p, ok := s.pools[interfacename]; ok {
+		release, err := p.acquire(ctx)
+		if err != nil {
+			return err
+		}
+		defer release()
+	}
+
+	if !in.Upgrade {
+		c.state.policy = s.config.FlushPolicy
+		c.state.streamTimeout = s.config.StreamTimeout
+	}
+	dispatch := func() (err error) {
+		defer s.recoverPanic(ctx, &c, &err)
+		defer s.boost(&c)()
+		if s.stuckCalls != nil && !in.More && !in.Upgrade {
+			defer s.watch(&c)()
+		}
+		return s.handler(iface)(ctx, c, interfacename, methodname)
+	}
+	start := time.Now()
+	if s.accounting != nil {
+		err = s.accounting.measure(in.Method, dispatch)
+	} else {
+		err = dispatch()
+	}
+	handleStats(s.config.Stats, Stats{Kind: CallCompleted, Method: in.Method, Duration: time.Since(start), Err: err})
+
+	final := c.state.end()
+	if _, canceled := err.(*StreamCanceled); !final && !in.Oneway && (canceled || c.state.streamContinued()) {
+		err = s.cancelStream(&c, err)
+		final = true
+	}
+	if ferr := c.Flush(ctx); ferr != nil && err == nil {
+		err = ferr
+	}
+
+	// The reply of the next call would be taken for the missing one
+	if !final && err == nil && !in.Oneway {
+		return fmt.Errorf("method %s returned without a final reply", in.Method)
+	}
+	return err
 }
 
-// Shutdown shuts down the listener of a running service.
+// Shutdown stops the service for good. It closes the listener, and the
+// connections once the calls in progress are handled; Listen returns when all
+// connections are closed; the contexts returned by Call.Context are canceled.
+// A service which was not started yet will not start, and calling Shutdown
+// again does nothing. A running service notifies the service manager with
+// STOPPING=1.
 func (s *Service) Shutdown() error {
-	s.running = false
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
+	return s.shutdownLocked()
+}
+
+// shutdownLocked shuts the service down; the caller holds the mutex.
+func (s *Service) shutdownLocked() error {
+	switch s.state {
+	case serviceNew:
+		s.state = serviceStopped
+	case serviceRunning:
+		s.state = serviceStopping
+		s.stopReading()
+		notify("STOPPING=1")
+	default:
+		return nil
+	}
+
 	if s.listener == nil {
 		return nil
 	}
 	return s.listener.Close()
 }
 
-func (s *Service) handleConnection(ctx context.Context, conn net.Conn, wg *sync.WaitGroup) {
+// ShutdownContext shuts the service down like Shutdown, and waits until all
+// connections are closed. If the context is done first, the remaining
+// connections are closed and the contexts of their calls canceled; Listen
+// returns once the handlers of the calls returned.
+func (s *Service) ShutdownContext(ctx context.Context) error {
+	s.mutex.Lock()
+	stopped := s.stopped
+	s.mutex.Unlock()
+
+	err := s.Shutdown()
+	if stopped == nil {
+		return err
+	}
+
+	select {
+	case <-stopped:
+		return err
+	case <-ctx.Done():
+		s.mutex.Lock()
+		if s.closeConns != nil {
+			s.closeConns()
+		}
+		s.mutex.Unlock()
+		return ctx.Err()
+	}
+}
+
+// start sets a new service running, and returns the context of its
+// connections, canceled by ShutdownContext, and the context interrupting the
+// reading of the connections on Shutdown.
+func (s *Service) start(ctx context.Context) (context.Context, context.Context, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	switch s.state {
+	case serviceRunning, serviceStopping:
+		return nil, nil, fmt.Errorf("service is already running")
+	case serviceStopped:
+		return nil, nil, ServiceStoppedError{}
+	}
+
+	if s.listener == nil {
+		return nil, nil, fmt.Errorf("No listener set")
+	}
+
+	connCtx, closeConns := context.WithCancel(ctx)
+	readCtx, stopReading := context.WithCancel(connCtx)
+	s.state = serviceRunning
+	s.closeConns = closeConns
+	s.running = connCtx
+	s.stopReading = stopReading
+	s.stopped = make(chan struct{})
+	return connCtx, readCtx, nil
+}
+
+// closeOnCancel closes the connection when the context is canceled, until the
+// returned function is called.
+func closeOnCancel(ctx context.Context, conn net.Conn) func() {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
+
+// stopping returns whether Shutdown was called on the running service.
+func (s *Service) stopping() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.state != serviceRunning
+}
+
+func (s *Service) handleConnection(ctx context.Context, readCtx context.Context, l net.Listener, conn net.Conn, wg *sync.WaitGroup) {
 	defer func() { s.mutex.Lock(); s.conncounter--; s.mutex.Unlock(); wg.Done() }()
+	release := closeOnCancel(ctx, conn)
+	defer release()
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
-	ctxConn := ctxio.NewConn(conn)
+	t, ok := s.admit(conn)
+	if !ok {
+		if s.config.ConnectionLimit == ReplyAtLimit {
+			s.refuse(ctx, conn)
+		}
+		conn.Close()
+		return
+	}
+	defer s.forget(t)
+	if s.config.Metrics != nil {
+		s.config.Metrics.ConnectionOpened()
+		defer s.config.Metrics.ConnectionClosed()
+	}
+
+	if cred, ok := peerCredentials(conn); ok {
+		ctx = context.WithValue(ctx, peerKey{}, &peer{credentials: *cred})
+	}
+	if s.negotiates {
+		ctx = context.WithValue(ctx, extensionsKey{}, &negotiated{})
+	}
+	ctx = context.WithValue(ctx, listenerKey{}, connListener(l, conn))
+	ctx = context.WithValue(ctx, peerAddrKey{}, conn.RemoteAddr())
+	if s.config.RateLimit != nil {
+		ctx = context.WithValue(ctx, rateBucketKey{}, &tokenBucket{})
+	}
+	if s.config.ConnContext != nil {
+		ctx = s.config.ConnContext(ctx, conn)
+	}
+
+	c, isVarlink, err := s.route(ctx, conn)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	if !isVarlink {
+		release()
+		s.config.OtherProtocol(c.NetConn())
+		return
+	}
+
+	if t, ok := c.Underlying().(*tls.Conn); ok {
+		state := t.ConnectionState()
+		ctx = context.WithValue(ctx, tlsStateKey{}, &state)
+	}
+	ctx = context.WithValue(ctx, callContextKey{}, &connectionContext{stop: readCtx, conn: c})
+	c.SetReadLimit(s.config.MaxMessageSize)
+	c.SetTimeouts(s.config.ReadTimeout, s.config.WriteTimeout)
+	var ctxConn ReadWriterContext = c
+	if s.config.Capture != nil {
+		ctxConn = newCaptureConn(ctxConn, s.config.Capture)
+	}
+
+	if s.config.ConcurrentCalls > 1 {
+		s.serveConcurrently(ctx, readCtx, c, ctxConn, t)
+		c.Close()
+		return
+	}
 
-	for {
-		request, err := ctxConn.ReadBytes(ctx, '\x00')
+	// Reading is interrupted on Shutdown, a call in progress is still handled
+	for readCtx.Err() == nil {
+		if err := s.awaitRequest(readCtx, c, nil); err != nil {
+			break
+		}
+		request, err := ctxConn.ReadBytes(readCtx, '\x00')
 		if err != nil {
+			s.readFailed(conn, err)
 			break
 		}
 
+		s.setBusy(t, true)
 		err = s.HandleMessage(ctx, ctxConn, request[:len(request)-1])
+		s.setBusy(t, false)
 		if err != nil {
-			// FIXME: report error
-			// fmt.Fprintf(os.Stderr, "handleMessage: %v", err)
+			s.warn(ConnectionClosed, err)
 			break
 		}
 	}
 
-	conn.Close()
+	c.Close()
+}
+
+// readFailed reports a request exceeding the size limit.
+func (s *Service) readFailed(conn net.Conn, err error) {
+	if errors.Is(err, ErrMessageTooLarge) {
+		s.warn(MessageTooLarge, fmt.Errorf("request from %s exceeds %d bytes", conn.RemoteAddr(), s.config.MaxMessageSize))
+	}
 }
 
 func (s *Service) teardown() {
 	s.mutex.Lock()
+	if s.listener != nil {
+		s.listener.Close()
+	}
 	s.listener = nil
-	s.running = false
+	s.readyError = nil
+	switch s.state {
+	case serviceRunning:
+		s.state = serviceNew
+	case serviceStopping:
+		s.state = serviceStopped
+	}
+	if s.closeConns != nil {
+		s.closeConns()
+		close(s.stopped)
+	}
+	s.closeConns = nil
+	s.stopReading = nil
+	s.stopped = nil
 	s.protocol = ""
 	s.address = ""
 	s.mutex.Unlock()
@@ -174,6 +546,12 @@ func (s *Service) parseAddress(address string) error {
 		break
 	case "tcp":
 		break
+	case "tls":
+		break
+	case "vsock":
+		break
+	case "npipe":
+		break
 
 	default:
 		return fmt.Errorf("Unknown protocol")
@@ -190,21 +568,42 @@ func (s *Service) GetListener() (net.Listener, error) {
 }
 
 func (s *Service) setListener(ctx context.Context) error {
-	l := activationListener()
+	l := reexecListener()
+	if l == nil {
+		l = activationListener("", s.warn)
+	}
 	if l == nil {
 		if s.protocol == "unix" && s.address[0] != '@' {
-			os.Remove(s.address)
+			if err := probeInstance(ctx, s.address); err != nil {
+				return err
+			}
+			if os.Remove(s.address) == nil {
+				s.warn(StaleSocketRemoved, fmt.Errorf("removed %s", s.address))
+			}
+		}
+
+		network := s.protocol
+		if network == "tls" {
+			network = "tcp"
 		}
 
 		var err error
-		l, err = listen(ctx, s.protocol, s.address)
+		switch network {
+		case "unix":
+			l, err = listenUnix(ctx, s.address)
+		case "npipe":
+			l, err = listenPipe(pipeName(s.address))
+		case "vsock":
+			var addr *VsockAddr
+			if addr, err = parseVsockAddr(s.address, true); err == nil {
+				l, err = listenVsock(addr)
+			}
+		default:
+			l, err = listen(ctx, network, s.address)
+		}
 		if err != nil {
 			return err
 		}
-
-		if s.protocol == "unix" && s.address[0] != '@' {
-			l.(*net.UnixListener).SetUnlinkOnClose(true)
-		}
 	}
 
 	s.mutex.Lock()
@@ -214,6 +613,40 @@ func (s *Service) setListener(ctx context.Context) error {
 	return nil
 }
 
+// probeInstance fails with ServiceRunningError if a live service answers on the
+// unix socket. A socket nobody listens on is a stale leftover and can be removed.
+func probeInstance(ctx context.Context, path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+
+	c, err := NewConnection(ctx, "unix:"+path)
+	if err != nil {
+		return nil
+	}
+	defer c.Close()
+
+	// Something is listening, even if it does not answer the probe
+	e := ServiceRunningError{Address: "unix:" + path}
+	c.GetInfo(ctx, &e.Vendor, &e.Product, &e.Version, nil, nil)
+	return e
+}
+
+// waitReexec waits while Reexec interrupted Accept, it returns whether it waited.
+func (s *Service) waitReexec() bool {
+	s.mutex.Lock()
+	resume := s.resume
+	s.mutex.Unlock()
+	if resume == nil {
+		return false
+	}
+	<-resume
+	return true
+}
+
 func (s *Service) refreshTimeout(timeout time.Duration) error {
 	type setDeadliner interface {
 		SetDeadline(time.Time) error
@@ -227,16 +660,18 @@ func (s *Service) refreshTimeout(timeout time.Duration) error {
 	return nil
 }
 
-// Bind binds the service to an address.
+// Bind binds the service to an address: "unix:path", "tcp:host:port",
+// "tls:host:port", "vsock:cid:port", where an empty cid listens on all of them,
+// or "npipe:name" for the Windows named pipe \\.\pipe\name.
 func (s *Service) Bind(ctx context.Context, address string) error {
-	s.mutex.Lock()
-	if s.running {
-		s.mutex.Unlock()
-		return fmt.Errorf("Init(): already running")
+	if err := s.checkBindable(); err != nil {
+		return err
 	}
-	s.mutex.Unlock()
 
 	s.parseAddress(address)
+	if s.protocol == "tls" && s.tlsConfig == nil {
+		return fmt.Errorf("TLS address without ServiceConfig.TLSConfig")
+	}
 
 	err := s.setListener(ctx)
 	if err != nil {
@@ -245,30 +680,110 @@ func (s *Service) Bind(ctx context.Context, address string) error {
 	return nil
 }
 
+func (s *Service) checkBindable() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	switch s.state {
+	case serviceRunning, serviceStopping:
+		return fmt.Errorf("Init(): already running")
+	case serviceStopped:
+		return ServiceStoppedError{}
+	}
+	return nil
+}
+
+// RunActivated serves the sockets passed with socket activation which have the
+// name, the FileDescriptorName= of their systemd socket units, like Listen. The
+// empty name selects the sockets Listen serves instead of its address.
+func (s *Service) RunActivated(ctx context.Context, name string, timeout time.Duration) error {
+	if err := s.bindActivated(name); err != nil {
+		s.teardown()
+		return err
+	}
+	return s.DoListen(ctx, timeout)
+}
+
+func (s *Service) bindActivated(name string) error {
+	if err := s.checkBindable(); err != nil {
+		return err
+	}
+
+	l := activationListener(name, s.warn)
+	if l == nil {
+		return fmt.Errorf("no activation socket named %q", name)
+	}
+	s.setBound(l)
+	return nil
+}
+
+// Serve serves the connections accepted by the listener, like Listen. The
+// listener is closed when it returns.
+func (s *Service) Serve(ctx context.Context, l net.Listener) error {
+	if err := s.checkBindable(); err != nil {
+		return err
+	}
+	s.setBound(l)
+	return s.DoListen(ctx, 0)
+}
+
+func (s *Service) setBound(l net.Listener) {
+	s.mutex.Lock()
+	s.protocol = l.Addr().Network()
+	s.address = l.Addr().String()
+	s.listener = l
+	s.mutex.Unlock()
+}
+
 // Listen starts a Service.
 func (s *Service) Listen(ctx context.Context, address string, timeout time.Duration) error {
+	if err := s.Bind(ctx, address); err != nil {
+		s.teardown()
+		return err
+	}
+	return s.DoListen(ctx, timeout)
+}
+
+// DoListen starts a Service. Under a systemd service manager with NOTIFY_SOCKET
+// set, it notifies READY=1 once the interfaces are ready, and pets the watchdog
+// configured with WatchdogSec= at half its interval, until it returns.
+func (s *Service) DoListen(ctx context.Context, timeout time.Duration) error {
 	var wg sync.WaitGroup
-	defer func() { s.teardown(); wg.Wait() }()
+	defer func() { wg.Wait(); s.teardown() }()
 
-	err := s.Bind(ctx, address)
+	connCtx, readCtx, err := s.start(ctx)
 	if err != nil {
 		return err
 	}
 
 	s.mutex.Lock()
-	s.running = true
 	l := s.listener
 	s.mutex.Unlock()
+	connCtx = s.baseContext(connCtx, l)
 
-	for s.running {
+	readyCtx, cancelReady := context.WithCancel(ctx)
+	defer cancelReady()
+	go s.notifyReady(readyCtx)
+	go s.watchdog(readyCtx)
+
+	slots := s.connectionSlots()
+	for !s.stopping() {
+		s.waitReexec()
 		if timeout != 0 {
 			if err := s.refreshTimeout(timeout); err != nil {
 				return err
 			}
 		}
+		release, ok := acquireSlot(readCtx, slots)
+		if !ok {
+			break
+		}
 		conn, err := l.Accept()
 		if err != nil {
-			if err.(net.Error).Timeout() {
+			release()
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				if s.waitReexec() || timeout == 0 {
+					continue
+				}
 				s.mutex.Lock()
 				if s.conncounter == 0 {
 					s.mutex.Unlock()
@@ -277,8 +792,8 @@ func (s *Service) Listen(ctx context.Context, address string, timeout time.Durat
 				s.mutex.Unlock()
 				continue
 			}
-			if !s.running {
-				return nil
+			if s.stopping() {
+				return s.readinessError()
 			}
 			return err
 		}
@@ -286,89 +801,201 @@ func (s *Service) Listen(ctx context.Context, address string, timeout time.Durat
 		s.conncounter++
 		s.mutex.Unlock()
 		wg.Add(1)
-		go s.handleConnection(ctx, conn, &wg)
+		go func() {
+			defer release()
+			s.handleConnection(connCtx, readCtx, l, conn, &wg)
+		}()
 	}
 
-	return nil
+	return s.readinessError()
 }
 
-// DoListen starts a Service.
-func (s *Service) DoListen(ctx context.Context, timeout time.Duration) error {
-	var wg sync.WaitGroup
-	defer func() { s.teardown(); wg.Wait() }()
-
-	s.mutex.Lock()
-	l := s.listener
-	s.mutex.Unlock()
+// RegisterInterface registers a varlink.Interface containing struct to the Service.
+// The interface must have a name, and a valid description declaring the
+// interface of that name. Interfaces can be registered while the service runs; GetInfo lists
+// them right away, and the VarlinkReady of an interface implementing Readiness
+// is called in the background.
+func (s *Service) RegisterInterface(iface dispatcher) error {
+	if isNil(iface) {
+		return fmt.Errorf("nil interface")
+	}
 
-	if l == nil {
-		return fmt.Errorf("No listener set")
+	name := iface.VarlinkGetName()
+	if name == "" {
+		return fmt.Errorf("interface without a name")
+	}
+	description := iface.VarlinkGetDescription()
+	if err := checkDescription(name, description); err != nil {
+		return err
+	}
+	stored, err := storeDescription(description)
+	if err != nil {
+		return err
 	}
 
 	s.mutex.Lock()
-	s.running = true
-	s.mutex.Unlock()
+	defer s.mutex.Unlock()
+	r := s.snapshot()
+	if _, ok := r.interfaces[name]; ok {
+		stored.release()
+		return fmt.Errorf("interface '%s' already registered", name)
+	}
 
-	for s.running {
-		if timeout != 0 {
-			if err := s.refreshTimeout(timeout); err != nil {
-				return err
-			}
+	r = r.copy()
+	r.interfaces[name] = iface
+	r.descriptions[name] = stored
+	r.names = append(r.names, name)
+	if _, ok := implementation(iface).(Readiness); ok {
+		if s.pending == nil {
+			s.pending = make(map[string]bool)
 		}
-		conn, err := l.Accept()
-		if err != nil {
-			if err.(net.Error).Timeout() {
-				s.mutex.Lock()
-				if s.conncounter == 0 {
-					s.mutex.Unlock()
-					return ServiceTimeoutError{}
-				}
-				s.mutex.Unlock()
-				continue
-			}
-			if !s.running {
-				return nil
-			}
-			return err
+		s.pending[name] = true
+		// WaitReady only waits for the interfaces registered before the start
+		if s.state == serviceRunning {
+			go s.awaitReady(s.running, name)
 		}
-		s.mutex.Lock()
-		s.conncounter++
-		s.mutex.Unlock()
-		wg.Add(1)
-		go s.handleConnection(ctx, conn, &wg)
 	}
+	s.publish(r)
 
 	return nil
 }
 
-// RegisterInterface registers a varlink.Interface containing struct to the Service
-func (s *Service) RegisterInterface(iface dispatcher) error {
-	name := iface.VarlinkGetName()
-	if _, ok := s.interfaces[name]; ok {
-		return fmt.Errorf("interface '%s' already registered", name)
+// UnregisterInterface removes the registered interface of the name, while the
+// service runs as well. New calls of the interface are answered with
+// org.varlink.service.InterfaceNotFound; the calls already dispatched to it are
+// handled to their end. The authorizers set with Authorize for the interface
+// are kept for an interface registered again under the name.
+func (s *Service) UnregisterInterface(name string) error {
+	if name == "org.varlink.service" {
+		return fmt.Errorf("interface '%s' cannot be unregistered", name)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	r := s.snapshot()
+	if _, ok := r.interfaces[name]; !ok {
+		return fmt.Errorf("interface '%s' not registered", name)
 	}
 
-	if s.running {
-		return fmt.Errorf("service is already running")
+	r = r.copy()
+	r.descriptions[name].release()
+	delete(r.interfaces, name)
+	delete(r.descriptions, name)
+	for i, n := range r.names {
+		if n == name {
+			r.names = append(r.names[:i], r.names[i+1:]...)
+			break
+		}
 	}
-	s.interfaces[name] = iface
-	s.descriptions[name] = iface.VarlinkGetDescription()
-	s.names = append(s.names, name)
+	delete(s.pending, name)
+	s.publish(r)
+
+	return nil
+}
 
+// checkDescription checks that the description is valid varlink IDL declaring
+// the named interface.
+func checkDescription(name string, description string) error {
+	if strings.TrimSpace(description) == "" {
+		return fmt.Errorf("interface '%s': empty description", name)
+	}
+	declared := interfaceRegexp.FindString(description)
+	if declared == "" {
+		return fmt.Errorf("interface '%s': description without an interface declaration", name)
+	}
+	if declared = strings.TrimSpace(declared[len("interface"):]); declared != name {
+		return fmt.Errorf("interface '%s': description declares interface '%s'", name, declared)
+	}
+	if _, err := idl.New(description); err != nil {
+		return fmt.Errorf("interface '%s': invalid description: %v", name, err)
+	}
 	return nil
 }
 
+// isNil returns whether the interface is nil or holds a nil pointer.
+func isNil(iface dispatcher) bool {
+	if iface == nil {
+		return true
+	}
+	v := reflect.ValueOf(iface)
+	return v.Kind() == reflect.Ptr && v.IsNil()
+}
+
 // NewService creates a new Service which implements the list of given varlink interfaces.
 func NewService(vendor string, product string, version string, url string) (*Service, error) {
+	return NewServiceWithConfig(vendor, product, version, url, ServiceConfig{})
+}
+
+// NewServiceWithConfig creates a new Service with the given optional settings.
+func NewServiceWithConfig(vendor string, product string, version string, url string, config ServiceConfig) (*Service, error) {
 	s := Service{
-		vendor:       vendor,
-		product:      product,
-		version:      version,
-		url:          url,
-		interfaces:   make(map[string]dispatcher),
-		descriptions: make(map[string]string),
+		vendor:    vendor,
+		product:   product,
+		version:   version,
+		url:       url,
+		config:    config,
+		tlsConfig: serverTLSConfig(config.TLSConfig),
+		details:   newParameterDetails(config),
+	}
+	s.memoryUsage = heapAlloc
+	if len(config.WorkerPools) > 0 {
+		s.pools = make(map[string]*workerPool, len(config.WorkerPools))
+		for name, size := range config.WorkerPools {
+			s.pools[name] = newWorkerPool(size)
+		}
+	}
+	if config.Accounting || config.AccountAllocations {
+		s.accounting = newAccounting(config.AccountAllocations)
+	}
+	if config.HandlerWatchdog != nil {
+		s.stuckCalls = newHandlerWatchdog(*config.HandlerWatchdog)
 	}
+	if config.MaxCalls > 0 || config.TenantLimits != nil || config.AdaptiveLimit != nil {
+		s.scheduler = newScheduler(config.MaxCalls, config.TenantLimits)
+	}
+	if config.AdaptiveLimit != nil {
+		s.scheduler.limit = newAdaptiveLimit(*config.AdaptiveLimit)
+		s.scheduler.max = s.scheduler.limit.current()
+	}
+	s.publish(newRegistry())
 	err := s.RegisterInterface(orgvarlinkserviceNew())
+	if err != nil {
+		return nil, err
+	}
+	for _, iface := range []dispatcher{orgvarlinkinternalNew(), orgvarlinkstreamNew()} {
+		if err := s.RegisterInterface(iface); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.RateLimit != nil {
+		if err := s.RegisterInterface(orgvarlinkratelimitNew()); err != nil {
+			return nil, err
+		}
+	}
+	if config.ParameterDetails {
+		if err := s.RegisterInterface(orgvarlinkparametersNew()); err != nil {
+			return nil, err
+		}
+	}
+
+	extensions := config.Extensions
+	if config.CompressDescriptions {
+		extensions = append(extensions[:len(extensions):len(extensions)], Extension{
+			Name:     CompressedDescriptions,
+			Versions: []int{1},
+		})
+	}
+	if extensions != nil {
+		e, err := newExtensionsInterface(extensions)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.RegisterInterface(e); err != nil {
+			return nil, err
+		}
+		s.negotiates = true
+	}
 
-	return &s, err
+	return &s, nil
 }
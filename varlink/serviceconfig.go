@@ -0,0 +1,208 @@
+package varlink
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"time"
+
+	"github.com/varlink/go/varlink/capture"
+)
+
+// ServiceConfig holds the optional settings of a Service. The zero value is a
+// valid configuration, which results in the default behavior.
+type ServiceConfig struct {
+	// TenantFunc returns the tenant of the caller of a method call. If set, the
+	// interfaces registered with RegisterTenantInterface are only visible to the
+	// callers of the same tenant.
+	TenantFunc func(ctx context.Context, c *Call) string
+
+	// MaxCalls limits the number of concurrently dispatched method calls. Free
+	// dispatch slots are handed out to the waiting calls by weighted fair queuing
+	// across tenants, so one busy tenant cannot monopolize the service. Zero means
+	// no limit.
+	MaxCalls int
+
+	// AdaptiveLimit adjusts the limit of concurrently dispatched calls to the
+	// observed latency, instead of the fixed MaxCalls.
+	AdaptiveLimit *AdaptiveLimit
+
+	// TenantLimits returns the scheduling weight and the quota of a tenant.
+	TenantLimits func(tenant string) TenantLimits
+
+	// WorkerPools bounds the number of concurrently running calls of the listed
+	// interfaces, like CPU-bound ones, so they cannot starve the other calls and
+	// the reading of the connections. A size of zero or less means the number of
+	// usable cores, GOMAXPROCS. Calls of other interfaces are not bounded.
+	WorkerPools map[string]int
+
+	// MaxConnections limits the number of open connections. At the limit, a new
+	// connection replaces the idle connection with the lowest priority, if that is
+	// lower than its own, or is handled as ConnectionLimit decides. Zero means no
+	// limit.
+	MaxConnections int
+
+	// ConnectionLimit decides what happens to the new connections at the limit,
+	// the zero value closes them right away.
+	ConnectionLimit ConnectionLimitPolicy
+
+	// MaxMemory limits the heap memory of the process, the HeapAlloc of
+	// runtime.MemStats, in bytes. Over the limit, a new connection replaces the
+	// idle connection with the lowest priority, like at MaxConnections, or is
+	// refused; ReplyAtLimit answers its first call, otherwise it is closed.
+	// Zero means no limit.
+	MaxMemory uint64
+
+	// ConnectionPriority returns the priority of a new connection, like a high
+	// priority for the operators' sessions found with PeerProcess. If nil, all
+	// connections have the priority zero.
+	ConnectionPriority func(conn net.Conn) int
+
+	// UseNumber lets Call.GetParameters decode numbers in untyped parameters, like
+	// interface{} or map[string]interface{} values, as json.Number instead of
+	// float64, which cannot represent varlink ints exactly beyond 2^53.
+	UseNumber bool
+
+	// ParameterDetails lets Call.ReplyParameterError, which generated services
+	// call for parameters of the wrong type, reply org.varlink.parameters.Invalid
+	// with the received value and the expected type, instead of the
+	// InvalidParameter of org.varlink.service. The values of the parameters
+	// named in RedactParameters, like passwords, are never echoed.
+	ParameterDetails bool
+	RedactParameters []string
+
+	// FlushPolicy decides when the replies of the method calls are written to
+	// the connection; methods override it with Call.SetFlushPolicy. The zero
+	// value writes every reply right away.
+	FlushPolicy FlushPolicy
+
+	// StreamTimeout limits the time a reply with the continues flag waits for
+	// the client to take it; the reply fails with StreamBlocked, the connection
+	// stays open, so a streaming method can slow down or end the stream instead
+	// of blocking until WriteTimeout closes the connection. Methods override it
+	// with Call.SetStreamTimeout. Zero means no limit.
+	StreamTimeout time.Duration
+
+	// Capture records the messages of all connections, for the analysis of
+	// protocol issues with "varlink decode".
+	Capture *capture.Writer
+
+	// OtherProtocol receives the connections whose first byte does not start a
+	// varlink message, like HTTP requests, with the first bytes still to be read.
+	// It owns the connection and should pass it on quickly, like
+	// ProtocolListener.Handoff; the service waits for it on shutdown.
+	OtherProtocol func(conn net.Conn)
+
+	// BaseContext returns the context whose values the calls of the connections
+	// accepted by the listener see, like BaseContext of net/http.Server; they
+	// are still canceled with the context passed to Listen. It must not
+	// return nil.
+	BaseContext func(l net.Listener) context.Context
+
+	// ConnContext returns the context of the calls of a new connection, derived
+	// from the base context, like ConnContext of net/http.Server.
+	ConnContext func(ctx context.Context, conn net.Conn) context.Context
+
+	// Visible returns whether the interface is reachable through the listener,
+	// like an administration interface only on a unix socket; nil makes all
+	// interfaces reachable everywhere. The hidden interfaces are answered with
+	// InterfaceNotFound and left out of GetInfo. The connections of ServeConn
+	// and the messages of HandleMessage were not accepted by a listener, their
+	// calls are checked with a nil listener.
+	Visible func(l net.Listener, iface string) bool
+
+	// MaintenanceAllowed lists the methods, like org.example.admin.Migrate, and
+	// the interfaces which stay available while the service is in maintenance,
+	// see Service.SetMaintenance.
+	MaintenanceAllowed []string
+
+	// ConcurrentCalls is the number of calls of a connection handled at once;
+	// zero or one handles them one after the other. The replies are still sent
+	// in the order of the calls; of a call waiting for the calls before it to
+	// end, the first reply is buffered and the following ones wait. Calls
+	// upgrading the connection wait for the calls before them. The contexts
+	// returned by Call.Context are canceled when the client disconnects while
+	// the connection is read, which pauses while the limit of calls is reached.
+	ConcurrentCalls int
+
+	// Warnings is called with the conditions the service handles on its own,
+	// which are worth logging, like a stale socket it removed. It must not
+	// block, it is called from the goroutines of the connections. Without it,
+	// the panics of handlers are written to the standard logger.
+	Warnings func(w Warning)
+
+	// MaxMessageSize limits the size of requests; zero means no limit. The
+	// connection sending a larger request is closed.
+	MaxMessageSize int
+
+	// ReadTimeout limits the time to read a request, WriteTimeout the time to
+	// write a reply; the connection is closed when they pass, so dead clients
+	// and clients not reading their replies do not hold it forever. IdleTimeout
+	// limits the time waiting for the next request while no call is in
+	// progress; if it is zero, ReadTimeout includes that wait. The timeouts
+	// apply to the reads and writes of the upgraded connections as well. Zero
+	// means no limit.
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	IdleTimeout  time.Duration
+
+	// TLSConfig serves all connections over TLS. The service offers the varlink
+	// ALPN protocol in addition to the NextProtos of the configuration; connections
+	// negotiating one of the others are passed to OtherProtocol, so one endpoint can
+	// serve browsers and varlink clients. It is required by "tls:host:port"
+	// addresses, which listen on TCP.
+	TLSConfig *tls.Config
+
+	// Accounting measures the wall time and the goroutines left running of every
+	// method call, reported per method by WriteStats and Usage. AccountAllocations
+	// measures the allocated bytes as well, which briefly stops the world twice
+	// per call. The goroutines and allocations are process-wide, concurrent calls
+	// are charged for each other's usage.
+	Accounting         bool
+	AccountAllocations bool
+
+	// HandlerWatchdog reports the calls running much longer than the calls of their
+	// method used to as HandlerStuck warnings, and optionally cancels them.
+	HandlerWatchdog *HandlerWatchdog
+
+	// AccessLog is called with every completed method call, to log the calls in
+	// any format. It must not block, it is called from the goroutines of the
+	// connections.
+	AccessLog func(e AccessLogEntry)
+
+	// Metrics receives the method calls, the messages and the connections of
+	// the service, for counters and latency histograms.
+	Metrics MetricsCollector
+
+	// Stats receives a CallCompleted event for every dispatched method call,
+	// like DialConfig.Stats does for the calls of a client.
+	Stats StatsHandler
+
+	// PriorityBoost handles the calls of high-priority callers with a raised
+	// scheduling priority.
+	PriorityBoost *PriorityBoost
+
+	// RateLimit limits the rate of the calls of each connection, or of each
+	// user.
+	RateLimit *RateLimit
+
+	// Tracer starts a span for every method call, continuing the trace context
+	// the client sent in the metadata of the call.
+	Tracer Tracer
+
+	// Authorizer is consulted before every call of the registered interfaces is
+	// dispatched, along with the authorizers set with Service.Authorize; a call
+	// it rejects is answered with org.varlink.service.PermissionDenied.
+	Authorizer Authorizer
+
+	// CompressDescriptions offers the CompressedDescriptions extension, so large
+	// interface descriptions are sent gzip-compressed to the clients which
+	// negotiated it.
+	CompressDescriptions bool
+
+	// Extensions are the optional protocol features the service supports. They
+	// are offered with the org.varlink.extensions interface, clients agree on
+	// them per connection with Connection.Negotiate and handlers check them with
+	// Call.Extension. Connections which did not negotiate speak baseline varlink.
+	Extensions []Extension
+}
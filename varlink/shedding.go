@@ -0,0 +1,170 @@
+package varlink
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"runtime"
+	"time"
+)
+
+// trackedConn is a connection counted against ServiceConfig.MaxConnections, or
+// shed over ServiceConfig.MaxMemory.
+type trackedConn struct {
+	conn     net.Conn
+	priority int
+	// busy is the number of calls in progress
+	busy   int
+	active time.Time
+}
+
+// ConnectionLimitPolicy decides what happens to new connections at the limit of
+// ServiceConfig.MaxConnections.
+type ConnectionLimitPolicy int
+
+const (
+	// CloseAtLimit closes a new connection which does not replace an idle one.
+	CloseAtLimit ConnectionLimitPolicy = iota
+
+	// ReplyAtLimit answers the first call of a new connection which does not
+	// replace an idle one with org.varlink.service.ServiceNotAvailable, and
+	// closes it, so the client can tell the service is overloaded.
+	ReplyAtLimit
+
+	// WaitAtLimit stops accepting connections until one is closed, the new
+	// connections wait in the backlog of the socket. No connection is replaced.
+	WaitAtLimit
+)
+
+// connectionSlots returns the slots of the connections, if the service waits
+// at the connection limit.
+func (s *Service) connectionSlots() chan struct{} {
+	if s.config.MaxConnections <= 0 || s.config.ConnectionLimit != WaitAtLimit {
+		return nil
+	}
+	return make(chan struct{}, s.config.MaxConnections)
+}
+
+// acquireSlot waits for a free slot, and returns the function releasing it, or
+// false if the context is done first.
+func acquireSlot(ctx context.Context, slots chan struct{}) (func(), bool) {
+	if slots == nil {
+		return func() {}, true
+	}
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, true
+	case <-ctx.Done():
+		return nil, false
+	}
+}
+
+// refuse answers the first call of a connection refused at the limit.
+func (s *Service) refuse(ctx context.Context, conn net.Conn) {
+	ctx, cancel := context.WithTimeout(ctx, handshakeTimeout)
+	defer cancel()
+
+	c, isVarlink, err := s.route(ctx, conn)
+	if err != nil || !isVarlink {
+		return
+	}
+	request, err := c.ReadBytes(ctx, '\x00')
+	if err != nil {
+		return
+	}
+	var in serviceCall
+	if json.Unmarshal(request[:len(request)-1], &in) != nil || in.Oneway {
+		return
+	}
+	b, _ := json.Marshal(&serviceReply{Error: "org.varlink.service.ServiceNotAvailable", Parameters: &ServiceNotAvailable{}})
+	c.Write(ctx, append(b, 0))
+}
+
+// heapAlloc returns the bytes of the allocated heap objects of the process.
+func heapAlloc() uint64 {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return m.HeapAlloc
+}
+
+// admit decides whether a new connection is served. At the connection limit,
+// or over the memory limit, the idle connection with the lowest priority is
+// closed to make room, if its priority is lower than the new connection's;
+// otherwise the new connection is refused, so a flood of connections cannot
+// push out the idle clients. Of the idle connections with the same priority,
+// the one idle for the longest time is closed.
+func (s *Service) admit(conn net.Conn) (*trackedConn, bool) {
+	counted := s.config.MaxConnections > 0 && s.config.ConnectionLimit != WaitAtLimit
+	if !counted && s.config.MaxMemory == 0 {
+		return nil, true
+	}
+
+	t := &trackedConn{conn: conn, active: time.Now()}
+	if s.config.ConnectionPriority != nil {
+		t.priority = s.config.ConnectionPriority(conn)
+	}
+	overMemory := s.config.MaxMemory > 0 && s.memoryUsage() > s.config.MaxMemory
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.connections == nil {
+		s.connections = make(map[*trackedConn]struct{})
+	}
+
+	if overMemory || (counted && len(s.connections) >= s.config.MaxConnections) {
+		var victim *trackedConn
+		for c := range s.connections {
+			if c.busy > 0 || c.priority >= t.priority {
+				continue
+			}
+			if victim == nil || c.priority < victim.priority ||
+				(c.priority == victim.priority && c.active.Before(victim.active)) {
+				victim = c
+			}
+		}
+		if victim == nil {
+			return nil, false
+		}
+
+		delete(s.connections, victim)
+		victim.conn.Close()
+	}
+
+	s.connections[t] = struct{}{}
+	return t, true
+}
+
+func (s *Service) forget(t *trackedConn) {
+	if t == nil {
+		return
+	}
+	s.mutex.Lock()
+	delete(s.connections, t)
+	s.mutex.Unlock()
+}
+
+// setBusy marks a connection as handling a call, busy connections are not shed.
+func (s *Service) setBusy(t *trackedConn, busy bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if busy {
+		s.busy++
+	} else {
+		s.busy--
+	}
+	if t != nil {
+		if busy {
+			t.busy++
+		} else {
+			t.busy--
+		}
+		t.active = time.Now()
+	}
+}
+
+// busyCalls returns the number of connections handling a call.
+func (s *Service) busyCalls() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.busy
+}
@@ -0,0 +1,14 @@
+// +build !windows,!js
+
+package varlink
+
+import (
+	"os"
+	"syscall"
+)
+
+var (
+	shutdownSignals = []os.Signal{syscall.SIGTERM, syscall.SIGINT}
+	reloadSignals   = []os.Signal{syscall.SIGHUP}
+	statsSignals    = []os.Signal{syscall.SIGUSR1}
+)
@@ -0,0 +1,15 @@
+// +build windows js
+
+package varlink
+
+import (
+	"os"
+	"syscall"
+)
+
+// Windows and js deliver no reload or stats signals.
+var (
+	shutdownSignals = []os.Signal{syscall.SIGTERM, os.Interrupt}
+	reloadSignals   []os.Signal
+	statsSignals    []os.Signal
+)
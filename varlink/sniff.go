@@ -0,0 +1,78 @@
+package varlink
+
+import (
+	"errors"
+	"net"
+	"sync"
+)
+
+// isVarlink returns whether the first byte received on a connection starts a
+// varlink message, a JSON object.
+func isVarlink(b byte) bool {
+	switch b {
+	case '{', ' ', '\t', '\r', '\n':
+		return true
+	}
+	return false
+}
+
+// ProtocolListener is a net.Listener for the connections a Service hands off
+// because the client does not speak varlink, so one socket can serve varlink
+// and another protocol, like HTTP:
+//
+//	l := varlink.NewProtocolListener(nil)
+//	service, _ := varlink.NewServiceWithConfig(vendor, product, version, url,
+//		varlink.ServiceConfig{OtherProtocol: l.Handoff})
+//	go http.Serve(l, handler)
+//
+// Only protocols where the client sends first can be detected.
+type ProtocolListener struct {
+	addr  net.Addr
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+}
+
+// NewProtocolListener returns a listener reporting the address; nil is a
+// placeholder address.
+func NewProtocolListener(addr net.Addr) *ProtocolListener {
+	if addr == nil {
+		addr = &net.UnixAddr{Name: "varlink", Net: "unix"}
+	}
+	return &ProtocolListener{
+		addr:  addr,
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+}
+
+// Handoff passes a connection to Accept. It waits until the connection is
+// accepted and closes it if the listener is closed.
+func (l *ProtocolListener) Handoff(conn net.Conn) {
+	select {
+	case l.conns <- conn:
+	case <-l.done:
+		conn.Close()
+	}
+}
+
+// Accept waits for the next handed off connection.
+func (l *ProtocolListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, errors.New("varlink: protocol listener closed")
+	}
+}
+
+// Close stops accepting connections.
+func (l *ProtocolListener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return nil
+}
+
+// Addr returns the address of the listener.
+func (l *ProtocolListener) Addr() net.Addr {
+	return l.addr
+}
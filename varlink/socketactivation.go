@@ -3,61 +3,158 @@
 package varlink
 
 import (
+	"fmt"
 	"net"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 )
 
-func activationListener() net.Listener {
-	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
-	if err != nil || pid != os.Getpid() {
-		return nil
+// The file descriptors passed by the service manager, kept open for the
+// following activation listeners.
+var activation struct {
+	mutex sync.Mutex
+	files map[int]*os.File
+}
+
+// activationFiles returns the sockets passed with socket activation, and their
+// names if the service manager passed them. The error describes the variables
+// which could not be used.
+func activationFiles() ([]*os.File, []string, error) {
+	value, set := os.LookupEnv("LISTEN_PID")
+	if !set {
+		return nil, nil, nil
+	}
+	pid, err := strconv.Atoi(value)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid LISTEN_PID %q", value)
+	}
+	// The variables were meant for another process
+	if pid != os.Getpid() {
+		return nil, nil, nil
 	}
 
-	nfds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	value = os.Getenv("LISTEN_FDS")
+	nfds, err := strconv.Atoi(value)
 	if err != nil || nfds < 1 {
-		return nil
+		return nil, nil, fmt.Errorf("invalid LISTEN_FDS %q", value)
 	}
 
-	fd := -1
-
-	// If more than one file descriptor is passed, find the
-	// "varlink" tag. The first file descriptor is always 3.
-	if nfds > 1 {
-		fdnames, set := os.LookupEnv("LISTEN_FDNAMES")
-		if !set {
-			return nil
+	var names []string
+	if fdnames, set := os.LookupEnv("LISTEN_FDNAMES"); set {
+		names = strings.Split(fdnames, ":")
+		if len(names) != nfds {
+			names = nil
+			err = fmt.Errorf("LISTEN_FDNAMES %q does not name %d sockets", fdnames, nfds)
 		}
+	}
 
-		names := strings.Split(fdnames, ":")
-		if len(names) != nfds {
-			return nil
+	activation.mutex.Lock()
+	defer activation.mutex.Unlock()
+	if activation.files == nil {
+		activation.files = make(map[int]*os.File)
+	}
+
+	// The first file descriptor is always 3
+	files := make([]*os.File, nfds)
+	for i := range files {
+		fd := 3 + i
+		file, ok := activation.files[fd]
+		if !ok {
+			syscall.CloseOnExec(fd)
+			file = os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd))
+			activation.files[fd] = file
 		}
+		files[i] = file
+	}
+	return files, names, err
+}
+
+// ActivationListeners returns listeners for all sockets passed by the service
+// manager with socket activation, in the order they were passed. Sockets which
+// do not accept connections are left out. Listen serves all of them, unless
+// some are named "varlink".
+func ActivationListeners() []net.Listener {
+	files, _, _ := activationFiles()
+	return fileListeners(files, nil)
+}
 
-		for i, name := range names {
-			if name == "varlink" {
-				fd = 3 + i
-				break
+// ActivationNames returns the names of the sockets passed with socket
+// activation, the FileDescriptorName= of their systemd socket units, in the
+// order they were passed. It returns nil if the service manager passed no names.
+func ActivationNames() []string {
+	_, names, _ := activationFiles()
+	return names
+}
+
+func fileListeners(files []*os.File, warn func(WarningKind, error)) []net.Listener {
+	var listeners []net.Listener
+	for _, file := range files {
+		listener, err := net.FileListener(file)
+		if err != nil {
+			if warn != nil {
+				warn(InvalidActivation, fmt.Errorf("%s: %v", file.Name(), err))
 			}
+			continue
 		}
+		listeners = append(listeners, listener)
+	}
+	return listeners
+}
 
-		if fd < 0 {
-			return nil
+// activationListener returns the listener of the sockets with the name. The
+// empty name selects the sockets named "varlink", or all sockets.
+func activationListener(name string, warn func(WarningKind, error)) net.Listener {
+	files, names, err := activationFiles()
+	if err != nil {
+		warn(InvalidActivation, err)
+	}
+
+	all := name == ""
+	if all {
+		name = "varlink"
+	}
+	var named []*os.File
+	for i, n := range names {
+		if n == name {
+			named = append(named, files[i])
 		}
+	}
+	if len(named) > 0 || !all {
+		files = named
+	}
+
+	listeners := fileListeners(files, warn)
+	switch len(listeners) {
+	case 0:
+		return nil
+	case 1:
+		return listeners[0]
+	default:
+		return newMultiListener(listeners)
+	}
+}
 
-	} else {
-		fd = 3
+// notify sends a state update to the service manager, if it asked for it.
+func notify(state string) error {
+	name := os.Getenv("NOTIFY_SOCKET")
+	if name == "" {
+		return nil
 	}
 
-	syscall.CloseOnExec(fd)
+	// Abstract namespace socket
+	if name[0] == '@' {
+		name = "\x00" + name[1:]
+	}
 
-	file := os.NewFile(uintptr(fd), "varlink")
-	listener, err := net.FileListener(file)
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: name, Net: "unixgram"})
 	if err != nil {
-		return nil
+		return err
 	}
+	defer conn.Close()
 
-	return listener
+	_, err = conn.Write([]byte(state))
+	return err
 }
@@ -2,6 +2,20 @@ package varlink
 
 import "net"
 
-func activationListener() net.Listener {
+// ActivationListeners returns nil, there is no socket activation on Windows.
+func ActivationListeners() []net.Listener {
+	return nil
+}
+
+// ActivationNames returns nil, there is no socket activation on Windows.
+func ActivationNames() []string {
+	return nil
+}
+
+func activationListener(name string, warn func(WarningKind, error)) net.Listener {
+	return nil
+}
+
+func notify(state string) error {
 	return nil
 }
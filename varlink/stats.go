@@ -0,0 +1,73 @@
+package varlink
+
+import (
+	"fmt"
+	"time"
+)
+
+// StatsKind is the kind of event a Stats reports.
+type StatsKind int
+
+const (
+	// DialAttempt reports one attempt of a client to connect to the service;
+	// Err is the reason it failed, Duration the time it took.
+	DialAttempt StatsKind = iota + 1
+	// Reconnected reports a Subscription connected again after its connection
+	// failed.
+	Reconnected
+	// CallCompleted reports the end of a method call, with the time from
+	// sending the call to its last reply on the client and the time spent in
+	// the method handler on the service. Err is the error of the call on the
+	// client, and the error returned by the handler on the service.
+	CallCompleted
+	// StreamReply reports a reply of a client call with the More flag, Duration
+	// is the time since the previous reply, or since the call for the first one.
+	StreamReply
+)
+
+func (k StatsKind) String() string {
+	switch k {
+	case DialAttempt:
+		return "dial attempt"
+	case Reconnected:
+		return "reconnected"
+	case CallCompleted:
+		return "call completed"
+	case StreamReply:
+		return "stream reply"
+	}
+	return fmt.Sprintf("StatsKind(%d)", int(k))
+}
+
+// Stats is an event of a client or a service, passed to a StatsHandler.
+type Stats struct {
+	Kind StatsKind
+	// Address is the address of the service on the client side.
+	Address string
+	// Method is the fully-qualified method of the call events.
+	Method   string
+	Duration time.Duration
+	Err      error
+}
+
+// StatsHandler receives the events of the connections of a client, set with
+// DialConfig.Stats and SubscriptionConfig.Stats, and of the calls of a service,
+// set with ServiceConfig.Stats, so metrics of both are gathered the same way.
+// It must not block, it is called from the goroutines making the calls.
+type StatsHandler interface {
+	HandleStats(s Stats)
+}
+
+// StatsFunc is a function used as a StatsHandler.
+type StatsFunc func(s Stats)
+
+// HandleStats calls f(s).
+func (f StatsFunc) HandleStats(s Stats) {
+	f(s)
+}
+
+func handleStats(h StatsHandler, s Stats) {
+	if h != nil {
+		h.HandleStats(s)
+	}
+}
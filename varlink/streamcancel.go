@@ -0,0 +1,85 @@
+package varlink
+
+import "encoding/json"
+
+// The reasons of StreamCanceled.
+const (
+	// CanceledShutdown ends the streams of a service shutting down.
+	CanceledShutdown = "shutdown"
+	// CanceledPolicy ends a stream the service decided to stop, like one whose
+	// caller lost its permission.
+	CanceledPolicy = "policy"
+	// CanceledError ends a stream whose handler failed.
+	CanceledError = "error"
+)
+
+// The service ended the stream of replies before its final reply, for the
+// reason. Instead of closing the connection after the replies with the
+// continues flag sent, the service ends the stream with this error reply, so
+// the client can tell it apart from the end of the stream and from a failed
+// connection. A handler returning a StreamCanceled error ends the stream with it
+// and keeps the connection open, like with CanceledPolicy.
+type StreamCanceled struct {
+	Reason string `json:"reason"`
+}
+
+func (e StreamCanceled) Error() string {
+	return "org.varlink.stream.Canceled"
+}
+
+// orgvarlinkstreamNew returns the interface declaring StreamCanceled, which
+// every service registers.
+func orgvarlinkstreamNew() *errorsInterface {
+	return &errorsInterface{
+		name: "org.varlink.stream",
+		description: `# Errors of the replies of calls with more replies.
+interface org.varlink.stream
+
+# The service ended the replies of the call before its final reply, for the
+# reason: shutdown, policy or error.
+error Canceled (reason: string)`,
+	}
+}
+
+// streamContinued reports whether a reply with the continues flag was sent.
+func (s *callState) streamContinued() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.continued
+}
+
+// cancelStream ends the stream of the call, which returned with the error
+// without its final reply, with a StreamCanceled error reply. It returns the
+// error of the call: nil if the handler returned a StreamCanceled itself.
+func (s *Service) cancelStream(c *Call, err error) error {
+	s.mutex.Lock()
+	shutdown := s.state == serviceStopping || s.state == serviceStopped
+	s.mutex.Unlock()
+
+	canceled, ok := err.(*StreamCanceled)
+	switch {
+	case ok:
+		err = nil
+	case shutdown:
+		canceled = &StreamCanceled{Reason: CanceledShutdown}
+	default:
+		canceled = &StreamCanceled{Reason: CanceledError}
+	}
+
+	b, merr := json.Marshal(&serviceReply{Error: canceled.Error(), Parameters: canceled})
+	if merr != nil {
+		return merr
+	}
+	b = append(b, 0)
+
+	// The reply is written by the flush of the buffered replies of the call
+	c.state.mutex.Lock()
+	c.state.final = true
+	c.state.pending = append(c.state.pending, b...)
+	c.state.mutex.Unlock()
+	c.state.sent(len(b), canceled.Error())
+	if c.metrics != nil {
+		c.metrics.BytesSent(len(b))
+	}
+	return err
+}
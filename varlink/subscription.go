@@ -0,0 +1,207 @@
+package varlink
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// dedupWindow is the number of recent event tokens remembered to drop events
+// replayed by the service after a reconnect.
+const dedupWindow = 1024
+
+// SubscriptionConfig describes a managed subscription to a method which streams
+// its replies with the More flag.
+type SubscriptionConfig struct {
+	// Dial returns a new connection to the service. It is called for the first
+	// call, and again after the connection failed.
+	Dial func(ctx context.Context) (*Connection, error)
+
+	// Method is the fully-qualified method to call.
+	Method string
+
+	// Parameters returns the parameters for each call of the method. Resume is the
+	// token of the last delivered event, so the service can continue the stream
+	// after it, or empty for the first call. If nil, no parameters are sent.
+	Parameters func(resume string) interface{}
+
+	// Token returns the identity of an event, which is used as the resume token
+	// and to drop events the service sends again after a reconnect. If nil, events
+	// are not deduplicated.
+	Token func(event json.RawMessage) string
+
+	// RetryInterval is the time to wait before calling the method again; it doubles
+	// with every failed attempt up to one minute. Zero means one second.
+	RetryInterval time.Duration
+
+	// Stats receives a Reconnected event every time the method is called again
+	// after the connection failed.
+	Stats StatsHandler
+}
+
+// Subscription is a stream of replies of a method call, which survives transient
+// disconnects. It is not safe for concurrent use.
+type Subscription struct {
+	config   SubscriptionConfig
+	conn     *Connection
+	receive  func(context.Context, interface{}) (uint64, error)
+	resume   string
+	seen     map[string]bool
+	recent   []string
+	failures int
+	ended    bool
+	// called is set once the method has been called
+	called bool
+}
+
+// NewSubscription returns a subscription; the method is called with the first Next.
+func NewSubscription(config SubscriptionConfig) *Subscription {
+	if config.RetryInterval <= 0 {
+		config.RetryInterval = time.Second
+	}
+	return &Subscription{
+		config: config,
+		seen:   make(map[string]bool),
+	}
+}
+
+// isReplyError returns whether the error was replied by the service, rather than
+// caused by a failed connection.
+func isReplyError(err error) bool {
+	switch e := err.(type) {
+	case *Error, *InterfaceNotFound, *MethodNotFound, *MethodNotImplemented,
+		*InvalidParameter, *InvalidParameterDetails, *PermissionDenied, *DecodeError:
+		return true
+	case *StreamCanceled:
+		return e.Reason == CanceledPolicy
+	}
+	return false
+}
+
+// backoff waits before the next attempt to call the method.
+func (s *Subscription) backoff(ctx context.Context) error {
+	interval := s.config.RetryInterval
+	for i := 0; i < s.failures && interval < time.Minute; i++ {
+		interval *= 2
+	}
+	if interval > time.Minute {
+		interval = time.Minute
+	}
+	s.failures++
+
+	select {
+	case <-time.After(interval):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Subscription) dial(ctx context.Context) error {
+	conn, err := s.config.Dial(ctx)
+	if err != nil {
+		return err
+	}
+
+	var parameters interface{}
+	if s.config.Parameters != nil {
+		parameters = s.config.Parameters(s.resume)
+	}
+
+	receive, err := conn.Send(ctx, s.config.Method, parameters, More)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	s.conn = conn
+	s.receive = receive
+	if s.called {
+		handleStats(s.config.Stats, Stats{Kind: Reconnected, Address: conn.address, Method: s.config.Method})
+	}
+	s.called = true
+	return nil
+}
+
+func (s *Subscription) disconnect() {
+	if s.conn != nil {
+		s.conn.Close()
+	}
+	s.conn = nil
+	s.receive = nil
+}
+
+// Next returns the next event of the stream. Failed connections are redialed and
+// the method is called again; so is a call rejected with ServiceNotAvailable,
+// and a stream canceled by the shutdown of the service or a failed handler. It
+// returns io.EOF when the service ended the stream, and the error replied by
+// the service, like a StreamCanceled with CanceledPolicy, or the error of the
+// context otherwise.
+func (s *Subscription) Next(ctx context.Context) (json.RawMessage, error) {
+	for {
+		if s.ended {
+			return nil, io.EOF
+		}
+
+		if s.receive == nil {
+			if err := s.dial(ctx); err != nil {
+				if isReplyError(err) {
+					return nil, err
+				}
+				if err := s.backoff(ctx); err != nil {
+					return nil, err
+				}
+				continue
+			}
+		}
+
+		var event json.RawMessage
+		flags, err := s.receive(ctx, &event)
+		if err != nil {
+			s.disconnect()
+			if isReplyError(err) {
+				return nil, err
+			}
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			if err := s.backoff(ctx); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		s.failures = 0
+
+		if flags&Continues == 0 {
+			s.disconnect()
+			s.ended = true
+		}
+
+		if s.config.Token != nil {
+			token := s.config.Token(event)
+			if s.seen[token] {
+				continue
+			}
+			s.remember(token)
+			s.resume = token
+		}
+
+		return event, nil
+	}
+}
+
+func (s *Subscription) remember(token string) {
+	s.seen[token] = true
+	s.recent = append(s.recent, token)
+	if len(s.recent) > dedupWindow {
+		delete(s.seen, s.recent[0])
+		s.recent = s.recent[1:]
+	}
+}
+
+// Close terminates the subscription.
+func (s *Subscription) Close() error {
+	s.disconnect()
+	return nil
+}
@@ -0,0 +1,166 @@
+// Package systemd emits the systemd units to run a varlink service with socket
+// activation. The service unit starts the service when the first client connects
+// to the socket unit; varlink.Service.Listen uses the passed socket instead of
+// its address.
+//
+//	config := systemd.Config{
+//		Name:      "org.example.this",
+//		Address:   "unix:/run/org.example.this",
+//		ExecStart: "/usr/bin/this",
+//		Hardening: true,
+//	}
+//	service, err := config.ServiceUnit()
+//	socket, err := config.SocketUnit()
+package systemd
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Config describes a socket activated varlink service. It is kept apart from
+// varlink.ServiceConfig, which holds no setting of the units: its timeouts,
+// limits and TLS configuration apply to the connections the service accepts on
+// the passed socket, while the units need the command, the user and the sandbox
+// of the service, which it knows nothing about. Both share only the address.
+type Config struct {
+	// Name of the units, without the .service and .socket suffix.
+	Name        string
+	Description string
+	// Address the service listens on, the one passed to varlink.Service.Listen.
+	// A tls address listens on TCP, ServiceConfig.TLSConfig serves it over TLS.
+	Address string
+	// ExecStart is the command line starting the service.
+	ExecStart string
+	// User and Group run the service; without a user, systemd allocates a
+	// dynamic user.
+	User  string
+	Group string
+	// SocketMode is the file mode of a unix socket, like 0660.
+	SocketMode string
+	// Hardening sandboxes the service. It can only connect to unix sockets,
+	// and to the network if it listens on a TCP address.
+	Hardening bool
+}
+
+var hardening = []string{
+	"NoNewPrivileges=yes",
+	"ProtectSystem=strict",
+	"ProtectHome=yes",
+	"PrivateTmp=yes",
+	"PrivateDevices=yes",
+	"ProtectKernelTunables=yes",
+	"ProtectKernelModules=yes",
+	"ProtectControlGroups=yes",
+	"RestrictNamespaces=yes",
+	"RestrictRealtime=yes",
+	"LockPersonality=yes",
+	"MemoryDenyWriteExecute=yes",
+	"SystemCallArchitectures=native",
+}
+
+// listenStream returns the ListenStream setting of the address.
+func (c *Config) listenStream() (string, string, error) {
+	words := strings.SplitN(c.Address, ":", 2)
+	if len(words) != 2 {
+		return "", "", fmt.Errorf("invalid address '%s'", c.Address)
+	}
+	protocol, address := words[0], strings.SplitN(words[1], ";", 2)[0]
+
+	switch protocol {
+	case "unix":
+		if address == "" {
+			return "", "", fmt.Errorf("invalid address '%s'", c.Address)
+		}
+		return protocol, address, nil
+
+	case "tcp", "tls":
+		// systemd does not resolve host names
+		if strings.HasPrefix(address, ":") {
+			address = address[1:]
+		}
+		if address == "" {
+			return "", "", fmt.Errorf("invalid address '%s'", c.Address)
+		}
+		return "tcp", address, nil
+	}
+
+	return "", "", fmt.Errorf("unsupported protocol '%s'", protocol)
+}
+
+func (c *Config) description(kind string) string {
+	if c.Description != "" {
+		return c.Description
+	}
+	return c.Name + " varlink " + kind
+}
+
+func (c *Config) check() error {
+	if c.Name == "" || strings.ContainsAny(c.Name, "/ \n") {
+		return fmt.Errorf("invalid unit name '%s'", c.Name)
+	}
+	return nil
+}
+
+// ServiceUnit returns the content of the .service unit.
+func (c *Config) ServiceUnit() (string, error) {
+	if err := c.check(); err != nil {
+		return "", err
+	}
+	protocol, _, err := c.listenStream()
+	if err != nil {
+		return "", err
+	}
+	if c.ExecStart == "" {
+		return "", fmt.Errorf("missing command to start the service")
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "[Unit]\nDescription=%s\nRequires=%s.socket\nAfter=%s.socket\n\n", c.description("service"), c.Name, c.Name)
+
+	// The service notifies systemd when its interfaces are ready
+	fmt.Fprintf(&b, "[Service]\nType=notify\nExecStart=%s\n", c.ExecStart)
+	if c.User != "" {
+		fmt.Fprintf(&b, "User=%s\n", c.User)
+	} else {
+		b.WriteString("DynamicUser=yes\n")
+	}
+	if c.Group != "" {
+		fmt.Fprintf(&b, "Group=%s\n", c.Group)
+	}
+
+	if c.Hardening {
+		for _, setting := range hardening {
+			b.WriteString(setting + "\n")
+		}
+		if protocol == "tcp" {
+			b.WriteString("RestrictAddressFamilies=AF_UNIX AF_INET AF_INET6\n")
+		} else {
+			b.WriteString("RestrictAddressFamilies=AF_UNIX\n")
+		}
+	}
+
+	return b.String(), nil
+}
+
+// SocketUnit returns the content of the .socket unit.
+func (c *Config) SocketUnit() (string, error) {
+	if err := c.check(); err != nil {
+		return "", err
+	}
+	protocol, address, err := c.listenStream()
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "[Unit]\nDescription=%s\n\n", c.description("socket"))
+
+	fmt.Fprintf(&b, "[Socket]\nListenStream=%s\nFileDescriptorName=varlink\n", address)
+	if protocol == "unix" && c.SocketMode != "" {
+		fmt.Fprintf(&b, "SocketMode=%s\n", c.SocketMode)
+	}
+
+	b.WriteString("\n[Install]\nWantedBy=sockets.target\n")
+	return b.String(), nil
+}
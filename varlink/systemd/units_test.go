@@ -0,0 +1,93 @@
+package systemd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUnits(t *testing.T) {
+	config := Config{
+		Name:       "org.example.this",
+		Address:    "unix:/run/org.example.this;mode=0666",
+		ExecStart:  "/usr/bin/this",
+		User:       "this",
+		SocketMode: "0660",
+	}
+
+	service, err := config.ServiceUnit()
+	if err != nil {
+		t.Fatalf("ServiceUnit: %v", err)
+	}
+	expected := `[Unit]
+Description=org.example.this varlink service
+Requires=org.example.this.socket
+After=org.example.this.socket
+
+[Service]
+Type=notify
+ExecStart=/usr/bin/this
+User=this
+`
+	if service != expected {
+		t.Fatalf("Expected:\n%s\nGot:\n%s", expected, service)
+	}
+
+	socket, err := config.SocketUnit()
+	if err != nil {
+		t.Fatalf("SocketUnit: %v", err)
+	}
+	expected = `[Unit]
+Description=org.example.this varlink socket
+
+[Socket]
+ListenStream=/run/org.example.this
+FileDescriptorName=varlink
+SocketMode=0660
+
+[Install]
+WantedBy=sockets.target
+`
+	if socket != expected {
+		t.Fatalf("Expected:\n%s\nGot:\n%s", expected, socket)
+	}
+
+	config = Config{Name: "this", Address: "tcp::12345", ExecStart: "/usr/bin/this", Hardening: true}
+	service, err = config.ServiceUnit()
+	if err != nil {
+		t.Fatalf("ServiceUnit: %v", err)
+	}
+	for _, s := range []string{"DynamicUser=yes\n", "ProtectSystem=strict\n", "RestrictAddressFamilies=AF_UNIX AF_INET AF_INET6\n"} {
+		if !strings.Contains(service, s) {
+			t.Fatalf("Service unit is missing `%s`:\n%s", s, service)
+		}
+	}
+	socket, err = config.SocketUnit()
+	if err != nil {
+		t.Fatalf("SocketUnit: %v", err)
+	}
+	if !strings.Contains(socket, "ListenStream=12345\n") {
+		t.Fatalf("Unexpected socket unit:\n%s", socket)
+	}
+
+	// TLS is served on the TCP socket by the service
+	config = Config{Name: "this", Address: "tls:127.0.0.1:8443", ExecStart: "/usr/bin/this", Hardening: true}
+	service, err = config.ServiceUnit()
+	if err != nil || !strings.Contains(service, "RestrictAddressFamilies=AF_UNIX AF_INET AF_INET6\n") {
+		t.Fatalf("Unexpected service unit %v:\n%s", err, service)
+	}
+	socket, err = config.SocketUnit()
+	if err != nil || !strings.Contains(socket, "ListenStream=127.0.0.1:8443\n") {
+		t.Fatalf("Unexpected socket unit %v:\n%s", err, socket)
+	}
+
+	for _, config := range []Config{
+		{Name: "this", Address: "udp:1234", ExecStart: "/usr/bin/this"},
+		{Name: "this", Address: "unix:", ExecStart: "/usr/bin/this"},
+		{Name: "", Address: "unix:/run/this", ExecStart: "/usr/bin/this"},
+		{Name: "this", Address: "unix:/run/this"},
+	} {
+		if _, err := config.ServiceUnit(); err == nil {
+			t.Fatalf("ServiceUnit accepted %+v", config)
+		}
+	}
+}
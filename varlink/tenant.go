@@ -0,0 +1,92 @@
+package varlink
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	tenantRegexp    = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+	interfaceRegexp = regexp.MustCompile(`(?m)^interface[ \t]+\S+`)
+)
+
+// tenantInterface serves an interface implementation under a tenant-scoped name.
+type tenantInterface struct {
+	dispatcher
+	tenant      string
+	name        string
+	description string
+}
+
+func (t *tenantInterface) VarlinkGetName() string {
+	return t.name
+}
+
+func (t *tenantInterface) VarlinkGetDescription() string {
+	return t.description
+}
+
+// implementation returns the registered implementation of an interface.
+func implementation(iface dispatcher) dispatcher {
+	if t, ok := iface.(*tenantInterface); ok {
+		return t.dispatcher
+	}
+	return iface
+}
+
+// TenantInterfaceName returns the tenant-scoped name of an interface; the tenant is
+// inserted in front of the last component, org.example.Iface becomes org.example.tenant.Iface.
+func TenantInterfaceName(tenant string, name string) string {
+	r := strings.LastIndex(name, ".")
+	return name[:r+1] + tenant + "." + name[r+1:]
+}
+
+// RegisterTenantInterface registers an interface implementation under the tenant-scoped
+// name returned by TenantInterfaceName. The same implementation can be registered for
+// many tenants; handlers retrieve the called tenant with Call.Tenant(). If
+// ServiceConfig.TenantFunc is set, the interface is only visible to callers of the tenant.
+func (s *Service) RegisterTenantInterface(tenant string, iface dispatcher) error {
+	if !tenantRegexp.MatchString(tenant) {
+		return fmt.Errorf("invalid tenant name '%s'", tenant)
+	}
+
+	if isNil(iface) {
+		return fmt.Errorf("nil interface")
+	}
+
+	name := iface.VarlinkGetName()
+	if strings.LastIndex(name, ".") <= 0 {
+		return fmt.Errorf("invalid interface name '%s'", name)
+	}
+
+	// The description of the tenant interface is rewritten to match its name
+	if err := checkDescription(name, iface.VarlinkGetDescription()); err != nil {
+		return err
+	}
+
+	scoped := TenantInterfaceName(tenant, name)
+
+	return s.RegisterInterface(&tenantInterface{
+		dispatcher:  iface,
+		tenant:      tenant,
+		name:        scoped,
+		description: interfaceRegexp.ReplaceAllLiteralString(iface.VarlinkGetDescription(), "interface "+scoped),
+	})
+}
+
+// visible returns whether the interface can be seen by the caller.
+func (s *Service) visible(c *Call, r *registry, name string) bool {
+	if !s.reachable(c, name) {
+		return false
+	}
+	if s.config.TenantFunc == nil {
+		return true
+	}
+
+	if t, ok := r.interfaces[name].(*tenantInterface); ok {
+		return t.tenant == c.tenant
+	}
+
+	return true
+}
@@ -0,0 +1,36 @@
+package varlink
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"github.com/varlink/go/varlink/internal/ctxio"
+)
+
+// awaitRequest waits up to ServiceConfig.IdleTimeout for the first byte of the
+// next request. While busy returns true, the connection is not idle and the
+// wait starts again.
+func (s *Service) awaitRequest(ctx context.Context, c *ctxio.Conn, busy func() bool) error {
+	if s.config.IdleTimeout <= 0 {
+		return nil
+	}
+
+	for {
+		idleCtx, cancel := context.WithTimeout(ctx, s.config.IdleTimeout)
+		_, err := c.Peek(idleCtx, 1)
+		cancel()
+		if err == nil || ctx.Err() != nil || !isTimeout(err) || busy == nil || !busy() {
+			return err
+		}
+	}
+}
+
+// isTimeout returns whether the error is the end of a deadline.
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	ne, ok := err.(net.Error)
+	return ok && ne.Timeout()
+}
@@ -0,0 +1,135 @@
+package varlink
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/varlink/go/varlink/internal/ctxio"
+)
+
+// ALPNProtocol is the name clients negotiate with TLS application-layer protocol
+// negotiation to speak varlink.
+const ALPNProtocol = "varlink"
+
+// handshakeTimeout limits the time for the TLS handshake of a new connection.
+const handshakeTimeout = 10 * time.Second
+
+// serverTLSConfig returns the TLS configuration of the service, which offers
+// varlink in addition to the protocols of the configuration.
+func serverTLSConfig(config *tls.Config) *tls.Config {
+	if config == nil {
+		return nil
+	}
+
+	config = config.Clone()
+	for _, p := range config.NextProtos {
+		if p == ALPNProtocol {
+			return config
+		}
+	}
+	config.NextProtos = append([]string{ALPNProtocol}, config.NextProtos...)
+	return config
+}
+
+// route returns the connection to serve and whether it speaks varlink. TLS
+// connections are routed by the negotiated protocol; without one, and on plain
+// connections, the first byte received decides if ServiceConfig.OtherProtocol
+// is set.
+func (s *Service) route(ctx context.Context, conn net.Conn) (*ctxio.Conn, bool, error) {
+	if s.tlsConfig != nil {
+		t := tls.Server(conn, s.tlsConfig)
+		t.SetDeadline(time.Now().Add(handshakeTimeout))
+		if err := t.Handshake(); err != nil {
+			return nil, false, err
+		}
+		t.SetDeadline(time.Time{})
+		conn = t
+
+		switch p := t.ConnectionState().NegotiatedProtocol; p {
+		case "":
+		case ALPNProtocol:
+			return ctxio.NewConn(conn), true, nil
+		default:
+			if s.config.OtherProtocol == nil {
+				return nil, false, fmt.Errorf("unsupported protocol '%s'", p)
+			}
+			return ctxio.NewConn(conn), false, nil
+		}
+	}
+
+	c := ctxio.NewConn(conn)
+	if s.config.OtherProtocol == nil {
+		return c, true, nil
+	}
+
+	b, err := c.Peek(ctx, 1)
+	if err != nil {
+		return nil, false, err
+	}
+	return c, isVarlink(b[0]), nil
+}
+
+type tlsStateKey struct{}
+
+func tlsStateFromContext(ctx context.Context) *tls.ConnectionState {
+	state, _ := ctx.Value(tlsStateKey{}).(*tls.ConnectionState)
+	return state
+}
+
+// TLSConnectionState returns the state of the TLS connection of the call, with
+// the negotiated version, cipher suite and protocol, and the certificate chain
+// of the client verified with tls.Config.ClientCAs, for authorizing clients by
+// their certificate. It is nil unless the service is served over TLS. The state
+// is shared between the calls of the connection and must not be modified.
+func (c *Call) TLSConnectionState() *tls.ConnectionState {
+	return c.tlsState
+}
+
+// NewTLSConnection returns a new connection over TLS to the "tls:host:port" or
+// "tcp:host:port" address, like NewConnection. The configuration, which may be
+// nil, verifies the service; without a ServerName, the host of the address is
+// verified, and the varlink ALPN protocol is offered unless NextProtos is set.
+func NewTLSConnection(ctx context.Context, address string, config *tls.Config) (*Connection, error) {
+	if strings.HasPrefix(address, "tcp:") {
+		address = "tls:" + strings.TrimPrefix(address, "tcp:")
+	}
+	return newConnection(ctx, address, DialConfig{TLSConfig: config})
+}
+
+// clientHandshake secures the connection to the address, before the context
+// expires or handshakeTimeout passes.
+func clientHandshake(ctx context.Context, conn net.Conn, addr string, config *tls.Config) (net.Conn, error) {
+	if config == nil {
+		config = &tls.Config{}
+	} else {
+		config = config.Clone()
+	}
+	if config.ServerName == "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			host = addr
+		}
+		config.ServerName = host
+	}
+	if len(config.NextProtos) == 0 {
+		config.NextProtos = []string{ALPNProtocol}
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(handshakeTimeout)
+	}
+
+	t := tls.Client(conn, config)
+	t.SetDeadline(deadline)
+	if err := t.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	t.SetDeadline(time.Time{})
+	return t, nil
+}
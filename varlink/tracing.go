@@ -0,0 +1,88 @@
+package varlink
+
+import "context"
+
+// SpanKind tells a span of a client calling a method from a span of a service
+// handling the call.
+type SpanKind int
+
+const (
+	// ClientSpan is the span of a call sent by a Connection, from sending the
+	// call to receiving its last reply.
+	ClientSpan SpanKind = iota + 1
+	// ServerSpan is the span of a call handled by a Service.
+	ServerSpan
+)
+
+// Tracer starts the spans of method calls, named after the fully-qualified
+// method, like an adapter to an OpenTelemetry tracer and its propagator:
+//
+//	type otelTracer struct {
+//		tracer     trace.Tracer
+//		propagator propagation.TextMapPropagator
+//	}
+//
+//	func (t otelTracer) Start(ctx context.Context, method string, kind varlink.SpanKind) (context.Context, varlink.Span) {
+//		k := trace.SpanKindServer
+//		if kind == varlink.ClientSpan {
+//			k = trace.SpanKindClient
+//		}
+//		ctx, span := t.tracer.Start(ctx, method, trace.WithSpanKind(k),
+//			trace.WithAttributes(attribute.String("rpc.system", "varlink"), attribute.String("rpc.method", method)))
+//		return ctx, otelSpan{span}
+//	}
+//
+//	func (t otelTracer) Inject(ctx context.Context, metadata map[string]string) {
+//		t.propagator.Inject(ctx, propagation.MapCarrier(metadata))
+//	}
+//
+//	func (t otelTracer) Extract(ctx context.Context, metadata map[string]string) context.Context {
+//		return t.propagator.Extract(ctx, propagation.MapCarrier(metadata))
+//	}
+//
+//	type otelSpan struct{ span trace.Span }
+//
+//	func (s otelSpan) End(err error) {
+//		if err != nil {
+//			s.span.SetStatus(codes.Error, err.Error())
+//		}
+//		s.span.End()
+//	}
+//
+// The trace context travels in the metadata of the calls on connections which
+// negotiated the CallMetadata extension, under the keys of the W3C Trace
+// Context, "traceparent" and "tracestate", as written by the TraceContext
+// propagator of OpenTelemetry.
+type Tracer interface {
+	// Start starts a span of the method call and returns the context holding
+	// it.
+	Start(ctx context.Context, method string, kind SpanKind) (context.Context, Span)
+	// Inject adds the trace context of the context to the metadata sent with a
+	// call.
+	Inject(ctx context.Context, metadata map[string]string)
+	// Extract returns the context with the trace context of the metadata of a
+	// received call, if any.
+	Extract(ctx context.Context, metadata map[string]string) context.Context
+}
+
+// Span is a span started by a Tracer.
+type Span interface {
+	// End ends the span, with the error of the call: the error replied, as an
+	// *Error or one of the org.varlink.service error types on the client,
+	// or the error returned by the handler on the service.
+	End(err error)
+}
+
+// spanError returns the error the span of the call ends with: the error of the
+// handler, else the error replied.
+func (s *callState) spanError(err error) error {
+	if err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.errorName != "" {
+		return &Error{Name: s.errorName}
+	}
+	return nil
+}
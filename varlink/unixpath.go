@@ -0,0 +1,69 @@
+package varlink
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+)
+
+// unixPathError is returned for socket paths longer than sun_path, which the
+// kernel would reject with a bare EINVAL.
+func unixPathError(path string) error {
+	return fmt.Errorf("unix socket path '%s' is longer than the %d bytes of sun_path", path, maxUnixPath)
+}
+
+// longPathListener is a unix socket listener bound to a path longer than
+// sun_path. It keeps the directory open, which the short path of the socket
+// refers to, until the socket is unlinked.
+type longPathListener struct {
+	*net.UnixListener
+	dir *os.File
+}
+
+func (l *longPathListener) Close() error {
+	err := l.UnixListener.Close()
+	l.dir.Close()
+	return err
+}
+
+// listenUnix listens on the unix socket path, which is removed again when the
+// listener is closed, unless it is an abstract one.
+func listenUnix(ctx context.Context, path string) (net.Listener, error) {
+	short, dir, err := shortUnixPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	l, err := listen(ctx, "unix", short)
+	if err != nil {
+		if dir != nil {
+			dir.Close()
+		}
+		return nil, err
+	}
+	if path == "" || path[0] == '@' {
+		return l, nil
+	}
+
+	u := l.(*net.UnixListener)
+	u.SetUnlinkOnClose(true)
+	if dir == nil {
+		return u, nil
+	}
+	return &longPathListener{u, dir}, nil
+}
+
+// dialUnix connects to the unix socket path.
+func dialUnix(ctx context.Context, path string) (net.Conn, error) {
+	short, dir, err := shortUnixPath(path)
+	if err != nil {
+		return nil, err
+	}
+	if dir != nil {
+		defer dir.Close()
+	}
+
+	var d net.Dialer
+	return d.DialContext(ctx, "unix", short)
+}
@@ -0,0 +1,30 @@
+package varlink
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// maxUnixPath is the size of sun_path, which needs no terminating NUL on Linux.
+const maxUnixPath = 108
+
+// shortUnixPath returns a path to the socket fitting into sun_path. For longer
+// paths, that is the socket in the opened directory of the path, under
+// /proc/self/fd, which needs to remain open while the path is used.
+func shortUnixPath(path string) (string, *os.File, error) {
+	if len(path) <= maxUnixPath || path[0] == '@' {
+		return path, nil, nil
+	}
+
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return "", nil, err
+	}
+	short := fmt.Sprintf("/proc/self/fd/%d/%s", dir.Fd(), filepath.Base(path))
+	if len(short) > maxUnixPath {
+		dir.Close()
+		return "", nil, unixPathError(path)
+	}
+	return short, dir, nil
+}
@@ -0,0 +1,19 @@
+// +build !linux
+
+package varlink
+
+import (
+	"os"
+)
+
+// maxUnixPath is the longest path fitting into sun_path of all systems, which
+// is 104 bytes with the terminating NUL on the BSDs.
+const maxUnixPath = 103
+
+// shortUnixPath fails for paths longer than sun_path.
+func shortUnixPath(path string) (string, *os.File, error) {
+	if len(path) > maxUnixPath {
+		return "", nil, unixPathError(path)
+	}
+	return path, nil, nil
+}
@@ -0,0 +1,315 @@
+package upgrade
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/varlink/go/varlink"
+)
+
+// ErrDetached is returned by ConsoleClient.Attach if the detach keys were typed.
+var ErrDetached = errors.New("upgrade: detached from console")
+
+// DefaultDetachKeys is ctrl-p ctrl-q.
+var DefaultDetachKeys = []byte{0x10, 0x11}
+
+// Console frame types. Every frame starts with the type byte and the
+// big-endian uint32 length of the payload.
+const (
+	frameData   = 0
+	frameResize = 1
+	frameDetach = 2
+)
+
+const maxFrameSize = 1 << 20
+
+// WindowSize is the size of a terminal window.
+type WindowSize struct {
+	Rows uint16
+	Cols uint16
+}
+
+func writeFrame(ctx context.Context, rw varlink.ReadWriterContext, kind byte, payload []byte) error {
+	b := make([]byte, 5+len(payload))
+	b[0] = kind
+	binary.BigEndian.PutUint32(b[1:5], uint32(len(payload)))
+	copy(b[5:], payload)
+
+	for len(b) > 0 {
+		n, err := rw.Write(ctx, b)
+		if err != nil {
+			return err
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+func readFrame(ctx context.Context, c *Conn) (byte, []byte, error) {
+	header := make([]byte, 5)
+	if err := c.ReadFull(ctx, header); err != nil {
+		return 0, nil, err
+	}
+
+	size := binary.BigEndian.Uint32(header[1:5])
+	if size > maxFrameSize {
+		return 0, nil, fmt.Errorf("upgrade: console frame of %d bytes exceeds the limit", size)
+	}
+
+	payload := make([]byte, size)
+	if err := c.ReadFull(ctx, payload); err != nil {
+		return 0, nil, err
+	}
+	return header[0], payload, nil
+}
+
+// ConsoleServer proxies a console, usually the master side of a pseudo terminal,
+// over an upgraded connection to a ConsoleClient.
+type ConsoleServer struct {
+	Console io.ReadWriter
+	// Resize is called when the client's window size changes.
+	Resize func(size WindowSize) error
+}
+
+// Serve proxies the console until the console reaches EOF, the client detaches,
+// the connection fails or the context is cancelled. The caller closes the console
+// and the connection afterwards, which ends a still pending console read.
+func (s *ConsoleServer) Serve(ctx context.Context, rw varlink.ReadWriterContext) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errc := make(chan error, 2)
+
+	go func() {
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := s.Console.Read(buf)
+			if n > 0 {
+				if werr := writeFrame(ctx, rw, frameData, buf[:n]); werr != nil {
+					errc <- werr
+					return
+				}
+			}
+			if err == io.EOF {
+				errc <- nil
+				return
+			}
+			if err != nil {
+				errc <- err
+				return
+			}
+		}
+	}()
+
+	go func() {
+		c := NewConn(rw)
+		for {
+			kind, payload, err := readFrame(ctx, c)
+			if err != nil {
+				errc <- err
+				return
+			}
+
+			switch kind {
+			case frameData:
+				if _, err := s.Console.Write(payload); err != nil {
+					errc <- err
+					return
+				}
+
+			case frameResize:
+				if len(payload) != 4 || s.Resize == nil {
+					continue
+				}
+				size := WindowSize{
+					Rows: binary.BigEndian.Uint16(payload[0:2]),
+					Cols: binary.BigEndian.Uint16(payload[2:4]),
+				}
+				if err := s.Resize(size); err != nil {
+					errc <- err
+					return
+				}
+
+			case frameDetach:
+				errc <- nil
+				return
+			}
+		}
+	}()
+
+	return <-errc
+}
+
+// ConsoleClient attaches a local terminal to a console served by a ConsoleServer.
+// The caller is responsible for putting the terminal into raw mode.
+type ConsoleClient struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	// DetachKeys end the session when typed; nil means DefaultDetachKeys, an
+	// empty non-nil slice disables detaching.
+	DetachKeys []byte
+	// Resize delivers the window size changes to forward to the server,
+	// usually fed from a SIGWINCH handler.
+	Resize <-chan WindowSize
+}
+
+// detacher finds the detach key sequence in the input stream.
+type detacher struct {
+	keys    []byte
+	matched int
+	// fallback holds, for every length of a partial match, the length of its
+	// longest proper suffix which is a prefix of the keys, like in the
+	// Knuth-Morris-Pratt algorithm
+	fallback []int
+}
+
+// scan returns the input to forward and whether the detach keys were typed.
+// A partially matching sequence is held back until it is complete or broken.
+func (d *detacher) scan(in []byte) ([]byte, bool) {
+	if len(d.keys) == 0 {
+		return in, false
+	}
+	if d.fallback == nil {
+		d.fallback = keyFallback(d.keys)
+	}
+
+	out := make([]byte, 0, len(in)+len(d.keys))
+	for _, b := range in {
+		// On a mismatch, the held back keys which cannot start the sequence
+		// anymore are forwarded, the rest may still match with this byte
+		for d.matched > 0 && b != d.keys[d.matched] {
+			next := d.fallback[d.matched]
+			out = append(out, d.keys[:d.matched-next]...)
+			d.matched = next
+		}
+		if b != d.keys[d.matched] {
+			out = append(out, b)
+			continue
+		}
+		d.matched++
+		if d.matched == len(d.keys) {
+			return out, true
+		}
+	}
+	return out, false
+}
+
+// keyFallback returns the fallback lengths of the partial matches of the keys.
+func keyFallback(keys []byte) []int {
+	fallback := make([]int, len(keys)+1)
+	for i, k := 1, 0; i < len(keys); i++ {
+		for k > 0 && keys[i] != keys[k] {
+			k = fallback[k]
+		}
+		if keys[i] == keys[k] {
+			k++
+		}
+		fallback[i+1] = k
+	}
+	return fallback
+}
+
+// Attach proxies the local terminal until the server closes the connection, the
+// detach keys are typed, or the context is cancelled. It returns ErrDetached if
+// the session was detached.
+func (c *ConsoleClient) Attach(ctx context.Context, rw varlink.ReadWriterContext) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	keys := c.DetachKeys
+	if keys == nil {
+		keys = DefaultDetachKeys
+	}
+
+	var mutex sync.Mutex
+	send := func(kind byte, payload []byte) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return writeFrame(ctx, rw, kind, payload)
+	}
+
+	errc := make(chan error, 3)
+
+	go func() {
+		d := detacher{keys: keys}
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := c.Stdin.Read(buf)
+			if n > 0 {
+				out, detached := d.scan(buf[:n])
+				if len(out) > 0 {
+					if werr := send(frameData, out); werr != nil {
+						errc <- werr
+						return
+					}
+				}
+				if detached {
+					if werr := send(frameDetach, nil); werr != nil {
+						errc <- werr
+						return
+					}
+					errc <- ErrDetached
+					return
+				}
+			}
+			if err != nil {
+				if err == io.EOF {
+					err = send(frameDetach, nil)
+					if err == nil {
+						err = ErrDetached
+					}
+				}
+				errc <- err
+				return
+			}
+		}
+	}()
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case size, ok := <-c.Resize:
+				if !ok {
+					return
+				}
+				payload := make([]byte, 4)
+				binary.BigEndian.PutUint16(payload[0:2], size.Rows)
+				binary.BigEndian.PutUint16(payload[2:4], size.Cols)
+				if err := send(frameResize, payload); err != nil {
+					errc <- err
+					return
+				}
+			}
+		}
+	}()
+
+	go func() {
+		conn := NewConn(rw)
+		for {
+			kind, payload, err := readFrame(ctx, conn)
+			if err == io.EOF {
+				errc <- nil
+				return
+			}
+			if err != nil {
+				errc <- err
+				return
+			}
+			if kind != frameData {
+				continue
+			}
+			if _, err := c.Stdout.Write(payload); err != nil {
+				errc <- err
+				return
+			}
+		}
+	}()
+
+	return <-errc
+}
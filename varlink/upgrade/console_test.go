@@ -0,0 +1,166 @@
+package upgrade_test
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/varlink/go/varlink/internal/ctxio"
+	"github.com/varlink/go/varlink/upgrade"
+)
+
+type console struct {
+	io.Reader
+	io.Writer
+}
+
+// serveUpperCase serves a console echoing its input in upper case, and
+// delivers the window size changes to resized.
+func serveUpperCase(server net.Conn, resized chan upgrade.WindowSize) chan error {
+	consoleIn, consoleInW := io.Pipe()
+	consoleOutR, consoleOut := io.Pipe()
+	go func() {
+		r := bufio.NewReader(consoleIn)
+		for {
+			b, err := r.ReadByte()
+			if err != nil {
+				consoleOut.Close()
+				return
+			}
+			if b >= 'a' && b <= 'z' {
+				b -= 'a' - 'A'
+			}
+			consoleOut.Write([]byte{b})
+		}
+	}()
+
+	s := upgrade.ConsoleServer{
+		Console: console{consoleOutR, consoleInW},
+		Resize: func(size upgrade.WindowSize) error {
+			resized <- size
+			return nil
+		},
+	}
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- s.Serve(context.Background(), ctxio.NewConn(server))
+	}()
+	return serveErr
+}
+
+func TestConsole(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	resized := make(chan upgrade.WindowSize, 1)
+	serveErr := serveUpperCase(server, resized)
+
+	stdin, stdinW := io.Pipe()
+	stdoutR, stdout := io.Pipe()
+	resize := make(chan upgrade.WindowSize, 1)
+	c := upgrade.ConsoleClient{
+		Stdin:  stdin,
+		Stdout: stdout,
+		Resize: resize,
+	}
+	attachErr := make(chan error, 1)
+	go func() {
+		attachErr <- c.Attach(context.Background(), ctxio.NewConn(client))
+	}()
+
+	resize <- upgrade.WindowSize{Rows: 24, Cols: 80}
+	if size := <-resized; size.Rows != 24 || size.Cols != 80 {
+		t.Fatalf("Unexpected window size %v", size)
+	}
+
+	// A partial detach sequence is forwarded
+	stdinW.Write([]byte("ls\x10x\n"))
+	line, err := bufio.NewReader(stdoutR).ReadString('\n')
+	if err != nil {
+		t.Fatalf("ReadString(): %v", err)
+	}
+	if line != "LS\x10X\n" {
+		t.Fatalf("Unexpected output `%q`", line)
+	}
+
+	stdinW.Write([]byte("\x10\x11"))
+	if err := <-attachErr; err != upgrade.ErrDetached {
+		t.Fatalf("Expected ErrDetached, got %v", err)
+	}
+	if err := <-serveErr; err != nil {
+		t.Fatalf("Serve(): %v", err)
+	}
+}
+
+func TestConsoleDetachDisabled(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+	serveUpperCase(server, make(chan upgrade.WindowSize, 1))
+
+	stdin, stdinW := io.Pipe()
+	stdoutR, stdout := io.Pipe()
+	c := upgrade.ConsoleClient{
+		Stdin:      stdin,
+		Stdout:     stdout,
+		DetachKeys: []byte{},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	attachErr := make(chan error, 1)
+	go func() {
+		attachErr <- c.Attach(ctx, ctxio.NewConn(client))
+	}()
+
+	// The default detach keys are forwarded
+	stdinW.Write([]byte("\x10\x11\n"))
+	line, err := bufio.NewReader(stdoutR).ReadString('\n')
+	if err != nil {
+		t.Fatalf("ReadString(): %v", err)
+	}
+	if line != "\x10\x11\n" {
+		t.Fatalf("Unexpected output `%q`", line)
+	}
+
+	cancel()
+	if err := <-attachErr; err == upgrade.ErrDetached {
+		t.Fatal("Detached with detaching disabled")
+	}
+}
+
+func TestConsoleRepeatedDetachKeys(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+	serveUpperCase(server, make(chan upgrade.WindowSize, 1))
+
+	stdin, stdinW := io.Pipe()
+	stdoutR, stdout := io.Pipe()
+	c := upgrade.ConsoleClient{
+		Stdin:      stdin,
+		Stdout:     stdout,
+		DetachKeys: []byte("\x1d\x1dq"),
+	}
+	attachErr := make(chan error, 1)
+	go func() {
+		attachErr <- c.Attach(context.Background(), ctxio.NewConn(client))
+	}()
+
+	// Broken sequences are forwarded as typed
+	stdinW.Write([]byte("ls\x1d\x1d\x1dx\n"))
+	line, err := bufio.NewReader(stdoutR).ReadString('\n')
+	if err != nil {
+		t.Fatalf("ReadString(): %v", err)
+	}
+	if line != "LS\x1d\x1d\x1dX\n" {
+		t.Fatalf("Unexpected output `%q`", line)
+	}
+
+	// The keys typed after a stray ^] detach
+	stdinW.Write([]byte("\x1d\x1d\x1dq"))
+	if err := <-attachErr; err != upgrade.ErrDetached {
+		t.Fatalf("Expected ErrDetached, got %v", err)
+	}
+}
@@ -0,0 +1,134 @@
+// Package upgrade provides helpers to implement stateful protocols on upgraded
+// varlink connections.
+//
+// After a method call with the upgrade flag has been replied to, the connection
+// no longer carries varlink messages but a custom protocol. The protocol is
+// implemented as a set of states; every state reads from and writes to the
+// connection and returns the next state:
+//
+//	func greeting(ctx context.Context, c *upgrade.Conn) (upgrade.State, error) {
+//		name, err := c.ReadBytes(ctx, '\n')
+//		if err != nil {
+//			return nil, err
+//		}
+//		fmt.Fprintf(c, "Hello %s", name)
+//		return nil, nil
+//	}
+//
+//	m := upgrade.Machine{StateTimeout: 10 * time.Second}
+//	err := m.Run(ctx, call.Conn, greeting)
+package upgrade
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"time"
+
+	"github.com/varlink/go/varlink"
+)
+
+// ErrStateTimeout is returned by Machine.Run if a state exceeded the StateTimeout.
+var ErrStateTimeout = errors.New("upgrade: state timeout")
+
+// State handles the connection while the protocol is in this state. It returns
+// the next state, or nil when the protocol is finished.
+type State func(ctx context.Context, c *Conn) (State, error)
+
+// Conn is an upgraded connection with buffered writes. Data written to it is sent
+// to the peer when a state is left or Flush is called.
+type Conn struct {
+	rw  varlink.ReadWriterContext
+	out bytes.Buffer
+}
+
+// NewConn returns a new buffered upgraded connection.
+func NewConn(rw varlink.ReadWriterContext) *Conn {
+	return &Conn{rw: rw}
+}
+
+// Read reads the available data from the connection.
+func (c *Conn) Read(ctx context.Context, buf []byte) (int, error) {
+	return c.rw.Read(ctx, buf)
+}
+
+// ReadFull reads exactly len(buf) bytes from the connection.
+func (c *Conn) ReadFull(ctx context.Context, buf []byte) error {
+	for n := 0; n < len(buf); {
+		m, err := c.rw.Read(ctx, buf[n:])
+		n += m
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadBytes reads from the connection until the delimiter is found. The
+// returned data includes the delimiter.
+func (c *Conn) ReadBytes(ctx context.Context, delim byte) ([]byte, error) {
+	return c.rw.ReadBytes(ctx, delim)
+}
+
+// Write buffers data to send to the peer.
+func (c *Conn) Write(b []byte) (int, error) {
+	return c.out.Write(b)
+}
+
+// Flush sends the buffered data to the peer.
+func (c *Conn) Flush(ctx context.Context) error {
+	for c.out.Len() > 0 {
+		n, err := c.rw.Write(ctx, c.out.Bytes())
+		c.out.Next(n)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Machine runs a protocol state machine on an upgraded connection.
+type Machine struct {
+	// StateTimeout limits the time spent in a single state, including
+	// flushing its output. Zero means no limit.
+	StateTimeout time.Duration
+}
+
+func (m *Machine) step(parent context.Context, c *Conn, state State) (State, error) {
+	ctx := parent
+	if m.StateTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, m.StateTimeout)
+		defer cancel()
+	}
+
+	next, err := state(ctx, c)
+	if err == nil {
+		err = c.Flush(ctx)
+	}
+
+	// The connection deadline may expire slightly before the context does
+	deadline, ok := ctx.Deadline()
+	expired := ctx.Err() == context.DeadlineExceeded || (ok && !time.Now().Before(deadline))
+	if err != nil && m.StateTimeout > 0 && expired && parent.Err() == nil {
+		return nil, ErrStateTimeout
+	}
+
+	return next, err
+}
+
+// Run runs the state machine starting with the initial state, until a state
+// returns no next state or an error.
+func (m *Machine) Run(ctx context.Context, rw varlink.ReadWriterContext, initial State) error {
+	c := NewConn(rw)
+
+	for state := initial; state != nil; {
+		var err error
+		state, err = m.step(ctx, c, state)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,71 @@
+package upgrade_test
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/varlink/go/varlink/internal/ctxio"
+	"github.com/varlink/go/varlink/upgrade"
+)
+
+func TestMachine(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+
+	var total int
+	var sum upgrade.State
+	sum = func(ctx context.Context, c *upgrade.Conn) (upgrade.State, error) {
+		line, err := c.ReadBytes(ctx, '\n')
+		if err != nil {
+			return nil, err
+		}
+		var n int
+		if _, err := fmt.Sscanf(string(line), "%d", &n); err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			fmt.Fprintf(c, "total %d\n", total)
+			return nil, nil
+		}
+		total += n
+		return sum, nil
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		m := upgrade.Machine{StateTimeout: time.Second}
+		errc <- m.Run(context.Background(), ctxio.NewConn(server), sum)
+	}()
+
+	fmt.Fprintf(client, "1\n2\n3\n0\n")
+	reply, err := bufio.NewReader(client).ReadString('\n')
+	if err != nil {
+		t.Fatalf("ReadString(): %v", err)
+	}
+	if reply != "total 6\n" {
+		t.Fatalf("Unexpected reply `%s`", reply)
+	}
+
+	if err := <-errc; err != nil {
+		t.Fatalf("Run(): %v", err)
+	}
+}
+
+func TestMachineStateTimeout(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+
+	wait := func(ctx context.Context, c *upgrade.Conn) (upgrade.State, error) {
+		buf := make([]byte, 4)
+		return nil, c.ReadFull(ctx, buf)
+	}
+
+	m := upgrade.Machine{StateTimeout: 10 * time.Millisecond}
+	if err := m.Run(context.Background(), ctxio.NewConn(server), wait); err != upgrade.ErrStateTimeout {
+		t.Fatalf("Expected ErrStateTimeout, got %v", err)
+	}
+}
@@ -3,10 +3,16 @@ package varlink
 // tests with access to internals
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"net"
+	"runtime"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func expect(t *testing.T, expected string, returned string) {
@@ -151,7 +157,7 @@ func TestService(t *testing.T) {
 		if err := service.HandleMessage(context.Background(), wf, msg); err != nil {
 			t.Fatalf("HandleMessage returned error: %v", err)
 		}
-		expect(t, `{"parameters":{"description":"# The Varlink Service Interface is provided by every varlink service. It\n# describes the service and the interfaces it implements.\ninterface org.varlink.service\n\n# Get a list of all the interfaces a service provides and information\n# about the implementation.\nmethod GetInfo() -\u003e (\n  vendor: string,\n  product: string,\n  version: string,\n  url: string,\n  interfaces: []string\n)\n\n# Get the description of an interface that is implemented by this service.\nmethod GetInterfaceDescription(interface: string) -\u003e (description: string)\n\n# The requested interface was not found.\nerror InterfaceNotFound (interface: string)\n\n# The requested method was not found\nerror MethodNotFound (method: string)\n\n# The interface defines the requested method, but the service does not\n# implement it.\nerror MethodNotImplemented (method: string)\n\n# One of the passed parameters is invalid.\nerror InvalidParameter (parameter: string)"}}`+"\000",
+		expect(t, `{"parameters":{"description":"# The Varlink Service Interface is provided by every varlink service. It\n# describes the service and the interfaces it implements.\ninterface org.varlink.service\n\n# Get a list of all the interfaces a service provides and information\n# about the implementation.\nmethod GetInfo() -\u003e (\n  vendor: string,\n  product: string,\n  version: string,\n  url: string,\n  interfaces: []string\n)\n\n# Get the description of an interface that is implemented by this service.\nmethod GetInterfaceDescription(interface: string) -\u003e (description: string)\n\n# The requested interface was not found.\nerror InterfaceNotFound (interface: string)\n\n# The requested method was not found\nerror MethodNotFound (method: string)\n\n# The interface defines the requested method, but the service does not\n# implement it.\nerror MethodNotImplemented (method: string)\n\n# One of the passed parameters is invalid.\nerror InvalidParameter (parameter: string)\n\n# The caller is not permitted to call the method.\nerror PermissionDenied ()\n\n# The service is not ready to handle calls yet.\nerror ServiceNotAvailable ()"}}`+"\000",
 			string(written))
 	})
 
@@ -165,7 +171,7 @@ func TestService(t *testing.T) {
 		if err := service.HandleMessage(context.Background(), wf, msg); err != nil {
 			t.Fatalf("HandleMessage returned error: %v", err)
 		}
-		expect(t, `{"parameters":{"vendor":"Varlink","product":"Varlink Test","version":"1","url":"https://github.com/varlink/go/varlink","interfaces":["org.varlink.service"]}}`+"\000",
+		expect(t, `{"parameters":{"vendor":"Varlink","product":"Varlink Test","version":"1","url":"https://github.com/varlink/go/varlink","interfaces":["org.varlink.service","org.varlink.internal","org.varlink.stream"]}}`+"\000",
 			string(written))
 	})
 }
@@ -220,7 +226,7 @@ func (s *VarlinkInterface) VarlinkGetName() string {
 }
 
 func (s *VarlinkInterface) VarlinkGetDescription() string {
-	return "#"
+	return "interface org.example.test\nmethod Ping() -> ()"
 }
 
 func TestMoreService(t *testing.T) {
@@ -278,3 +284,1512 @@ func TestMoreService(t *testing.T) {
 			string(written))
 	})
 }
+
+type SelfTestInterface struct {
+	description string
+	methods     []string
+}
+
+func (s *SelfTestInterface) VarlinkDispatch(ctx context.Context, call Call, methodname string) error {
+	switch methodname {
+	case "Ping":
+		return call.Reply(ctx, nil)
+	}
+	return call.ReplyMethodNotImplemented(ctx, methodname)
+}
+
+func (s *SelfTestInterface) VarlinkGetName() string {
+	return `org.example.selftest`
+}
+
+func (s *SelfTestInterface) VarlinkGetDescription() string {
+	return s.description
+}
+
+func (s *SelfTestInterface) VarlinkGetMethods() []string {
+	return s.methods
+}
+
+func TestSelfTest(t *testing.T) {
+	newService := func(iface *SelfTestInterface) *Service {
+		service, _ := NewService(
+			"Varlink",
+			"Varlink Test",
+			"1",
+			"https://github.com/varlink/go/varlink",
+		)
+		if err := service.RegisterInterface(iface); err != nil {
+			t.Fatalf("Couldn't register service: %v", err)
+		}
+		return service
+	}
+
+	description := "interface org.example.selftest\nmethod Ping() -> ()\nmethod Pong() -> ()"
+
+	t.Run("Valid", func(t *testing.T) {
+		service := newService(&SelfTestInterface{description, []string{"Ping", "Pong"}})
+		if err := service.SelfTest(context.Background(), "org.example.selftest.Ping"); err != nil {
+			t.Fatalf("SelfTest(): %v", err)
+		}
+	})
+
+	t.Run("FailingSelfCheck", func(t *testing.T) {
+		service := newService(&SelfTestInterface{description, []string{"Ping", "Pong"}})
+		if err := service.SelfTest(context.Background(), "org.example.selftest.Pong"); err == nil {
+			t.Fatal("SelfTest() accepted an error reply")
+		}
+	})
+
+	t.Run("InvalidDescription", func(t *testing.T) {
+		// RegisterInterface refuses the interface, it is only added for the test
+		service := newService(&SelfTestInterface{description, []string{"Ping", "Pong"}})
+		r := service.snapshot().copy()
+		r.descriptions["org.example.selftest"], _ = storeDescription("interface org.example.selftest\nmethod Ping(")
+		service.publish(r)
+		if err := service.SelfTest(context.Background()); err == nil {
+			t.Fatal("SelfTest() accepted an invalid description")
+		}
+	})
+
+	t.Run("WrongName", func(t *testing.T) {
+		// RegisterInterface refuses the interface, it is only added for the test
+		service := newService(&SelfTestInterface{description, []string{"Ping"}})
+		r := service.snapshot().copy()
+		r.descriptions["org.example.selftest"], _ = storeDescription("interface org.example.other\nmethod Ping() -> ()")
+		service.publish(r)
+		if err := service.SelfTest(context.Background()); err == nil {
+			t.Fatal("SelfTest() accepted a mismatching interface name")
+		}
+	})
+
+	t.Run("MissingMethod", func(t *testing.T) {
+		service := newService(&SelfTestInterface{description, []string{"Ping"}})
+		if err := service.SelfTest(context.Background()); err == nil {
+			t.Fatal("SelfTest() accepted an undispatched method")
+		}
+	})
+
+	t.Run("UndescribedMethods", func(t *testing.T) {
+		// The error names the same method on every run
+		for i := 0; i < 10; i++ {
+			service := newService(&SelfTestInterface{description, []string{"Ping", "Pong", "Zap", "Bar", "Foo"}})
+			err := service.SelfTest(context.Background())
+			if err == nil || err.Error() != "interface 'org.example.selftest': dispatched method 'Bar' is not described" {
+				t.Fatalf("SelfTest() returned %v", err)
+			}
+		}
+	})
+}
+
+func TestRegisterInterfaceValidation(t *testing.T) {
+	service, _ := NewService("Varlink", "Varlink Test", "1", "https://github.com/varlink/go/varlink")
+
+	var typedNil *boundInterface
+	for _, iface := range []dispatcher{
+		nil,
+		typedNil,
+		&boundInterface{""},
+		&SelfTestInterface{" \n", nil},
+		&SelfTestInterface{"# no declaration", nil},
+		&SelfTestInterface{"interface org.example.other\nmethod Ping() -> ()", nil},
+		&SelfTestInterface{"interface org.example.selftest", nil},
+		&SelfTestInterface{"interface org.example.selftest\nmethod Ping(", nil},
+	} {
+		if err := service.RegisterInterface(iface); err == nil {
+			t.Fatalf("RegisterInterface(%#v) accepted an invalid interface", iface)
+		}
+	}
+
+	if err := service.RegisterInterface(&SelfTestInterface{"# doc\ninterface org.example.selftest\nmethod Ping() -> ()", nil}); err != nil {
+		t.Fatalf("RegisterInterface(): %v", err)
+	}
+	if err := service.RegisterInterface(&SelfTestInterface{"interface org.example.selftest\nmethod Ping() -> ()", nil}); err == nil {
+		t.Fatal("RegisterInterface() accepted a duplicate interface")
+	}
+	if err := service.RegisterTenantInterface("tenant", nil); err == nil {
+		t.Fatal("RegisterTenantInterface() accepted a nil interface")
+	}
+	if err := service.RegisterTenantInterface("tenant", &SelfTestInterface{"interface org.example.other\nmethod Ping() -> ()", nil}); err == nil {
+		t.Fatal("RegisterTenantInterface() accepted a mismatching interface name")
+	}
+}
+
+func TestConfigInterface(t *testing.T) {
+	level := "info"
+	allowed := false
+
+	config := NewConfigInterface()
+	err := config.Register("log-level", Setting{
+		Description: "The log level",
+		Get:         func() interface{} { return level },
+		Set: func(value json.RawMessage) error {
+			var l string
+			if err := json.Unmarshal(value, &l); err != nil {
+				return err
+			}
+			if l != "info" && l != "debug" {
+				return fmt.Errorf("unknown log level")
+			}
+			level = l
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("Register(): %v", err)
+	}
+
+	// The config interface is guarded by the authorizer of the service
+	service, _ := NewServiceWithConfig(
+		"Varlink",
+		"Varlink Test",
+		"1",
+		"https://github.com/varlink/go/varlink",
+		ServiceConfig{
+			Authorizer: AuthorizerFunc(func(ctx context.Context, r *AuthorizationRequest) error {
+				if r.Interface == "org.varlink.config" && !allowed {
+					return fmt.Errorf("denied")
+				}
+				return nil
+			}),
+		},
+	)
+	if err := service.RegisterInterface(config); err != nil {
+		t.Fatalf("Couldn't register service: %v", err)
+	}
+	if err := service.SelfTest(context.Background()); err != nil {
+		t.Fatalf("SelfTest(): %v", err)
+	}
+
+	call := func(msg string) string {
+		var written []byte
+		wf := readWriterContextFunc(func(ctx context.Context, in []byte) (int, error) {
+			written = append(written, in...)
+			return len(in), nil
+		})
+		if err := service.HandleMessage(context.Background(), wf, []byte(msg)); err != nil {
+			t.Fatalf("HandleMessage returned error: %v", err)
+		}
+		return string(written)
+	}
+
+	expect(t, `{"parameters":{},"error":"org.varlink.service.PermissionDenied"}`+"\000",
+		call(`{"method":"org.varlink.config.List"}`))
+
+	allowed = true
+	expect(t, `{"parameters":{"settings":[{"name":"log-level","description":"The log level","value":"info"}]}}`+"\000",
+		call(`{"method":"org.varlink.config.List"}`))
+	expect(t, `{"parameters":{"name":"log-level","reason":"unknown log level"},"error":"org.varlink.config.InvalidValue"}`+"\000",
+		call(`{"method":"org.varlink.config.Set","parameters":{"name":"log-level","value":"trace"}}`))
+	expect(t, `{}`+"\000",
+		call(`{"method":"org.varlink.config.Set","parameters":{"name":"log-level","value":"debug"}}`))
+	expect(t, `{"parameters":{"value":"debug"}}`+"\000",
+		call(`{"method":"org.varlink.config.Get","parameters":{"name":"log-level"}}`))
+	expect(t, `{"parameters":{"name":"foo"},"error":"org.varlink.config.SettingNotFound"}`+"\000",
+		call(`{"method":"org.varlink.config.Get","parameters":{"name":"foo"}}`))
+
+	// Without an authorizer, the settings can be read but not updated
+	service, _ = NewService(
+		"Varlink",
+		"Varlink Test",
+		"1",
+		"https://github.com/varlink/go/varlink",
+	)
+	if err := service.RegisterInterface(config); err != nil {
+		t.Fatalf("Couldn't register service: %v", err)
+	}
+	expect(t, `{"parameters":{"value":"debug"}}`+"\000",
+		call(`{"method":"org.varlink.config.Get","parameters":{"name":"log-level"}}`))
+	expect(t, `{"parameters":{},"error":"org.varlink.service.PermissionDenied"}`+"\000",
+		call(`{"method":"org.varlink.config.Set","parameters":{"name":"log-level","value":"info"}}`))
+}
+
+type tenantKey struct{}
+
+func TestTenantInterface(t *testing.T) {
+	service, _ := NewServiceWithConfig(
+		"Varlink",
+		"Varlink Test",
+		"1",
+		"https://github.com/varlink/go/varlink",
+		ServiceConfig{
+			TenantFunc: func(ctx context.Context, c *Call) string {
+				return ctx.Value(tenantKey{}).(string)
+			},
+		},
+	)
+
+	iface := &SelfTestInterface{"interface org.example.selftest\nmethod Ping() -> ()\nmethod Pong() -> ()", []string{"Ping", "Pong"}}
+	for _, tenant := range []string{"a", "b"} {
+		if err := service.RegisterTenantInterface(tenant, iface); err != nil {
+			t.Fatalf("RegisterTenantInterface(): %v", err)
+		}
+	}
+	if err := service.RegisterTenantInterface("A", iface); err == nil {
+		t.Fatal("RegisterTenantInterface() accepted an invalid tenant")
+	}
+	if err := service.SelfTest(context.Background()); err != nil {
+		t.Fatalf("SelfTest(): %v", err)
+	}
+
+	call := func(tenant string, msg string) string {
+		var written []byte
+		wf := readWriterContextFunc(func(ctx context.Context, in []byte) (int, error) {
+			written = append(written, in...)
+			return len(in), nil
+		})
+		ctx := context.WithValue(context.Background(), tenantKey{}, tenant)
+		if err := service.HandleMessage(ctx, wf, []byte(msg)); err != nil {
+			t.Fatalf("HandleMessage returned error: %v", err)
+		}
+		return string(written)
+	}
+
+	expect(t, `{}`+"\000",
+		call("a", `{"method":"org.example.a.selftest.Ping"}`))
+	expect(t, `{"parameters":{"interface":"org.example.b.selftest"},"error":"org.varlink.service.InterfaceNotFound"}`+"\000",
+		call("a", `{"method":"org.example.b.selftest.Ping"}`))
+	expect(t, `{"parameters":{"vendor":"Varlink","product":"Varlink Test","version":"1","url":"https://github.com/varlink/go/varlink","interfaces":["org.varlink.service","org.varlink.internal","org.varlink.stream","org.example.b.selftest"]}}`+"\000",
+		call("b", `{"method":"org.varlink.service.GetInfo"}`))
+	expect(t, `{"parameters":{"description":"interface org.example.b.selftest\nmethod Ping() -\u003e ()\nmethod Pong() -\u003e ()"}}`+"\000",
+		call("b", `{"method":"org.varlink.service.GetInterfaceDescription","parameters":{"interface":"org.example.b.selftest"}}`))
+}
+
+type ReloadInterface struct {
+	SelfTestInterface
+	err error
+}
+
+func (s *ReloadInterface) VarlinkReload() error {
+	if s.err != nil {
+		return s.err
+	}
+	s.description = "interface org.example.selftest\nmethod Ping() -> ()"
+	return nil
+}
+
+func TestReload(t *testing.T) {
+	service, _ := NewService(
+		"Varlink",
+		"Varlink Test",
+		"1",
+		"https://github.com/varlink/go/varlink",
+	)
+
+	iface := &ReloadInterface{SelfTestInterface: SelfTestInterface{"interface org.example.selftest\nmethod Pong() -> ()", nil}}
+	if err := service.RegisterInterface(iface); err != nil {
+		t.Fatalf("Couldn't register service: %v", err)
+	}
+	if err := service.Reload(); err != nil {
+		t.Fatalf("Reload(): %v", err)
+	}
+
+	var written []byte
+	wf := readWriterContextFunc(func(ctx context.Context, in []byte) (int, error) {
+		written = append(written, in...)
+		return len(in), nil
+	})
+	msg := []byte(`{"method":"org.varlink.service.GetInterfaceDescription","parameters":{"interface":"org.example.selftest"}}`)
+	if err := service.HandleMessage(context.Background(), wf, msg); err != nil {
+		t.Fatalf("HandleMessage returned error: %v", err)
+	}
+	expect(t, `{"parameters":{"description":"interface org.example.selftest\nmethod Ping() -\u003e ()"}}`+"\000",
+		string(written))
+
+	iface.err = fmt.Errorf("broken")
+	if err := service.Reload(); err == nil {
+		t.Fatal("Reload() should error")
+	}
+
+	var stats bytes.Buffer
+	if err := service.WriteStats(&stats); err != nil {
+		t.Fatalf("WriteStats(): %v", err)
+	}
+	if !strings.Contains(stats.String(), "interface: org.example.selftest\n") {
+		t.Fatalf("Unexpected stats `%s`", stats.String())
+	}
+}
+
+func TestRegistrySnapshot(t *testing.T) {
+	service, _ := NewService(
+		"Varlink",
+		"Varlink Test",
+		"1",
+		"https://github.com/varlink/go/varlink",
+	)
+
+	// Add and remove an interface the way dynamic registration would, while
+	// a client lists the interfaces and asks for each description
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			service.mutex.Lock()
+			r := service.snapshot().copy()
+			if i%2 == 0 {
+				iface := &SelfTestInterface{"interface org.example.selftest\nmethod Ping() -> ()", nil}
+				r.names = append(r.names, "org.example.selftest")
+				r.interfaces["org.example.selftest"] = iface
+				r.descriptions["org.example.selftest"], _ = storeDescription(iface.VarlinkGetDescription())
+			} else {
+				r.names = r.names[:len(r.names)-1]
+				delete(r.interfaces, "org.example.selftest")
+				delete(r.descriptions, "org.example.selftest")
+			}
+			service.publish(r)
+			service.mutex.Unlock()
+		}
+	}()
+
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+
+		r := service.snapshot()
+		for _, name := range r.names {
+			if _, ok := r.descriptions[name]; !ok {
+				t.Fatalf("%s is listed without a description", name)
+			}
+		}
+
+		var written []byte
+		wf := readWriterContextFunc(func(ctx context.Context, in []byte) (int, error) {
+			written = append(written, in...)
+			return len(in), nil
+		})
+		msg := []byte(`{"method":"org.varlink.service.GetInfo"}`)
+		if err := service.HandleMessage(context.Background(), wf, msg); err != nil {
+			t.Fatalf("HandleMessage returned error: %v", err)
+		}
+	}
+}
+
+type ReadyInterface struct {
+	SelfTestInterface
+	ready chan struct{}
+}
+
+func (s *ReadyInterface) VarlinkReady(ctx context.Context) error {
+	select {
+	case <-s.ready:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func TestReadiness(t *testing.T) {
+	service, _ := NewService(
+		"Varlink",
+		"Varlink Test",
+		"1",
+		"https://github.com/varlink/go/varlink",
+	)
+
+	iface := &ReadyInterface{
+		SelfTestInterface: SelfTestInterface{"interface org.example.selftest\nmethod Ping() -> ()", []string{"Ping"}},
+		ready:             make(chan struct{}),
+	}
+	if err := service.RegisterInterface(iface); err != nil {
+		t.Fatalf("Couldn't register service: %v", err)
+	}
+
+	call := func(msg string) string {
+		var written []byte
+		wf := readWriterContextFunc(func(ctx context.Context, in []byte) (int, error) {
+			written = append(written, in...)
+			return len(in), nil
+		})
+		if err := service.HandleMessage(context.Background(), wf, []byte(msg)); err != nil {
+			t.Fatalf("HandleMessage returned error: %v", err)
+		}
+		return string(written)
+	}
+
+	expect(t, `{"parameters":{},"error":"org.varlink.service.ServiceNotAvailable"}`+"\000",
+		call(`{"method":"org.example.selftest.Ping"}`))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := service.WaitReady(ctx); err == nil {
+		t.Fatal("WaitReady() should error")
+	}
+
+	close(iface.ready)
+	if err := service.WaitReady(context.Background()); err != nil {
+		t.Fatalf("WaitReady(): %v", err)
+	}
+	expect(t, `{}`+"\000",
+		call(`{"method":"org.example.selftest.Ping"}`))
+}
+
+func TestReadinessAfterListenReturned(t *testing.T) {
+	service, _ := NewService(
+		"Varlink",
+		"Varlink Test",
+		"1",
+		"https://github.com/varlink/go/varlink",
+	)
+
+	iface := &ReadyInterface{
+		SelfTestInterface: SelfTestInterface{"interface org.example.selftest\nmethod Ping() -> ()", []string{"Ping"}},
+		ready:             make(chan struct{}),
+	}
+	if err := service.RegisterInterface(iface); err != nil {
+		t.Fatalf("Couldn't register service: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		err := service.Listen(context.Background(), "tcp:127.0.0.1:0", 50*time.Millisecond)
+		if _, ok := err.(ServiceTimeoutError); !ok {
+			t.Fatalf("Listen() #%d returned %v", i, err)
+		}
+		// Let the readiness notifier see its canceled context.
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestStoredDescriptions(t *testing.T) {
+	description := "interface org.example.selftest\n" + strings.Repeat("# A long comment.\n", 500) + "method Ping() -> ()"
+	iface := &SelfTestInterface{description, []string{"Ping"}}
+	var services []*Service
+	for i := 0; i < 2; i++ {
+		service, _ := NewService("Varlink", "Varlink Test", "1", "https://github.com/varlink/go/varlink")
+		if err := service.RegisterInterface(iface); err != nil {
+			t.Fatalf("Couldn't register service: %v", err)
+		}
+		services = append(services, service)
+	}
+
+	stored := services[0].snapshot().descriptions["org.example.selftest"]
+	if stored != services[1].snapshot().descriptions["org.example.selftest"] {
+		t.Fatal("The services do not share the description")
+	}
+	if stored.compressed == nil || len(stored.compressed) >= len(description)/10 {
+		t.Fatalf("The description is stored in %d bytes", len(stored.compressed)+len(stored.plain))
+	}
+	if text, err := stored.text(); err != nil || text != description {
+		t.Fatalf("text() returned %v", err)
+	}
+
+	// The changed description is stored on its own
+	iface.description += "\nmethod Pong() -> ()"
+	if err := services[0].Reload(); err != nil {
+		t.Fatalf("Reload(): %v", err)
+	}
+	reloaded := services[0].snapshot().descriptions["org.example.selftest"]
+	if text, _ := reloaded.text(); reloaded == stored || text != iface.description {
+		t.Fatal("Reload() kept the description")
+	}
+
+	// The description is released with the last interface
+	sum := stored.sum
+	for _, service := range services {
+		service.UnregisterInterface("org.example.selftest")
+	}
+	descriptions.mutex.Lock()
+	_, ok := descriptions.stored[sum]
+	_, reloadedOk := descriptions.stored[reloaded.sum]
+	descriptions.mutex.Unlock()
+	if ok || reloadedOk {
+		t.Fatal("The unregistered descriptions are still stored")
+	}
+}
+
+func TestDNSResolver(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen(): %v", err)
+	}
+	defer l.Close()
+	port := l.Addr().(*net.TCPAddr).Port
+
+	defer func(srv func(context.Context, string, string, string) (string, []*net.SRV, error), txt func(context.Context, string) ([]string, error)) {
+		lookupSRV = srv
+		lookupTXT = txt
+	}(lookupSRV, lookupTXT)
+
+	lookupSRV = func(ctx context.Context, service string, proto string, name string) (string, []*net.SRV, error) {
+		switch name {
+		case "org.example.plain.example.com", "org.example.tls.example.com", "org.example.servfail.example.com":
+			return "", []*net.SRV{{Target: "127.0.0.1.", Port: uint16(port)}}, nil
+		}
+		return "", nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	lookupTXT = func(ctx context.Context, name string) ([]string, error) {
+		switch name {
+		case "_varlink._tcp.org.example.tls.example.com":
+			return []string{"tls=required"}, nil
+		case "_varlink._tcp.org.example.servfail.example.com":
+			return nil, &net.DNSError{Err: "server misbehaving", Name: name, IsTemporary: true}
+		}
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+
+	r := DNSResolver("example.com.")
+	for iface, expected := range map[string]string{
+		"org.example.plain":   fmt.Sprintf("tcp:127.0.0.1:%d", port),
+		"org.example.tls":     fmt.Sprintf("tcp:127.0.0.1:%d;tls", port),
+		"org.example.missing": "",
+	} {
+		address, err := r.ResolveName(context.Background(), iface)
+		if err != nil {
+			t.Fatalf("ResolveName(): %v", err)
+		}
+		if address != expected {
+			t.Fatalf("ResolveName(%s) returned %s", iface, address)
+		}
+	}
+
+	// A failed TXT lookup does not drop the TLS requirement
+	if address, err := r.ResolveName(context.Background(), "org.example.servfail"); err == nil {
+		t.Fatalf("ResolveName() with a failing TXT lookup returned %s", address)
+	}
+
+	c, err := NewConnection(context.Background(), "varlink://org.example.plain@example.com")
+	if err != nil {
+		t.Fatalf("NewConnection(): %v", err)
+	}
+	c.Close()
+
+	for _, address := range []string{
+		"varlink://org.example.tls@example.com",
+		"varlink://org.example.missing@example.com",
+		"varlink://example.com",
+	} {
+		// The listener never completes the TLS handshake
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second/5)
+		defer cancel()
+		if _, err := NewConnection(ctx, address); err == nil {
+			t.Fatalf("NewConnection(%s) should error", address)
+		}
+	}
+}
+
+type NumberInterface struct{}
+
+func (s *NumberInterface) VarlinkDispatch(ctx context.Context, call Call, methodname string) error {
+	switch methodname {
+	case "Typed":
+		var in struct {
+			Count int64 `json:"count"`
+		}
+		if err := call.GetParameters(&in); err != nil {
+			return call.ReplyInvalidParameter(ctx, "count")
+		}
+		return call.Reply(ctx, &in)
+
+	default:
+		var in map[string]interface{}
+		if err := call.GetParameters(&in); err != nil {
+			return call.ReplyInvalidParameter(ctx, "parameters")
+		}
+		return call.Reply(ctx, map[string]interface{}{"count": in["count"], "type": fmt.Sprintf("%T", in["count"])})
+	}
+}
+
+func (s *NumberInterface) VarlinkGetName() string {
+	return `org.example.number`
+}
+
+func (s *NumberInterface) VarlinkGetDescription() string {
+	return `interface org.example.number
+method Typed(count: int) -> (count: int)
+method Untyped(count: object) -> (count: object, type: string)`
+}
+
+func TestNumbers(t *testing.T) {
+	newCall := func(config ServiceConfig) func(string) string {
+		service, _ := NewServiceWithConfig(
+			"Varlink",
+			"Varlink Test",
+			"1",
+			"https://github.com/varlink/go/varlink",
+			config,
+		)
+		if err := service.RegisterInterface(&NumberInterface{}); err != nil {
+			t.Fatalf("Couldn't register service: %v", err)
+		}
+
+		return func(msg string) string {
+			var written []byte
+			wf := readWriterContextFunc(func(ctx context.Context, in []byte) (int, error) {
+				written = append(written, in...)
+				return len(in), nil
+			})
+			if err := service.HandleMessage(context.Background(), wf, []byte(msg)); err != nil {
+				t.Fatalf("HandleMessage returned error: %v", err)
+			}
+			return string(written)
+		}
+	}
+	call := newCall(ServiceConfig{UseNumber: true})
+
+	// The full int64 range survives, floats are rejected for ints
+	expect(t, `{"parameters":{"count":9223372036854775807}}`+"\000",
+		call(`{"method":"org.example.number.Typed","parameters":{"count":9223372036854775807}}`))
+	expect(t, `{"parameters":{"parameter":"count"},"error":"org.varlink.service.InvalidParameter"}`+"\000",
+		call(`{"method":"org.example.number.Typed","parameters":{"count":1.5}}`))
+	for _, config := range []ServiceConfig{{}, {UseNumber: true}} {
+		for _, count := range []string{"1.5", "1e2", "1.0", "-0.5"} {
+			expect(t, `{"parameters":{"parameter":"count"},"error":"org.varlink.service.InvalidParameter"}`+"\000",
+				newCall(config)(`{"method":"org.example.number.Typed","parameters":{"count":`+count+`}}`))
+		}
+	}
+
+	// An int above 2^53 makes the round trip through untyped parameters
+	untyped := `{"method":"org.example.number.Untyped","parameters":{"count":9007199254740993}}`
+	expect(t, `{"parameters":{"count":9007199254740993,"type":"json.Number"}}`+"\000", call(untyped))
+
+	var out map[string]interface{}
+	reply := json.RawMessage(`{"count":9007199254740993}`)
+	if err := decode("org.example.number.Untyped", &reply, &out, false, true); err != nil {
+		t.Fatalf("decode(): %v", err)
+	}
+	if b, _ := json.Marshal(out); string(b) != string(reply) {
+		t.Fatalf("reply decoded as %s", b)
+	}
+
+	// By default the precision is lost, like with encoding/json
+	expect(t, `{"parameters":{"count":9007199254740992,"type":"float64"}}`+"\000",
+		newCall(ServiceConfig{})(untyped))
+}
+
+type alphaInterface interface{ Alpha() }
+type betaInterface interface{ Beta() }
+
+type composedImpl struct{}
+
+type boundInterface struct{ name string }
+
+func (s *boundInterface) VarlinkDispatch(ctx context.Context, call Call, methodname string) error {
+	return call.ReplyMethodNotImplemented(ctx, methodname)
+}
+
+func (s *boundInterface) VarlinkGetName() string {
+	return s.name
+}
+
+func (s *boundInterface) VarlinkGetDescription() string {
+	return "interface " + s.name + "\nmethod Ping() -> ()"
+}
+
+func (composedImpl) Alpha() {}
+
+func TestRegisterAll(t *testing.T) {
+	RegisterBinding("org.example.alpha", func(impl interface{}) interface{} {
+		if _, ok := impl.(alphaInterface); ok {
+			return &boundInterface{"org.example.alpha"}
+		}
+		return nil
+	})
+	RegisterBinding("org.example.beta", func(impl interface{}) interface{} {
+		if _, ok := impl.(betaInterface); ok {
+			return &boundInterface{"org.example.beta"}
+		}
+		return nil
+	})
+	defer func() {
+		bindings.Lock()
+		delete(bindings.bind, "org.example.alpha")
+		delete(bindings.bind, "org.example.beta")
+		bindings.Unlock()
+	}()
+
+	service, _ := NewService(
+		"Varlink",
+		"Varlink Test",
+		"1",
+		"https://github.com/varlink/go/varlink",
+	)
+
+	if err := RegisterAll(service, composedImpl{}); err != nil {
+		t.Fatalf("RegisterAll failed: %v", err)
+	}
+	if _, ok := service.snapshot().interfaces["org.example.alpha"]; !ok {
+		t.Fatalf("org.example.alpha is not registered")
+	}
+	if _, ok := service.snapshot().interfaces["org.example.beta"]; ok {
+		t.Fatalf("org.example.beta is registered without being implemented")
+	}
+
+	if err := RegisterAll(service, struct{}{}); err == nil {
+		t.Fatalf("RegisterAll accepted a type implementing no interface")
+	}
+}
+
+func TestParseCgroup(t *testing.T) {
+	for _, test := range []struct {
+		content string
+		cgroup  string
+		unit    string
+	}{
+		{"0::/system.slice/example.service\n", "/system.slice/example.service", "example.service"},
+		{"12:pids:/user.slice\n1:name=systemd:/user.slice/user-1000.slice/session-2.scope\n", "/user.slice/user-1000.slice/session-2.scope", "session-2.scope"},
+		{"0::/user.slice/user-1000.slice/user@1000.service/app.slice/example.service\n", "/user.slice/user-1000.slice/user@1000.service/app.slice/example.service", "example.service"},
+		{"0::/\n", "/", ""},
+	} {
+		p := Process{Cgroup: parseCgroup(test.content)}
+		if p.Cgroup != test.cgroup || p.Unit() != test.unit {
+			t.Fatalf("Unexpected cgroup '%s' unit '%s' for %q", p.Cgroup, p.Unit(), test.content)
+		}
+	}
+}
+
+func TestAccounting(t *testing.T) {
+	service, _ := NewServiceWithConfig(
+		"Varlink",
+		"Varlink Test",
+		"1",
+		"https://github.com/varlink/go/varlink",
+		ServiceConfig{AccountAllocations: true},
+	)
+	if err := service.RegisterInterface(&NumberInterface{}); err != nil {
+		t.Fatalf("Couldn't register service: %v", err)
+	}
+
+	wf := readWriterContextFunc(func(ctx context.Context, in []byte) (int, error) {
+		return len(in), nil
+	})
+	for i := 0; i < 2; i++ {
+		msg := []byte(`{"method":"org.example.number.Untyped","parameters":{"count":1}}`)
+		if err := service.HandleMessage(context.Background(), wf, msg); err != nil {
+			t.Fatalf("HandleMessage returned error: %v", err)
+		}
+	}
+
+	usage := service.Usage()
+	if len(usage) != 1 || usage[0].Method != "org.example.number.Untyped" || usage[0].Calls != 2 {
+		t.Fatalf("Unexpected usage %+v", usage)
+	}
+	if usage[0].WallTime <= 0 || usage[0].MaxWallTime > usage[0].WallTime || usage[0].AllocatedBytes == 0 {
+		t.Fatalf("Unexpected measurements %+v", usage[0])
+	}
+
+	var b bytes.Buffer
+	if err := service.WriteStats(&b); err != nil {
+		t.Fatalf("WriteStats: %v", err)
+	}
+	if !strings.Contains(b.String(), "method: org.example.number.Untyped calls=2 wall=") ||
+		!strings.Contains(b.String(), " allocated=") {
+		t.Fatalf("Unexpected stats:\n%s", b.String())
+	}
+}
+
+type priorityConn struct {
+	net.Conn
+	priority int
+	closed   bool
+}
+
+func (c *priorityConn) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestConnectionShedding(t *testing.T) {
+	service, _ := NewServiceWithConfig(
+		"Varlink",
+		"Varlink Test",
+		"1",
+		"https://github.com/varlink/go/varlink",
+		ServiceConfig{
+			MaxConnections:     2,
+			ConnectionPriority: func(conn net.Conn) int { return conn.(*priorityConn).priority },
+		},
+	)
+
+	admit := func(priority int) (*priorityConn, *trackedConn, bool) {
+		c := &priorityConn{priority: priority}
+		tc, ok := service.admit(c)
+		return c, tc, ok
+	}
+
+	operator, operatorTracked, _ := admit(10)
+	user, userTracked, _ := admit(0)
+
+	// A connection of the same priority does not push out the idle one
+	if _, _, ok := admit(0); ok || user.closed || operator.closed {
+		t.Fatalf("Connection of the same priority admitted over the limit")
+	}
+
+	// The idle user connection makes room for a higher priority one
+	staff, staffTracked, ok := admit(5)
+	if !ok || !user.closed || operator.closed {
+		t.Fatalf("Idle user connection was not shed")
+	}
+	service.forget(userTracked)
+
+	// A busy connection is kept, the idle operator makes room for an admin
+	service.setBusy(staffTracked, true)
+	if _, _, ok := admit(20); !ok || !operator.closed || staff.closed {
+		t.Fatalf("Idle operator connection was not shed")
+	}
+	service.forget(operatorTracked)
+
+	// A busy connection is kept, a connection of higher priority is kept
+	if _, _, ok := admit(10); ok || staff.closed {
+		t.Fatalf("Busy or higher priority connection was shed")
+	}
+}
+
+func TestMemoryShedding(t *testing.T) {
+	service, _ := NewServiceWithConfig(
+		"Varlink",
+		"Varlink Test",
+		"1",
+		"https://github.com/varlink/go/varlink",
+		ServiceConfig{
+			MaxMemory:          1000,
+			ConnectionPriority: func(conn net.Conn) int { return conn.(*priorityConn).priority },
+		},
+	)
+	var used uint64
+	service.memoryUsage = func() uint64 { return used }
+
+	admit := func(priority int) (*priorityConn, *trackedConn, bool) {
+		c := &priorityConn{priority: priority}
+		tc, ok := service.admit(c)
+		return c, tc, ok
+	}
+
+	// Below the limit all connections are admitted
+	operator, _, _ := admit(10)
+	first, firstTracked, _ := admit(0)
+	second, _, ok := admit(0)
+	if !ok || first.closed || second.closed || operator.closed {
+		t.Fatalf("Connection refused below the memory limit")
+	}
+
+	// Over the limit, a connection of the same priority is refused
+	used = 2000
+	if _, _, ok := admit(0); ok {
+		t.Fatalf("Connection admitted over the memory limit")
+	}
+
+	// The longest idle user connection makes room for a higher priority one
+	time.Sleep(time.Millisecond)
+	service.setBusy(firstTracked, true)
+	service.setBusy(firstTracked, false)
+	if _, _, ok := admit(5); !ok || !second.closed || first.closed || operator.closed {
+		t.Fatalf("Idle user connection was not shed")
+	}
+}
+
+type GroupInterface struct {
+	finished chan string
+}
+
+func (s *GroupInterface) VarlinkDispatch(ctx context.Context, call Call, methodname string) error {
+	switch methodname {
+	case "Fail":
+		for i := 0; i < 3; i++ {
+			i := i
+			call.Go(func(ctx context.Context) error {
+				if i == 1 {
+					return fmt.Errorf("worker %d failed", i)
+				}
+				<-ctx.Done()
+				return nil
+			})
+		}
+		if err := call.Wait(); err != nil {
+			return call.ReplyError(ctx, "org.example.group.Failed", map[string]string{"reason": err.Error()})
+		}
+		return call.Reply(ctx, nil)
+
+	case "Background":
+		call.Go(func(ctx context.Context) error {
+			<-ctx.Done()
+			time.Sleep(time.Second / 20)
+			s.finished <- "background"
+			return ctx.Err()
+		})
+		return call.Reply(ctx, nil)
+	}
+
+	return call.ReplyMethodNotFound(ctx, methodname)
+}
+
+func (s *GroupInterface) VarlinkGetName() string {
+	return `org.example.group`
+}
+
+func (s *GroupInterface) VarlinkGetDescription() string {
+	return `interface org.example.group
+method Fail() -> ()
+method Background() -> ()
+error Failed (reason: string)`
+}
+
+func TestCallGo(t *testing.T) {
+	service, _ := NewService(
+		"Varlink",
+		"Varlink Test",
+		"1",
+		"https://github.com/varlink/go/varlink",
+	)
+	group := &GroupInterface{finished: make(chan string, 2)}
+	if err := service.RegisterInterface(group); err != nil {
+		t.Fatalf("Couldn't register service: %v", err)
+	}
+
+	var b bytes.Buffer
+	wf := readWriterContextFunc(func(ctx context.Context, in []byte) (int, error) {
+		return b.Write(in)
+	})
+
+	// The first error cancels the other goroutines
+	if err := service.HandleMessage(context.Background(), wf, []byte(`{"method":"org.example.group.Fail"}`)); err != nil {
+		t.Fatalf("HandleMessage returned error: %v", err)
+	}
+	expect(t, `{"parameters":{"reason":"worker 1 failed"},"error":"org.example.group.Failed"}`+"\000", b.String())
+
+	// The call ends after its goroutines
+	b.Reset()
+	if err := service.HandleMessage(context.Background(), wf, []byte(`{"method":"org.example.group.Background"}`)); err != nil {
+		t.Fatalf("HandleMessage returned error: %v", err)
+	}
+	group.finished <- "call"
+	if first := <-group.finished; first != "background" {
+		t.Fatalf("Call ended before its goroutine")
+	}
+}
+
+type CPUInterface struct {
+	mutex   sync.Mutex
+	running int
+	max     int
+}
+
+func (s *CPUInterface) VarlinkDispatch(ctx context.Context, call Call, methodname string) error {
+	s.mutex.Lock()
+	s.running++
+	if s.running > s.max {
+		s.max = s.running
+	}
+	s.mutex.Unlock()
+
+	time.Sleep(time.Second / 20)
+
+	s.mutex.Lock()
+	s.running--
+	s.mutex.Unlock()
+	return call.Reply(ctx, nil)
+}
+
+func (s *CPUInterface) VarlinkGetName() string {
+	return `org.example.cpu`
+}
+
+func (s *CPUInterface) VarlinkGetDescription() string {
+	return `interface org.example.cpu
+method Compute() -> ()`
+}
+
+func TestWorkerPools(t *testing.T) {
+	if p := newWorkerPool(0); cap(p.workers) != runtime.GOMAXPROCS(0) {
+		t.Fatalf("Default pool has %d workers", cap(p.workers))
+	}
+
+	service, _ := NewServiceWithConfig(
+		"Varlink",
+		"Varlink Test",
+		"1",
+		"https://github.com/varlink/go/varlink",
+		ServiceConfig{WorkerPools: map[string]int{"org.example.cpu": 2}},
+	)
+	cpu := &CPUInterface{}
+	if err := service.RegisterInterface(cpu); err != nil {
+		t.Fatalf("Couldn't register service: %v", err)
+	}
+
+	wf := readWriterContextFunc(func(ctx context.Context, in []byte) (int, error) {
+		return len(in), nil
+	})
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := service.HandleMessage(context.Background(), wf, []byte(`{"method":"org.example.cpu.Compute"}`)); err != nil {
+				t.Errorf("HandleMessage returned error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if cpu.max != 2 {
+		t.Fatalf("%d calls ran concurrently on a pool of 2", cpu.max)
+	}
+
+	var b bytes.Buffer
+	if err := service.WriteStats(&b); err != nil {
+		t.Fatalf("WriteStats: %v", err)
+	}
+	if !strings.Contains(b.String(), "pool: org.example.cpu workers=2 busy=0\n") {
+		t.Fatalf("Unexpected stats:\n%s", b.String())
+	}
+}
+
+type blockingInterface struct{ release chan struct{} }
+
+func (s *blockingInterface) VarlinkDispatch(ctx context.Context, call Call, methodname string) error {
+	<-s.release
+	return call.Reply(ctx, nil)
+}
+
+func (s *blockingInterface) VarlinkGetName() string {
+	return `org.example.block`
+}
+
+func (s *blockingInterface) VarlinkGetDescription() string {
+	return `interface org.example.block
+method Block() -> ()`
+}
+
+func TestWorkerPoolScheduling(t *testing.T) {
+	service, _ := NewServiceWithConfig(
+		"Varlink",
+		"Varlink Test",
+		"1",
+		"https://github.com/varlink/go/varlink",
+		ServiceConfig{MaxCalls: 1, WorkerPools: map[string]int{"org.example.cpu": 1}},
+	)
+	block := &blockingInterface{make(chan struct{})}
+	for _, iface := range []dispatcher{block, &CPUInterface{}} {
+		if err := service.RegisterInterface(iface); err != nil {
+			t.Fatalf("Couldn't register service: %v", err)
+		}
+	}
+
+	wf := readWriterContextFunc(func(ctx context.Context, in []byte) (int, error) {
+		return len(in), nil
+	})
+	var wg sync.WaitGroup
+	for _, method := range []string{"org.example.block.Block", "org.example.cpu.Compute"} {
+		wg.Add(1)
+		go func(method string) {
+			defer wg.Done()
+			if err := service.HandleMessage(context.Background(), wf, []byte(`{"method":"`+method+`"}`)); err != nil {
+				t.Errorf("HandleMessage returned error: %v", err)
+			}
+		}(method)
+		time.Sleep(time.Second / 20)
+	}
+
+	// The compute call waits for the scheduler without holding a worker
+	if busy := len(service.pools["org.example.cpu"].workers); busy != 0 {
+		t.Fatalf("%d workers held by a call waiting for the scheduler", busy)
+	}
+	close(block.release)
+	wg.Wait()
+}
+
+type FlushInterface struct{ policy *FlushPolicy }
+
+func (s *FlushInterface) VarlinkDispatch(ctx context.Context, call Call, methodname string) error {
+	if s.policy != nil {
+		call.SetFlushPolicy(*s.policy)
+	}
+	for i := 0; i < 3; i++ {
+		call.Continues = i < 2
+		if err := call.Reply(ctx, map[string]int{"n": i}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *FlushInterface) VarlinkGetName() string {
+	return `org.example.flush`
+}
+
+func (s *FlushInterface) VarlinkGetDescription() string {
+	return `interface org.example.flush
+method Count() -> (n: int)`
+}
+
+func TestFlushPolicy(t *testing.T) {
+	writes := func(config ServiceConfig, policy *FlushPolicy) []string {
+		service, _ := NewServiceWithConfig("Varlink", "Varlink Test", "1", "https://github.com/varlink/go/varlink", config)
+		if err := service.RegisterInterface(&FlushInterface{policy}); err != nil {
+			t.Fatalf("RegisterInterface(): %v", err)
+		}
+
+		var written []string
+		wf := readWriterContextFunc(func(ctx context.Context, in []byte) (int, error) {
+			written = append(written, string(in))
+			return len(in), nil
+		})
+		if err := service.HandleMessage(context.Background(), wf, []byte(`{"method":"org.example.flush.Count","more":true}`)); err != nil {
+			t.Fatalf("HandleMessage(): %v", err)
+		}
+		return written
+	}
+
+	replies := `{"parameters":{"n":0},"continues":true}` + "\000" +
+		`{"parameters":{"n":1},"continues":true}` + "\000" +
+		`{"parameters":{"n":2}}` + "\000"
+
+	if w := writes(ServiceConfig{}, nil); len(w) != 3 || strings.Join(w, "") != replies {
+		t.Fatalf("replies written as %q", w)
+	}
+	if w := writes(ServiceConfig{FlushPolicy: FlushOnReturn}, nil); len(w) != 1 || w[0] != replies {
+		t.Fatalf("buffered replies written as %q", w)
+	}
+
+	every := FlushEveryReply
+	if w := writes(ServiceConfig{FlushPolicy: FlushOnReturn}, &every); len(w) != 3 {
+		t.Fatalf("replies of the overriding call written as %q", w)
+	}
+	onReturn := FlushOnReturn
+	if w := writes(ServiceConfig{}, &onReturn); len(w) != 1 || w[0] != replies {
+		t.Fatalf("buffered replies of the overriding call written as %q", w)
+	}
+}
+
+func TestVsockAddr(t *testing.T) {
+	for _, tc := range []struct {
+		address string
+		listen  bool
+		want    string
+	}{
+		{"2:1024", false, "2:1024"},
+		{"3:1024", true, "3:1024"},
+		{":1024", true, ":1024"},
+		{":1024", false, ""},
+		{"2", false, ""},
+		{"2:port", false, ""},
+		{"-1:1024", false, ""},
+	} {
+		addr, err := parseVsockAddr(tc.address, tc.listen)
+		if tc.want == "" {
+			if err == nil {
+				t.Fatalf("parseVsockAddr(%q) returned %s", tc.address, addr)
+			}
+			continue
+		}
+		if err != nil || addr.String() != tc.want {
+			t.Fatalf("parseVsockAddr(%q) returned %v %v", tc.address, addr, err)
+		}
+	}
+}
+
+func TestPipeName(t *testing.T) {
+	for address, name := range map[string]string{
+		"org.example.service":          `\\.\pipe\org.example.service`,
+		`\\.\pipe\org.example.service`: `\\.\pipe\org.example.service`,
+		"//./pipe/org.example.service": `\\.\pipe\org.example.service`,
+	} {
+		if n := pipeName(address); string(n) != name {
+			t.Fatalf("pipeName(%s) returned %s", address, n)
+		}
+	}
+}
+
+type LoginInterface struct{}
+
+func (s *LoginInterface) VarlinkDispatch(ctx context.Context, call Call, methodname string) error {
+	var in struct {
+		User struct {
+			Name     string `json:"name"`
+			Password string `json:"password"`
+		} `json:"user"`
+		Attempts int64 `json:"attempts"`
+	}
+	if err := call.GetParameters(&in); err != nil {
+		return call.ReplyParameterError(ctx, err)
+	}
+	return call.Reply(ctx, nil)
+}
+
+func (s *LoginInterface) VarlinkGetName() string {
+	return `org.example.login`
+}
+
+func (s *LoginInterface) VarlinkGetDescription() string {
+	return `interface org.example.login
+type User (name: string, password: string)
+method Login(user: User, attempts: int) -> ()`
+}
+
+func TestParameterDetails(t *testing.T) {
+	newCall := func(config ServiceConfig) func(string) string {
+		service, _ := NewServiceWithConfig(
+			"Varlink",
+			"Varlink Test",
+			"1",
+			"https://github.com/varlink/go/varlink",
+			config,
+		)
+		if err := service.RegisterInterface(&LoginInterface{}); err != nil {
+			t.Fatalf("Couldn't register service: %v", err)
+		}
+
+		return func(parameters string) string {
+			var written []byte
+			wf := readWriterContextFunc(func(ctx context.Context, in []byte) (int, error) {
+				written = append(written, in...)
+				return len(in), nil
+			})
+			msg := `{"method":"org.example.login.Login","parameters":` + parameters + `}`
+			if err := service.HandleMessage(context.Background(), wf, []byte(msg)); err != nil {
+				t.Fatalf("HandleMessage returned error: %v", err)
+			}
+			return string(written)
+		}
+	}
+
+	// Without details only the parameter is named
+	expect(t, `{"parameters":{"parameter":"attempts"},"error":"org.varlink.service.InvalidParameter"}`+"\000",
+		newCall(ServiceConfig{})(`{"attempts":"many"}`))
+	expect(t, `{"parameters":{"parameter":"parameters"},"error":"org.varlink.service.InvalidParameter"}`+"\000",
+		newCall(ServiceConfig{})(`[]`))
+
+	call := newCall(ServiceConfig{ParameterDetails: true, RedactParameters: []string{"password"}})
+	expect(t, `{"parameters":{"parameter":"attempts","value":"\"many\"","expected":"int"},"error":"org.varlink.parameters.Invalid"}`+"\000",
+		call(`{"attempts":"many"}`))
+	expect(t, `{"parameters":{"parameter":"user.name","value":"42","expected":"string"},"error":"org.varlink.parameters.Invalid"}`+"\000",
+		call(`{"user":{"name":42}}`))
+	expect(t, `{"parameters":{"parameter":"attempts","value":"2.5","expected":"int"},"error":"org.varlink.parameters.Invalid"}`+"\000",
+		call(`{"attempts":2.5}`))
+
+	// Redacted values are never echoed
+	expect(t, `{"parameters":{"parameter":"user.password","expected":"string"},"error":"org.varlink.parameters.Invalid"}`+"\000",
+		call(`{"user":{"password":1234}}`))
+
+	// Long values are cut
+	long := `["` + strings.Repeat("x", 2*maxEchoSize) + `"]`
+	expect(t, `{"parameters":{"parameter":"attempts","value":"`+strings.ReplaceAll(long[:maxEchoSize], `"`, `\"`)+`...","expected":"int"},"error":"org.varlink.parameters.Invalid"}`+"\000",
+		call(`{"attempts":`+long+`}`))
+}
+
+func TestMultipleListeners(t *testing.T) {
+	service, _ := NewService(
+		"Varlink",
+		"Varlink Test",
+		"1",
+		"https://github.com/varlink/go/varlink",
+	)
+
+	var listeners []net.Listener
+	for i := 0; i < 2; i++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Listen(): %v", err)
+		}
+		listeners = append(listeners, l)
+	}
+	l := newMultiListener(listeners)
+	service.listener = l
+
+	servererror := make(chan error)
+	go func() {
+		servererror <- service.DoListen(context.Background(), 0)
+	}()
+
+	// Both sockets are served at the same time
+	var conns []*Connection
+	for _, listener := range listeners {
+		c, err := NewConnection(context.Background(), "tcp:"+listener.Addr().String())
+		if err != nil {
+			t.Fatalf("NewConnection(): %v", err)
+		}
+		defer c.Close()
+		conns = append(conns, c)
+	}
+	for _, c := range conns {
+		var vendor string
+		if err := c.GetInfo(context.Background(), &vendor, nil, nil, nil, nil); err != nil || vendor != "Varlink" {
+			t.Fatalf("GetInfo(): %q %v", vendor, err)
+		}
+	}
+
+	service.Shutdown()
+	if err := <-servererror; err != nil {
+		t.Fatalf("DoListen(): %v", err)
+	}
+	if _, err := listeners[1].Accept(); err == nil {
+		t.Fatalf("listener still open")
+	}
+
+	// Accept times out at the deadline
+	l = newMultiListener([]net.Listener{NewProtocolListener(nil)})
+	defer l.Close()
+	l.SetDeadline(time.Now().Add(time.Millisecond))
+	if _, err := l.Accept(); err == nil || !err.(net.Error).Timeout() {
+		t.Fatalf("Accept(): %v", err)
+	}
+}
+
+func TestListenerVisibility(t *testing.T) {
+	var listeners []net.Listener
+	for i := 0; i < 2; i++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Listen(): %v", err)
+		}
+		listeners = append(listeners, l)
+	}
+
+	// The administration interface is only reachable on the first listener
+	service, _ := NewServiceWithConfig(
+		"Varlink",
+		"Varlink Test",
+		"1",
+		"https://github.com/varlink/go/varlink",
+		ServiceConfig{
+			Visible: func(l net.Listener, iface string) bool {
+				return iface != "org.example.admin" || l == listeners[0]
+			},
+		},
+	)
+	for _, name := range []string{"org.example.admin", "org.example.public"} {
+		if err := service.RegisterInterface(&boundInterface{name: name}); err != nil {
+			t.Fatalf("Couldn't register service: %v", err)
+		}
+	}
+	service.listener = newMultiListener(listeners)
+
+	servererror := make(chan error)
+	go func() {
+		servererror <- service.DoListen(context.Background(), 0)
+	}()
+
+	for i, expected := range [][]string{{"org.example.admin", "org.example.public"}, {"org.example.public"}} {
+		c, err := NewConnection(context.Background(), "tcp:"+listeners[i].Addr().String())
+		if err != nil {
+			t.Fatalf("NewConnection(): %v", err)
+		}
+		defer c.Close()
+
+		var interfaces []string
+		if err := c.GetInfo(context.Background(), nil, nil, nil, nil, &interfaces); err != nil {
+			t.Fatalf("GetInfo(): %v", err)
+		}
+		expect(t, strings.Join(append([]string{"org.varlink.service", "org.varlink.internal", "org.varlink.stream"}, expected...), " "), strings.Join(interfaces, " "))
+
+		err = c.Call(context.Background(), "org.example.admin.Reset", nil, nil)
+		if _, hidden := err.(*InterfaceNotFound); hidden != (i == 1) {
+			t.Fatalf("Call() on listener %d: %v", i, err)
+		}
+	}
+
+	// A connection served without a listener is checked with a nil listener
+	config := DialConfig{
+		DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+			client, server := net.Pipe()
+			go service.ServeConn(context.Background(), server)
+			return client, nil
+		},
+	}
+	c, err := NewConnectionWithConfig(context.Background(), "tcp:pipe:0", config)
+	if err != nil {
+		t.Fatalf("NewConnectionWithConfig(): %v", err)
+	}
+	defer c.Close()
+	err = c.Call(context.Background(), "org.example.admin.Reset", nil, nil)
+	if _, hidden := err.(*InterfaceNotFound); !hidden {
+		t.Fatalf("Call() on ServeConn: %v", err)
+	}
+
+	service.Shutdown()
+	if err := <-servererror; err != nil {
+		t.Fatalf("DoListen(): %v", err)
+	}
+}
+
+func TestMaintenance(t *testing.T) {
+	service, _ := NewServiceWithConfig(
+		"Varlink",
+		"Varlink Test",
+		"1",
+		"https://github.com/varlink/go/varlink",
+		ServiceConfig{MaintenanceAllowed: []string{"org.example.admin", "org.example.public.Status"}},
+	)
+	for _, name := range []string{"org.example.admin", "org.example.public"} {
+		if err := service.RegisterInterface(&boundInterface{name: name}); err != nil {
+			t.Fatalf("Couldn't register service: %v", err)
+		}
+	}
+
+	call := func(method string) string {
+		var written []byte
+		wf := readWriterContextFunc(func(ctx context.Context, in []byte) (int, error) {
+			written = append(written, in...)
+			return len(in), nil
+		})
+		msg := `{"method":"` + method + `"}`
+		if err := service.HandleMessage(context.Background(), wf, []byte(msg)); err != nil {
+			t.Fatalf("HandleMessage returned error: %v", err)
+		}
+		return string(written)
+	}
+	notImplemented := func(method string) string {
+		return `{"parameters":{"method":"` + method[strings.LastIndex(method, ".")+1:] + `"},"error":"org.varlink.service.MethodNotImplemented"}` + "\000"
+	}
+
+	service.SetMaintenance(true, "migrating", 1500*time.Millisecond)
+	expect(t, `{"parameters":{"message":"migrating","retry_after":2},"error":"org.varlink.maintenance.Unavailable"}`+"\000",
+		call("org.example.public.Get"))
+	for _, method := range []string{"org.example.public.Status", "org.example.admin.Migrate"} {
+		expect(t, notImplemented(method), call(method))
+	}
+	if !strings.Contains(call("org.varlink.service.GetInfo"), `"org.varlink.maintenance"`) {
+		t.Fatal("GetInfo() is not available in maintenance, or does not list the error interface")
+	}
+
+	service.SetMaintenance(false, "", 0)
+	expect(t, notImplemented("org.example.public.Get"), call("org.example.public.Get"))
+}
+
+func TestPipelineBuffersOneReply(t *testing.T) {
+	var mutex sync.Mutex
+	var written []string
+	p := &pipeline{conn: readWriterContextFunc(func(ctx context.Context, in []byte) (int, error) {
+		mutex.Lock()
+		written = append(written, string(in))
+		mutex.Unlock()
+		return len(in), nil
+	})}
+	head := p.add()
+	stream := p.add()
+
+	// The first reply of the call behind the head is buffered, the next waits
+	if _, err := stream.Write(context.Background(), []byte("1")); err != nil {
+		t.Fatalf("Write(): %v", err)
+	}
+	done := make(chan error)
+	go func() {
+		_, err := stream.Write(context.Background(), []byte("2"))
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("Write() did not wait for the head: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	head.Write(context.Background(), []byte("0"))
+	head.end(context.Background())
+	if err := <-done; err != nil {
+		t.Fatalf("Write(): %v", err)
+	}
+	mutex.Lock()
+	expect(t, "0 1 2", strings.Join(written, " "))
+	mutex.Unlock()
+
+	// A waiting reply gives up with its context
+	next := p.add()
+	last := p.add()
+	last.Write(context.Background(), []byte("3"))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := last.Write(ctx, []byte("4")); err != context.DeadlineExceeded {
+		t.Fatalf("Write() returned %v", err)
+	}
+	next.end(context.Background())
+}
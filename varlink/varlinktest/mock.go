@@ -0,0 +1,219 @@
+package varlinktest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/varlink/go/varlink"
+	"github.com/varlink/go/varlink/idl"
+)
+
+// expectation is an interaction expected by a Mock.
+type expectation struct {
+	Interaction
+	call   call
+	called bool
+}
+
+// Mock is a service replying to the expected calls with their replies, on a
+// unix socket in a temporary directory. Calls which were not expected are
+// replied with MethodNotImplemented.
+type Mock struct {
+	service *varlink.Service
+	address string
+	dir     string
+	done    chan struct{}
+
+	mutex        sync.Mutex
+	interfaces   map[string]bool
+	expectations []*expectation
+}
+
+// NewMock starts a mock service of the interfaces of the descriptions.
+func NewMock(descriptions ...string) (*Mock, error) {
+	service, err := varlink.NewService("Varlink", "Mock", "1", "https://github.com/varlink/go")
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Mock{
+		service:    service,
+		done:       make(chan struct{}),
+		interfaces: make(map[string]bool),
+	}
+	for _, description := range descriptions {
+		midl, err := idl.New(description)
+		if err != nil {
+			return nil, err
+		}
+		if err := service.RegisterInterface(&mockInterface{mock: m, idl: midl}); err != nil {
+			return nil, err
+		}
+		m.interfaces[midl.Name] = true
+	}
+
+	m.dir, err = ioutil.TempDir("", "varlinktest")
+	if err != nil {
+		return nil, err
+	}
+	m.address = "unix:" + filepath.Join(m.dir, "mock")
+	if err := service.Bind(context.Background(), m.address); err != nil {
+		os.RemoveAll(m.dir)
+		return nil, err
+	}
+
+	go func() {
+		service.DoListen(context.Background(), 0)
+		close(m.done)
+	}()
+	return m, nil
+}
+
+// Address returns the address of the mock service.
+func (m *Mock) Address() string {
+	return m.address
+}
+
+// Expect lets the mock reply to the call with the replies. Calls are matched by
+// their method and parameters, the first matching expectation replies.
+func (m *Mock) Expect(call string, replies ...string) error {
+	e := &expectation{Interaction: Interaction{Call: json.RawMessage(call)}}
+	if err := json.Unmarshal(e.Call, &e.call); err != nil {
+		return fmt.Errorf("invalid call: %v", err)
+	}
+	if r := strings.LastIndex(e.call.Method, "."); r <= 0 || !m.interfaces[e.call.Method[:r]] {
+		return fmt.Errorf("call of %s, not a method of the mocked interfaces", e.call.Method)
+	}
+	for _, r := range replies {
+		var check reply
+		if err := json.Unmarshal([]byte(r), &check); err != nil {
+			return fmt.Errorf("invalid reply: %v", err)
+		}
+		e.Replies = append(e.Replies, json.RawMessage(r))
+	}
+
+	m.mutex.Lock()
+	m.expectations = append(m.expectations, e)
+	m.mutex.Unlock()
+	return nil
+}
+
+// Contract returns the expected interactions which were called.
+func (m *Mock) Contract() *Contract {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	c := &Contract{Interactions: []Interaction{}}
+	for _, e := range m.expectations {
+		if e.called {
+			c.Interactions = append(c.Interactions, e.Interaction)
+		}
+	}
+	return c
+}
+
+// Uncalled returns the expected interactions which were not called.
+func (m *Mock) Uncalled() []Interaction {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var uncalled []Interaction
+	for _, e := range m.expectations {
+		if !e.called {
+			uncalled = append(uncalled, e.Interaction)
+		}
+	}
+	return uncalled
+}
+
+// Close stops the mock service.
+func (m *Mock) Close() error {
+	err := m.service.Shutdown()
+	<-m.done
+	os.RemoveAll(m.dir)
+	return err
+}
+
+func (m *Mock) expected(method string, parameters json.RawMessage) *expectation {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, e := range m.expectations {
+		if e.call.Method == method && sameJSON(e.call.Parameters, parameters) {
+			e.called = true
+			return e
+		}
+	}
+	return nil
+}
+
+// mockInterface dispatches the calls of an interface to the expectations.
+type mockInterface struct {
+	mock *Mock
+	idl  *idl.IDL
+}
+
+func (i *mockInterface) VarlinkDispatch(ctx context.Context, c varlink.Call, methodname string) error {
+	var parameters json.RawMessage
+	c.GetParameters(&parameters)
+
+	e := i.mock.expected(i.idl.Name+"."+methodname, parameters)
+	if e == nil {
+		return c.ReplyMethodNotImplemented(ctx, i.idl.Name+"."+methodname)
+	}
+
+	for _, raw := range e.Replies {
+		var r reply
+		if err := json.Unmarshal(raw, &r); err != nil {
+			return err
+		}
+		c.Continues = r.Continues
+		if err := sendReply(ctx, &c, &r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sendReply(ctx context.Context, c *varlink.Call, r *reply) error {
+	var parameters interface{}
+	if len(r.Parameters) > 0 {
+		parameters = r.Parameters
+	}
+	if r.Error == "" {
+		return c.Reply(ctx, parameters)
+	}
+
+	// The errors of org.varlink.service are only sent by their reply methods
+	var p map[string]string
+	json.Unmarshal(r.Parameters, &p)
+	switch r.Error {
+	case "org.varlink.service.InterfaceNotFound":
+		return c.ReplyInterfaceNotFound(ctx, p["interface"])
+	case "org.varlink.service.MethodNotFound":
+		return c.ReplyMethodNotFound(ctx, p["method"])
+	case "org.varlink.service.MethodNotImplemented":
+		return c.ReplyMethodNotImplemented(ctx, p["method"])
+	case "org.varlink.service.InvalidParameter":
+		return c.ReplyInvalidParameter(ctx, p["parameter"])
+	case "org.varlink.service.PermissionDenied":
+		return c.ReplyPermissionDenied(ctx)
+	case "org.varlink.service.ServiceNotAvailable":
+		return c.ReplyServiceNotAvailable(ctx)
+	}
+	return c.ReplyError(ctx, r.Error, parameters)
+}
+
+func (i *mockInterface) VarlinkGetName() string {
+	return i.idl.Name
+}
+
+func (i *mockInterface) VarlinkGetDescription() string {
+	return i.idl.Description
+}
@@ -0,0 +1,50 @@
+package varlinktest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/varlink/go/varlink"
+)
+
+// Interface is the implementation of a varlink interface, like the one returned
+// by VarlinkNew of a generated package.
+type Interface interface {
+	VarlinkDispatch(ctx context.Context, c varlink.Call, methodname string) error
+	VarlinkGetName() string
+	VarlinkGetDescription() string
+}
+
+// Serve runs a service with the interfaces on a local TCP port, for the tests
+// of a service, until the returned function is called. It returns the address
+// of the service.
+func Serve(t testing.TB, ifaces ...Interface) (string, func()) {
+	t.Helper()
+
+	service, err := varlink.NewService("Varlink", "Test", "1", "https://github.com/varlink/go")
+	if err != nil {
+		t.Fatalf("NewService(): %v", err)
+	}
+	for _, iface := range ifaces {
+		if err := service.RegisterInterface(iface); err != nil {
+			t.Fatalf("RegisterInterface(): %v", err)
+		}
+	}
+	ctx := context.Background()
+	if err := service.Bind(ctx, "tcp:127.0.0.1:0"); err != nil {
+		t.Fatalf("Bind(): %v", err)
+	}
+	l, _ := service.GetListener()
+	servererror := make(chan error, 1)
+	go func() {
+		servererror <- service.DoListen(ctx, 0)
+	}()
+
+	return "tcp:" + l.Addr().String(), func() {
+		t.Helper()
+		service.Shutdown()
+		if err := <-servererror; err != nil {
+			t.Fatalf("DoListen(): %v", err)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package varlinktest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"sync"
+	"time"
+
+	"github.com/varlink/go/varlink"
+)
+
+// SoakConfig describes the load of Soak.
+type SoakConfig struct {
+	// Duration of the load.
+	Duration time.Duration
+
+	// Clients is the number of concurrent clients, each connecting, calling and
+	// disconnecting in a loop. Zero means 8.
+	Clients int
+
+	// Calls are method calls, the varlink messages, of which the clients send a
+	// random one on every connection and receive all replies.
+	Calls []string
+
+	// Streams are method calls with the more flag, like subscriptions, of which
+	// the clients send a random one and disconnect after StreamReplies replies,
+	// without waiting for the last one.
+	Streams       []string
+	StreamReplies int
+
+	// Settle is how long the goroutines and open files may take to return to
+	// their numbers before the load. Zero means five seconds.
+	Settle time.Duration
+}
+
+// SoakResult reports the load of Soak and the goroutines and open files of the
+// process before and after it. The files are -1 where they cannot be counted.
+type SoakResult struct {
+	Connections int
+	Calls       int
+	Errors      int
+
+	Goroutines      int
+	GoroutinesAfter int
+	Files           int
+	FilesAfter      int
+}
+
+// openFiles returns the number of open files of the process, or -1 if the
+// system does not tell.
+func openFiles() int {
+	d, err := os.Open("/proc/self/fd")
+	if err != nil {
+		return -1
+	}
+	defer d.Close()
+
+	names, err := d.Readdirnames(-1)
+	if err != nil {
+		return -1
+	}
+	// Do not count the directory itself
+	return len(names) - 1
+}
+
+// Soak connects to the service at the address from many clients at once,
+// which call methods and abandon streams, until the duration passed. The
+// service is expected to run in the same process; the goroutines and open files
+// of the process must return to their numbers before the load, or Soak returns
+// an error with the stacks of the goroutines. Failed calls are only counted, as
+// a service may well reject calls under load.
+func Soak(ctx context.Context, address string, config SoakConfig) (*SoakResult, error) {
+	if len(config.Calls) == 0 && len(config.Streams) == 0 {
+		return nil, fmt.Errorf("no calls to send")
+	}
+	for _, m := range append(append([]string(nil), config.Calls...), config.Streams...) {
+		if !json.Valid([]byte(m)) {
+			return nil, fmt.Errorf("invalid call: %s", m)
+		}
+	}
+	clients := config.Clients
+	if clients <= 0 {
+		clients = 8
+	}
+	settle := config.Settle
+	if settle <= 0 {
+		settle = 5 * time.Second
+	}
+
+	result := &SoakResult{
+		Goroutines: runtime.NumGoroutine(),
+		Files:      openFiles(),
+	}
+
+	var mutex sync.Mutex
+	count := func(calls int, err error) {
+		mutex.Lock()
+		result.Connections++
+		result.Calls += calls
+		if err != nil {
+			result.Errors++
+		}
+		mutex.Unlock()
+	}
+
+	// The deadline passes for the connections before the context is done
+	end := time.Now().Add(config.Duration)
+	loadCtx, cancel := context.WithDeadline(ctx, end)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(seed))
+			for loadCtx.Err() == nil && time.Now().Before(end) {
+				calls, err := soakConnection(loadCtx, address, &config, r)
+				if loadCtx.Err() != nil || !time.Now().Before(end) {
+					// Interrupted by the end of the load
+					err = nil
+				}
+				count(calls, err)
+			}
+		}(int64(i))
+	}
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		return result, ctx.Err()
+	}
+
+	// Closed connections end their goroutines asynchronously
+	deadline := time.Now().Add(settle)
+	for {
+		runtime.GC()
+		result.GoroutinesAfter = runtime.NumGoroutine()
+		result.FilesAfter = openFiles()
+		if result.GoroutinesAfter <= result.Goroutines && result.FilesAfter <= result.Files {
+			return result, nil
+		}
+		if time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	var stacks bytes.Buffer
+	pprof.Lookup("goroutine").WriteTo(&stacks, 1)
+	return result, fmt.Errorf("leaked %d goroutines and %d files after %d connections:\n%s",
+		result.GoroutinesAfter-result.Goroutines, result.FilesAfter-result.Files, result.Connections, stacks.String())
+}
+
+// soakConnection connects and either disconnects right away, sends a call or
+// abandons a stream, and returns the number of calls sent.
+func soakConnection(ctx context.Context, address string, config *SoakConfig, r *rand.Rand) (int, error) {
+	c, err := varlink.NewConnection(ctx, address)
+	if err != nil {
+		return 0, err
+	}
+	defer c.Close()
+
+	n := r.Intn(3)
+	switch {
+	case n == 1 && len(config.Calls) > 0:
+		receive, err := sendMessage(ctx, c, config.Calls[r.Intn(len(config.Calls))], 0)
+		if err != nil {
+			return 1, err
+		}
+		for {
+			flags, err := receive(ctx, nil)
+			if err != nil {
+				return 1, err
+			}
+			if flags&varlink.Continues == 0 {
+				return 1, nil
+			}
+		}
+
+	case n == 2 && len(config.Streams) > 0:
+		receive, err := sendMessage(ctx, c, config.Streams[r.Intn(len(config.Streams))], varlink.More)
+		if err != nil {
+			return 1, err
+		}
+		for i := 0; i < config.StreamReplies; i++ {
+			flags, err := receive(ctx, nil)
+			if err != nil {
+				return 1, err
+			}
+			if flags&varlink.Continues == 0 {
+				break
+			}
+		}
+		return 1, nil
+	}
+
+	return 0, nil
+}
+
+func sendMessage(ctx context.Context, c *varlink.Connection, message string, flags uint64) (func(context.Context, interface{}) (uint64, error), error) {
+	var in call
+	if err := json.Unmarshal([]byte(message), &in); err != nil {
+		return nil, err
+	}
+	if in.More {
+		flags |= varlink.More
+	}
+
+	var parameters interface{}
+	if len(in.Parameters) > 0 {
+		parameters = in.Parameters
+	}
+	return c.Send(ctx, in.Method, parameters, flags)
+}
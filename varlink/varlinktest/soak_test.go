@@ -0,0 +1,97 @@
+package varlinktest
+
+import (
+	"context"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/varlink/go/varlink"
+)
+
+type soakInterface struct {
+	// leak is never closed while the test runs, method calls waiting for it leak
+	leak chan struct{}
+}
+
+func (s *soakInterface) VarlinkDispatch(ctx context.Context, c varlink.Call, methodname string) error {
+	switch methodname {
+	case "Monitor":
+		for {
+			c.Continues = true
+			if err := c.Reply(ctx, nil); err != nil {
+				return err
+			}
+			time.Sleep(time.Millisecond)
+		}
+
+	case "Ping":
+		if s.leak != nil {
+			go func() { <-s.leak }()
+		}
+		return c.Reply(ctx, nil)
+	}
+	return c.ReplyMethodNotFound(ctx, methodname)
+}
+
+func (s *soakInterface) VarlinkGetName() string {
+	return "org.example.soak"
+}
+
+func (s *soakInterface) VarlinkGetDescription() string {
+	return `interface org.example.soak
+method Ping() -> ()
+method Monitor() -> ()`
+}
+
+func soak(t *testing.T, address string, soakInterface *soakInterface) (*SoakResult, error) {
+	if runtime.GOOS == "windows" {
+		t.Skip("skipping test on windows")
+	}
+
+	ctx := context.Background()
+	service, _ := varlink.NewService("Varlink", "Varlink Test", "1", "https://github.com/varlink/go/varlink")
+	if err := service.RegisterInterface(soakInterface); err != nil {
+		t.Fatalf("RegisterInterface(): %v", err)
+	}
+	servererror := make(chan error)
+	go func() {
+		servererror <- service.Listen(ctx, address, 0)
+	}()
+	time.Sleep(time.Second / 5)
+	defer func() {
+		service.Shutdown()
+		if err := <-servererror; err != nil {
+			t.Fatalf("service.Listen(): %v", err)
+		}
+	}()
+
+	return Soak(ctx, address, SoakConfig{
+		Duration:      time.Second / 2,
+		Calls:         []string{`{"method": "org.example.soak.Ping"}`},
+		Streams:       []string{`{"method": "org.example.soak.Monitor"}`},
+		StreamReplies: 3,
+		Settle:        time.Second,
+	})
+}
+
+func TestSoak(t *testing.T) {
+	result, err := soak(t, "unix:varlinktest_TestSoak", &soakInterface{})
+	if err != nil {
+		t.Fatalf("Soak(): %v", err)
+	}
+	if result.Connections == 0 || result.Calls == 0 || result.Errors > 0 {
+		t.Fatalf("Unexpected result %+v", result)
+	}
+}
+
+func TestSoakLeak(t *testing.T) {
+	leak := make(chan struct{})
+	defer close(leak)
+
+	_, err := soak(t, "unix:varlinktest_TestSoakLeak", &soakInterface{leak: leak})
+	if err == nil || !strings.HasPrefix(err.Error(), "leaked ") {
+		t.Fatalf("Soak() returned %v", err)
+	}
+}
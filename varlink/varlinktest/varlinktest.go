@@ -0,0 +1,198 @@
+// Package varlinktest provides a mock service for the tests of varlink clients,
+// and contract tests verifying the calls the clients rely on against the actual
+// service.
+//
+// The tests of a client expect calls from a Mock, and write the calls which were
+// made as a Contract, like a golden file checked into the repository of the
+// client. The tests of the service verify the contract against the service,
+// which catches changes breaking the client before it is deployed:
+//
+//	// client
+//	mock, _ := varlinktest.NewMock(orgexampleping.VarlinkNew(nil).VarlinkGetDescription())
+//	defer mock.Close()
+//	mock.Expect(`{"method": "org.example.ping.Ping", "parameters": {"ping": "hi"}}`, `{"parameters": {"pong": "hi"}}`)
+//	// ... run the client against mock.Address()
+//	mock.Contract().Write(file)
+//
+//	// service
+//	address, stop := varlinktest.Serve(t, orgexampleping.VarlinkNew(&Ping{}))
+//	defer stop()
+//	contract, _ := varlinktest.ReadContract(file)
+//	err := varlinktest.Verify(ctx, address, contract)
+package varlinktest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+
+	"github.com/varlink/go/varlink"
+)
+
+// Interaction is a method call with the replies to it, the varlink messages
+// without their terminating NUL byte. A oneway call has no replies.
+type Interaction struct {
+	Call    json.RawMessage   `json:"call"`
+	Replies []json.RawMessage `json:"replies,omitempty"`
+}
+
+// Contract holds the interactions a client relies on.
+type Contract struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+// ReadContract reads a contract written with Write.
+func ReadContract(r io.Reader) (*Contract, error) {
+	var c Contract
+	if err := json.NewDecoder(r).Decode(&c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// Write writes the contract as indented JSON.
+func (c *Contract) Write(w io.Writer) error {
+	b, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+type call struct {
+	Method     string          `json:"method"`
+	Parameters json.RawMessage `json:"parameters"`
+	More       bool            `json:"more"`
+	Oneway     bool            `json:"oneway"`
+}
+
+type reply struct {
+	Parameters json.RawMessage `json:"parameters,omitempty"`
+	Continues  bool            `json:"continues,omitempty"`
+	Error      string          `json:"error,omitempty"`
+}
+
+// sameJSON reports whether the values are equal, with a missing value equal to
+// an empty object, like the parameters of a reply.
+func sameJSON(a json.RawMessage, b json.RawMessage) bool {
+	decode := func(raw json.RawMessage) interface{} {
+		var v interface{}
+		if len(raw) > 0 {
+			json.Unmarshal(raw, &v)
+		}
+		if v == nil {
+			v = map[string]interface{}{}
+		}
+		return v
+	}
+	return reflect.DeepEqual(decode(a), decode(b))
+}
+
+// errorReply returns the reply of an error returned by a receive function, or
+// nil if the error is no error reply.
+func errorReply(err error) *reply {
+	if e, ok := err.(*varlink.Error); ok {
+		r := &reply{Error: e.Name}
+		if raw, ok := e.Parameters.(*json.RawMessage); ok && raw != nil {
+			r.Parameters = *raw
+		}
+		return r
+	}
+
+	// The errors of org.varlink.service are converted to their types
+	if name := err.Error(); strings.HasPrefix(name, "org.varlink.service.") && !strings.Contains(name, " ") {
+		parameters, _ := json.Marshal(err)
+		return &reply{Error: name, Parameters: parameters}
+	}
+	return nil
+}
+
+// Verify sends the call to the service at the address, on a new connection, and
+// compares the replies to the expected ones. Replies which do not match the
+// interface description of the service fail as well.
+func (i Interaction) Verify(ctx context.Context, address string) error {
+	var in call
+	if err := json.Unmarshal(i.Call, &in); err != nil {
+		return err
+	}
+	if !in.Oneway && len(i.Replies) == 0 {
+		return fmt.Errorf("the call has no reply")
+	}
+
+	c, err := varlink.NewConnection(ctx, address)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	var diagnostics []string
+	c.SetDiagnostics(func(d varlink.Diagnostic) {
+		diagnostics = append(diagnostics, d.String())
+	})
+
+	var flags uint64
+	if in.More {
+		flags |= varlink.More
+	}
+	if in.Oneway {
+		flags |= varlink.Oneway
+	}
+	var parameters interface{}
+	if len(in.Parameters) > 0 {
+		parameters = in.Parameters
+	}
+	receive, err := c.Send(ctx, in.Method, parameters, flags)
+	if err != nil {
+		return err
+	}
+
+	for n, r := range i.Replies {
+		var expected reply
+		if err := json.Unmarshal(r, &expected); err != nil {
+			return err
+		}
+
+		var out json.RawMessage
+		flags, err := receive(ctx, &out)
+		got := &reply{Parameters: out, Continues: flags&varlink.Continues != 0}
+		if err != nil {
+			if got = errorReply(err); got == nil {
+				return err
+			}
+		}
+
+		if got.Error != expected.Error || got.Continues != expected.Continues || !sameJSON(got.Parameters, expected.Parameters) {
+			b, _ := json.Marshal(got)
+			return fmt.Errorf("reply %d is %s, expected %s", n, b, r)
+		}
+		if len(diagnostics) > 0 {
+			return fmt.Errorf("reply %d does not match the interface: %s", n, strings.Join(diagnostics, "; "))
+		}
+		if !got.Continues && n < len(i.Replies)-1 {
+			return fmt.Errorf("reply %d is the last one, expected %d replies", n, len(i.Replies))
+		}
+		if got.Continues && n == len(i.Replies)-1 {
+			return fmt.Errorf("more than the expected %d replies", len(i.Replies))
+		}
+	}
+	return nil
+}
+
+// Verify verifies all interactions of the contract against the service at the
+// address, and returns an error describing every one which failed.
+func Verify(ctx context.Context, address string, contract *Contract) error {
+	var failures []string
+	for n, i := range contract.Interactions {
+		if err := i.Verify(ctx, address); err != nil {
+			failures = append(failures, fmt.Sprintf("interaction %d %s: %v", n, i.Call, err))
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("%d of %d interactions failed:\n%s", len(failures), len(contract.Interactions), strings.Join(failures, "\n"))
+	}
+	return nil
+}
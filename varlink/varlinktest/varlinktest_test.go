@@ -0,0 +1,130 @@
+package varlinktest
+
+import (
+	"bytes"
+	"context"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/varlink/go/varlink"
+)
+
+const pingDescription = `interface org.example.ping
+method Ping(ping: string) -> (pong: string)
+error Empty ()`
+
+// pingInterface is the service of the contract, which replies with the wrong
+// type if drifted.
+type pingInterface struct {
+	drifted bool
+}
+
+func (p *pingInterface) VarlinkDispatch(ctx context.Context, c varlink.Call, methodname string) error {
+	var in struct {
+		Ping string `json:"ping"`
+	}
+	c.GetParameters(&in)
+	if in.Ping == "" {
+		return c.ReplyError(ctx, "org.example.ping.Empty", nil)
+	}
+	if p.drifted {
+		return c.Reply(ctx, map[string]int{"pong": len(in.Ping)})
+	}
+	return c.Reply(ctx, map[string]string{"pong": in.Ping})
+}
+
+func (p *pingInterface) VarlinkGetName() string {
+	return "org.example.ping"
+}
+
+func (p *pingInterface) VarlinkGetDescription() string {
+	return pingDescription
+}
+
+func TestContract(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("skipping test on windows")
+	}
+	ctx := context.Background()
+
+	// The client side records the calls it made
+	mock, err := NewMock(pingDescription)
+	if err != nil {
+		t.Fatalf("NewMock(): %v", err)
+	}
+	for _, e := range [][2]string{
+		{`{"method": "org.example.ping.Ping", "parameters": {"ping": "hi"}}`, `{"parameters": {"pong": "hi"}}`},
+		{`{"method": "org.example.ping.Ping", "parameters": {"ping": ""}}`, `{"error": "org.example.ping.Empty"}`},
+		{`{"method": "org.example.ping.Ping", "parameters": {"ping": "unused"}}`, `{"parameters": {"pong": "unused"}}`},
+	} {
+		if err := mock.Expect(e[0], e[1]); err != nil {
+			t.Fatalf("Expect(): %v", err)
+		}
+	}
+	if err := mock.Expect(`{"method": "org.example.other.Ping"}`, `{}`); err == nil {
+		t.Fatal("Expect() accepted a call of another interface")
+	}
+
+	c, err := varlink.NewConnection(ctx, mock.Address())
+	if err != nil {
+		t.Fatalf("NewConnection(): %v", err)
+	}
+	var out struct {
+		Pong string `json:"pong"`
+	}
+	if err := c.Call(ctx, "org.example.ping.Ping", map[string]string{"ping": "hi"}, &out); err != nil || out.Pong != "hi" {
+		t.Fatalf("Call() returned '%s' %v", out.Pong, err)
+	}
+	if err := c.Call(ctx, "org.example.ping.Ping", map[string]string{"ping": ""}, &out); err == nil || err.Error() != "org.example.ping.Empty" {
+		t.Fatalf("Call() returned %v", err)
+	}
+	if err := c.Call(ctx, "org.example.ping.Ping", map[string]string{"ping": "unexpected"}, &out); err == nil {
+		t.Fatal("Call() of an unexpected call succeeded")
+	}
+	c.Close()
+
+	var b bytes.Buffer
+	if err := mock.Contract().Write(&b); err != nil {
+		t.Fatalf("Write(): %v", err)
+	}
+	if uncalled := mock.Uncalled(); len(uncalled) != 1 {
+		t.Fatalf("Uncalled() returned %v", uncalled)
+	}
+	mock.Close()
+
+	contract, err := ReadContract(&b)
+	if err != nil {
+		t.Fatalf("ReadContract(): %v", err)
+	}
+	if len(contract.Interactions) != 2 {
+		t.Fatalf("Contract has %d interactions", len(contract.Interactions))
+	}
+
+	// The service side verifies the contract
+	for _, drifted := range []bool{false, true} {
+		service, _ := varlink.NewService("Varlink", "Varlink Test", "1", "https://github.com/varlink/go/varlink")
+		if err := service.RegisterInterface(&pingInterface{drifted: drifted}); err != nil {
+			t.Fatalf("RegisterInterface(): %v", err)
+		}
+		servererror := make(chan error)
+		go func() {
+			servererror <- service.Listen(ctx, "unix:varlinktest_TestContract", 0)
+		}()
+		time.Sleep(time.Second / 5)
+
+		err := Verify(ctx, "unix:varlinktest_TestContract", contract)
+		if !drifted && err != nil {
+			t.Fatalf("Verify(): %v", err)
+		}
+		if drifted && (err == nil || !strings.HasPrefix(err.Error(), "1 of 2 interactions failed")) {
+			t.Fatalf("Verify() of the drifted service returned %v", err)
+		}
+
+		service.Shutdown()
+		if err := <-servererror; err != nil {
+			t.Fatalf("service.Listen(): %v", err)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package varlink
+
+import (
+	"context"
+	"net"
+)
+
+type listenerKey struct{}
+
+func listenerFromContext(ctx context.Context) net.Listener {
+	l, _ := ctx.Value(listenerKey{}).(net.Listener)
+	return l
+}
+
+// connListener returns the listener which accepted the connection.
+func connListener(l net.Listener, conn net.Conn) net.Listener {
+	if m, ok := l.(*multiListener); ok {
+		if origin := m.origin(conn); origin != nil {
+			return origin
+		}
+	}
+	return l
+}
+
+// reachable returns whether the interface is visible on the listener of the
+// call. Calls not accepted by a listener are checked with a nil listener.
+func (s *Service) reachable(c *Call, name string) bool {
+	if s.config.Visible == nil {
+		return true
+	}
+	return s.config.Visible(c.listener, name)
+}
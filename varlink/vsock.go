@@ -0,0 +1,56 @@
+package varlink
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// VsockAnyContextID is the context id of a VsockAddr listening on all of them.
+const VsockAnyContextID = math.MaxUint32
+
+// VsockAddr is the address of an AF_VSOCK socket, which connects virtual
+// machines and their host without networking. The host has the context id 2,
+// the guests get theirs from the hypervisor.
+type VsockAddr struct {
+	ContextID uint32
+	Port      uint32
+}
+
+// Network returns "vsock".
+func (a *VsockAddr) Network() string {
+	return "vsock"
+}
+
+// String returns the address in the "cid:port" form of varlink addresses.
+func (a *VsockAddr) String() string {
+	if a.ContextID == VsockAnyContextID {
+		return fmt.Sprintf(":%d", a.Port)
+	}
+	return fmt.Sprintf("%d:%d", a.ContextID, a.Port)
+}
+
+// parseVsockAddr parses the "cid:port" of a vsock address; listeners may leave
+// the context id empty to listen on all of them.
+func parseVsockAddr(address string, listen bool) (*VsockAddr, error) {
+	words := strings.SplitN(address, ":", 2)
+	if len(words) != 2 {
+		return nil, fmt.Errorf("vsock address '%s' is not of the form cid:port", address)
+	}
+
+	addr := VsockAddr{ContextID: VsockAnyContextID}
+	if words[0] != "" || !listen {
+		cid, err := strconv.ParseUint(words[0], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid vsock context id '%s'", words[0])
+		}
+		addr.ContextID = uint32(cid)
+	}
+	port, err := strconv.ParseUint(words[1], 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid vsock port '%s'", words[1])
+	}
+	addr.Port = uint32(port)
+	return &addr, nil
+}
@@ -0,0 +1,208 @@
+// +build !386
+
+package varlink
+
+import (
+	"context"
+	"net"
+	"os"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// afVsock is the address family of vsock, which the syscall package lacks.
+const afVsock = 40
+
+// rawSockaddrVM is struct sockaddr_vm.
+type rawSockaddrVM struct {
+	Family    uint16
+	Reserved1 uint16
+	Port      uint32
+	CID       uint32
+	Zero      [4]uint8
+}
+
+func newRawSockaddrVM(addr *VsockAddr) *rawSockaddrVM {
+	return &rawSockaddrVM{Family: afVsock, Port: addr.Port, CID: addr.ContextID}
+}
+
+// vsockName returns the address of the socket, or of its peer, with the
+// getsockname or getpeername system call.
+func vsockName(fd uintptr, trap uintptr) (*VsockAddr, error) {
+	var sa rawSockaddrVM
+	n := uint32(unsafe.Sizeof(sa))
+	_, _, errno := syscall.Syscall(trap, fd, uintptr(unsafe.Pointer(&sa)), uintptr(unsafe.Pointer(&n)))
+	if errno != 0 {
+		return nil, errno
+	}
+	return &VsockAddr{ContextID: sa.CID, Port: sa.Port}, nil
+}
+
+// vsockSocket returns a new non-blocking socket. The os.File of a non-blocking
+// descriptor uses the runtime poller, as long as Fd, which makes it blocking, is
+// not called.
+func vsockSocket() (uintptr, error) {
+	fd, err := syscall.Socket(afVsock, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC|syscall.SOCK_NONBLOCK, 0)
+	if err != nil {
+		return 0, os.NewSyscallError("socket", err)
+	}
+	return uintptr(fd), nil
+}
+
+// vsockConn is a connected vsock socket.
+type vsockConn struct {
+	*os.File
+	local  *VsockAddr
+	remote *VsockAddr
+}
+
+func (c *vsockConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+func (c *vsockConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// vsockListener is a listening vsock socket.
+type vsockListener struct {
+	file *os.File
+	addr *VsockAddr
+}
+
+func listenVsock(addr *VsockAddr) (net.Listener, error) {
+	fd, err := vsockSocket()
+	if err != nil {
+		return nil, err
+	}
+
+	sa := newRawSockaddrVM(addr)
+	_, _, errno := syscall.Syscall(syscall.SYS_BIND, fd, uintptr(unsafe.Pointer(sa)), unsafe.Sizeof(*sa))
+	if errno != 0 {
+		syscall.Close(int(fd))
+		return nil, os.NewSyscallError("bind", errno)
+	}
+	if err := syscall.Listen(int(fd), syscall.SOMAXCONN); err != nil {
+		syscall.Close(int(fd))
+		return nil, os.NewSyscallError("listen", err)
+	}
+	local, err := vsockName(fd, syscall.SYS_GETSOCKNAME)
+	if err != nil {
+		syscall.Close(int(fd))
+		return nil, os.NewSyscallError("getsockname", err)
+	}
+	return &vsockListener{file: os.NewFile(fd, "vsock"), addr: local}, nil
+}
+
+func (l *vsockListener) Accept() (net.Conn, error) {
+	raw, err := l.file.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	var nfd uintptr
+	var sa rawSockaddrVM
+	var errno syscall.Errno
+	err = raw.Read(func(fd uintptr) bool {
+		n := uint32(unsafe.Sizeof(sa))
+		nfd, _, errno = syscall.Syscall6(syscall.SYS_ACCEPT4, fd, uintptr(unsafe.Pointer(&sa)), uintptr(unsafe.Pointer(&n)), syscall.SOCK_CLOEXEC|syscall.SOCK_NONBLOCK, 0, 0)
+		return errno != syscall.EAGAIN && errno != syscall.EINTR
+	})
+	if err == nil && errno != 0 {
+		err = os.NewSyscallError("accept4", errno)
+	}
+	if err != nil {
+		return nil, &net.OpError{Op: "accept", Net: "vsock", Addr: l.addr, Err: err}
+	}
+
+	return &vsockConn{
+		File:   os.NewFile(nfd, "vsock"),
+		local:  l.addr,
+		remote: &VsockAddr{ContextID: sa.CID, Port: sa.Port},
+	}, nil
+}
+
+func (l *vsockListener) Close() error {
+	return l.file.Close()
+}
+
+func (l *vsockListener) Addr() net.Addr {
+	return l.addr
+}
+
+func (l *vsockListener) SetDeadline(t time.Time) error {
+	return l.file.SetDeadline(t)
+}
+
+func dialVsock(ctx context.Context, addr *VsockAddr) (net.Conn, error) {
+	fd, err := vsockSocket()
+	if err != nil {
+		return nil, err
+	}
+	f := os.NewFile(fd, "vsock")
+	raw, err := f.SyscallConn()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		f.SetWriteDeadline(deadline)
+	}
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			f.SetWriteDeadline(time.Unix(1, 0))
+		case <-stop:
+		}
+	}()
+
+	// The non-blocking connect completes when the socket becomes writable
+	sa := newRawSockaddrVM(addr)
+	started := false
+	var errno syscall.Errno
+	err = raw.Write(func(fd uintptr) bool {
+		if !started {
+			started = true
+			_, _, errno = syscall.Syscall(syscall.SYS_CONNECT, fd, uintptr(unsafe.Pointer(sa)), unsafe.Sizeof(*sa))
+			return errno != syscall.EINPROGRESS
+		}
+		soerr, err := syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_ERROR)
+		if err != nil {
+			errno = err.(syscall.Errno)
+			return true
+		}
+		errno = syscall.Errno(soerr)
+		if errno != 0 {
+			return true
+		}
+		_, err = vsockName(fd, syscall.SYS_GETPEERNAME)
+		return err != syscall.ENOTCONN
+	})
+	close(stop)
+	<-stopped
+
+	if err == nil && errno != 0 {
+		err = os.NewSyscallError("connect", errno)
+	}
+	if err != nil {
+		f.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, &net.OpError{Op: "dial", Net: "vsock", Addr: addr, Err: err}
+	}
+	f.SetWriteDeadline(time.Time{})
+
+	// The local port is chosen by connect
+	local, err := vsockName(fd, syscall.SYS_GETSOCKNAME)
+	if err != nil {
+		f.Close()
+		return nil, os.NewSyscallError("getsockname", err)
+	}
+	return &vsockConn{File: f, local: local, remote: addr}, nil
+}
@@ -0,0 +1,17 @@
+// +build !linux linux,386
+
+package varlink
+
+import (
+	"context"
+	"fmt"
+	"net"
+)
+
+func listenVsock(addr *VsockAddr) (net.Listener, error) {
+	return nil, fmt.Errorf("vsock is not supported on this platform")
+}
+
+func dialVsock(ctx context.Context, addr *VsockAddr) (net.Conn, error) {
+	return nil, fmt.Errorf("vsock is not supported on this platform")
+}
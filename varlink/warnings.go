@@ -0,0 +1,63 @@
+package varlink
+
+import "fmt"
+
+// WarningKind is the kind of condition a Warning reports.
+type WarningKind int
+
+const (
+	// StaleSocketRemoved reports the removal of a unix socket file nobody
+	// listened on, left over by a previous instance.
+	StaleSocketRemoved WarningKind = iota + 1
+	// InvalidActivation reports socket activation variables which could not be
+	// used; the service listens on its address instead.
+	InvalidActivation
+	// MessageTooLarge reports a request exceeding ServiceConfig.MaxMessageSize;
+	// the connection is closed.
+	MessageTooLarge
+	// ConnectionClosed reports a connection closed because a request could not
+	// be handled, like a message which is not JSON, or a method returned
+	// without a final reply.
+	ConnectionClosed
+	// HandlerPanicked reports a panic of the handler of a call, with a
+	// *PanicError; the call ends with an InternalError.
+	HandlerPanicked
+	// HandlerStuck reports a call running much longer than the calls of the
+	// method used to, with a *StuckError; see ServiceConfig.HandlerWatchdog.
+	HandlerStuck
+)
+
+func (k WarningKind) String() string {
+	switch k {
+	case StaleSocketRemoved:
+		return "stale socket removed"
+	case InvalidActivation:
+		return "invalid socket activation"
+	case MessageTooLarge:
+		return "message too large"
+	case ConnectionClosed:
+		return "connection closed"
+	case HandlerPanicked:
+		return "handler panicked"
+	case HandlerStuck:
+		return "handler stuck"
+	}
+	return fmt.Sprintf("WarningKind(%d)", int(k))
+}
+
+// Warning is a condition the service recovered from on its own, passed to
+// ServiceConfig.Warnings.
+type Warning struct {
+	Kind WarningKind
+	Err  error
+}
+
+func (w Warning) String() string {
+	return w.Kind.String() + ": " + w.Err.Error()
+}
+
+func (s *Service) warn(kind WarningKind, err error) {
+	if s.config.Warnings != nil {
+		s.config.Warnings(Warning{Kind: kind, Err: err})
+	}
+}
@@ -0,0 +1,205 @@
+package varlink
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+)
+
+// HandlerWatchdog reports the method calls running much longer than the calls
+// of the method used to, which points at a deadlock in the method handler. The
+// p99 of the runtime of the recent calls of every method is learned; a call
+// running longer than Factor times it is reported with a HandlerStuck warning,
+// with the stack of the goroutine handling the call. Calls which want more
+// replies or an upgrade are not watched.
+type HandlerWatchdog struct {
+	// Factor is the multiple of the p99 runtime after which a call is stuck;
+	// zero means 10.
+	Factor float64
+	// MinDuration is the least runtime of a stuck call, so the calls of fast
+	// methods are not reported for a scheduling hiccup; zero means one second.
+	MinDuration time.Duration
+	// MinSamples is the number of calls of a method needed before its calls
+	// are watched; zero means 100.
+	MinSamples int
+	// Cancel cancels the context returned by Call.Context of a stuck call.
+	Cancel bool
+}
+
+// watchdogWindow is the number of recent calls of a method the p99 is taken
+// from, watchdogRefresh the number of calls after which it is taken again.
+const (
+	watchdogWindow  = 1000
+	watchdogRefresh = 50
+)
+
+// StuckError is the error of a HandlerStuck warning.
+type StuckError struct {
+	// Method is the fully-qualified name of the called method.
+	Method string
+	// Duration is how long the call is running, Threshold the runtime after
+	// which it is considered stuck.
+	Duration  time.Duration
+	Threshold time.Duration
+	// Stack is the stack of the goroutine handling the call.
+	Stack []byte
+	// Canceled is whether the context of the call was canceled.
+	Canceled bool
+}
+
+func (e *StuckError) Error() string {
+	return fmt.Sprintf("%s running for %s, longer than %s", e.Method, e.Duration.Round(time.Millisecond), e.Threshold.Round(time.Millisecond))
+}
+
+type methodRuntimes struct {
+	samples []time.Duration
+	next    int
+	fresh   int
+	p99     time.Duration
+}
+
+func (m *methodRuntimes) add(d time.Duration) {
+	if len(m.samples) < watchdogWindow {
+		m.samples = append(m.samples, d)
+	} else {
+		m.samples[m.next] = d
+		m.next = (m.next + 1) % watchdogWindow
+	}
+
+	m.fresh++
+	if m.p99 == 0 || m.fresh >= watchdogRefresh {
+		sorted := append([]time.Duration(nil), m.samples...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		m.p99 = sorted[(len(sorted)*99)/100]
+		m.fresh = 0
+	}
+}
+
+type handlerWatchdog struct {
+	config  HandlerWatchdog
+	mutex   sync.Mutex
+	methods map[string]*methodRuntimes
+}
+
+func newHandlerWatchdog(config HandlerWatchdog) *handlerWatchdog {
+	if config.Factor <= 0 {
+		config.Factor = 10
+	}
+	if config.MinDuration <= 0 {
+		config.MinDuration = time.Second
+	}
+	if config.MinSamples <= 0 {
+		config.MinSamples = 100
+	}
+	return &handlerWatchdog{config: config, methods: make(map[string]*methodRuntimes)}
+}
+
+// threshold returns the runtime after which a call of the method is stuck, zero
+// while too few calls of it were seen.
+func (w *handlerWatchdog) threshold(method string) time.Duration {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	m, ok := w.methods[method]
+	if !ok || len(m.samples) < w.config.MinSamples {
+		return 0
+	}
+	t := time.Duration(w.config.Factor * float64(m.p99))
+	if t < w.config.MinDuration {
+		t = w.config.MinDuration
+	}
+	return t
+}
+
+func (w *handlerWatchdog) record(method string, d time.Duration) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	m, ok := w.methods[method]
+	if !ok {
+		m = &methodRuntimes{}
+		w.methods[method] = m
+	}
+	m.add(d)
+}
+
+// goroutineHeader returns the start of the stack trace of the calling
+// goroutine, like "goroutine 42 ".
+func goroutineHeader() []byte {
+	b := make([]byte, 64)
+	b = b[:runtime.Stack(b, false)]
+	if i := bytes.IndexByte(b, '['); i > 0 {
+		return b[:i]
+	}
+	return nil
+}
+
+// goroutineStack returns the stack trace of the goroutine with the header.
+func goroutineStack(header []byte) []byte {
+	b := make([]byte, 1<<16)
+	for {
+		n := runtime.Stack(b, true)
+		if n < len(b) {
+			b = b[:n]
+			break
+		}
+		b = make([]byte, 2*len(b))
+	}
+
+	for _, stack := range bytes.Split(b, []byte("\n\n")) {
+		if bytes.HasPrefix(stack, header) {
+			return stack
+		}
+	}
+	return nil
+}
+
+// watch watches the call handled by the calling goroutine, until the returned
+// function is called with the end of the call, which is deferred.
+func (s *Service) watch(c *Call) func() {
+	method := c.In.Method
+	start := time.Now()
+	t := s.stuckCalls.threshold(method)
+	if t == 0 {
+		return func() { s.stuckCalls.record(method, time.Since(start)) }
+	}
+
+	header := goroutineHeader()
+	var mutex sync.Mutex
+	returned, stuck := false, false
+	timer := time.AfterFunc(t, func() {
+		stack := goroutineStack(header)
+		mutex.Lock()
+		stuck = !returned
+		mutex.Unlock()
+		if !stuck {
+			return
+		}
+
+		if s.stuckCalls.config.Cancel {
+			c.state.cancelContext(c.In.Upgrade)
+		}
+		s.warn(HandlerStuck, &StuckError{
+			Method:    method,
+			Duration:  time.Since(start),
+			Threshold: t,
+			Stack:     stack,
+			Canceled:  s.stuckCalls.config.Cancel,
+		})
+	})
+
+	// The runtime of a stuck call would raise the p99 the next ones are held to
+	return func() {
+		timer.Stop()
+		mutex.Lock()
+		returned = true
+		record := !stuck
+		mutex.Unlock()
+		if record {
+			s.stuckCalls.record(method, time.Since(start))
+		}
+	}
+}